@@ -72,6 +72,12 @@ type Packet struct {
 type PacketHeader interface {
 }
 
+// TimedMetadata is the header of a metadata packet injected mid stream,
+// such as the track being played. Fields are the values its data carries
+type TimedMetadata struct {
+	Fields map[string]string
+}
+
 type AudioPacketHeader interface {
 	PacketHeader
 	SoundFormat() uint8
@@ -153,3 +159,9 @@ type WriteCloser interface {
 	CalcTime
 	Write(*Packet) error
 }
+
+// Player is implemented by the writers that deliver a stream to a viewer,
+// as opposed to internal writers like the hls source or the flv archive
+type Player interface {
+	IsPlayer() bool
+}
@@ -0,0 +1,29 @@
+package configure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// APIKey is the key of the http api, taken from the API_KEY environment
+// variable, else from the file at api.key_file, else from api.key. The
+// file is trimmed so a secret written with a trailing newline still works.
+func APIKey() (string, error) {
+	if key := os.Getenv("API_KEY"); key != "" {
+		return key, nil
+	}
+	if file := Config.GetString("api.key_file"); file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("api.key_file: %v", err)
+		}
+		key := strings.TrimSpace(string(b))
+		if key == "" {
+			return "", fmt.Errorf("api.key_file: %s is empty", file)
+		}
+		return key, nil
+	}
+	return Config.GetString("api.key"), nil
+}
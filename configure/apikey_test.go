@@ -0,0 +1,61 @@
+package configure
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKey(t *testing.T) {
+	at := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "livego-apikey")
+	at.Nil(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "api_key")
+	at.Nil(ioutil.WriteFile(file, []byte("  from-file\n"), 0600))
+
+	oldEnv, hadEnv := os.LookupEnv("API_KEY")
+	os.Unsetenv("API_KEY")
+	defer func() {
+		if hadEnv {
+			os.Setenv("API_KEY", oldEnv)
+		}
+		Config.Set("api.key", "")
+		Config.Set("api.key_file", "")
+	}()
+
+	key, err := APIKey()
+	at.Nil(err)
+	at.Equal("", key)
+
+	Config.Set("api.key", "inline")
+	key, err = APIKey()
+	at.Nil(err)
+	at.Equal("inline", key)
+
+	// the file wins over the inline key, trimmed
+	Config.Set("api.key_file", file)
+	key, err = APIKey()
+	at.Nil(err)
+	at.Equal("from-file", key)
+
+	// and the environment over both
+	os.Setenv("API_KEY", "from-env")
+	key, err = APIKey()
+	at.Nil(err)
+	at.Equal("from-env", key)
+	os.Unsetenv("API_KEY")
+
+	Config.Set("api.key_file", filepath.Join(dir, "missing"))
+	_, err = APIKey()
+	at.NotNil(err)
+
+	at.Nil(ioutil.WriteFile(file, []byte("\n"), 0600))
+	Config.Set("api.key_file", file)
+	_, err = APIKey()
+	at.NotNil(err)
+}
@@ -1,7 +1,13 @@
 package configure
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/SpooderfyBot/live/utils/uid"
 
@@ -13,6 +19,9 @@ import (
 type RoomKeysType struct {
 	redisCli   *redis.Client
 	localCache *cache.Cache
+
+	saltLock sync.Mutex
+	salt     string // of the hashed keys, read from the store once
 }
 
 var RoomKeys = &RoomKeysType{
@@ -21,7 +30,118 @@ var RoomKeys = &RoomKeysType{
 
 var saveInLocal = true
 
+const (
+	defaultKeyLength = 48
+	// minKeyLength keeps the keys unguessable with the smallest charset
+	minKeyLength = 16
+)
+
+// keyCharsets are the values of roomkeys.charset, some encoders and
+// players don't take every character in a url
+var keyCharsets = map[string]string{
+	"alphanumeric": "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"lowercase":    "0123456789abcdefghijklmnopqrstuvwxyz",
+	"hex":          "0123456789abcdef",
+}
+
+// keyFormat returns roomkeys.length and the characters of roomkeys.charset,
+// an error tells which one is invalid, the defaults are returned with it
+func keyFormat() (int, string, error) {
+	length, name := Config.GetInt("roomkeys.length"), Config.GetString("roomkeys.charset")
+	if length == 0 {
+		length = defaultKeyLength
+	}
+	if name == "" {
+		name = "alphanumeric"
+	}
+	charset, ok := keyCharsets[name]
+	if !ok {
+		return defaultKeyLength, keyCharsets["alphanumeric"], fmt.Errorf("roomkeys.charset: unknown charset %q", name)
+	}
+	if length < minKeyLength {
+		return defaultKeyLength, keyCharsets["alphanumeric"], fmt.Errorf("roomkeys.length: %d is below the minimum of %d", length, minKeyLength)
+	}
+	return length, charset, nil
+}
+
+// newKey is a random key of roomkeys.length characters of roomkeys.charset
+func newKey() string {
+	length, charset, _ := keyFormat()
+	return uid.RandString(length, charset)
+}
+
+// roomPrefix marks the index of the provisioned rooms, keys and channels
+// share the store so rooms can't be told apart from keys otherwise
+const roomPrefix = "room:"
+
+const (
+	// hashPrefix marks a hashed key, stored as the key of its channel and
+	// in place of the key in the index of the channels. Generated keys
+	// never hold a colon so a hash can't be passed as a key.
+	hashPrefix = "sha256:"
+	// saltKey holds the salt of the hashed keys, made on first use
+	saltKey    = "roomkeys:salt"
+	saltLength = 32
+)
+
+// ErrKeyHashed is returned by GetKey for a room whose key is only stored
+// hashed, a new key has to be set to learn one
+var ErrKeyHashed = fmt.Errorf("the key of the room is stored hashed, reset it to get a new one")
+
+// hashKeys is roomkeys.hash, keys set while it is on are stored hashed
+func hashKeys() bool {
+	return Config.GetBool("roomkeys.hash")
+}
+
+// keySalt is the salt of the hashed keys, kept in the store so every
+// process sharing it hashes alike
+func (r *RoomKeysType) keySalt() (string, error) {
+	r.saltLock.Lock()
+	defer r.saltLock.Unlock()
+	if r.salt != "" {
+		return r.salt, nil
+	}
+
+	salt := uid.RandString(saltLength, keyCharsets["alphanumeric"])
+	if !saveInLocal {
+		if err := r.redisCli.SetNX(saltKey, salt, 0).Err(); err != nil {
+			return "", err
+		}
+		var err error
+		if salt, err = r.redisCli.Get(saltKey).Result(); err != nil {
+			return "", err
+		}
+	} else if err := r.localCache.Add(saltKey, salt, cache.NoExpiration); err != nil {
+		v, _ := r.localCache.Get(saltKey)
+		salt = v.(string)
+	}
+	r.salt = salt
+	return salt, nil
+}
+
+// hashKey is the salted hash of key as it is stored
+func (r *RoomKeysType) hashKey(key string) (string, error) {
+	salt, err := r.keySalt()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(salt + key))
+	return hashPrefix + hex.EncodeToString(sum[:]), nil
+}
+
+// storedKey is what is stored for key, its hash with roomkeys.hash
+func (r *RoomKeysType) storedKey(key string) (string, error) {
+	if !hashKeys() {
+		return key, nil
+	}
+	return r.hashKey(key)
+}
+
 func Init() {
+	if _, _, err := keyFormat(); err != nil {
+		log.Warningf("%v, using %d alphanumeric characters", err, defaultKeyLength)
+	}
+
 	saveInLocal = len(Config.GetString("redis_addr")) == 0
 	if saveInLocal {
 		return
@@ -42,17 +162,35 @@ func Init() {
 }
 
 // set/reset a random key for channel
+//
+// The previous key of the channel stops being valid right away, so any new
+// connection using it is rejected. Publishers that are already live were
+// authenticated on connect and are not affected.
 func (r *RoomKeysType) SetKey(channel string) (key string, err error) {
 	if !saveInLocal {
+		oldKey, _ := r.redisCli.Get(channel).Result()
 		for {
-			key = uid.RandStringRunes(48)
-			if _, err = r.redisCli.Get(key).Result(); err == redis.Nil {
-				err = r.redisCli.Set(channel, key, 0).Err()
+			key = newKey()
+			var stored string
+			if stored, err = r.storedKey(key); err != nil {
+				return "", err
+			}
+			if _, err = r.redisCli.Get(stored).Result(); err == redis.Nil {
+				err = r.redisCli.Set(channel, stored, 0).Err()
+				if err != nil {
+					return
+				}
+				err = r.redisCli.Set(roomPrefix+channel, 1, 0).Err()
 				if err != nil {
 					return
 				}
 
-				err = r.redisCli.Set(key, channel, 0).Err()
+				err = r.redisCli.Set(stored, channel, 0).Err()
+				if err != nil || oldKey == "" {
+					return
+				}
+
+				err = r.redisCli.Del(oldKey).Err()
 				return
 			} else if err != nil {
 				return
@@ -60,23 +198,41 @@ func (r *RoomKeysType) SetKey(channel string) (key string, err error) {
 		}
 	}
 
+	oldKey, hasOld := r.localCache.Get(channel)
 	for {
-		key = uid.RandStringRunes(48)
-		if _, found := r.localCache.Get(key); !found {
-			r.localCache.SetDefault(channel, key)
-			r.localCache.SetDefault(key, channel)
+		key = newKey()
+		stored, err := r.storedKey(key)
+		if err != nil {
+			return "", err
+		}
+		if _, found := r.localCache.Get(stored); !found {
+			r.localCache.SetDefault(channel, stored)
+			r.localCache.SetDefault(stored, channel)
+			r.localCache.SetDefault(roomPrefix+channel, true)
 			break
 		}
 	}
+	if hasOld {
+		r.localCache.Delete(oldKey.(string))
+	}
 	return
 }
 
+// GetKey returns the key of channel, a new one is set when it has none.
+// A key stored hashed is only returned by the SetKey making it, GetKey
+// then fails with ErrKeyHashed.
 func (r *RoomKeysType) GetKey(channel string) (newKey string, err error) {
 	if !saveInLocal {
 		if newKey, err = r.redisCli.Get(channel).Result(); err == redis.Nil {
 			newKey, err = r.SetKey(channel)
-			log.Debugf("[KEY] new channel [%s]: %s", channel, newKey)
+			log.Debugf("[KEY] new channel [%s]", channel)
 			return
+		} else if err == nil {
+			// indexes the rooms provisioned before Rooms existed
+			r.redisCli.SetNX(roomPrefix+channel, 1, 0)
+			if strings.HasPrefix(newKey, hashPrefix) {
+				return "", ErrKeyHashed
+			}
 		}
 
 		return
@@ -85,33 +241,98 @@ func (r *RoomKeysType) GetKey(channel string) (newKey string, err error) {
 	var key interface{}
 	var found bool
 	if key, found = r.localCache.Get(channel); found {
+		if strings.HasPrefix(key.(string), hashPrefix) {
+			return "", ErrKeyHashed
+		}
 		return key.(string), nil
 	}
 	newKey, err = r.SetKey(channel)
-	log.Debugf("[KEY] new channel [%s]: %s", channel, newKey)
+	log.Debugf("[KEY] new channel [%s]", channel)
 	return
 }
 
+// ErrNoKey is the error of FindKey for a room without a key
+var ErrNoKey = fmt.Errorf("the room has no key")
+
+// FindKey is GetKey without creating the key of a room that has none
+func (r *RoomKeysType) FindKey(channel string) (string, error) {
+	key, found, err := r.lookup(channel)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", ErrNoKey
+	}
+	if strings.HasPrefix(key, hashPrefix) {
+		return "", ErrKeyHashed
+	}
+	return key, nil
+}
+
+// GetChannel authenticates key, returning its channel. The key is looked
+// up hashed first, then as is for the keys set before roomkeys.hash, and
+// is checked against the one of the channel in constant time.
 func (r *RoomKeysType) GetChannel(key string) (channel string, err error) {
-	if !saveInLocal {
-		return r.redisCli.Get(key).Result()
+	if key == "" || strings.Contains(key, ":") {
+		return "", fmt.Errorf("invalid key")
+	}
+	hashed, err := r.hashKey(key)
+	if err != nil {
+		return "", err
+	}
+	for _, stored := range []string{hashed, key} {
+		channel, found, err := r.lookup(stored)
+		if err != nil {
+			return "", err
+		}
+		if !found || strings.HasPrefix(channel, hashPrefix) {
+			continue
+		}
+		// the index could be stale, the channel holds the current key
+		current, _, err := r.lookup(channel)
+		if err != nil {
+			return "", err
+		}
+		if subtle.ConstantTimeCompare([]byte(current), []byte(stored)) != 1 {
+			break
+		}
+		return channel, nil
 	}
+	return "", fmt.Errorf("%s does not exists", key)
+}
 
-	chann, found := r.localCache.Get(key)
-	if found {
-		return chann.(string), nil
-	} else {
-		return "", fmt.Errorf("%s does not exists", key)
+// lookup reads the value of k in the store
+func (r *RoomKeysType) lookup(k string) (string, bool, error) {
+	if !saveInLocal {
+		v, err := r.redisCli.Get(k).Result()
+		if err == redis.Nil {
+			return "", false, nil
+		}
+		return v, err == nil, err
+	}
+	v, found := r.localCache.Get(k)
+	if !found {
+		return "", false, nil
 	}
+	s, ok := v.(string)
+	return s, ok, nil
 }
 
 func (r *RoomKeysType) DeleteChannel(channel string) bool {
+	r.deleteSettings(channel)
 	if !saveInLocal {
-		return r.redisCli.Del(channel).Err() != nil
+		key, _ := r.redisCli.Get(channel).Result()
+		r.redisCli.Del(roomPrefix + channel)
+		deleted, err := r.redisCli.Del(channel).Result()
+		if deleted > 0 && err == nil && key != "" {
+			r.redisCli.Del(key)
+		}
+		return deleted > 0 && err == nil
 	}
 
 	key, ok := r.localCache.Get(channel)
 	if ok {
+		r.localCache.Delete(roomPrefix + channel)
 		r.localCache.Delete(channel)
 		r.localCache.Delete(key.(string))
 		return true
@@ -120,15 +341,45 @@ func (r *RoomKeysType) DeleteChannel(channel string) bool {
 }
 
 func (r *RoomKeysType) DeleteKey(key string) bool {
+	if _, found, _ := r.lookup(key); !found {
+		if hashed, err := r.hashKey(key); err == nil {
+			key = hashed
+		}
+	}
 	if !saveInLocal {
-		return r.redisCli.Del(key).Err() != nil
+		deleted, err := r.redisCli.Del(key).Result()
+		return deleted > 0 && err == nil
 	}
 
 	channel, ok := r.localCache.Get(key)
 	if ok {
+		r.localCache.Delete(roomPrefix + channel.(string))
 		r.localCache.Delete(channel.(string))
 		r.localCache.Delete(key)
 		return true
 	}
 	return false
 }
+
+// Rooms lists every provisioned room, sorted by name. With redis, rooms
+// created before the index existed show up once their key is read again
+func (r *RoomKeysType) Rooms() ([]string, error) {
+	rooms := []string{}
+	if !saveInLocal {
+		iter := r.redisCli.Scan(0, roomPrefix+"*", 0).Iterator()
+		for iter.Next() {
+			rooms = append(rooms, strings.TrimPrefix(iter.Val(), roomPrefix))
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	} else {
+		for k := range r.localCache.Items() {
+			if strings.HasPrefix(k, roomPrefix) {
+				rooms = append(rooms, strings.TrimPrefix(k, roomPrefix))
+			}
+		}
+	}
+	sort.Strings(rooms)
+	return rooms, nil
+}
@@ -0,0 +1,244 @@
+package configure
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyFormat(t *testing.T) {
+	at := assert.New(t)
+	defer Config.Set("roomkeys.length", 0)
+	defer Config.Set("roomkeys.charset", "")
+
+	key, err := RoomKeys.SetKey("keyformat")
+	at.Nil(err)
+	at.Len(key, defaultKeyLength)
+
+	Config.Set("roomkeys.length", 20)
+	Config.Set("roomkeys.charset", "hex")
+	for i := 0; i < 10; i++ {
+		key, err = RoomKeys.SetKey("keyformat")
+		at.Nil(err)
+		at.Len(key, 20)
+		at.Empty(strings.Trim(key, keyCharsets["hex"]), key)
+	}
+	channel, err := RoomKeys.GetChannel(key)
+	at.Nil(err)
+	at.Equal("keyformat", channel)
+
+	Config.Set("roomkeys.charset", "lowercase")
+	key, _ = RoomKeys.SetKey("keyformat")
+	at.Equal(strings.ToLower(key), key)
+
+	// too short or unknown, the defaults are used
+	Config.Set("roomkeys.length", 8)
+	_, _, err = keyFormat()
+	at.NotNil(err)
+	key, _ = RoomKeys.SetKey("keyformat")
+	at.Len(key, defaultKeyLength)
+
+	Config.Set("roomkeys.length", 20)
+	Config.Set("roomkeys.charset", "emoji")
+	_, _, err = keyFormat()
+	at.NotNil(err)
+	key, _ = RoomKeys.SetKey("keyformat")
+	at.Len(key, defaultKeyLength)
+
+	RoomKeys.DeleteChannel("keyformat")
+}
+
+func TestHashedKeys(t *testing.T) {
+	at := assert.New(t)
+	plain, err := RoomKeys.SetKey("hashed_before")
+	at.Nil(err)
+	Config.Set("roomkeys.hash", true)
+	defer Config.Set("roomkeys.hash", false)
+	defer RoomKeys.DeleteChannel("hashed")
+	defer RoomKeys.DeleteChannel("hashed_before")
+
+	// the plaintext is only told when the key is made
+	key, err := RoomKeys.GetKey("hashed")
+	at.Nil(err)
+	at.Len(key, defaultKeyLength)
+	_, err = RoomKeys.GetKey("hashed")
+	at.Equal(ErrKeyHashed, err)
+	for k, v := range RoomKeys.localCache.Items() {
+		at.NotContains(k, key)
+		if s, ok := v.Object.(string); ok {
+			at.NotContains(s, key)
+		}
+	}
+
+	channel, err := RoomKeys.GetChannel(key)
+	at.Nil(err)
+	at.Equal("hashed", channel)
+
+	// neither a wrong key, the hash nor the room name authenticate
+	_, err = RoomKeys.GetChannel(key[1:] + "x")
+	at.NotNil(err)
+	stored, _ := RoomKeys.localCache.Get("hashed")
+	_, err = RoomKeys.GetChannel(stored.(string))
+	at.NotNil(err)
+	_, err = RoomKeys.GetChannel("hashed")
+	at.NotNil(err)
+
+	// a reset tells the new key, the old one stops working
+	renewed, err := RoomKeys.SetKey("hashed")
+	at.Nil(err)
+	_, err = RoomKeys.GetChannel(key)
+	at.NotNil(err)
+	channel, err = RoomKeys.GetChannel(renewed)
+	at.Nil(err)
+	at.Equal("hashed", channel)
+
+	// the keys set before still work
+	channel, err = RoomKeys.GetChannel(plain)
+	at.Nil(err)
+	at.Equal("hashed_before", channel)
+	got, err := RoomKeys.GetKey("hashed_before")
+	at.Nil(err)
+	at.Equal(plain, got)
+}
+
+// fakeRedis serves the few commands the room keys send, over the redis
+// protocol, from a map
+type fakeRedis struct {
+	lock sync.Mutex
+	data map[string]string
+}
+
+func (f *fakeRedis) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		conn.Write([]byte(f.exec(args)))
+	}
+}
+
+// readCommand reads an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch strings.ToLower(args[0]) {
+	case "ping":
+		return "+PONG\r\n"
+	case "get":
+		v, ok := f.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(v)
+	case "set":
+		f.data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "setnx":
+		if _, ok := f.data[args[1]]; ok {
+			return ":0\r\n"
+		}
+		f.data[args[1]] = args[2]
+		return ":1\r\n"
+	case "del":
+		n := 0
+		for _, k := range args[1:] {
+			if _, ok := f.data[k]; ok {
+				delete(f.data, k)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	}
+	return "-ERR unknown command " + args[0] + "\r\n"
+}
+
+func TestDeleteChannelRedis(t *testing.T) {
+	at := assert.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer l.Close()
+	store := &fakeRedis{data: map[string]string{}}
+	go store.serve(l)
+
+	r := &RoomKeysType{
+		redisCli:   redis.NewClient(&redis.Options{Addr: l.Addr().String()}),
+		localCache: cache.New(cache.NoExpiration, 0),
+	}
+	defer r.redisCli.Close()
+	saveInLocal = false
+	defer func() { saveInLocal = true }()
+
+	key, err := r.SetKey("redis_room")
+	at.Nil(err)
+	channel, err := r.GetChannel(key)
+	at.Nil(err)
+	at.Equal("redis_room", channel)
+
+	// a delete reports whether the room had a key
+	at.True(r.DeleteChannel("redis_room"))
+	at.False(r.DeleteChannel("redis_room"))
+	_, err = r.FindKey("redis_room")
+	at.Equal(ErrNoKey, err)
+	_, err = r.GetChannel(key)
+	at.NotNil(err)
+	at.Empty(store.data[key])
+
+	key, err = r.SetKey("redis_room")
+	at.Nil(err)
+	at.True(r.DeleteKey(key))
+	at.False(r.DeleteKey(key))
+	at.True(r.DeleteChannel("redis_room"))
+}
@@ -1,10 +1,7 @@
 package configure
 
 import (
-	"bytes"
-	"encoding/json"
-	"strings"
-
+	"github.com/dgrijalva/jwt-go"
 	"github.com/kr/pretty"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
@@ -35,28 +32,266 @@ type Application struct {
 
 type Applications []Application
 
+// StaticRelay is a relay created on startup and kept alive by the server
+type StaticRelay struct {
+	App  string `mapstructure:"app"`
+	Name string `mapstructure:"name"`
+	URL  string `mapstructure:"url"`
+}
+
+type StaticRelays []StaticRelay
+
+type TLS struct {
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
+}
+
 type JWT struct {
 	Secret    string `mapstructure:"secret"`
 	Algorithm string `mapstructure:"algorithm"`
 }
+
+type CORS struct {
+	AllowedOrigins   []string `mapstructure:"allowed_origins"`
+	AllowedMethods   []string `mapstructure:"allowed_methods"`
+	AllowedHeaders   []string `mapstructure:"allowed_headers"`
+	AllowCredentials bool     `mapstructure:"allow_credentials"`
+}
+
+// Statics serves the files of dir under /statics/ of the api, disabled
+// by default. dir defaults to statics, relative to the working directory
+type Statics struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Dir     string `mapstructure:"dir"`
+}
+
+// API unix_socket is the path of a unix socket served along api_addr.
+// serve_media also serves the http-flv and hls players on the api port.
+// key_file is a file holding the api key, read when API_KEY is unset and
+// preferred over key. control, stats and media limit their routes to the
+// clients of their allowed_cidrs, X-Forwarded-For is only believed from
+// trusted_proxies. test_endpoints serves /control/test-publish.
+// trace_requests tags the requests with an X-Request-ID logged along them.
+// log_level is the level of the access log lines.
+type API struct {
+	CORS           CORS     `mapstructure:"cors"`
+	Statics        Statics  `mapstructure:"statics"`
+	UnixSocket     string   `mapstructure:"unix_socket"`
+	ServeMedia     bool     `mapstructure:"serve_media"`
+	Key            string   `mapstructure:"key"`
+	KeyFile        string   `mapstructure:"key_file"`
+	Control        APIACL   `mapstructure:"control"`
+	Stats          APIACL   `mapstructure:"stats"`
+	Media          APIACL   `mapstructure:"media"`
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	TestEndpoints  bool     `mapstructure:"test_endpoints"`
+	TraceRequests  bool     `mapstructure:"trace_requests"`
+	LogLevel       string   `mapstructure:"log_level"`
+}
+
+// APIACL allowed_cidrs are the CIDRs or IPs allowed on a group of api
+// routes, empty allows any
+type APIACL struct {
+	AllowedCIDRs []string `mapstructure:"allowed_cidrs"`
+}
+
+// RateLimit is in requests per second per client address, behind the
+// api.trusted_proxies the forwarded one, 0 means unlimited
+type RateLimit struct {
+	ControlRate  float64 `mapstructure:"control_rate"`
+	ControlBurst int     `mapstructure:"control_burst"`
+	StatsRate    float64 `mapstructure:"stats_rate"`
+	StatsBurst   int     `mapstructure:"stats_burst"`
+}
+
+// HLS segment_duration is in ms, window_size is the number of segments
+// listed in the playlist, 0 keeps the defaults (3000ms, 3 segments).
+// dvr_window is the ms of past segments kept for dvr.m3u8, 0 disables it.
+// groups maps a group to the APP/ROOM renditions of /hls/GROUP/master.m3u8.
+// align_segments cuts segments on the key frames crossing a multiple of
+// segment_duration of the publisher timestamps, lining up the renditions.
+// block_timeout is the ms a playlist request with _HLS_msn waits for that
+// segment before the current playlist is served, 0 is three segment
+// durations. persist also writes the segments to disk.
+type HLS struct {
+	SegmentDuration int                 `mapstructure:"segment_duration"`
+	WindowSize      int                 `mapstructure:"window_size"`
+	DVRWindow       int                 `mapstructure:"dvr_window"`
+	Groups          map[string][]string `mapstructure:"groups"`
+	AlignSegments   bool                `mapstructure:"align_segments"`
+	BlockTimeout    int                 `mapstructure:"block_timeout"`
+	Persist         HLSPersist          `mapstructure:"persist"`
+}
+
+// HLSPersist dir is where the segments are written, at their url path,
+// empty keeps them in memory only. A failed write is retried retries
+// times (default 3) waiting backoff ms (default 100) doubling each time,
+// then the stream is served from memory only.
+type HLSPersist struct {
+	Dir     string `mapstructure:"dir"`
+	Retries int    `mapstructure:"retries"`
+	Backoff int    `mapstructure:"backoff"`
+}
+
+// Hooks on_segment is a url POSTed a json description of each finalized
+// hls segment, workers is the older name of workers.size
+type Hooks struct {
+	OnSegment string `mapstructure:"on_segment"`
+	Workers   int    `mapstructure:"workers"`
+}
+
+// Workers is the pool the webhooks and relay retries run on, size is its
+// number of goroutines (default 4) and queue_len the jobs each one queues
+// (default 256) before new ones are dropped
+type Workers struct {
+	Size     int `mapstructure:"size"`
+	QueueLen int `mapstructure:"queue_len"`
+}
+
+// WriteBuffer is the packet queue of each rtmp player, size is its length
+// in packets (default 1024), lag_threshold the percent of it from which a
+// player is reported lagging (default 75) and drop_on_lag drops frames of a
+// lagging player instead of waiting for its queue to fill up
+type WriteBuffer struct {
+	Size         int  `mapstructure:"size"`
+	LagThreshold int  `mapstructure:"lag_threshold"`
+	DropOnLag    bool `mapstructure:"drop_on_lag"`
+}
+
+// Relay allowed_hosts limits the hosts /control/push and /control/pull
+// may connect to, empty allows any host. start_timeout is the seconds a
+// relay may take to connect both ends, 0 keeps the default of 10. Every
+// reap_interval seconds (default 60) the relays of the api stopped for
+// longer than reap_grace seconds (default 300) are removed.
+type Relay struct {
+	AllowedHosts []string `mapstructure:"allowed_hosts"`
+	StartTimeout int      `mapstructure:"start_timeout"`
+	ReapInterval float64  `mapstructure:"reap_interval"`
+	ReapGrace    float64  `mapstructure:"reap_grace"`
+}
+
+// RTMP handshake_timeout is the ms a connection has to complete the
+// handshake and its connect and publish or play commands (default 10000).
+// An IP failing ban_threshold times within ban_time seconds is refused
+// for ban_time seconds, a ban_threshold of 0 bans no one. A publisher
+// above max_publish_bitrate_kbps for max_publish_bitrate_window seconds
+// (default 10) is disconnected, 0 is unlimited. The players of a publisher
+// whose connection drops wait publish_grace seconds for it to come back.
+// unix_socket is the path of a unix socket served along rtmp_addr, its
+// clients are never banned. A message longer than max_message_size bytes
+// (default 8MiB) closes the connection before it is allocated.
+// allowed_connect_hosts limits the hosts of the tcUrl of a connect, a
+// client connecting to another one is refused, empty accepts any.
+// duplicate_publish is what a publisher connecting to a live room gets:
+// reject_new (default) closes it, reject_new_with_status also sends it an
+// onStatus error first and replace_old closes the live publisher instead.
+// maintenance refuses the new publishers and players, a reload with it set
+// turns the mode on and only /control/maintenance?oper=off turns it off.
+// chunk_size is the size of the chunks sent to the clients (default 128).
+// max_players_per_stream caps the players of each stream, 0 is unlimited.
+// tls serves RTMPS with its cert and key instead of RTMP.
+type RTMP struct {
+	TLS                     TLS      `mapstructure:"tls"`
+	ChunkSize               int      `mapstructure:"chunk_size"`
+	MaxPlayersPerStream     int      `mapstructure:"max_players_per_stream"`
+	HandshakeTimeout        int      `mapstructure:"handshake_timeout"`
+	BanThreshold            int      `mapstructure:"ban_threshold"`
+	BanTime                 int      `mapstructure:"ban_time"`
+	MaxPublishBitrate       int      `mapstructure:"max_publish_bitrate_kbps"`
+	MaxPublishBitrateWindow int      `mapstructure:"max_publish_bitrate_window"`
+	PublishGrace            float64  `mapstructure:"publish_grace"`
+	UnixSocket              string   `mapstructure:"unix_socket"`
+	MaxMessageSize          int      `mapstructure:"max_message_size"`
+	AllowedConnectHosts     []string `mapstructure:"allowed_connect_hosts"`
+	Aliases                 []Alias  `mapstructure:"aliases"`
+	DuplicatePublish        string   `mapstructure:"duplicate_publish"`
+	MaxStreams              int      `mapstructure:"max_streams"`
+	MaxConnections          int      `mapstructure:"max_connections"`
+	Maintenance             bool     `mapstructure:"maintenance"`
+}
+
+// Alias serves the players of the from app/room with the stream of the
+// to app/room, publishing to from is refused
+type Alias struct {
+	From string `mapstructure:"from" json:"from"`
+	To   string `mapstructure:"to" json:"to"`
+}
+
+// RoomKeysCfg length is the characters of the generated stream keys (at
+// least 16, default 48), charset picks them from alphanumeric (default),
+// lowercase or hex. hash stores the keys set from then on as salted
+// hashes, /control/get can't return them afterwards.
+type RoomKeysCfg struct {
+	Length  int    `mapstructure:"length"`
+	Charset string `mapstructure:"charset"`
+	Hash    bool   `mapstructure:"hash"`
+}
+
+// Record rolls the flv recordings on the first key frame past
+// segment_duration seconds or segment_size bytes, 0 for both keeps one
+// file per publish
+type Record struct {
+	SegmentDuration int   `mapstructure:"segment_duration"`
+	SegmentSize     int64 `mapstructure:"segment_size"`
+}
+
+// PlaybackCfg mode is a preset of the gop cache, player queues and
+// flushes: low_latency, smooth, or custom (default) to use gop_num and
+// write_buffer.
+type PlaybackCfg struct {
+	Mode string `mapstructure:"mode"`
+}
+
+// LogSample thins out the debug lines logged per packet or connection,
+// keep of every every lines of each is logged, all when every is 0.
+type LogSample struct {
+	Keep  int `mapstructure:"keep"`
+	Every int `mapstructure:"every"`
+}
+
+type DASH struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
 type ServerCfg struct {
 	Level           string       `mapstructure:"level"`
+	LogSample       LogSample    `mapstructure:"log_sample"`
 	ConfigFile      string       `mapstructure:"config_file"`
 	FLVArchive      bool         `mapstructure:"flv_archive"`
 	FLVDir          string       `mapstructure:"flv_dir"`
+	FLVVOD          bool         `mapstructure:"flv_vod"`
+	Record          Record       `mapstructure:"record"`
 	RTMPNoAuth      bool         `mapstructure:"rtmp_noauth"`
 	RTMPAddr        string       `mapstructure:"rtmp_addr"`
+	RTMP            RTMP         `mapstructure:"rtmp"`
+	WriteBuffer     WriteBuffer  `mapstructure:"write_buffer"`
+	Playback        PlaybackCfg  `mapstructure:"playback"`
 	HTTPFLVAddr     string       `mapstructure:"httpflv_addr"`
+	HTTPFLVMaxRate  int          `mapstructure:"httpflv_maxrate"`
 	HLSAddr         string       `mapstructure:"hls_addr"`
 	HLSKeepAfterEnd bool         `mapstructure:"hls_keep_after_end"`
+	HLS             HLS          `mapstructure:"hls"`
+	DASH            DASH         `mapstructure:"dash"`
+	Hooks           Hooks        `mapstructure:"hooks"`
+	Workers         Workers      `mapstructure:"workers"`
+	WebRTCAddr      string       `mapstructure:"webrtc_addr"`
 	APIAddr         string       `mapstructure:"api_addr"`
 	RedisAddr       string       `mapstructure:"redis_addr"`
 	RedisPwd        string       `mapstructure:"redis_pwd"`
 	ReadTimeout     int          `mapstructure:"read_timeout"`
 	WriteTimeout    int          `mapstructure:"write_timeout"`
 	GopNum          int          `mapstructure:"gop_num"`
+	EventHistory    int          `mapstructure:"event_history_size"`
+	EventTTL        int          `mapstructure:"event_history_ttl"`
+	EventLogDebug   bool         `mapstructure:"event_log_debug"`
+	RoomKeys        RoomKeysCfg  `mapstructure:"roomkeys"`
 	JWT             JWT          `mapstructure:"jwt"`
+	RateLimit       RateLimit    `mapstructure:"rate_limit"`
+	API             API          `mapstructure:"api"`
+	Relay           Relay        `mapstructure:"relay"`
 	Server          Applications `mapstructure:"server"`
+	StaticPush      StaticRelays `mapstructure:"static_push"`
+	StaticPull      StaticRelays `mapstructure:"static_pull"`
 }
 
 // default config
@@ -65,13 +300,18 @@ var defaultConf = ServerCfg{
 	FLVArchive:      false,
 	RTMPNoAuth:      false,
 	RTMPAddr:        ":1935",
+	RTMP:            RTMP{ChunkSize: 128},
 	HTTPFLVAddr:     ":7001",
 	HLSAddr:         ":7002",
 	HLSKeepAfterEnd: false,
+	WebRTCAddr:      ":7003",
 	APIAddr:         ":8090",
+	API:             API{LogLevel: "info"},
 	WriteTimeout:    10,
 	ReadTimeout:     10,
 	GopNum:          1,
+	EventHistory:    64,
+	EventLogDebug:   false,
 	Server: Applications{{
 		Appname:    "live",
 		Live:       true,
@@ -82,10 +322,14 @@ var defaultConf = ServerCfg{
 	}},
 }
 
-var Config = viper.New()
+var Config = &Store{v: viper.New()}
 
 func initLog() {
-	if l, err := log.ParseLevel(Config.GetString("level")); err == nil {
+	level := Config.GetString("level")
+	if levelOverride != "" {
+		level = levelOverride
+	}
+	if l, err := log.ParseLevel(level); err == nil {
 		log.SetLevel(l)
 		log.SetReportCaller(l == log.DebugLevel)
 	}
@@ -94,18 +338,15 @@ func initLog() {
 func init() {
 	defer Init()
 
-	// Default config
-	b, _ := json.Marshal(defaultConf)
-	defaultConfig := bytes.NewReader(b)
-	viper.SetConfigType("json")
-	viper.ReadConfig(defaultConfig)
-	Config.MergeConfigMap(viper.AllSettings())
-
 	// Flags
 	pflag.String("rtmp_addr", ":1935", "RTMP server listen address")
+	pflag.Int("rtmp.chunk_size", 128, "RTMP output chunk size")
 	pflag.String("httpflv_addr", ":7001", "HTTP-FLV server listen address")
+	pflag.Int("httpflv_maxrate", 0, "HTTP-FLV max rate per player in kbit/s, 0 is unlimited")
 	pflag.String("hls_addr", ":7002", "HLS server listen address")
+	pflag.String("webrtc_addr", ":7003", "WebRTC (WHIP/WHEP) server listen address, needs the webrtc build tag")
 	pflag.String("api_addr", ":8090", "HTTP manage interface server listen address")
+	pflag.String("api.log_level", "info", "Log level of the HTTP manage interface access log")
 	pflag.String("config_file", "livego.yaml", "configure filename")
 	pflag.String("level", "info", "Log level")
 	pflag.Bool("hls_keep_after_end", false, "Maintains the HLS after the stream ends")
@@ -113,25 +354,19 @@ func init() {
 	pflag.Int("read_timeout", 10, "read time out")
 	pflag.Int("write_timeout", 10, "write time out")
 	pflag.Int("gop_num", 1, "gop num")
+	pflag.Int("rtmp.max_players_per_stream", 0, "max players per stream, 0 is unlimited")
+	pflag.Int("event_history_size", 64, "number of events kept per stream")
 	pflag.Parse()
-	Config.BindPFlags(pflag.CommandLine)
 
-	// File
-	Config.SetConfigFile(Config.GetString("config_file"))
-	Config.AddConfigPath(".")
-	err := Config.ReadInConfig()
+	// Defaults, flags, file and environment
+	flags := viper.New()
+	flags.BindPFlags(pflag.CommandLine)
+	settings, err := readFile(flags.GetString("config_file"))
 	if err != nil {
 		log.Warning(err)
 		log.Info("Using default config")
-	} else {
-		Config.MergeInConfig()
 	}
-
-	// Environment
-	replacer := strings.NewReplacer(".", "_")
-	Config.SetEnvKeyReplacer(replacer)
-	Config.AllowEmptyEnv(true)
-	Config.AutomaticEnv()
+	Config.swap(load(settings))
 
 	// Log
 	initLog()
@@ -167,3 +402,12 @@ func GetStaticPushUrlList(appname string) ([]string, bool) {
 	}
 	return nil, false
 }
+
+// JWTSigningMethod is the jwt.algorithm tokens are signed with, HS256
+// when unset or unknown
+func JWTSigningMethod() jwt.SigningMethod {
+	if m := jwt.GetSigningMethod(Config.GetString("jwt.algorithm")); m != nil {
+		return m
+	}
+	return jwt.SigningMethodHS256
+}
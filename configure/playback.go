@@ -0,0 +1,53 @@
+package configure
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// playback.mode presets
+const (
+	PlaybackCustom     = "custom"
+	PlaybackLowLatency = "low_latency"
+	PlaybackSmooth     = "smooth"
+)
+
+// Playback is how the streams are buffered for their players. A
+// playback.mode preset sets all of it, without one gop_num and the
+// write_buffer settings apply.
+type Playback struct {
+	Mode string
+	// GopNum is the gops cached for the players joining a stream
+	GopNum int
+	// QueueSize is the packet queue of each rtmp player, 0 is the default
+	QueueSize int
+	DropOnLag bool
+	// FlushEach flushes a player after each packet, else only once its
+	// queue is empty
+	FlushEach bool
+}
+
+var playbackPresets = map[string]Playback{
+	// players start on the last key frame and are never far behind it
+	PlaybackLowLatency: {Mode: PlaybackLowLatency, GopNum: 1, QueueSize: 256, DropOnLag: true, FlushEach: true},
+	// players start further back and ride out network hiccups
+	PlaybackSmooth: {Mode: PlaybackSmooth, GopNum: 2, QueueSize: 4096, DropOnLag: false, FlushEach: false},
+}
+
+// PlaybackSettings is the playback of playback.mode, or the one of the
+// separate settings when it is unset or unknown
+func PlaybackSettings() Playback {
+	mode := Config.GetString("playback.mode")
+	if p, ok := playbackPresets[mode]; ok {
+		return p
+	}
+	if mode != "" && mode != PlaybackCustom {
+		log.Warningf("unknown playback.mode %q, using gop_num and write_buffer", mode)
+	}
+	return Playback{
+		Mode:      PlaybackCustom,
+		GopNum:    Config.GetInt("gop_num"),
+		QueueSize: Config.GetInt("write_buffer.size"),
+		DropOnLag: Config.GetBool("write_buffer.drop_on_lag"),
+		FlushEach: true,
+	}
+}
@@ -0,0 +1,36 @@
+package configure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaybackSettings(t *testing.T) {
+	at := assert.New(t)
+	defer Config.Set("playback.mode", "")
+
+	Config.Set("write_buffer.size", 512)
+	defer Config.Set("write_buffer.size", 0)
+	p := PlaybackSettings()
+	at.Equal(PlaybackCustom, p.Mode)
+	at.Equal(Config.GetInt("gop_num"), p.GopNum)
+	at.Equal(512, p.QueueSize)
+	at.True(p.FlushEach)
+
+	// a preset wins over the separate settings
+	Config.Set("playback.mode", PlaybackLowLatency)
+	p = PlaybackSettings()
+	at.Equal(PlaybackLowLatency, p.Mode)
+	at.Equal(256, p.QueueSize)
+	at.True(p.DropOnLag)
+
+	Config.Set("playback.mode", PlaybackSmooth)
+	p = PlaybackSettings()
+	at.Equal(2, p.GopNum)
+	at.False(p.DropOnLag)
+	at.False(p.FlushEach)
+
+	Config.Set("playback.mode", "fast")
+	at.Equal(PlaybackCustom, PlaybackSettings().Mode)
+}
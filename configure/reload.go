@@ -0,0 +1,197 @@
+package configure
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// restartOnly are the settings read once when the listeners start, a
+// reload leaves them as they are
+var restartOnly = []string{
+	"rtmp_addr",
+	"rtmp.tls",
+	"httpflv_addr",
+	"hls_addr",
+	"webrtc_addr",
+	"api_addr",
+	"redis_addr",
+	"redis_pwd",
+	"server",
+	"workers",
+}
+
+// secretKeys are the settings, or parts of their name, whose values a
+// reload never reports
+var secretKeys = []string{"secret", "pwd", "password", "api.key"}
+
+// redacted replaces the value of a secret setting in a ReloadResult
+const redacted = "<redacted>"
+
+// ConfigChange is a setting that differs between the running config and
+// the file
+type ConfigChange struct {
+	Key string      `json:"key"`
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// ReloadResult lists the settings a reload applied and the ones left as
+// they are until restart
+type ReloadResult struct {
+	Changed  []ConfigChange `json:"changed"`
+	Deferred []ConfigChange `json:"deferred"`
+	Level    string         `json:"level"` // the log level in effect
+}
+
+var (
+	reloadLock  sync.Mutex
+	reloadHooks []func()
+	// levelOverride is the log level of the last reload asking for one,
+	// it wins over the level of the file
+	levelOverride string
+)
+
+// OnReload registers fn to run after every Reload, for the settings that
+// are copied somewhere when the server starts
+func OnReload(fn func()) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	reloadHooks = append(reloadHooks, fn)
+}
+
+// Reload reads the config file again and applies what can change while
+// running: log level, rate limits, hooks, quotas, cors... Most settings are
+// read when they are used so they need nothing more, the others register
+// with OnReload. Listen addresses and the like are ignored until restart,
+// a setting taken out of the file goes back to its default.
+func Reload() error {
+	_, err := ReloadChanges("")
+	return err
+}
+
+// ReloadChanges is Reload, it also reports what changed. A level raises
+// or lowers the log level until the next reload, empty to use the one of
+// the file.
+func ReloadChanges(level string) (*ReloadResult, error) {
+	if level != "" {
+		if _, err := log.ParseLevel(level); err != nil {
+			return nil, err
+		}
+	}
+
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	file := Config.GetString("config_file")
+	settings, err := readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// the settings read once are left out of the new config and keep the
+	// value they run with, what the file no longer sets goes back to its
+	// default
+	fromFile := viper.New()
+	fromFile.MergeConfigMap(settings)
+	for _, key := range restartOnly {
+		deleteKey(settings, key)
+	}
+	next := load(settings)
+	next.Set("config_file", file)
+
+	Config.lock.RLock()
+	running := Config.v
+	keys := map[string]bool{}
+	for _, key := range append(append(running.AllKeys(), next.AllKeys()...), fromFile.AllKeys()...) {
+		keys[key] = true
+	}
+	result := &ReloadResult{Changed: []ConfigChange{}, Deferred: []ConfigChange{}}
+	for key := range keys {
+		old, cur := running.Get(key), next.Get(key)
+		restart := isRestartOnly(key)
+		if restart {
+			if running.IsSet(key) {
+				next.Set(key, old)
+			}
+			if !fromFile.IsSet(key) {
+				continue
+			}
+			cur = fromFile.Get(key)
+		}
+		if fmt.Sprint(old) == fmt.Sprint(cur) {
+			continue
+		}
+		change := ConfigChange{Key: key, Old: old, New: cur}
+		if isSecret(key) {
+			change.Old, change.New = redacted, redacted
+		}
+		if restart {
+			log.Warningf("Config %s changed, ignored until restart", key)
+			result.Deferred = append(result.Deferred, change)
+		} else {
+			result.Changed = append(result.Changed, change)
+		}
+	}
+	Config.lock.RUnlock()
+	sortChanges(result.Changed)
+	sortChanges(result.Deferred)
+	Config.swap(next)
+
+	levelOverride = level
+	initLog()
+	for _, fn := range reloadHooks {
+		fn()
+	}
+	result.Level = log.GetLevel().String()
+	log.Info("Config reloaded from ", file)
+	return result, nil
+}
+
+// isRestartOnly tells if key is one of restartOnly or nested in one
+func isRestartOnly(key string) bool {
+	for _, k := range restartOnly {
+		if key == k || strings.HasPrefix(key, k+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteKey removes the dotted key from the nested settings, the maps on
+// its path are copied first as the viper of the file shares them
+func deleteKey(settings map[string]interface{}, key string) {
+	path := strings.Split(key, ".")
+	for _, k := range path[:len(path)-1] {
+		next, ok := settings[k].(map[string]interface{})
+		if !ok {
+			return
+		}
+		copied := make(map[string]interface{}, len(next))
+		for k, v := range next {
+			copied[k] = v
+		}
+		settings[k] = copied
+		settings = copied
+	}
+	delete(settings, path[len(path)-1])
+}
+
+func sortChanges(changes []ConfigChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+}
+
+func isSecret(key string) bool {
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package configure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	at := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "livego-reload")
+	at.Nil(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "livego.yaml")
+
+	oldFile, oldLevel := Config.GetString("config_file"), log.GetLevel()
+	Config.Set("config_file", file)
+	defer func() {
+		Config.Set("config_file", oldFile)
+		log.SetLevel(oldLevel)
+	}()
+
+	at.Nil(ioutil.WriteFile(file, []byte("level: info\n"), 0644))
+	at.Nil(Reload())
+	at.Equal(log.InfoLevel, log.GetLevel())
+
+	reloaded := 0
+	OnReload(func() { reloaded++ })
+
+	rtmpAddr := Config.GetString("rtmp_addr")
+	at.Nil(ioutil.WriteFile(file, []byte("level: debug\nrtmp_addr: \":19350\"\nrtmp:\n  max_streams: 3\n  tls:\n    cert: reload.crt\n"), 0644))
+	result, err := ReloadChanges("")
+	at.Nil(err)
+	at.Equal(log.DebugLevel, log.GetLevel())
+	at.Equal("debug", Config.GetString("level"))
+	at.Equal(rtmpAddr, Config.GetString("rtmp_addr"))
+	at.Equal(1, reloaded)
+	// the listener keeps its certificate, the other rtmp settings apply
+	at.Equal("", Config.GetString("rtmp.tls.cert"))
+	at.Equal(3, Config.GetInt("rtmp.max_streams"))
+	at.Contains(result.Deferred, ConfigChange{Key: "rtmp.tls.cert", Old: "", New: "reload.crt"})
+
+	at.Nil(ioutil.WriteFile(file, []byte("level: warn\n"), 0644))
+	at.Nil(Reload())
+	at.Equal(log.WarnLevel, log.GetLevel())
+	at.Equal(2, reloaded)
+
+	at.Nil(os.Remove(file))
+	at.NotNil(Reload())
+	at.Equal(log.WarnLevel, log.GetLevel())
+}
+
+func TestReloadWhileReading(t *testing.T) {
+	at := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "livego-reload")
+	at.Nil(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "livego.yaml")
+
+	oldFile, oldLevel := Config.GetString("config_file"), log.GetLevel()
+	Config.Set("config_file", file)
+	defer func() {
+		Config.Set("config_file", oldFile)
+		log.SetLevel(oldLevel)
+	}()
+
+	// the streams read their settings as they go, run with -race
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				Config.GetInt("rtmp.publish_grace")
+				Config.GetString("level")
+				CheckAppName("live")
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		at.Nil(ioutil.WriteFile(file, []byte(fmt.Sprintf("level: info\nrtmp:\n  publish_grace: %d\n", i)), 0644))
+		at.Nil(Reload())
+		at.Equal(i, Config.GetInt("rtmp.publish_grace"))
+	}
+	close(done)
+	wg.Wait()
+
+	// a setting taken out of the file goes back to its default
+	at.Nil(ioutil.WriteFile(file, []byte("level: info\n"), 0644))
+	result, err := ReloadChanges("")
+	at.Nil(err)
+	at.False(Config.IsSet("rtmp.publish_grace"))
+	at.Equal(0, Config.GetInt("rtmp.publish_grace"))
+	if at.Len(result.Changed, 1) {
+		at.Equal("rtmp.publish_grace", result.Changed[0].Key)
+		at.Nil(result.Changed[0].New)
+	}
+	at.True(CheckAppName("live"), "the default apps stay")
+}
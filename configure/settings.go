@@ -0,0 +1,75 @@
+package configure
+
+import (
+	"encoding/json"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const settingsPrefix = "settings:"
+
+// RoomSettings are the per room overrides of the server config, consulted
+// when a publisher connects. A nil field keeps the server default
+type RoomSettings struct {
+	HlsEnabled *bool `json:"hls_enabled"`
+	FlvEnabled *bool `json:"flv_enabled"`
+	Record     *bool `json:"record"`
+}
+
+func (s RoomSettings) HLS() bool {
+	return s.HlsEnabled == nil || *s.HlsEnabled
+}
+
+func (s RoomSettings) FLV() bool {
+	return s.FlvEnabled == nil || *s.FlvEnabled
+}
+
+// Archive defaults to flv_archive
+func (s RoomSettings) Archive() bool {
+	if s.Record == nil {
+		return Config.GetBool("flv_archive")
+	}
+	return *s.Record
+}
+
+// GetSettings returns the settings of channel, a channel without
+// settings gets the zero value
+func (r *RoomKeysType) GetSettings(channel string) (settings RoomSettings, err error) {
+	if !saveInLocal {
+		var b []byte
+		b, err = r.redisCli.Get(settingsPrefix + channel).Bytes()
+		if err == redis.Nil {
+			return settings, nil
+		} else if err != nil {
+			return
+		}
+		err = json.Unmarshal(b, &settings)
+		return
+	}
+
+	if v, found := r.localCache.Get(settingsPrefix + channel); found {
+		settings = v.(RoomSettings)
+	}
+	return
+}
+
+func (r *RoomKeysType) SetSettings(channel string, settings RoomSettings) error {
+	if !saveInLocal {
+		b, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		return r.redisCli.Set(settingsPrefix+channel, b, 0).Err()
+	}
+
+	r.localCache.SetDefault(settingsPrefix+channel, settings)
+	return nil
+}
+
+func (r *RoomKeysType) deleteSettings(channel string) {
+	if !saveInLocal {
+		r.redisCli.Del(settingsPrefix + channel)
+		return
+	}
+	r.localCache.Delete(settingsPrefix + channel)
+}
@@ -0,0 +1,136 @@
+package configure
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Store is the running config. Viper is not safe for concurrent use, so
+// every read goes through the lock and a reload builds a new viper from
+// the file and swaps it in whole instead of changing the one in use.
+type Store struct {
+	lock sync.RWMutex
+	v    *viper.Viper
+}
+
+// readFile reads the settings of the config file
+func readFile(file string) (map[string]interface{}, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return v.AllSettings(), nil
+}
+
+// load builds a viper from the defaults, the flags, the settings of the
+// file and the environment, each one over the ones before
+func load(settings map[string]interface{}) *viper.Viper {
+	v := viper.New()
+	b, _ := json.Marshal(defaultConf)
+	defaults := viper.New()
+	defaults.SetConfigType("json")
+	defaults.ReadConfig(bytes.NewReader(b))
+	v.MergeConfigMap(defaults.AllSettings())
+
+	v.BindPFlags(pflag.CommandLine)
+	v.MergeConfigMap(settings)
+
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AllowEmptyEnv(true)
+	v.AutomaticEnv()
+	return v
+}
+
+// swap makes v the running config and returns the one it replaces
+func (s *Store) swap(v *viper.Viper) *viper.Viper {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	old := s.v
+	s.v = v
+	return old
+}
+
+func (s *Store) Get(key string) interface{} {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.v.Get(key)
+}
+
+func (s *Store) GetString(key string) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.v.GetString(key)
+}
+
+func (s *Store) GetBool(key string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.v.GetBool(key)
+}
+
+func (s *Store) GetInt(key string) int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.v.GetInt(key)
+}
+
+func (s *Store) GetInt64(key string) int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.v.GetInt64(key)
+}
+
+func (s *Store) GetUint64(key string) uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.v.GetUint64(key)
+}
+
+func (s *Store) GetFloat64(key string) float64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.v.GetFloat64(key)
+}
+
+func (s *Store) GetStringSlice(key string) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.v.GetStringSlice(key)
+}
+
+func (s *Store) GetStringMapStringSlice(key string) map[string][]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.v.GetStringMapStringSlice(key)
+}
+
+func (s *Store) IsSet(key string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.v.IsSet(key)
+}
+
+func (s *Store) Unmarshal(rawVal interface{}) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.v.Unmarshal(rawVal)
+}
+
+func (s *Store) UnmarshalKey(key string, rawVal interface{}) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.v.UnmarshalKey(key, rawVal)
+}
+
+// Set overrides key until the next reload, the tests use it
+func (s *Store) Set(key string, value interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.v.Set(key, value)
+}
@@ -0,0 +1,21 @@
+package flv
+
+import "github.com/SpooderfyBot/live/av"
+
+const aacSoundHeader = av.SOUND_AAC<<4 | av.SOUND_44Khz<<2 | av.SOUND_16BIT<<1 | av.SOUND_STEREO
+
+// NewAACSeqHeader builds the data of an aac sequence header audio tag
+// from an AudioSpecificConfig
+func NewAACSeqHeader(config []byte) []byte {
+	b := make([]byte, 0, 2+len(config))
+	b = append(b, aacSoundHeader, av.AAC_SEQHDR)
+	return append(b, config...)
+}
+
+// NewAACRaw builds the data of an aac raw audio tag from a frame
+// without its adts header
+func NewAACRaw(frame []byte) []byte {
+	b := make([]byte, 0, 2+len(frame))
+	b = append(b, aacSoundHeader, av.AAC_RAW)
+	return append(b, frame...)
+}
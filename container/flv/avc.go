@@ -0,0 +1,48 @@
+package flv
+
+import (
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/utils/pio"
+)
+
+// NewAVCSeqHeader builds the data of an avc sequence header video tag
+// (AVCDecoderConfigurationRecord) from a sps and a pps without start codes
+func NewAVCSeqHeader(sps, pps []byte) []byte {
+	if len(sps) < 4 {
+		return nil
+	}
+	b := make([]byte, 0, 16+len(sps)+len(pps))
+	b = append(b, av.FRAME_KEY<<4|av.VIDEO_H264, av.AVC_SEQHDR, 0, 0, 0)
+	// version, profile, compatibility, level, 4 bytes nalu length
+	b = append(b, 0x01, sps[1], sps[2], sps[3], 0xff)
+	b = append(b, 0xe1, 0, 0)
+	pio.PutU16BE(b[len(b)-2:], uint16(len(sps)))
+	b = append(b, sps...)
+	b = append(b, 0x01, 0, 0)
+	pio.PutU16BE(b[len(b)-2:], uint16(len(pps)))
+	b = append(b, pps...)
+	return b
+}
+
+// NewAVCNALU builds the data of an avc nalu video tag from nalus
+// without start codes, each one is prefixed with its 4 bytes length
+func NewAVCNALU(nalus [][]byte, keyFrame bool, compositionTime int32) []byte {
+	size := 5
+	for _, nalu := range nalus {
+		size += 4 + len(nalu)
+	}
+	b := make([]byte, 5, size)
+	frameType := uint8(av.FRAME_INTER)
+	if keyFrame {
+		frameType = av.FRAME_KEY
+	}
+	b[0] = frameType<<4 | av.VIDEO_H264
+	b[1] = av.AVC_NALU
+	pio.PutI24BE(b[2:5], compositionTime)
+	for _, nalu := range nalus {
+		b = append(b, 0, 0, 0, 0)
+		pio.PutU32BE(b[len(b)-4:], uint32(len(nalu)))
+		b = append(b, nalu...)
+	}
+	return b
+}
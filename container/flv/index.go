@@ -0,0 +1,113 @@
+package flv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/utils/pio"
+)
+
+// fileHeaderLen is the flv header and the first previous tag size
+const fileHeaderLen = 13
+
+var ErrNotFLV = fmt.Errorf("not an flv file")
+
+// Range is a span of bytes of a file
+type Range struct {
+	Offset int64
+	Len    int64
+}
+
+// KeyFrame is a video key frame tag of a file
+type KeyFrame struct {
+	Time   uint32 // ms
+	Offset int64
+}
+
+// Index locates the key frames of an flv file, along with the tags a
+// player needs before the first frame it is sent
+type Index struct {
+	Preamble  []Range // file header, metadata and sequence header tags
+	KeyFrames []KeyFrame
+	Size      int64
+}
+
+// BuildIndex reads the tag headers of an flv file, a file still being
+// written ends on its last complete tag
+func BuildIndex(r io.Reader) (*Index, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, fileHeaderLen)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:3]) != "FLV" {
+		return nil, ErrNotFLV
+	}
+
+	idx := &Index{
+		Preamble: []Range{{Offset: 0, Len: fileHeaderLen}},
+		Size:     fileHeaderLen,
+	}
+	tag := make([]byte, headerLen+2)
+	for {
+		if _, err := io.ReadFull(br, tag[:headerLen]); err != nil {
+			break
+		}
+		typeID := tag[0]
+		dataLen := int64(pio.U24BE(tag[1:4]))
+		timestamp := pio.U24BE(tag[4:7]) | uint32(tag[7])<<24
+		tagLen := headerLen + dataLen + 4
+
+		// the first two bytes of the data tell frames from sequence headers
+		peek := int64(2)
+		if dataLen < peek {
+			peek = dataLen
+		}
+		if _, err := io.ReadFull(br, tag[headerLen:headerLen+peek]); err != nil {
+			break
+		}
+		if _, err := br.Discard(int(tagLen - headerLen - peek)); err != nil {
+			break
+		}
+		data := tag[headerLen : headerLen+peek]
+
+		switch {
+		case typeID == av.TAG_SCRIPTDATAAMF0 || isSeqHeader(typeID, data):
+			if len(idx.KeyFrames) == 0 {
+				idx.Preamble = append(idx.Preamble, Range{Offset: idx.Size, Len: tagLen})
+			}
+		case typeID == av.TAG_VIDEO && len(data) > 0 && data[0]>>4 == av.FRAME_KEY:
+			idx.KeyFrames = append(idx.KeyFrames, KeyFrame{Time: timestamp, Offset: idx.Size})
+		}
+		idx.Size += tagLen
+	}
+	return idx, nil
+}
+
+func isSeqHeader(typeID byte, data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
+	switch typeID {
+	case av.TAG_VIDEO:
+		return data[0]&0x0f == av.VIDEO_H264 && data[1] == av.AVC_SEQHDR
+	case av.TAG_AUDIO:
+		return data[0]>>4 == av.SOUND_AAC && data[1] == av.AAC_SEQHDR
+	}
+	return false
+}
+
+// Seek returns the offset of the last key frame at or before ms, the
+// first one when ms is before it
+func (idx *Index) Seek(ms uint32) (int64, bool) {
+	if len(idx.KeyFrames) == 0 {
+		return 0, false
+	}
+	offset := idx.KeyFrames[0].Offset
+	for _, kf := range idx.KeyFrames {
+		if kf.Time > ms {
+			break
+		}
+		offset = kf.Offset
+	}
+	return offset, true
+}
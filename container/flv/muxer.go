@@ -56,6 +56,7 @@ type FLVWriter struct {
 	buf             []byte
 	closed          chan struct{}
 	ctx             *os.File
+	seg             *segmenter
 }
 
 func NewFLVWriter(app, title, url string, ctx *os.File) *FLVWriter {
@@ -70,16 +71,21 @@ func NewFLVWriter(app, title, url string, ctx *os.File) *FLVWriter {
 		buf:     make([]byte, headerLen),
 	}
 
-	ret.ctx.Write(flvHeader)
-	pio.PutI32BE(ret.buf[:4], 0)
-	ret.ctx.Write(ret.buf[:4])
+	ret.writeHeader()
 
 	return ret
 }
 
+// writeHeader starts a file with the flv header and the first previous
+// tag size
+func (writer *FLVWriter) writeHeader() {
+	writer.ctx.Write(flvHeader)
+	pio.PutI32BE(writer.buf[:4], 0)
+	writer.ctx.Write(writer.buf[:4])
+}
+
 func (writer *FLVWriter) Write(p *av.Packet) error {
 	writer.RWBaser.SetPreTime()
-	h := writer.buf[:headerLen]
 	typeID := av.TAG_VIDEO
 	if !p.IsVideo {
 		if p.IsMetadata {
@@ -93,11 +99,24 @@ func (writer *FLVWriter) Write(p *av.Packet) error {
 			typeID = av.TAG_AUDIO
 		}
 	}
-	dataLen := len(p.Data)
 	timestamp := p.TimeStamp
 	timestamp += writer.BaseTimeStamp()
 	writer.RWBaser.RecTimeStamp(timestamp, uint32(typeID))
 
+	if writer.seg != nil {
+		var err error
+		if timestamp, err = writer.seg.write(writer, p, timestamp); err != nil {
+			return err
+		}
+	}
+	return writer.writeTag(typeID, timestamp, p.Data)
+}
+
+// writeTag writes a tag and its previous tag size to the file
+func (writer *FLVWriter) writeTag(typeID int, timestamp uint32, data []byte) error {
+	h := writer.buf[:headerLen]
+	dataLen := len(data)
+
 	preDataLen := dataLen + headerLen
 	timestampbase := timestamp & 0xffffff
 	timestampExt := timestamp >> 24 & 0xff
@@ -111,7 +130,7 @@ func (writer *FLVWriter) Write(p *av.Packet) error {
 		return err
 	}
 
-	if _, err := writer.ctx.Write(p.Data); err != nil {
+	if _, err := writer.ctx.Write(data); err != nil {
 		return err
 	}
 
@@ -120,6 +139,9 @@ func (writer *FLVWriter) Write(p *av.Packet) error {
 		return err
 	}
 
+	if writer.seg != nil {
+		writer.seg.size += int64(headerLen + dataLen + 4)
+	}
 	return nil
 }
 
@@ -131,6 +153,10 @@ func (writer *FLVWriter) Wait() {
 }
 
 func (writer *FLVWriter) Close(error) {
+	if writer.seg != nil {
+		writer.seg.lock.Lock()
+		defer writer.seg.lock.Unlock()
+	}
 	writer.ctx.Close()
 	close(writer.closed)
 }
@@ -159,7 +185,12 @@ func (f *FlvDvr) GetWriter(info av.Info) av.WriteCloser {
 		return nil
 	}
 
-	fileName := fmt.Sprintf("%s_%d.%s", path.Join(flvDir, info.Key), time.Now().Unix(), "flv")
+	base := fmt.Sprintf("%s_%d", path.Join(flvDir, info.Key), time.Now().Unix())
+	seg := newSegmenter(base)
+	fileName := base + ".flv"
+	if seg != nil {
+		fileName = seg.fileName()
+	}
 	log.Debug("flv dvr save stream to: ", fileName)
 	w, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, 0755)
 	if err != nil {
@@ -168,6 +199,7 @@ func (f *FlvDvr) GetWriter(info av.Info) av.WriteCloser {
 	}
 
 	writer := NewFLVWriter(paths[0], paths[1], info.URL, w)
+	writer.seg = seg
 	log.Debug("new flv dvr: ", writer.Info())
 	return writer
 }
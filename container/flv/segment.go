@@ -0,0 +1,145 @@
+package flv
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Recording is the file a recorder is writing and the number of files
+// it wrote so far, that one included
+type Recording struct {
+	File     string `json:"file"`
+	Segments int    `json:"segments"`
+}
+
+// segmenter rolls the file of a recorder on the first key frame past
+// record.segment_duration seconds or record.segment_size bytes. Each file
+// starts with the flv header, the metadata and the sequence headers seen
+// so far, and its timestamps start at 0.
+type segmenter struct {
+	base     string
+	duration uint32 // ms, 0 is unlimited
+	maxSize  int64  // bytes, 0 is unlimited
+
+	// lock guards index and the file of the writer, read by the stats
+	lock  sync.Mutex
+	index int
+	size  int64
+	start uint32
+
+	metadata, videoSeq, audioSeq []byte
+}
+
+// newSegmenter returns nil when the recordings are not segmented, the
+// files are then named base.flv, else base_1.flv, base_2.flv...
+func newSegmenter(base string) *segmenter {
+	duration := configure.Config.GetInt("record.segment_duration")
+	size := configure.Config.GetInt64("record.segment_size")
+	if duration <= 0 && size <= 0 {
+		return nil
+	}
+	seg := &segmenter{base: base, index: 1, size: fileHeaderLen}
+	if duration > 0 {
+		seg.duration = uint32(duration) * 1000
+	}
+	if size > 0 {
+		seg.maxSize = size
+	}
+	return seg
+}
+
+func (seg *segmenter) fileName() string {
+	return fmt.Sprintf("%s_%d.flv", seg.base, seg.index)
+}
+
+// write keeps the packets a new file starts with and rolls the file when
+// p is a key frame past the limits, it returns the timestamp of p within
+// the file
+func (seg *segmenter) write(writer *FLVWriter, p *av.Packet, timestamp uint32) (uint32, error) {
+	switch {
+	case p.IsMetadata:
+		if _, timed := p.Header.(*av.TimedMetadata); !timed {
+			seg.metadata = append(seg.metadata[:0], p.Data...)
+		}
+	case p.IsVideo:
+		vh, ok := p.Header.(av.VideoPacketHeader)
+		if !ok {
+			break
+		}
+		if vh.IsSeq() {
+			seg.videoSeq = append(seg.videoSeq[:0], p.Data...)
+		} else if vh.IsKeyFrame() && seg.due(timestamp) {
+			if err := seg.roll(writer, timestamp); err != nil {
+				return 0, err
+			}
+		}
+	default:
+		ah, ok := p.Header.(av.AudioPacketHeader)
+		if ok && ah.SoundFormat() == av.SOUND_AAC && ah.AACPacketType() == av.AAC_SEQHDR {
+			seg.audioSeq = append(seg.audioSeq[:0], p.Data...)
+		}
+	}
+	if timestamp < seg.start {
+		return 0, nil
+	}
+	return timestamp - seg.start, nil
+}
+
+func (seg *segmenter) due(timestamp uint32) bool {
+	if seg.duration > 0 && timestamp >= seg.start && timestamp-seg.start >= seg.duration {
+		return true
+	}
+	return seg.maxSize > 0 && seg.size >= seg.maxSize
+}
+
+// roll closes the file of writer and starts the next one at timestamp
+func (seg *segmenter) roll(writer *FLVWriter, timestamp uint32) error {
+	seg.lock.Lock()
+	writer.ctx.Close()
+	seg.index++
+	fileName := seg.fileName()
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, 0755)
+	if err != nil {
+		seg.lock.Unlock()
+		return err
+	}
+	writer.ctx = f
+	seg.lock.Unlock()
+	log.Debug("flv dvr save stream to: ", fileName)
+
+	seg.size = fileHeaderLen
+	seg.start = timestamp
+	writer.writeHeader()
+	for _, tag := range []struct {
+		typeID int
+		data   []byte
+	}{
+		{av.TAG_SCRIPTDATAAMF0, seg.metadata},
+		{av.TAG_VIDEO, seg.videoSeq},
+		{av.TAG_AUDIO, seg.audioSeq},
+	} {
+		if tag.data == nil {
+			continue
+		}
+		if err := writer.writeTag(tag.typeID, 0, tag.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Recording is the file the writer is on and how many it wrote
+func (writer *FLVWriter) Recording() Recording {
+	if writer.seg == nil {
+		return Recording{File: writer.ctx.Name(), Segments: 1}
+	}
+	writer.seg.lock.Lock()
+	defer writer.seg.lock.Unlock()
+	return Recording{File: writer.ctx.Name(), Segments: writer.seg.index}
+}
@@ -0,0 +1,75 @@
+package flv
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/amf"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordSegments(t *testing.T) {
+	at := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "livego-record")
+	at.Nil(err)
+	defer os.RemoveAll(dir)
+	configure.Config.Set("flv_dir", dir)
+	configure.Config.Set("record.segment_duration", 1)
+	defer configure.Config.Set("flv_dir", "tmp")
+	defer configure.Config.Set("record.segment_duration", 0)
+
+	writer := new(FlvDvr).GetWriter(av.Info{Key: "live/movie"}).(*FLVWriter)
+	media := func(isVideo bool, ts uint32, data []byte) {
+		tag := &Tag{}
+		_, err := tag.ParseMediaTagHeader(data, isVideo)
+		at.Nil(err)
+		at.Nil(writer.Write(&av.Packet{IsVideo: isVideo, IsAudio: !isVideo, TimeStamp: ts, Data: data, Header: tag}))
+	}
+
+	meta := bytes.NewBuffer(nil)
+	(&amf.Encoder{}).EncodeAmf0String(meta, "onMetaData", true)
+	(&amf.Encoder{}).EncodeAmf0EcmaArray(meta, amf.Object{"width": 1280.0}, true)
+	at.Nil(writer.Write(&av.Packet{IsMetadata: true, Data: meta.Bytes()}))
+	media(true, 0, NewAVCSeqHeader([]byte{0x67, 0x42, 0x00, 0x1e, 0xab}, []byte{0x68, 0xce, 0x3c, 0x80}))
+	media(false, 0, NewAACSeqHeader([]byte{0x12, 0x10}))
+	// a key frame every 500ms, the files roll on the ones past each second
+	for ts := uint32(0); ts <= 2600; ts += 100 {
+		if ts%500 == 0 {
+			media(true, ts, NewAVCNALU([][]byte{{0x65, 0x88, 0x84}}, true, 0))
+		} else {
+			media(true, ts, NewAVCNALU([][]byte{{0x41, 0x9a, 0x02}}, false, 0))
+		}
+		media(false, ts, NewAACRaw([]byte{0x21, 0x19}))
+	}
+	rec := writer.Recording()
+	at.Equal(3, rec.Segments)
+	at.Equal(filepath.Join(dir, "live"), filepath.Dir(rec.File))
+	writer.Close(nil)
+
+	files, err := filepath.Glob(filepath.Join(dir, "live", "movie_*_*.flv"))
+	at.Nil(err)
+	if !at.Equal(3, len(files)) {
+		return
+	}
+	for _, file := range files {
+		f, err := os.Open(file)
+		at.Nil(err)
+		idx, err := BuildIndex(f)
+		f.Close()
+		at.Nil(err, file)
+		// header, metadata and both sequence headers
+		at.Equal(4, len(idx.Preamble), file)
+		if at.NotEmpty(idx.KeyFrames, file) {
+			at.Equal(uint32(0), idx.KeyFrames[0].Time, file)
+		}
+		stat, _ := os.Stat(file)
+		at.Equal(stat.Size(), idx.Size, file)
+	}
+}
@@ -0,0 +1,220 @@
+package ts
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	streamTypeH264 = 0x1b
+	streamTypeAAC  = 0x0f
+)
+
+var (
+	ErrInvalidPacket = fmt.Errorf("invalid ts packet")
+	ErrInvalidPES    = fmt.Errorf("invalid pes packet")
+)
+
+// Frame is an elementary stream frame of a transport stream, an annexb
+// access unit for h264 and adts frames for aac. PTS and DTS are in 90kHz.
+type Frame struct {
+	IsVideo bool
+	PTS     int64
+	DTS     int64
+	Data    []byte
+}
+
+// Demuxer reads the h264 and aac streams of the first program of a
+// transport stream. The pids it learned are kept, so the segments of
+// one stream can be fed one after the other.
+type Demuxer struct {
+	pmtPID   uint16
+	videoPID uint16
+	audioPID uint16
+	pes      map[uint16][]byte
+}
+
+func NewDemuxer() *Demuxer {
+	return &Demuxer{
+		pes: make(map[uint16][]byte),
+	}
+}
+
+// Demux reads ts packets from r until EOF, calling f with each frame
+func (d *Demuxer) Demux(r io.Reader, f func(*Frame) error) error {
+	var packet [tsPacketLen]byte
+	for {
+		if _, err := io.ReadFull(r, packet[:]); err != nil {
+			if err == io.EOF {
+				return d.flush(f)
+			}
+			return err
+		}
+		if err := d.demuxPacket(packet[:], f); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *Demuxer) demuxPacket(b []byte, f func(*Frame) error) error {
+	if b[0] != 0x47 {
+		return ErrInvalidPacket
+	}
+	pid := uint16(b[1]&0x1f)<<8 | uint16(b[2])
+	start := b[1]&0x40 != 0
+	afc := (b[3] >> 4) & 0x03
+
+	payload := b[4:]
+	if afc&0x02 != 0 {
+		n := int(b[4]) + 1
+		if n > len(payload) {
+			return ErrInvalidPacket
+		}
+		payload = payload[n:]
+	}
+	if afc&0x01 == 0 {
+		return nil
+	}
+
+	switch {
+	case pid == 0:
+		if start {
+			d.parsePAT(payload)
+		}
+	case pid == d.pmtPID && d.pmtPID != 0:
+		if start {
+			d.parsePMT(payload)
+		}
+	case pid == d.videoPID || pid == d.audioPID:
+		if start {
+			if err := d.flushPID(pid, f); err != nil {
+				return err
+			}
+			d.pes[pid] = append([]byte(nil), payload...)
+		} else if buf, ok := d.pes[pid]; ok {
+			d.pes[pid] = append(buf, payload...)
+		}
+		// a pes with its length set is done once all of it is read
+		if buf := d.pes[pid]; len(buf) >= 6 {
+			if length := int(buf[4])<<8 | int(buf[5]); length != 0 && len(buf) >= 6+length {
+				return d.flushPID(pid, f)
+			}
+		}
+	}
+	return nil
+}
+
+// section returns the table after the pointer field, without its crc
+func section(payload []byte) []byte {
+	if len(payload) < 1 || int(payload[0])+1 > len(payload) {
+		return nil
+	}
+	b := payload[payload[0]+1:]
+	if len(b) < 3 {
+		return nil
+	}
+	length := int(b[1]&0x0f)<<8 | int(b[2])
+	if length < 4 || 3+length > len(b) {
+		return nil
+	}
+	return b[:3+length-4]
+}
+
+func (d *Demuxer) parsePAT(payload []byte) {
+	b := section(payload)
+	if len(b) < 8 {
+		return
+	}
+	for i := 8; i+4 <= len(b); i += 4 {
+		program := uint16(b[i])<<8 | uint16(b[i+1])
+		if program == 0 {
+			continue
+		}
+		d.pmtPID = uint16(b[i+2]&0x1f)<<8 | uint16(b[i+3])
+		return
+	}
+}
+
+func (d *Demuxer) parsePMT(payload []byte) {
+	b := section(payload)
+	if len(b) < 12 {
+		return
+	}
+	i := 12 + (int(b[10]&0x0f)<<8 | int(b[11]))
+	for i+5 <= len(b) {
+		streamType := b[i]
+		pid := uint16(b[i+1]&0x1f)<<8 | uint16(b[i+2])
+		switch streamType {
+		case streamTypeH264:
+			d.videoPID = pid
+		case streamTypeAAC:
+			d.audioPID = pid
+		}
+		i += 5 + (int(b[i+3]&0x0f)<<8 | int(b[i+4]))
+	}
+}
+
+func (d *Demuxer) flush(f func(*Frame) error) error {
+	for _, pid := range []uint16{d.videoPID, d.audioPID} {
+		if err := d.flushPID(pid, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Demuxer) flushPID(pid uint16, f func(*Frame) error) error {
+	buf, ok := d.pes[pid]
+	if !ok {
+		return nil
+	}
+	delete(d.pes, pid)
+	frame, err := parsePES(buf)
+	if err != nil {
+		return err
+	}
+	frame.IsVideo = pid == d.videoPID
+	return f(frame)
+}
+
+func parsePES(b []byte) (*Frame, error) {
+	if len(b) < 9 || b[0] != 0 || b[1] != 0 || b[2] != 1 {
+		return nil, ErrInvalidPES
+	}
+	flags := b[7]
+	headerLen := 9 + int(b[8])
+	if headerLen > len(b) {
+		return nil, ErrInvalidPES
+	}
+
+	frame := &Frame{}
+	if flags&0x80 != 0 {
+		if len(b) < 14 {
+			return nil, ErrInvalidPES
+		}
+		frame.PTS = readTimestamp(b[9:14])
+		frame.DTS = frame.PTS
+	}
+	if flags&0x40 != 0 {
+		if len(b) < 19 {
+			return nil, ErrInvalidPES
+		}
+		frame.DTS = readTimestamp(b[14:19])
+	}
+
+	data := b[headerLen:]
+	// a pes length of 0 means unbounded, which is allowed for video
+	if length := int(b[4])<<8 | int(b[5]); length != 0 && 6+length <= len(b) {
+		data = b[headerLen : 6+length]
+	}
+	frame.Data = data
+	return frame, nil
+}
+
+func readTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 |
+		int64(b[1])<<22 |
+		int64(b[2]>>1)<<15 |
+		int64(b[3])<<7 |
+		int64(b[4]>>1)
+}
@@ -0,0 +1,71 @@
+package ts
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testVideoHeader struct {
+	key bool
+	cts int32
+}
+
+func (h testVideoHeader) IsKeyFrame() bool       { return h.key }
+func (h testVideoHeader) IsSeq() bool            { return false }
+func (h testVideoHeader) CodecID() uint8         { return av.VIDEO_H264 }
+func (h testVideoHeader) CompositionTime() int32 { return h.cts }
+
+func TestDemuxer(t *testing.T) {
+	at := assert.New(t)
+
+	m := NewMuxer()
+	var buf bytes.Buffer
+	buf.Write(m.PAT())
+	buf.Write(m.PMT(av.SOUND_AAC, true))
+
+	video := bytes.Repeat([]byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x88}, 100)
+	audio := []byte{0xff, 0xf1, 0x50, 0x80, 0x01, 0x7f, 0xfc, 0x21, 0x19}
+	at.Nil(m.Mux(&av.Packet{IsVideo: true, TimeStamp: 40, Data: video, Header: testVideoHeader{true, 80}}, &buf))
+	at.Nil(m.Mux(&av.Packet{TimeStamp: 50, Data: audio}, &buf))
+	at.Nil(m.Mux(&av.Packet{IsVideo: true, TimeStamp: 80, Data: video[:60], Header: testVideoHeader{}}, &buf))
+
+	var frames []*Frame
+	d := NewDemuxer()
+	err := d.Demux(&buf, func(f *Frame) error {
+		frames = append(frames, f)
+		return nil
+	})
+	at.Nil(err)
+	if !at.Equal(3, len(frames)) {
+		return
+	}
+
+	at.True(frames[0].IsVideo)
+	at.Equal(video, frames[0].Data)
+	at.Equal(int64(40*90), frames[0].DTS)
+	at.Equal(int64(120*90), frames[0].PTS)
+
+	at.False(frames[1].IsVideo)
+	at.Equal(audio, frames[1].Data)
+	at.Equal(int64(50*90), frames[1].PTS)
+
+	at.True(frames[2].IsVideo)
+	at.Equal(video[:60], frames[2].Data)
+	at.Equal(frames[2].PTS, frames[2].DTS)
+
+	// the pids are kept for the next segment
+	buf.Reset()
+	at.Nil(m.Mux(&av.Packet{TimeStamp: 90, Data: audio}, &buf))
+	frames = nil
+	at.Nil(d.Demux(&buf, func(f *Frame) error {
+		frames = append(frames, f)
+		return nil
+	}))
+	at.Equal(1, len(frames))
+
+	at.Equal(ErrInvalidPacket, d.Demux(bytes.NewReader(make([]byte, tsPacketLen)), func(*Frame) error { return nil }))
+}
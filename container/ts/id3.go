@@ -0,0 +1,55 @@
+package ts
+
+import (
+	"sort"
+)
+
+// id3Frames maps the well known metadata fields to their ID3v2.4 text
+// frames, any other field is written as a TXXX user text frame
+var id3Frames = map[string]string{
+	"title":  "TIT2",
+	"artist": "TPE1",
+	"album":  "TALB",
+}
+
+func putSyncsafe(b []byte, n int) {
+	b[0] = byte(n>>21) & 0x7f
+	b[1] = byte(n>>14) & 0x7f
+	b[2] = byte(n>>7) & 0x7f
+	b[3] = byte(n) & 0x7f
+}
+
+func id3Frame(id string, data []byte) []byte {
+	b := make([]byte, 10, 10+len(data))
+	copy(b, id)
+	putSyncsafe(b[4:8], len(data))
+	return append(b, data...)
+}
+
+// NewID3 returns an ID3v2.4 tag of fields with utf-8 text frames, the
+// frames are sorted by field so a tag is stable
+func NewID3(fields map[string]string) []byte {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var frames []byte
+	for _, name := range names {
+		value := fields[name]
+		if id, ok := id3Frames[name]; ok {
+			frames = append(frames, id3Frame(id, append([]byte{0x03}, value...))...)
+			continue
+		}
+		data := append([]byte{0x03}, name...)
+		data = append(data, 0x00)
+		frames = append(frames, id3Frame("TXXX", append(data, value...))...)
+	}
+
+	tag := make([]byte, 10, 10+len(frames))
+	copy(tag, "ID3")
+	tag[3] = 0x04
+	putSyncsafe(tag[6:10], len(frames))
+	return append(tag, frames...)
+}
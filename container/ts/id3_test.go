@@ -0,0 +1,42 @@
+package ts
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewID3(t *testing.T) {
+	at := assert.New(t)
+
+	tag := NewID3(map[string]string{"title": "Song", "station": "fm"})
+	at.Equal([]byte{'I', 'D', '3', 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x24}, tag[:10])
+	frames := tag[10:]
+	at.Equal(0x24, len(frames))
+	// station sorts first and has no frame of its own
+	at.Equal(append([]byte{'T', 'X', 'X', 'X', 0, 0, 0, 11, 0, 0, 0x03}, "station\x00fm"...), frames[:21])
+	at.Equal(append([]byte{'T', 'I', 'T', '2', 0, 0, 0, 5, 0, 0, 0x03}, "Song"...), frames[21:])
+}
+
+func TestMuxID3(t *testing.T) {
+	at := assert.New(t)
+	m := NewMuxer()
+	m.EnableID3()
+
+	pmt := m.PMT(av.SOUND_AAC, true)
+	at.True(bytes.Contains(pmt, []byte{0x15, 0xe1, 0x02}), "no ID3 stream in the PMT")
+	at.True(bytes.Contains(pmt, id3PointerDescriptor))
+
+	w := bytes.NewBuffer(nil)
+	tag := NewID3(map[string]string{"title": "Song"})
+	at.Nil(m.Mux(&av.Packet{IsMetadata: true, TimeStamp: 1000, Data: tag}, w))
+	b := w.Bytes()
+	at.Equal(188, len(b))
+	at.Equal([]byte{0x47, 0x41, 0x02}, b[:3])
+	// a private stream 1 PES carrying the tag
+	at.Equal([]byte{0x00, 0x00, 0x01, metadataSID}, b[bytes.Index(b, []byte{0x00, 0x00, 0x01}):][:4])
+	at.True(bytes.HasSuffix(b, tag))
+}
@@ -11,15 +11,27 @@ const (
 	tsPacketLen      = 188
 	h264DefaultHZ    = 90
 
-	videoPID = 0x100
-	audioPID = 0x101
-	videoSID = 0xe0
-	audioSID = 0xc0
+	videoPID    = 0x100
+	audioPID    = 0x101
+	metadataPID = 0x102
+	videoSID    = 0xe0
+	audioSID    = 0xc0
+	metadataSID = 0xbd
+)
+
+var (
+	// metadata_pointer_descriptor of the program, the ID3 stream is
+	// timed metadata of program 1
+	id3PointerDescriptor = []byte{0x25, 0x0f, 0xff, 0xff, 'I', 'D', '3', ' ', 0xff, 'I', 'D', '3', ' ', 0x00, 0x1f, 0x00, 0x01}
+	// metadata_descriptor of the ID3 elementary stream
+	id3Descriptor = []byte{0x26, 0x0d, 0xff, 0xff, 'I', 'D', '3', ' ', 0xff, 'I', 'D', '3', ' ', 0x00, 0x0f}
 )
 
 type Muxer struct {
+	id3      bool
 	videoCc  byte
 	audioCc  byte
+	id3Cc    byte
 	patCc    byte
 	pmtCc    byte
 	pat      [tsPacketLen]byte
@@ -31,6 +43,12 @@ func NewMuxer() *Muxer {
 	return &Muxer{}
 }
 
+// EnableID3 declares an ID3 timed metadata stream in the PMT, metadata
+// packets are muxed on it with their ID3 tag as data
+func (muxer *Muxer) EnableID3() {
+	muxer.id3 = true
+}
+
 func (muxer *Muxer) Mux(p *av.Packet, w io.Writer) error {
 	first := true
 	wBytes := 0
@@ -43,7 +61,9 @@ func (muxer *Muxer) Mux(p *av.Packet, w io.Writer) error {
 	pts := dts
 	pid := audioPID
 	var videoH av.VideoPacketHeader
-	if p.IsVideo {
+	if p.IsMetadata {
+		pid = metadataPID
+	} else if p.IsVideo {
 		pid = videoPID
 		videoH, _ = p.Header.(av.VideoPacketHeader)
 		pts = dts + int64(videoH.CompositionTime())*int64(h264DefaultHZ)
@@ -59,7 +79,12 @@ func (muxer *Muxer) Mux(p *av.Packet, w io.Writer) error {
 		if packetBytesLen <= 0 {
 			break
 		}
-		if p.IsVideo {
+		if p.IsMetadata {
+			muxer.id3Cc++
+			if muxer.id3Cc > 0xf {
+				muxer.id3Cc = 0
+			}
+		} else if p.IsVideo {
 			muxer.videoCc++
 			if muxer.videoCc > 0xf {
 				muxer.videoCc = 0
@@ -89,7 +114,9 @@ func (muxer *Muxer) Mux(p *av.Packet, w io.Writer) error {
 		i++
 
 		//scram control, adaptation control, counter
-		if p.IsVideo {
+		if p.IsMetadata {
+			muxer.tsPacket[i] = 0x10 | byte(muxer.id3Cc&0x0f)
+		} else if p.IsVideo {
 			muxer.tsPacket[i] = 0x10 | byte(muxer.videoCc&0x0f)
 		} else {
 			muxer.tsPacket[i] = 0x10 | byte(muxer.audioCc&0x0f)
@@ -211,7 +238,6 @@ func (muxer *Muxer) PMT(soundFormat byte, hasVideo bool) []byte {
 			0x0f, 0xe1, 0x01, 0xf0, 0x00, //mp3 or aac
 		}
 	}
-	pmtHeader[2] = byte(len(progInfo) + 9 + 4)
 
 	if muxer.pmtCc > 0xf {
 		muxer.pmtCc = 0
@@ -227,6 +253,14 @@ func (muxer *Muxer) PMT(soundFormat byte, hasVideo bool) []byte {
 			progInfo[0] = 0x4
 		}
 	}
+	if muxer.id3 {
+		pmtHeader[11] = byte(len(id3PointerDescriptor))
+		info := append([]byte{}, id3PointerDescriptor...)
+		info = append(info, progInfo...)
+		progInfo = append(info, 0x15, 0xe1, 0x02, 0xf0, byte(len(id3Descriptor)))
+		progInfo = append(progInfo, id3Descriptor...)
+	}
+	pmtHeader[2] = byte(len(progInfo) + 9 + 4)
 
 	copy(muxer.pmt[i:], tsHeader)
 	i += len(tsHeader)
@@ -300,7 +334,9 @@ func (header *pesHeader) packet(p *av.Packet, pts, dts int64) error {
 	i++
 
 	sid := audioSID
-	if p.IsVideo {
+	if p.IsMetadata {
+		sid = metadataSID
+	} else if p.IsVideo {
 		sid = videoSID
 	}
 	header.data[i] = byte(sid)
@@ -9,10 +9,14 @@ require (
 	github.com/gorilla/mux v1.7.4 // indirect
 	github.com/kr/pretty v0.1.0
 	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/pion/interceptor v0.1.25
+	github.com/pion/rtcp v1.2.12
+	github.com/pion/rtp v1.8.3
+	github.com/pion/webrtc/v3 v3.2.24
 	github.com/satori/go.uuid v1.2.0
 	github.com/sirupsen/logrus v1.5.0
 	github.com/spf13/pflag v1.0.3
 	github.com/spf13/viper v1.6.3
-	github.com/stretchr/testify v1.4.0
+	github.com/stretchr/testify v1.8.4
 	github.com/urfave/negroni v1.0.0 // indirect
 )
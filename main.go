@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/SpooderfyBot/live/configure"
 	"github.com/SpooderfyBot/live/protocol/api"
@@ -8,9 +9,13 @@ import (
 	"github.com/SpooderfyBot/live/protocol/httpflv"
 	"github.com/SpooderfyBot/live/protocol/rtmp"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"runtime"
+	"sync"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -38,6 +43,43 @@ func startHls() *hls.Server {
 	return hlsServer
 }
 
+var (
+	socketsLock sync.Mutex
+	sockets     []string
+)
+
+// listenUnix listens on the unix socket path, the socket file of a
+// previous run that didn't exit cleanly is removed first
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	socketsLock.Lock()
+	sockets = append(sockets, path)
+	socketsLock.Unlock()
+	return l, nil
+}
+
+// removeSocketsOnExit removes the unix socket files when the process is
+// interrupted or terminated, they would be left behind otherwise
+func removeSocketsOnExit() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stop
+		socketsLock.Lock()
+		for _, path := range sockets {
+			os.Remove(path)
+		}
+		socketsLock.Unlock()
+		os.Exit(0)
+	}()
+}
+
 var rtmpAddr string
 
 func startRtmp(stream *rtmp.RtmpStream, hlsServer *hls.Server) {
@@ -48,6 +90,17 @@ func startRtmp(stream *rtmp.RtmpStream, hlsServer *hls.Server) {
 		log.Fatal(err)
 	}
 
+	if cert := configure.Config.GetString("rtmp.tls.cert"); cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, configure.Config.GetString("rtmp.tls.key"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		rtmpListen = tls.NewListener(rtmpListen, &tls.Config{
+			Certificates: []tls.Certificate{pair},
+		})
+		log.Info("RTMPS enabled")
+	}
+
 	var rtmpServer *rtmp.Server
 
 	if hlsServer == nil {
@@ -58,6 +111,23 @@ func startRtmp(stream *rtmp.RtmpStream, hlsServer *hls.Server) {
 		log.Info("HLS server enable....")
 	}
 
+	// the local proxy in front of the socket terminates tls if any
+	if socket := configure.Config.GetString("rtmp.unix_socket"); socket != "" {
+		unixListen, err := listenUnix(socket)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error("RTMP unix socket server panic: ", r)
+				}
+			}()
+			log.Info("RTMP Listen On unix:", socket)
+			rtmpServer.Serve(unixListen)
+		}()
+	}
+
 	defer func() {
 		if r := recover(); r != nil {
 			log.Error("RTMP server panic: ", r)
@@ -67,7 +137,7 @@ func startRtmp(stream *rtmp.RtmpStream, hlsServer *hls.Server) {
 	rtmpServer.Serve(rtmpListen)
 }
 
-func startHTTPFlv(stream *rtmp.RtmpStream) {
+func startHTTPFlv(stream *rtmp.RtmpStream) *httpflv.Server {
 	httpflvAddr := configure.Config.GetString("httpflv_addr")
 
 	flvListen, err := net.Listen("tcp", httpflvAddr)
@@ -85,27 +155,67 @@ func startHTTPFlv(stream *rtmp.RtmpStream) {
 		log.Info("HTTP-FLV listen On ", httpflvAddr)
 		hdlServer.Serve(flvListen)
 	}()
+	return hdlServer
 }
 
-func startAPI(stream *rtmp.RtmpStream, apiKey string) {
+func startAPI(stream *rtmp.RtmpStream, hlsServer *hls.Server, flvServer *httpflv.Server, apiKey string) {
 	apiAddr := configure.Config.GetString("api_addr")
+	socket := configure.Config.GetString("api.unix_socket")
+	if apiAddr == "" && socket == "" {
+		return
+	}
 
-	if apiAddr != "" {
-		opListen, err := net.Listen("tcp", apiAddr)
-		if err != nil {
-			log.Fatal(err)
-		}
-		opServer := api.NewServer(stream, rtmpAddr)
+	// startRtmp runs after us, so don't rely on rtmpAddr being set yet
+	opServer := api.NewServer(stream, configure.Config.GetString("rtmp_addr"))
+	var flvHandler, hlsHandler http.Handler
+	if hlsServer != nil {
+		opServer.SetSegmentCounter(hlsServer)
+		hlsHandler = hlsServer.Handler()
+	}
+	if flvServer != nil {
+		flvHandler = flvServer.Handler()
+	}
+	opServer.SetMediaHandlers(flvHandler, hlsHandler)
+	handler := opServer.Handler(apiKey)
+
+	serve := func(l net.Listener, name string) {
 		go func() {
 			defer func() {
 				if r := recover(); r != nil {
 					log.Error("HTTP-API server panic: ", r)
 				}
 			}()
-			log.Info("HTTP-API listen On ", apiAddr)
-			_ = opServer.Serve(opListen, apiKey)
+			log.Info("HTTP-API listen On ", name)
+			_ = http.Serve(l, handler)
 		}()
 	}
+	if apiAddr != "" {
+		opListen, err := net.Listen("tcp", apiAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		serve(opListen, apiAddr)
+	}
+	if socket != "" {
+		unixListen, err := listenUnix(socket)
+		if err != nil {
+			log.Fatal(err)
+		}
+		serve(unixListen, "unix:"+socket)
+	}
+}
+
+// reloadOnHangup reloads the config file on every SIGHUP
+func reloadOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := configure.Reload(); err != nil {
+				log.Error("Config reload error: ", err)
+			}
+		}
+	}()
 }
 
 func init() {
@@ -127,21 +237,30 @@ func main() {
 	}()
 
 	log.Infof(`LiveGo: Spooderfy Edition!`)
+	reloadOnHangup()
+	removeSocketsOnExit()
 
 	apps := configure.Applications{}
 	configure.Config.UnmarshalKey("server", &apps)
 	for _, app := range apps {
 		stream := rtmp.NewRtmpStream()
 		var hlsServer *hls.Server
-		if app.Hls {
+		// dash is served by the hls server from the same segments
+		if app.Hls || configure.Config.GetBool("dash.enabled") {
 			hlsServer = startHls()
 		}
+		var flvServer *httpflv.Server
 		if app.Flv {
-			startHTTPFlv(stream)
+			flvServer = startHTTPFlv(stream)
 		}
 		if app.Api {
-			startAPI(stream, os.Getenv("API_KEY"))
+			apiKey, err := configure.APIKey()
+			if err != nil {
+				log.Fatal(err)
+			}
+			startAPI(stream, hlsServer, flvServer, apiKey)
 		}
+		startWebRTC(stream, hlsServer)
 
 		startRtmp(stream, hlsServer)
 	}
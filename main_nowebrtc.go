@@ -0,0 +1,12 @@
+//go:build !webrtc
+// +build !webrtc
+
+package main
+
+import (
+	"github.com/SpooderfyBot/live/protocol/hls"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+)
+
+// startWebRTC is a no-op, build with -tags webrtc to get WHIP/WHEP
+func startWebRTC(stream *rtmp.RtmpStream, hlsServer *hls.Server) {}
@@ -0,0 +1,43 @@
+//go:build webrtc
+// +build webrtc
+
+package main
+
+import (
+	"net"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/hls"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/webrtc"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func startWebRTC(stream *rtmp.RtmpStream, hlsServer *hls.Server) {
+	webrtcAddr := configure.Config.GetString("webrtc_addr")
+	if webrtcAddr == "" {
+		return
+	}
+
+	webrtcListen, err := net.Listen("tcp", webrtcAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var getter av.GetWriter
+	if hlsServer != nil {
+		getter = hlsServer
+	}
+	webrtcServer := webrtc.NewServer(stream, getter)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error("WebRTC server panic: ", r)
+			}
+		}()
+		log.Info("WebRTC listen On ", webrtcAddr)
+		webrtcServer.Serve(webrtcListen)
+	}()
+}
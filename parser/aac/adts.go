@@ -0,0 +1,49 @@
+package aac
+
+import "fmt"
+
+var adtsInvalid = fmt.Errorf("adts frame invalid")
+
+// SplitADTS splits a run of adts frames into the raw aac frames, config is
+// the AudioSpecificConfig of the first frame header
+func SplitADTS(b []byte) (config []byte, frames [][]byte, err error) {
+	for len(b) > 0 {
+		if len(b) < adtsHeaderLen || b[0] != 0xff || b[1]&0xf0 != 0xf0 {
+			return nil, nil, adtsInvalid
+		}
+		headerLen := adtsHeaderLen
+		// protection absent unset means a crc follows the header
+		if b[1]&0x01 == 0 {
+			headerLen += 2
+		}
+		frameLen := int(b[3]&0x03)<<11 | int(b[4])<<3 | int(b[5])>>5
+		if frameLen < headerLen || frameLen > len(b) {
+			return nil, nil, adtsInvalid
+		}
+		if config == nil {
+			objectType := (b[2]>>6)&0x03 + 1
+			sampleRate := (b[2] >> 2) & 0x0f
+			channel := (b[2]&0x01)<<2 | b[3]>>6
+			config = []byte{
+				objectType<<3 | sampleRate>>1,
+				sampleRate<<7 | channel<<3,
+			}
+		}
+		frames = append(frames, b[headerLen:frameLen])
+		b = b[frameLen:]
+	}
+	return
+}
+
+// ConfigSampleRate is the sample rate of an AudioSpecificConfig
+func ConfigSampleRate(config []byte) int {
+	rate := 44100
+	if len(config) < 2 {
+		return rate
+	}
+	index := int((config[0]&0x07)<<1 | config[1]>>7)
+	if index < len(aacRates) {
+		rate = aacRates[index]
+	}
+	return rate
+}
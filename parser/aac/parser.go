@@ -112,3 +112,11 @@ func (parser *Parser) Parse(b []byte, packetType uint8, w io.Writer) (err error)
 	}
 	return
 }
+
+// Codec is the RFC 6381 codecs name of an AudioSpecificConfig, mp4a.40.OT
+func Codec(src []byte) (string, error) {
+	if len(src) < 2 {
+		return "", specificBufInvalid
+	}
+	return fmt.Sprintf("mp4a.40.%d", src[0]>>3), nil
+}
@@ -0,0 +1,52 @@
+package h264
+
+// SplitAnnexB splits an annex-b byte stream into its nalus,
+// without their 3 or 4 byte start codes
+func SplitAnnexB(b []byte) [][]byte {
+	var nalus [][]byte
+	start := -1
+	for i := 0; i+2 < len(b); i++ {
+		if b[i] != 0x00 || b[i+1] != 0x00 || b[i+2] != 0x01 {
+			continue
+		}
+		if start >= 0 {
+			end := i
+			if end > start && b[end-1] == 0x00 {
+				end--
+			}
+			if end > start {
+				nalus = append(nalus, b[start:end])
+			}
+		}
+		start = i + 3
+		i += 2
+	}
+	if start >= 0 && start < len(b) {
+		nalus = append(nalus, b[start:])
+	}
+	return nalus
+}
+
+// NaluType returns the type of a nalu without its start code
+func NaluType(nalu []byte) byte {
+	if len(nalu) == 0 {
+		return nalu_type_not_define
+	}
+	return nalu[0] & 0x1f
+}
+
+func IsSPS(nalu []byte) bool {
+	return NaluType(nalu) == nalu_type_sps
+}
+
+func IsPPS(nalu []byte) bool {
+	return NaluType(nalu) == nalu_type_pps
+}
+
+func IsIDR(nalu []byte) bool {
+	return NaluType(nalu) == nalu_type_idr
+}
+
+func IsAUD(nalu []byte) bool {
+	return NaluType(nalu) == nalu_type_aud
+}
@@ -90,3 +90,49 @@ func TestH264Mp4DemuxException2(t *testing.T) {
 	err := d.Parse(nalu, false, w)
 	at.Equal(err, naluBodyLenError)
 }
+
+func TestSplitAnnexB(t *testing.T) {
+	at := assert.New(t)
+	b := []byte{
+		0x00, 0x00, 0x00, 0x01, 0x67, 0x4d, 0x00, 0x1e,
+		0x00, 0x00, 0x01, 0x68, 0xde, 0x31, 0x12,
+		0x00, 0x00, 0x00, 0x01, 0x65, 0x23,
+	}
+	nalus := SplitAnnexB(b)
+	at.Equal(3, len(nalus))
+	at.Equal([]byte{0x67, 0x4d, 0x00, 0x1e}, nalus[0])
+	at.Equal([]byte{0x68, 0xde, 0x31, 0x12}, nalus[1])
+	at.Equal([]byte{0x65, 0x23}, nalus[2])
+	at.True(IsSPS(nalus[0]))
+	at.True(IsPPS(nalus[1]))
+	at.True(IsIDR(nalus[2]))
+	at.Nil(SplitAnnexB([]byte{0x65, 0x23}))
+}
+
+func TestParseSPS(t *testing.T) {
+	at := assert.New(t)
+
+	// 720x576 main profile with 25fps timing info
+	sps, err := ParseAVCConfig([]byte{
+		0x01, 0x4d, 0x00, 0x1e, 0xff, 0xe1, 0x00, 0x17, 0x67, 0x4d, 0x00,
+		0x1e, 0xab, 0x40, 0x5a, 0x12, 0x6c, 0x09, 0x28, 0x28, 0x28, 0x2f,
+		0x80, 0x00, 0x01, 0xf4, 0x00, 0x00, 0x61, 0xa8, 0x4a, 0x01, 0x00,
+		0x04, 0x68, 0xde, 0x31, 0x12,
+	})
+	at.Nil(err)
+	at.Equal(SPS{ProfileIdc: 77, LevelIdc: 30, Width: 720, Height: 576, FrameRate: 25}, sps)
+	at.Equal("avc1.4d001e", sps.Codec())
+
+	// 1080p high profile, cropped from 1088 lines, with emulation prevention bytes
+	sps, err = ParseSPS([]byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0xc0, 0x44,
+		0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xc8, 0x3c, 0x60, 0xc6, 0x58})
+	at.Nil(err)
+	at.Equal(1920, sps.Width)
+	at.Equal(1080, sps.Height)
+	at.Equal(25.0, sps.FrameRate)
+
+	_, err = ParseSPS([]byte{0x67, 0x64, 0x00, 0x28, 0xac})
+	at.NotNil(err)
+	_, err = ParseSPS([]byte{0x68, 0xeb, 0xe3, 0xcb})
+	at.NotNil(err)
+}
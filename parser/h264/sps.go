@@ -0,0 +1,215 @@
+package h264
+
+import (
+	"fmt"
+)
+
+var errSPSTruncated = fmt.Errorf("sps truncated")
+
+// SPS holds the fields of a sequence parameter set the stats report
+type SPS struct {
+	ProfileIdc  byte
+	Constraints byte
+	LevelIdc    byte
+	Width       int
+	Height      int
+	FrameRate   float64 // 0 when the sps carries no timing info
+}
+
+// Codec is the RFC 6381 codecs name of the stream, avc1.PPCCLL
+func (sps SPS) Codec() string {
+	return fmt.Sprintf("avc1.%02x%02x%02x", sps.ProfileIdc, sps.Constraints, sps.LevelIdc)
+}
+
+// bitReader reads the exp-golomb coded fields of an rbsp
+type bitReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *bitReader) bits(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.b)*8 {
+			r.err = errSPSTruncated
+			return 0
+		}
+		v = v<<1 | uint32(r.b[r.pos/8]>>(7-uint(r.pos%8))&1)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) flag() bool {
+	return r.bits(1) == 1
+}
+
+func (r *bitReader) ue() uint32 {
+	zeros := 0
+	for !r.flag() {
+		if r.err != nil || zeros > 31 {
+			r.err = errSPSTruncated
+			return 0
+		}
+		zeros++
+	}
+	return 1<<uint(zeros) - 1 + r.bits(zeros)
+}
+
+func (r *bitReader) se() int32 {
+	v := r.ue()
+	if v&1 == 1 {
+		return int32(v+1) / 2
+	}
+	return -int32(v / 2)
+}
+
+// rbsp drops the emulation prevention bytes of a nalu payload
+func rbsp(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if i >= 2 && b[i] == 0x03 && b[i-1] == 0x00 && b[i-2] == 0x00 {
+			continue
+		}
+		out = append(out, b[i])
+	}
+	return out
+}
+
+func skipScalingList(r *bitReader, size int) {
+	last, next := int32(8), int32(8)
+	for i := 0; i < size; i++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// ParseSPS reads the profile, level, frame size and frame rate of an sps
+// nalu without its start code
+func ParseSPS(nalu []byte) (sps SPS, err error) {
+	if !IsSPS(nalu) || len(nalu) < 4 {
+		return sps, spsDataError
+	}
+	sps.ProfileIdc = nalu[1]
+	sps.Constraints = nalu[2]
+	sps.LevelIdc = nalu[3]
+
+	r := &bitReader{b: rbsp(nalu[4:])}
+	r.ue() // seq_parameter_set_id
+	chromaFormat := uint32(1)
+	switch sps.ProfileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = r.ue()
+		if chromaFormat == 3 {
+			r.bits(1) // separate_colour_plane_flag
+		}
+		r.ue()        // bit_depth_luma_minus8
+		r.ue()        // bit_depth_chroma_minus8
+		r.bits(1)     // qpprime_y_zero_transform_bypass_flag
+		if r.flag() { // seq_scaling_matrix_present_flag
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.flag() {
+					if i < 6 {
+						skipScalingList(r, 16)
+					} else {
+						skipScalingList(r, 64)
+					}
+				}
+			}
+		}
+	}
+	r.ue()          // log2_max_frame_num_minus4
+	switch r.ue() { // pic_order_cnt_type
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bits(1) // delta_pic_order_always_zero_flag
+		r.se()    // offset_for_non_ref_pic
+		r.se()    // offset_for_top_to_bottom_field
+		for n := r.ue(); n > 0 && r.err == nil; n-- {
+			r.se()
+		}
+	}
+	r.ue()    // max_num_ref_frames
+	r.bits(1) // gaps_in_frame_num_value_allowed_flag
+	widthMbs := int(r.ue()) + 1
+	heightMapUnits := int(r.ue()) + 1
+	frameMbsOnly := r.flag()
+	if !frameMbsOnly {
+		r.bits(1) // mb_adaptive_frame_field_flag
+	}
+	r.bits(1) // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom int
+	if r.flag() {
+		cropLeft, cropRight = int(r.ue()), int(r.ue())
+		cropTop, cropBottom = int(r.ue()), int(r.ue())
+	}
+	frameHeightMul := 2
+	if frameMbsOnly {
+		frameHeightMul = 1
+	}
+	cropX, cropY := 1, frameHeightMul
+	switch chromaFormat {
+	case 1:
+		cropX, cropY = 2, 2*frameHeightMul
+	case 2:
+		cropX = 2
+	}
+	sps.Width = widthMbs*16 - cropX*(cropLeft+cropRight)
+	sps.Height = frameHeightMul*heightMapUnits*16 - cropY*(cropTop+cropBottom)
+
+	if r.flag() { // vui_parameters_present_flag
+		if r.flag() { // aspect_ratio_info_present_flag
+			if r.bits(8) == 255 { // Extended_SAR
+				r.bits(32)
+			}
+		}
+		if r.flag() { // overscan_info_present_flag
+			r.bits(1)
+		}
+		if r.flag() { // video_signal_type_present_flag
+			r.bits(4)
+			if r.flag() { // colour_description_present_flag
+				r.bits(24)
+			}
+		}
+		if r.flag() { // chroma_loc_info_present_flag
+			r.ue()
+			r.ue()
+		}
+		if r.flag() { // timing_info_present_flag
+			unitsInTick := r.bits(32)
+			timeScale := r.bits(32)
+			if unitsInTick > 0 && r.err == nil {
+				sps.FrameRate = float64(timeScale) / float64(2*unitsInTick)
+			}
+		}
+	}
+	if r.err != nil {
+		return sps, r.err
+	}
+	return sps, nil
+}
+
+// ParseAVCConfig reads the first sps of an AVCDecoderConfigurationRecord,
+// the payload of an flv avc sequence header
+func ParseAVCConfig(src []byte) (SPS, error) {
+	if len(src) < 8 || src[5]&0x1f == 0 {
+		return SPS{}, spsDataError
+	}
+	spsLen := int(src[6])<<8 | int(src[7])
+	if spsLen <= 0 || len(src[8:]) < spsLen {
+		return SPS{}, spsDataError
+	}
+	return ParseSPS(src[8 : 8+spsLen])
+}
@@ -0,0 +1,174 @@
+package amf
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// metaNumbers are the onMetaData fields players and the stats read as
+// numbers, some encoders send them as strings
+var metaNumbers = map[string]bool{
+	"duration":        true,
+	"filesize":        true,
+	"width":           true,
+	"height":          true,
+	"framerate":       true,
+	"videodatarate":   true,
+	"videocodecid":    true,
+	"audiodatarate":   true,
+	"audiosamplerate": true,
+	"audiosamplesize": true,
+	"audiocodecid":    true,
+}
+
+// metaBooleans are the onMetaData fields read as booleans
+var metaBooleans = map[string]bool{
+	"stereo": true,
+}
+
+// metaAliases are other names encoders give to a field
+var metaAliases = map[string]string{
+	"fps":            "framerate",
+	"videoframerate": "framerate",
+}
+
+// metaCodecIDs are the flv codec ids of the fourccs and names sent in
+// place of them
+var metaCodecIDs = map[string]float64{
+	"avc1": 7,
+	"h264": 7,
+	"hev1": 12,
+	"hvc1": 12,
+	"mp4a": 10,
+	"aac":  10,
+	".mp3": 2,
+	"mp3":  2,
+}
+
+// NormalizeMetaData gives the known fields of an onMetaData data message
+// their canonical names and types, the other fields and messages are
+// left alone. p is returned as is when there is nothing to change.
+func NormalizeMetaData(p []byte) ([]byte, error) {
+	r := bytes.NewReader(p)
+	decoder := &Decoder{}
+
+	name, err := decoder.DecodeAmf0(r)
+	if err != nil {
+		return nil, err
+	}
+	if name == SetDataFrame {
+		if name, err = decoder.DecodeAmf0(r); err != nil {
+			return nil, err
+		}
+	}
+	if name != OnMetaData || r.Len() == 0 {
+		return p, nil
+	}
+
+	start := len(p) - r.Len()
+	marker := p[start]
+	v, err := decoder.DecodeAmf0(r)
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := v.(Object)
+	if !ok || (marker != AMF0_OBJECT_MARKER && marker != AMF0_ECMA_ARRAY_MARKER) {
+		return p, nil
+	}
+	if !normalizeObject(meta) {
+		return p, nil
+	}
+
+	b := bytes.NewBuffer(make([]byte, 0, len(p)))
+	b.Write(p[:start])
+	encoder := &Encoder{}
+	if marker == AMF0_ECMA_ARRAY_MARKER {
+		_, err = encoder.EncodeAmf0EcmaArray(b, meta, true)
+	} else {
+		_, err = encoder.EncodeAmf0Object(b, meta, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.Write(p[len(p)-r.Len():])
+	return b.Bytes(), nil
+}
+
+// normalizeObject renames and coerces the known fields of meta in place,
+// it returns whether anything changed
+func normalizeObject(meta Object) bool {
+	changed := false
+	for key, v := range meta {
+		name := strings.ToLower(key)
+		if alias, ok := metaAliases[name]; ok {
+			name = alias
+		}
+		if !metaNumbers[name] && !metaBooleans[name] {
+			continue
+		}
+		if name != key {
+			// a field already under the canonical name wins
+			if _, ok := meta[name]; ok {
+				continue
+			}
+			delete(meta, key)
+			meta[name] = v
+			changed = true
+		}
+
+		if metaNumbers[name] {
+			if _, ok := v.(float64); ok {
+				continue
+			}
+			if n, ok := metaNumber(name, v); ok {
+				meta[name] = n
+				changed = true
+			}
+		} else {
+			if _, ok := v.(bool); ok {
+				continue
+			}
+			if b, ok := metaBoolean(v); ok {
+				meta[name] = b
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func metaNumber(name string, v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		s := strings.ToLower(strings.TrimSpace(v))
+		if id, ok := metaCodecIDs[s]; ok && strings.HasSuffix(name, "codecid") {
+			return id, true
+		}
+		// "2500 kbps", "30fps"...
+		s = strings.TrimSpace(strings.TrimRight(s, "abcdefghijklmnopqrstuvwxyz/"))
+		n, err := strconv.ParseFloat(s, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func metaBoolean(v interface{}) (bool, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v != 0, true
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "1", "yes", "stereo":
+			return true, true
+		case "false", "0", "no", "mono":
+			return false, true
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,167 @@
+package amf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func metaBlob(t *testing.T, ecma bool, names []string, meta Object) []byte {
+	b := new(bytes.Buffer)
+	enc := &Encoder{}
+	for _, name := range names {
+		if _, err := enc.EncodeAmf0String(b, name, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var err error
+	if ecma {
+		_, err = enc.EncodeAmf0EcmaArray(b, meta, true)
+	} else {
+		_, err = enc.EncodeAmf0Object(b, meta, true)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func decodeMeta(t *testing.T, p []byte) (Array, byte) {
+	dec := &Decoder{}
+	r := bytes.NewReader(p)
+	var vs Array
+	var marker byte
+	for r.Len() > 0 {
+		marker = p[len(p)-r.Len()]
+		v, err := dec.DecodeAmf0(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vs = append(vs, v)
+	}
+	return vs, marker
+}
+
+func TestNormalizeMetaData(t *testing.T) {
+	tests := []struct {
+		name   string
+		ecma   bool
+		names  []string
+		meta   Object
+		expect Object
+	}{
+		{
+			// an hardware encoder sending every field as a string
+			name:  "strings",
+			ecma:  true,
+			names: []string{SetDataFrame, OnMetaData},
+			meta: Object{
+				"width":         "1280",
+				"height":        "720",
+				"framerate":     "29.97",
+				"videodatarate": "2500 kbps",
+				"audiodatarate": "128",
+				"videocodecid":  "avc1",
+				"audiocodecid":  "mp4a",
+				"stereo":        "true",
+				"encoder":       "HW Encoder 2.1",
+				"serial":        "0042",
+			},
+			expect: Object{
+				"width":         float64(1280),
+				"height":        float64(720),
+				"framerate":     float64(29.97),
+				"videodatarate": float64(2500),
+				"audiodatarate": float64(128),
+				"videocodecid":  float64(7),
+				"audiocodecid":  float64(10),
+				"stereo":        true,
+				"encoder":       "HW Encoder 2.1",
+				"serial":        "0042",
+			},
+		},
+		{
+			// a mobile app with its own idea of the names, in an object
+			name:  "names",
+			names: []string{OnMetaData},
+			meta: Object{
+				"Width":           float64(720),
+				"Height":          float64(1280),
+				"fps":             "30fps",
+				"VideoDataRate":   float64(1200),
+				"audiosamplerate": float64(44100),
+				"stereo":          float64(0),
+				"deviceModel":     "Pixel",
+			},
+			expect: Object{
+				"width":           float64(720),
+				"height":          float64(1280),
+				"framerate":       float64(30),
+				"videodatarate":   float64(1200),
+				"audiosamplerate": float64(44100),
+				"stereo":          false,
+				"deviceModel":     "Pixel",
+			},
+		},
+		{
+			name:  "canonical name wins",
+			ecma:  true,
+			names: []string{OnMetaData},
+			meta: Object{
+				"framerate": float64(25),
+				"fps":       "60",
+			},
+			expect: Object{
+				"framerate": float64(25),
+				"fps":       "60",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		p := metaBlob(t, test.ecma, test.names, test.meta)
+		got, err := NormalizeMetaData(p)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		vs, marker := decodeMeta(t, got)
+		if len(vs) != len(test.names)+1 {
+			t.Fatalf("%s: expect %d values got %v", test.name, len(test.names)+1, vs)
+		}
+		for i, name := range test.names {
+			if vs[i] != name {
+				t.Errorf("%s: expect %s got %v", test.name, name, vs[i])
+			}
+		}
+		if ecma := marker == AMF0_ECMA_ARRAY_MARKER; ecma != test.ecma {
+			t.Errorf("%s: expect ecma array %v got %v", test.name, test.ecma, ecma)
+		}
+		if !reflect.DeepEqual(test.expect, vs[len(vs)-1]) {
+			t.Errorf("%s: expect %v got %v", test.name, test.expect, vs[len(vs)-1])
+		}
+	}
+}
+
+func TestNormalizeMetaDataPassthrough(t *testing.T) {
+	clean := metaBlob(t, true, []string{SetDataFrame, OnMetaData}, Object{
+		"width":  float64(1920),
+		"height": float64(1080),
+		"stereo": true,
+	})
+	cue := metaBlob(t, false, []string{"onCuePoint"}, Object{
+		"width": "not metadata",
+	})
+	for _, p := range [][]byte{clean, cue} {
+		got, err := NormalizeMetaData(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if &got[0] != &p[0] || len(got) != len(p) {
+			t.Errorf("expect the data unchanged")
+		}
+	}
+
+	if _, err := NormalizeMetaData([]byte{0xff}); err == nil {
+		t.Errorf("expect an error for invalid amf")
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/utils/reqid"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// query parameters that carry credentials and never make it into the logs
+var redactedParams = []string{"api_key", "jwt"}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// AccessLogMiddleware logs one line per request at api.log_level
+func AccessLogMiddleware(next http.Handler) http.Handler {
+	level, err := log.ParseLevel(configure.Config.GetString("api.log_level"))
+	if err != nil {
+		log.Warningf("api.log_level: %v, using info", err)
+		level = log.InfoLevel
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		fields := log.Fields{
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"query":    redactQuery(r.URL.Query()),
+			"remote":   r.RemoteAddr,
+			"status":   rec.status,
+			"duration": time.Since(start).String(),
+		}
+		if room := r.URL.Query().Get("room"); room != "" {
+			fields["room"] = room
+		}
+		reqid.Logger(r.Context()).WithFields(fields).Log(level, "api request")
+	})
+}
+
+func redactQuery(query url.Values) string {
+	for _, p := range redactedParams {
+		if _, ok := query[p]; ok {
+			query.Set(p, "REDACTED")
+		}
+	}
+	return query.Encode()
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SpooderfyBot/live/configure"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLog(t *testing.T) {
+	at := assert.New(t)
+	hook := test.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	level := log.GetLevel()
+	log.SetLevel(log.DebugLevel)
+	defer log.SetLevel(level)
+	configure.Config.Set("api.log_level", "debug")
+	defer configure.Config.Set("api.log_level", "info")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte("{}"))
+	})
+	h := AccessLogMiddleware(next)
+	serve := func(method, url string) *log.Entry {
+		hook.Reset()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, url, nil))
+		for _, e := range hook.AllEntries() {
+			if e.Message == "api request" {
+				return e
+			}
+		}
+		t.Fatalf("%s %s was not logged", method, url)
+		return nil
+	}
+
+	e := serve("GET", "/control/get?room=r1&api_key=secret&jwt=token")
+	at.Equal(log.DebugLevel, e.Level)
+	at.Equal("GET", e.Data["method"])
+	at.Equal("/control/get", e.Data["path"])
+	at.Equal(200, e.Data["status"])
+	at.Equal("r1", e.Data["room"])
+	at.NotEmpty(e.Data["duration"])
+	// the credentials never make it into the logs
+	query := e.Data["query"].(string)
+	at.False(strings.Contains(query, "secret"), query)
+	at.False(strings.Contains(query, "token"), query)
+	at.True(strings.Contains(query, "api_key=REDACTED"), query)
+	at.True(strings.Contains(query, "room=r1"), query)
+
+	e = serve("POST", "/missing")
+	at.Equal(404, e.Data["status"])
+	at.Equal("POST", e.Data["method"])
+	_, ok := e.Data["room"]
+	at.False(ok)
+
+	// an unknown level falls back to info
+	configure.Config.Set("api.log_level", "loud")
+	h = AccessLogMiddleware(next)
+	e = serve("GET", "/stats/summary")
+	at.Equal(log.InfoLevel, e.Level)
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/utils/reqid"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the groups of routes with an allowlist of their own
+const (
+	controlRoutes = iota
+	statsRoutes
+	mediaRoutes
+)
+
+// ipList is a list of networks, a bare IP is a network of one address
+type ipList []*net.IPNet
+
+// parseIPList parses CIDRs and IPs, invalid entries are logged and skipped
+func parseIPList(setting string) ipList {
+	var ret ipList
+	for _, entry := range configure.Config.GetStringSlice(setting) {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Warningf("%s: invalid entry %q", setting, entry)
+			continue
+		}
+		ret = append(ret, network)
+	}
+	return ret
+}
+
+func (l ipList) contains(ip net.IP) bool {
+	for _, network := range l {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// accessList allows the routes to the sources of api.control.allowed_cidrs,
+// api.stats.allowed_cidrs and api.media.allowed_cidrs, an empty list
+// allows anyone. X-Forwarded-For is only read from api.trusted_proxies.
+type accessList struct {
+	lock    sync.RWMutex
+	allowed [3]ipList
+	proxies ipList
+}
+
+func newAccessList() *accessList {
+	a := &accessList{}
+	a.load()
+	return a
+}
+
+// load reads the lists from the config
+func (a *accessList) load() {
+	allowed := [3]ipList{
+		controlRoutes: parseIPList("api.control.allowed_cidrs"),
+		statsRoutes:   parseIPList("api.stats.allowed_cidrs"),
+		mediaRoutes:   parseIPList("api.media.allowed_cidrs"),
+	}
+	proxies := parseIPList("api.trusted_proxies")
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.allowed, a.proxies = allowed, proxies
+}
+
+// clientIP is the address r comes from, see clientAddr
+func (a *accessList) clientIP(r *http.Request) net.IP {
+	a.lock.RLock()
+	proxies := a.proxies
+	a.lock.RUnlock()
+	return clientAddr(r, proxies)
+}
+
+// clientAddr is the address r comes from: the peer, or when the peer is
+// one of proxies the last address of X-Forwarded-For not one of them. It
+// is nil for a peer without an IP, on a unix socket.
+func clientAddr(r *http.Request, proxies ipList) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !proxies.contains(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// the proxy can't vouch for what comes before
+			break
+		}
+		ip = hop
+		if !proxies.contains(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// check answers 403 and returns true when the client of r is not allowed
+// on routes. Clients of a unix socket are local and always allowed.
+func (a *accessList) check(routes int, w http.ResponseWriter, r *http.Request) bool {
+	a.lock.RLock()
+	allowed, proxies := a.allowed[routes], a.proxies
+	a.lock.RUnlock()
+
+	if len(allowed) == 0 {
+		return false
+	}
+	ip := clientAddr(r, proxies)
+	if ip == nil || allowed.contains(ip) {
+		return false
+	}
+	reqid.Logger(r.Context()).Warningf("%s from %s refused by the api allowlist", r.URL.Path, ip)
+	res := &Response{
+		w:      w,
+		Data:   "Forbidden",
+		Status: 403,
+	}
+	_, _ = res.SendJson()
+	return true
+}
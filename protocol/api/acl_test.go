@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlAllowlist(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("api.control.allowed_cidrs", []string{"10.0.0.0/8", "192.0.2.7"})
+	configure.Config.Set("api.trusted_proxies", []string{"172.16.0.1"})
+	defer configure.Config.Set("api.control.allowed_cidrs", []string{})
+	defer configure.Config.Set("api.trusted_proxies", []string{})
+
+	handler := (&Server{handler: rtmp.NewRtmpStream()}).Handler("secret")
+	get := func(path, remote, forwarded string) int {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = remote
+		r.Header.Set("Authorization", "secret")
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	at.Equal(200, get("/control/rooms", "10.1.2.3:5000", ""))
+	at.Equal(200, get("/control/rooms", "192.0.2.7:5000", ""))
+	at.Equal(403, get("/control/rooms", "192.0.2.8:5000", ""))
+	// the stats have a list of their own
+	at.Equal(200, get("/stats/livestats", "192.0.2.8:5000", ""))
+
+	// only a trusted proxy is believed
+	at.Equal(403, get("/control/rooms", "192.0.2.8:5000", "10.1.2.3"))
+	at.Equal(200, get("/control/rooms", "172.16.0.1:5000", "10.1.2.3"))
+	at.Equal(403, get("/control/rooms", "172.16.0.1:5000", "10.1.2.3, 192.0.2.8"))
+	at.Equal(200, get("/control/rooms", "172.16.0.1:5000", "192.0.2.8, 10.1.2.3, 172.16.0.1"))
+	at.Equal(403, get("/control/rooms", "172.16.0.1:5000", ""))
+
+	// the allowlist comes before the api key
+	r := httptest.NewRequest("GET", "/control/rooms", nil)
+	r.RemoteAddr = "192.0.2.8:5000"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	at.Equal(403, w.Code)
+}
+
+func TestClientIPDuringReload(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("api.trusted_proxies", []string{"172.16.0.1"})
+	defer configure.Config.Set("api.trusted_proxies", []string{})
+	acl := newAccessList()
+
+	r := httptest.NewRequest("GET", "/stats/livestats", nil)
+	r.RemoteAddr = "172.16.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.3")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			acl.load()
+		}
+	}()
+	// the rate limiter reads the proxies while a reload swaps them
+	for i := 0; i < 100; i++ {
+		at.Equal("198.51.100.3", caller(acl, r))
+	}
+	<-done
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+)
+
+// roomAliaser is implemented by the inspectors able to serve a room with
+// the stream of another one, such as *rtmp.RtmpStream
+type roomAliaser interface {
+	rtmp.Aliaser
+	SetAlias(from, to string) error
+	Aliases() []configure.Alias
+}
+
+// http://127.0.0.1:8090/control/alias?from=ROOM&to=APP/ROOM[&app=live], an
+// empty to removes the alias and no from lists them
+func (server *Server) handleAlias(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if err := r.ParseForm(); err != nil {
+		res.Status = 400
+		res.Data = "url: /control/alias?from=<ROOM>&to=<APP/ROOM>"
+		return
+	}
+	app, err := streamApp(r)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+
+	inspector, ok := rtmp.Inspect(server.handler)
+	aliaser, canAlias := inspector.(roomAliaser)
+	if !ok || !canAlias {
+		res.Status = 500
+		res.Data = "Get rtmp stream information error"
+		return
+	}
+
+	if from := r.Form.Get("from"); from != "" {
+		to := r.Form.Get("to")
+		// a bare room is in the app of from
+		if to != "" && !strings.Contains(to, "/") {
+			to = app + "/" + to
+		}
+		if i := strings.Index(to, "/"); i >= 0 && !configure.CheckAppName(to[:i]) {
+			res.Status = 400
+			res.Data = fmt.Sprintf("application name=%s is not configured", to[:i])
+			return
+		}
+		if err := aliaser.SetAlias(app+"/"+from, to); err != nil {
+			res.Status = 400
+			res.Data = err.Error()
+			return
+		}
+	}
+	res.Data = aliaser.Aliases()
+}
@@ -1,32 +1,55 @@
 package api
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/SpooderfyBot/live/av"
 	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/container/flv"
 	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/rtmp/cache"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
 	"github.com/SpooderfyBot/live/protocol/rtmp/rtmprelay"
+	"github.com/SpooderfyBot/live/utils/reqid"
+	"github.com/SpooderfyBot/live/utils/worker"
 
-	jwtmiddleware "github.com/auth0/go-jwt-middleware"
-	"github.com/dgrijalva/jwt-go"
 	log "github.com/sirupsen/logrus"
 )
 
 type Response struct {
 	w      http.ResponseWriter
 	Status int         `json:"status"`
+	Code   string      `json:"code,omitempty"` // tells errors apart where Data is free text
 	Data   interface{} `json:"data"`
 }
 
+// SendJson writes the response, a Data which can't be marshalled is
+// answered with a 500 error payload instead
 func (r *Response) SendJson() (int, error) {
-	resp, _ := json.Marshal(r)
+	resp, err := json.Marshal(r)
+	if err != nil {
+		log.Error("Marshal api response error: ", err)
+		r.Status = 500
+		resp, _ = json.Marshal(&Response{Status: r.Status, Data: "internal error"})
+	}
 	r.w.Header().Set("Content-Type", "application/json")
 	r.w.WriteHeader(r.Status)
-	return r.w.Write(resp)
+	n, err := r.w.Write(resp)
+	if err != nil {
+		log.Warning("Write api response error: ", err)
+	}
+	return n, err
 }
 
 type Operation struct {
@@ -49,59 +72,47 @@ type ClientInfo struct {
 }
 
 type Server struct {
-	handler  av.Handler
-	session  map[string]*rtmprelay.RtmpRelay
-	rtmpAddr string
+	handler     av.Handler
+	sessionLock sync.RWMutex
+	session     map[string]*rtmprelay.RtmpRelay
+	rtmpAddr    string
+	segments    SegmentCounter
+	flv, hls    http.Handler
+}
+
+// SegmentCounter reports the hls segments cached for a stream
+type SegmentCounter interface {
+	SegmentCount(key string) int
 }
 
 func NewServer(h av.Handler, rtmpAddr string) *Server {
-	return &Server{
+	server := &Server{
 		handler:  h,
 		session:  make(map[string]*rtmprelay.RtmpRelay),
 		rtmpAddr: rtmpAddr,
 	}
+	server.startStaticRelays()
+	go server.reapSessions()
+	return server
 }
 
-func JWTMiddleware(next http.Handler) http.Handler {
-	isJWT := len(configure.Config.GetString("jwt.secret")) > 0
-	if !isJWT {
-		return next
+// apiKeyFromRequest mirrors the jwt FromFirst extractor, the authorization
+// header is preferred and the api_key query parameter is the fallback for
+// clients that can't set headers.
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("authorization"); key != "" {
+		return key
 	}
-
-	log.Info("Using JWT middleware")
-
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var algorithm jwt.SigningMethod
-		if len(configure.Config.GetString("jwt.algorithm")) > 0 {
-			algorithm = jwt.GetSigningMethod(configure.Config.GetString("jwt.algorithm"))
-		}
-
-		if algorithm == nil {
-			algorithm = jwt.SigningMethodHS256
-		}
-
-		jwtMiddleware := jwtmiddleware.New(jwtmiddleware.Options{
-			Extractor: jwtmiddleware.FromFirst(jwtmiddleware.FromAuthHeader, jwtmiddleware.FromParameter("jwt")),
-			ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-				return []byte(configure.Config.GetString("jwt.secret")), nil
-			},
-			SigningMethod: algorithm,
-			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err string) {
-				res := &Response{
-					w:      w,
-					Status: 403,
-					Data:   err,
-				}
-				res.SendJson()
-			},
-		})
-
-		jwtMiddleware.HandlerWithNext(w, r, next.ServeHTTP)
-	})
+	if key := r.URL.Query().Get("api_key"); key != "" {
+		reqid.Logger(r.Context()).Warningf("API key passed as query parameter from %s, it may show up in access logs", r.RemoteAddr)
+		return key
+	}
+	return ""
 }
 
 func checkAuth(expectedKey string, w http.ResponseWriter, r *http.Request) bool {
-	if r.Header.Get("authorization") != expectedKey {
+	key := apiKeyFromRequest(r)
+	if subtle.ConstantTimeCompare([]byte(key), []byte(expectedKey)) != 1 {
 		res := &Response{
 			w:      w,
 			Data:   "Unauthorized",
@@ -114,75 +125,246 @@ func checkAuth(expectedKey string, w http.ResponseWriter, r *http.Request) bool
 	return false
 }
 
+// apiRoute is an endpoint of the http api, its entry in apiDocs
+// describes it in /api/openapi.json
+type apiRoute struct {
+	path   string
+	stats  bool // rate limited and compressed as a stats endpoint
+	handle func(*Server, http.ResponseWriter, *http.Request)
+}
+
+// apiRoutes lists the endpoints served behind the api key, a new
+// endpoint is added here and documented in apiDocs
+var apiRoutes = []apiRoute{
+	{path: "/control/push", handle: (*Server).handlePush},
+	{path: "/control/pull", handle: (*Server).handlePull},
+	{path: "/control/ping", handle: (*Server).handlePing},
+	{path: "/control/get", handle: (*Server).handleGet},
+	{path: "/control/reset", handle: (*Server).handleReset},
+	{path: "/control/limits", handle: (*Server).handleLimits},
+	{path: "/control/quota", handle: (*Server).handleQuota},
+	{path: "/control/settings", handle: (*Server).handleSettings},
+	{path: "/control/kick", handle: (*Server).handleKick},
+	{path: "/control/delete", handle: (*Server).handleDelete},
+	{path: "/control/promote", handle: (*Server).handlePromote},
+	{path: "/control/alias", handle: (*Server).handleAlias},
+	{path: "/control/flush-gop", handle: (*Server).handleFlushGop},
+	{path: "/control/test-publish", handle: (*Server).handleTestPublish},
+	{path: "/control/maintenance", handle: (*Server).handleMaintenance},
+	{path: "/control/rooms", handle: (*Server).handleRooms},
+	{path: "/control/metadata", handle: (*Server).handleMetadata},
+	{path: "/control/snapshot", handle: (*Server).handleSnapshot},
+	{path: "/control/restore", handle: (*Server).handleRestore},
+	{path: "/control/reload", handle: (*Server).handleReload},
+	{path: "/stats/livestats", stats: true, handle: (*Server).GetLiveStatics},
+	{path: "/stats/livestat", stats: true, handle: (*Server).GetLiveStat},
+	{path: "/stats/rooms", stats: true, handle: (*Server).GetRooms},
+	{path: "/stats/summary", stats: true, handle: (*Server).GetSummary},
+	{path: "/stats/events", stats: true, handle: (*Server).GetEvents},
+	{path: "/stats/blocked", stats: true, handle: (*Server).GetBlocked},
+	{path: "/stats/relay", stats: true, handle: (*Server).GetRelay},
+	{path: "/v2/stats/livestats", stats: true, handle: (*Server).GetLiveStaticsV2},
+	{path: "/v2/stats/livestat", stats: true, handle: (*Server).GetLiveStatV2},
+	{path: "/v2/stats/summary", stats: true, handle: (*Server).GetSummaryV2},
+	{path: "/api/v2/relays", stats: true, handle: (*Server).GetRelaysV2},
+	{path: "/api/v2/relays/pull/start", handle: (*Server).handlePullStartV2},
+	{path: "/api/v2/relays/pull/stop", handle: (*Server).handlePullStopV2},
+	{path: "/api/v2/relays/push/start", handle: (*Server).handlePushStartV2},
+	{path: "/api/v2/relays/push/stop", handle: (*Server).handlePushStopV2},
+	{path: "/api/v2/rooms/key", handle: (*Server).handleRoomKeyV2},
+	{path: "/api/v2/rooms/reset", handle: (*Server).handleRoomResetV2},
+	{path: "/api/v2/rooms/delete", handle: (*Server).handleRoomDeleteV2},
+	{path: "/api/v2/rooms/kick", handle: (*Server).handleRoomKickV2},
+}
+
 func (server *Server) Serve(l net.Listener, apiKey string) error {
-	fmt.Printf("Using API KEY: %s", apiKey)
+	_ = http.Serve(l, server.Handler(apiKey))
+	return nil
+}
+
+// Handler is the api as served by Serve, for serving it on several
+// listeners with the same rate limits
+func (server *Server) Handler(apiKey string) http.Handler {
+	if apiKey == "" {
+		log.Warning("No API_KEY set, the HTTP API is not protected")
+	}
+
+	controlLimit := newRateLimiter(
+		configure.Config.GetFloat64("rate_limit.control_rate"),
+		configure.Config.GetInt("rate_limit.control_burst"),
+	)
+	statsLimit := newRateLimiter(
+		configure.Config.GetFloat64("rate_limit.stats_rate"),
+		configure.Config.GetInt("rate_limit.stats_burst"),
+	)
+	acl := newAccessList()
+	configure.OnReload(func() {
+		acl.load()
+		controlLimit.set(
+			configure.Config.GetFloat64("rate_limit.control_rate"),
+			configure.Config.GetInt("rate_limit.control_burst"),
+		)
+		statsLimit.set(
+			configure.Config.GetFloat64("rate_limit.stats_rate"),
+			configure.Config.GetInt("rate_limit.stats_burst"),
+		)
+	})
 
 	mux := http.NewServeMux()
 
-	mux.Handle("/statics/", http.StripPrefix("/statics/", http.FileServer(http.Dir("statics"))))
+	mountStatics(mux)
 
-	mux.HandleFunc("/control/push", func(w http.ResponseWriter, r *http.Request) {
-		if checkAuth(apiKey, w, r) {
-			return
+	for _, route := range apiRoutes {
+		route, limit, routes := route, controlLimit, controlRoutes
+		allowed := route.allowedMethods()
+		handle := func(w http.ResponseWriter, r *http.Request) {
+			route.handle(server, w, r)
 		}
-		server.handlePush(w, r)
-	})
-	mux.HandleFunc("/control/pull", func(w http.ResponseWriter, r *http.Request) {
-		if checkAuth(apiKey, w, r) {
-			return
+		if route.stats {
+			limit, routes = statsLimit, statsRoutes
+			handle = withCompression(handle)
 		}
-		server.handlePull(w, r)
-	})
-	mux.HandleFunc("/control/get", func(w http.ResponseWriter, r *http.Request) {
-		if checkAuth(apiKey, w, r) {
-			return
-		}
-		server.handleGet(w, r)
-	})
-	mux.HandleFunc("/control/reset", func(w http.ResponseWriter, r *http.Request) {
-		if checkAuth(apiKey, w, r) {
-			return
-		}
-		server.handleReset(w, r)
-	})
-	mux.HandleFunc("/control/delete", func(w http.ResponseWriter, r *http.Request) {
-		if checkAuth(apiKey, w, r) {
-			return
-		}
-		server.handleDelete(w, r)
-	})
-	mux.HandleFunc("/stats/livestats", func(w http.ResponseWriter, r *http.Request) {
-		if checkAuth(apiKey, w, r) {
-			return
-		}
-		server.GetLiveStatics(w, r)
-	})
-	mux.HandleFunc("/stats/livestat", func(w http.ResponseWriter, r *http.Request) {
-		if checkAuth(apiKey, w, r) {
-			return
-		}
-		server.GetLiveStat(w, r)
-	})
-	_ = http.Serve(l, JWTMiddleware(mux))
-	return nil
+		mux.HandleFunc(route.path, func(w http.ResponseWriter, r *http.Request) {
+			if acl.check(routes, w, r) || checkAuth(apiKey, w, r) || checkMethod(allowed, w, r) || limit.check(caller(acl, r), w) {
+				return
+			}
+			handle(w, r)
+		})
+	}
+	// the spec holds no secrets, integrators can read it without a key
+	mux.HandleFunc(openAPIPath, serveOpenAPI)
+	mux.HandleFunc("/", handleNotFound)
+
+	return server.withMedia(acl, RequestIDMiddleware(AccessLogMiddleware(CORSMiddleware(JWTMiddleware(mux)))))
 }
 
 type stream struct {
 	Key             string `json:"key"`
+	Id              string `json:"id"`
+	Addr            string `json:"addr,omitempty"`
 	Url             string `json:"url"`
 	StreamId        uint32 `json:"stream_id"`
 	VideoTotalBytes uint64 `json:"video_total_bytes"`
-	VideoSpeed      uint64 `json:"video_speed"` // todo maybe rename this??? to bitrate
+	VideoSpeed      uint64 `json:"video_speed"` // deprecated, use video_bitrate_kbps
 	AudioTotalBytes uint64 `json:"audio_total_bytes"`
-	AudioSpeed      uint64 `json:"audio_speed"`
+	AudioSpeed      uint64 `json:"audio_speed"` // deprecated, use audio_bitrate_kbps
+	VideoBitrate    uint64 `json:"video_bitrate_kbps"`
+	AudioBitrate    uint64 `json:"audio_bitrate_kbps"`
+	PlayerCount     int    `json:"player_count,omitempty"`
+	MaxPlayers      int    `json:"max_players,omitempty"`
+	HlsSegments     int    `json:"hls_segments,omitempty"`
+	HlsWriteFails   uint64 `json:"hls_write_failures,omitempty"`
+	SourceType      string `json:"source_type,omitempty"` // publishers only
+	MaxBitrate      uint64 `json:"max_bitrate_kbps,omitempty"`
+	NearMaxBitrate  bool   `json:"near_max_bitrate,omitempty"`
+	QuotaRemaining  *int64 `json:"quota_remaining_bytes,omitempty"` // of the rtmp players
+
+	// timestamp gaps and packets arriving late since the publisher connected
+	Discontinuities  uint64 `json:"discontinuities"`
+	ReorderedPackets uint64 `json:"reordered_packets"`
+
+	// from the sequence headers of the publisher, blank until it sent them
+	LastKeyFrameAge *int64  `json:"last_keyframe_age_ms"`
+	VideoCodec      string  `json:"video_codec"`
+	AudioCodec      string  `json:"audio_codec"`
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+	FrameRate       float64 `json:"framerate"`
+
+	Buffer *rtmp.BufferStats `json:"buffer,omitempty"`
+
+	// the flv_archive file of a publisher, nil when it is not recorded
+	Recording *flv.Recording `json:"recording,omitempty"`
+}
+
+// roomLimits is implemented by the inspectors keeping the max players of
+// the rooms, such as *rtmp.RtmpStream
+type roomLimits interface {
+	MaxPlayers(key string) int
+	SetMaxPlayers(key string, n int)
+}
+
+// roomQuotas is implemented by the inspectors keeping the bandwidth
+// quotas of the rooms, such as *rtmp.RtmpStream
+type roomQuotas interface {
+	SetQuota(key string, q rtmp.Quota)
+	QuotaStatus(key string) (rtmp.QuotaStatus, bool)
+}
+
+// maxPlayers is the max players of key, rtmp.max_players_per_stream when the
+// inspector keeps no limits
+func maxPlayers(inspector rtmp.StreamInspector, key string) int {
+	if l, ok := inspector.(roomLimits); ok {
+		return l.MaxPlayers(key)
+	}
+	return configure.Config.GetInt("rtmp.max_players_per_stream")
+}
+
+// setQuota fills the bytes left in the quota of key, if it has one
+func (msg *stream) setQuota(inspector rtmp.StreamInspector, key string) {
+	q, ok := inspector.(roomQuotas)
+	if !ok {
+		return
+	}
+	if status, ok := q.QuotaStatus(key); ok {
+		msg.QuotaRemaining = &status.Remaining
+	}
+}
+
+func (msg *stream) setContinuity(c rtmp.ContinuityStats) {
+	msg.Discontinuities = c.Discontinuities
+	msg.ReorderedPackets = c.ReorderedPackets
+}
+
+// setMedia fills the codec fields, the key frame age stays null until
+// the first key frame
+func (msg *stream) setMedia(info cache.MediaInfo) {
+	msg.VideoCodec = info.VideoCodec
+	msg.AudioCodec = info.AudioCodec
+	msg.Width = info.Width
+	msg.Height = info.Height
+	msg.FrameRate = info.FrameRate
+	if !info.LastKeyFrame.IsZero() {
+		age := int64(time.Since(info.LastKeyFrame) / time.Millisecond)
+		msg.LastKeyFrameAge = &age
+	}
+}
+
+// capacityReporter is implemented by the inspectors enforcing the global
+// limits, such as *rtmp.RtmpStream
+type capacityReporter interface {
+	Capacity() rtmp.Capacity
+}
+
+// summary speeds are in kbit/s like the per stream ones
+type summary struct {
+	Publishers    int    `json:"publishers"`
+	Players       int    `json:"players"`
+	InboundSpeed  uint64 `json:"inbound_speed"`
+	OutboundSpeed uint64 `json:"outbound_speed"`
+	ActiveRelays  int    `json:"active_relays"`
+	PlaybackMode  string `json:"playback_mode"`
+	Maintenance   bool   `json:"maintenance"`
+
+	// the pool running the webhooks and relay retries
+	Workers worker.Stats `json:"workers"`
+	// the live streams and connections against their limits
+	Capacity rtmp.Capacity `json:"capacity"`
+	// the live rooms, without their keys
+	Streams []StreamDescriptor `json:"streams"`
 }
 
 type streams struct {
 	Publishers []stream `json:"publishers"`
 	Players    []stream `json:"players"`
+	Relays     []relay  `json:"relays"`
 }
 
-// http://127.0.0.1:8090/stats/livestat?room=xyz
+// notReadyRetryAfter is the Retry-After in seconds for a stream
+// still being set up
+const notReadyRetryAfter = "1"
+
+// http://127.0.0.1:8090/stats/livestat?room=xyz[&app=live]
 func (server *Server) GetLiveStat(w http.ResponseWriter, req *http.Request) {
 	res := &Response{
 		w:      w,
@@ -192,56 +374,82 @@ func (server *Server) GetLiveStat(w http.ResponseWriter, req *http.Request) {
 
 	defer res.SendJson()
 
-	rtmpStream := server.handler.(*rtmp.RtmpStream)
-	if rtmpStream == nil {
+	if msg := server.liveStat(w, req, res); msg != nil {
+		res.Data = msg
+	}
+}
+
+// liveStat collects the stats of the publisher of a room for every api
+// version, on error it fills res and returns nil
+func (server *Server) liveStat(w http.ResponseWriter, req *http.Request, res *Response) *stream {
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
 		res.Status = 500
 		res.Data = "Get rtmp stream information error"
-		return
+		return nil
 	}
 
 	if req.ParseForm() != nil {
 		res.Status = 500
 		res.Data = "Failed to parse form"
-		return
+		return nil
 	}
 
+	app, err := streamApp(req)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return nil
+	}
 	room := req.Form.Get("room")
-	key := fmt.Sprintf("live/%s", room)
+	key := fmt.Sprintf("%s/%s", app, room)
 
-	s, ok := rtmpStream.GetStream(key)
+	s, ok := inspector.GetStream(key)
 	if !ok {
 		res.Status = 404
 		res.Data = "No room was found"
-		return
+		return nil
 	}
 
+	// the stream is created as soon as the publisher connects,
+	// its reader is only attached right after
 	reader := s.GetReader()
 	if reader == nil {
-		res.Status = 500
-		res.Data = "This room has no readers"
-		return
+		w.Header().Set("Retry-After", notReadyRetryAfter)
+		res.Status = 503
+		res.Data = "This room is not ready yet"
+		return nil
 	}
 
 	switch s.GetReader().(type) {
 	case *rtmp.VirReader:
 		v := s.GetReader().(*rtmp.VirReader)
+		bw := v.BWInfo()
 		msg := stream{
-			key,
-			v.Info().URL,
-			v.ReadBWInfo.StreamId,
-			v.ReadBWInfo.VideoDatainBytes,
-			v.ReadBWInfo.VideoSpeedInBytesperMS,
-			v.ReadBWInfo.AudioDatainBytes,
-			v.ReadBWInfo.AudioSpeedInBytesperMS,
+			Key:             key,
+			Id:              v.Info().UID,
+			Url:             v.Info().URL,
+			StreamId:        bw.StreamId,
+			VideoTotalBytes: bw.VideoDatainBytes,
+			VideoSpeed:      bw.VideoSpeedInBytesperMS,
+			AudioTotalBytes: bw.AudioDatainBytes,
+			AudioSpeed:      bw.AudioSpeedInBytesperMS,
+			VideoBitrate:    bw.VideoBitrateKbps,
+			AudioBitrate:    bw.AudioBitrateKbps,
+			PlayerCount:     s.PlayerCount(),
+			MaxPlayers:      maxPlayers(inspector, key),
+			SourceType:      server.sourceType(key),
 		}
-
-		res.Data = msg
-		return
+		msg.MaxBitrate, msg.NearMaxBitrate = v.BitrateLimit()
+		msg.setContinuity(v.Continuity())
+		msg.setQuota(inspector, key)
+		msg.setMedia(s.MediaInfo())
+		return &msg
 	}
 
 	res.Status = 500
 	res.Data = "Reader returned by RTMP stream was not virtual reader."
-
+	return nil
 }
 
 // http://127.0.0.1:8090/stats/livestats
@@ -254,23 +462,151 @@ func (server *Server) GetLiveStatics(w http.ResponseWriter, req *http.Request) {
 
 	defer res.SendJson()
 
-	rtmpStream := server.handler.(*rtmp.RtmpStream)
-	if rtmpStream == nil {
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
 		res.Status = 500
 		res.Data = "Get rtmp stream information error"
 		return
 	}
 
+	msgs := server.collectStreams(inspector)
+
+	// resp, _ := json.Marshal(msgs)
+	res.Data = msgs
+}
+
+// http://127.0.0.1:8090/stats/summary
+func (server *Server) GetSummary(w http.ResponseWriter, req *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+
+	defer res.SendJson()
+
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
+		res.Status = 500
+		res.Data = "Get rtmp stream information error"
+		return
+	}
+
+	res.Data = server.summarize(server.collectStreams(inspector), req)
+}
+
+// summarize totals the collected streams for every api version
+func (server *Server) summarize(msgs *streams, r *http.Request) summary {
+	msg := summary{
+		Publishers: len(msgs.Publishers),
+		Players:    len(msgs.Players),
+	}
+	for _, p := range msgs.Publishers {
+		msg.InboundSpeed += p.VideoBitrate + p.AudioBitrate
+	}
+	for _, p := range msgs.Players {
+		msg.OutboundSpeed += p.VideoBitrate + p.AudioBitrate
+	}
+	for _, r := range msgs.Relays {
+		if r.Running {
+			msg.ActiveRelays++
+		}
+	}
+	msg.Workers = worker.Shared().Stats()
+	if inspector, ok := rtmp.Inspect(server.handler); ok {
+		if c, ok := inspector.(capacityReporter); ok {
+			msg.Capacity = c.Capacity()
+		}
+	}
+	msg.PlaybackMode = configure.PlaybackSettings().Mode
+	msg.Maintenance = rtmp.Maintenance()
+	msg.Streams = make([]StreamDescriptor, 0, len(msgs.Publishers))
+	for _, p := range msgs.Publishers {
+		app, room := p.Key, ""
+		if i := strings.Index(p.Key, "/"); i >= 0 {
+			app, room = p.Key[:i], p.Key[i+1:]
+		}
+		msg.Streams = append(msg.Streams, server.describe(app, room, "", r))
+	}
+	return msg
+}
+
+// recording is the file the recorder of s is writing, nil when s is
+// not recorded
+func recording(s *rtmp.Stream) (ret *flv.Recording) {
+	s.GetWs().Range(func(k, v interface{}) bool {
+		pw, ok := v.(*rtmp.PackWriterCloser)
+		if !ok {
+			return true
+		}
+		if recorder, ok := pw.GetWriter().(interface{ Recording() flv.Recording }); ok {
+			r := recorder.Recording()
+			ret = &r
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// SetSegmentCounter makes the stats report the hls segments of each stream
+func (server *Server) SetSegmentCounter(c SegmentCounter) {
+	server.segments = c
+}
+
+func (server *Server) segmentCount(key string) int {
+	if server.segments == nil {
+		return 0
+	}
+	return server.segments.SegmentCount(key)
+}
+
+// segmentWriteFailures is implemented by the segment counters also
+// writing the segments to disk, such as *hls.Server
+type segmentWriteFailures interface {
+	WriteFailures(key string) uint64
+}
+
+func (server *Server) writeFailures(key string) uint64 {
+	if f, ok := server.segments.(segmentWriteFailures); ok {
+		return f.WriteFailures(key)
+	}
+	return 0
+}
+
+// collectStreams gathers the publishers, players and relays of the server
+func (server *Server) collectStreams(inspector rtmp.StreamInspector) *streams {
 	msgs := new(streams)
 
-	rtmpStream.GetStreams().Range(func(key, val interface{}) bool {
+	inspector.GetStreams().Range(func(key, val interface{}) bool {
 		if s, ok := val.(*rtmp.Stream); ok {
 			if s.GetReader() != nil {
 				switch s.GetReader().(type) {
 				case *rtmp.VirReader:
 					v := s.GetReader().(*rtmp.VirReader)
-					msg := stream{key.(string), v.Info().URL, v.ReadBWInfo.StreamId, v.ReadBWInfo.VideoDatainBytes, v.ReadBWInfo.VideoSpeedInBytesperMS,
-						v.ReadBWInfo.AudioDatainBytes, v.ReadBWInfo.AudioSpeedInBytesperMS}
+					bw := v.BWInfo()
+					msg := stream{
+						Key:             key.(string),
+						Id:              v.Info().UID,
+						Url:             v.Info().URL,
+						StreamId:        bw.StreamId,
+						VideoTotalBytes: bw.VideoDatainBytes,
+						VideoSpeed:      bw.VideoSpeedInBytesperMS,
+						AudioTotalBytes: bw.AudioDatainBytes,
+						AudioSpeed:      bw.AudioSpeedInBytesperMS,
+						VideoBitrate:    bw.VideoBitrateKbps,
+						AudioBitrate:    bw.AudioBitrateKbps,
+						PlayerCount:     s.PlayerCount(),
+						MaxPlayers:      maxPlayers(inspector, key.(string)),
+						HlsSegments:     server.segmentCount(key.(string)),
+						HlsWriteFails:   server.writeFailures(key.(string)),
+						SourceType:      server.sourceType(key.(string)),
+					}
+					msg.MaxBitrate, msg.NearMaxBitrate = v.BitrateLimit()
+					msg.setContinuity(v.Continuity())
+					msg.setQuota(inspector, key.(string))
+					msg.setMedia(s.MediaInfo())
+					msg.Recording = recording(s)
 					msgs.Publishers = append(msgs.Publishers, msg)
 				}
 			}
@@ -278,7 +614,7 @@ func (server *Server) GetLiveStatics(w http.ResponseWriter, req *http.Request) {
 		return true
 	})
 
-	rtmpStream.GetStreams().Range(func(key, val interface{}) bool {
+	inspector.GetStreams().Range(func(key, val interface{}) bool {
 		ws := val.(*rtmp.Stream).GetWs()
 		ws.Range(func(k, v interface{}) bool {
 			if pw, ok := v.(*rtmp.PackWriterCloser); ok {
@@ -286,8 +622,23 @@ func (server *Server) GetLiveStatics(w http.ResponseWriter, req *http.Request) {
 					switch pw.GetWriter().(type) {
 					case *rtmp.VirWriter:
 						v := pw.GetWriter().(*rtmp.VirWriter)
-						msg := stream{key.(string), v.Info().URL, v.WriteBWInfo.StreamId, v.WriteBWInfo.VideoDatainBytes, v.WriteBWInfo.VideoSpeedInBytesperMS,
-							v.WriteBWInfo.AudioDatainBytes, v.WriteBWInfo.AudioSpeedInBytesperMS}
+						bw := v.BWInfo()
+						msg := stream{
+							Key:             key.(string),
+							Id:              v.Info().UID,
+							Addr:            v.RemoteAddr(),
+							Url:             v.Info().URL,
+							StreamId:        bw.StreamId,
+							VideoTotalBytes: bw.VideoDatainBytes,
+							VideoSpeed:      bw.VideoSpeedInBytesperMS,
+							AudioTotalBytes: bw.AudioDatainBytes,
+							AudioSpeed:      bw.AudioSpeedInBytesperMS,
+							VideoBitrate:    bw.VideoBitrateKbps,
+							AudioBitrate:    bw.AudioBitrateKbps,
+						}
+						msg.setMedia(val.(*rtmp.Stream).MediaInfo())
+						buffer := v.BufferStats()
+						msg.Buffer = &buffer
 						msgs.Players = append(msgs.Players, msg)
 					}
 				}
@@ -297,11 +648,68 @@ func (server *Server) GetLiveStatics(w http.ResponseWriter, req *http.Request) {
 		return true
 	})
 
-	// resp, _ := json.Marshal(msgs)
-	res.Data = msgs
+	for key, r := range server.sessions() {
+		msgs.Relays = append(msgs.Relays, relay{
+			Key:        key,
+			Group:      sessionGroup(key),
+			PlayUrl:    r.PlayUrl,
+			PublishUrl: r.PublishUrl,
+			Running:    r.IsStart(),
+		})
+	}
+	sort.Slice(msgs.Relays, func(i, j int) bool {
+		return msgs.Relays[i].Key < msgs.Relays[j].Key
+	})
+
+	return msgs
 }
 
-// http://127.0.0.1:8090/control/pull?&oper=start&app=live&name=123456&url=rtmp://192.168.16.136/live/123456
+// http://127.0.0.1:8090/stats/events?room=xyz[&app=live]
+func (server *Server) GetEvents(w http.ResponseWriter, req *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+
+	defer res.SendJson()
+
+	if req.ParseForm() != nil {
+		res.Status = 400
+		res.Data = "url: /stats/events?room=<ROOM_NAME>"
+		return
+	}
+
+	room := req.Form.Get("room")
+	if len(room) == 0 {
+		res.Status = 400
+		res.Data = "url: /stats/events?room=<ROOM_NAME>"
+		return
+	}
+
+	app, err := streamApp(req)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+	res.Data = events.Get(fmt.Sprintf("%s/%s", app, room))
+}
+
+// http://127.0.0.1:8090/stats/blocked
+func (server *Server) GetBlocked(w http.ResponseWriter, req *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+
+	defer res.SendJson()
+
+	res.Data = rtmp.BlockedIPs()
+}
+
+// http://127.0.0.1:8090/control/pull?&oper=start&app=live&name=123456&url=rtmp://192.168.16.136/live/123456[&url=MIRROR_URL][&dry_run=true]
 func (server *Server) handlePull(w http.ResponseWriter, req *http.Request) {
 	var retString string
 	var err error
@@ -323,21 +731,34 @@ func (server *Server) handlePull(w http.ResponseWriter, req *http.Request) {
 	oper := req.Form.Get("oper")
 	app := req.Form.Get("app")
 	name := req.Form.Get("name")
-	url := req.Form.Get("url")
+	// repeated urls are mirrors of the first, by priority
+	urls := req.Form["url"]
 
-	log.Debugf("control pull: oper=%v, app=%v, name=%v, url=%v", oper, app, name, url)
-	if (len(app) <= 0) || (len(name) <= 0) || (len(url) <= 0) {
+	reqid.Logger(req.Context()).Debugf("control pull: oper=%v, app=%v, name=%v, urls=%v", oper, app, name, urls)
+	if (len(app) <= 0) || (len(name) <= 0) || (len(urls) <= 0) {
 		res.Status = 400
 		res.Data = "control push parameter error, please check them."
 		return
 	}
 
-	remoteurl := "rtmp://127.0.0.1" + server.rtmpAddr + "/" + app + "/" + name
-	localurl := url
+	remoteurl := server.localUrl(app, name)
+	url := urls[0]
+	localurls := urls
 
 	keyString := "pull:" + app + "/" + name
+	if isDryRun(req) {
+		if oper != "stop" {
+			if localurls, err = checkRelayURLs(urls, pullSchemes); err != nil {
+				res.Status = 400
+				res.Data = err.Error()
+				return
+			}
+		}
+		res.Data = server.dryRunRelay(req.Context(), oper, keyString, localurls)
+		return
+	}
 	if oper == "stop" {
-		pullRtmprelay, found := server.session[keyString]
+		pullRtmprelay, found := server.takeSession(keyString)
 
 		if !found {
 			retString = fmt.Sprintf("session key[%s] not exist, please check it again.", keyString)
@@ -345,31 +766,42 @@ func (server *Server) handlePull(w http.ResponseWriter, req *http.Request) {
 			res.Data = retString
 			return
 		}
-		log.Debugf("rtmprelay stop push %s from %s", remoteurl, localurl)
+		reqid.Logger(req.Context()).Debugf("rtmprelay stop push %s from %s", remoteurl, pullRtmprelay.ActiveSource())
 		pullRtmprelay.Stop()
 
-		delete(server.session, keyString)
 		retString = fmt.Sprintf("<h1>push url stop %s ok</h1></br>", url)
 		res.Status = 400
 		res.Data = retString
-		log.Debugf("pull stop return %s", retString)
+		reqid.Logger(req.Context()).Debugf("pull stop return %s", retString)
 	} else {
-		pullRtmprelay := rtmprelay.NewRtmpRelay(&localurl, &remoteurl)
-		log.Debugf("rtmprelay start push %s from %s", remoteurl, localurl)
-		err = pullRtmprelay.Start()
+		if localurls, err = checkRelayURLs(urls, pullSchemes); err != nil {
+			res.Status = 400
+			res.Data = err.Error()
+			return
+		}
+		reqid.Logger(req.Context()).Debugf("rtmprelay start push %s from %v", remoteurl, localurls)
+		pullRtmprelay, err := server.startRelay(req.Context(), keyString, app+"/"+name, localurls[0], remoteurl, localurls[1:])
 		if err != nil {
 			retString = fmt.Sprintf("push error=%v", err)
 		} else {
-			server.session[keyString] = pullRtmprelay
-			retString = fmt.Sprintf("<h1>push url start %s ok</h1></br>", url)
+			retString = fmt.Sprintf("<h1>push url start %s ok</h1></br>", pullRtmprelay.ActiveSource())
 		}
-		res.Status = 400
+		res.Status = startStatus(err, 400)
 		res.Data = retString
-		log.Debugf("pull start return %s", retString)
+		reqid.Logger(req.Context()).Debugf("pull start return %s", retString)
+	}
+}
+
+// startStatus is the status of a relay start that returned err, a start
+// timing out is a 504 so callers can tell it from a bad request
+func startStatus(err error, status int) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
 	}
+	return status
 }
 
-// http://127.0.0.1:8090/control/push?&oper=start&app=live&name=123456&url=rtmp://192.168.16.136/live/123456
+// http://127.0.0.1:8090/control/push?&oper=start&app=live&name=123456&url=rtmp://192.168.16.136/live/123456[&dry_run=true]
 func (server *Server) handlePush(w http.ResponseWriter, req *http.Request) {
 	var retString string
 	var err error
@@ -390,46 +822,81 @@ func (server *Server) handlePush(w http.ResponseWriter, req *http.Request) {
 	oper := req.Form.Get("oper")
 	app := req.Form.Get("app")
 	name := req.Form.Get("name")
-	url := req.Form.Get("url")
+	urls, err := pushTargets(req)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
 
-	log.Debugf("control push: oper=%v, app=%v, name=%v, url=%v", oper, app, name, url)
-	if (len(app) <= 0) || (len(name) <= 0) || (len(url) <= 0) {
+	reqid.Logger(req.Context()).Debugf("control push: oper=%v, app=%v, name=%v, urls=%v", oper, app, name, urls)
+	if (len(app) <= 0) || (len(name) <= 0) || (oper != "stop" && len(urls) <= 0) {
 		res.Data = "control push parameter error, please check them."
 		return
 	}
 
-	localurl := "rtmp://127.0.0.1" + server.rtmpAddr + "/" + app + "/" + name
-	remoteurl := url
+	localurl := server.localUrl(app, name)
 
 	keyString := "push:" + app + "/" + name
+	if isDryRun(req) && oper == "stop" {
+		res.Data = server.dryRunRelay(req.Context(), oper, keyString, nil)
+		return
+	}
 	if oper == "stop" {
-		pushRtmprelay, found := server.session[keyString]
-		if !found {
+		pushRtmprelays := server.takeSessionGroup(keyString)
+		if len(pushRtmprelays) == 0 {
 			retString = fmt.Sprintf("<h1>session key[%s] not exist, please check it again.</h1>", keyString)
 			res.Data = retString
 			return
 		}
-		log.Debugf("rtmprelay stop push %s from %s", remoteurl, localurl)
-		pushRtmprelay.Stop()
+		for _, pushRtmprelay := range pushRtmprelays {
+			reqid.Logger(req.Context()).Debugf("rtmprelay stop push %s from %s", pushRtmprelay.PublishUrl, localurl)
+			pushRtmprelay.Stop()
+		}
 
-		delete(server.session, keyString)
-		retString = fmt.Sprintf("<h1>push url stop %s ok</h1></br>", url)
+		retString = fmt.Sprintf("<h1>push url stop %s ok</h1></br>", strings.Join(urls, ", "))
 		res.Data = retString
-		log.Debugf("push stop return %s", retString)
-	} else {
-		pushRtmprelay := rtmprelay.NewRtmpRelay(&localurl, &remoteurl)
-		log.Debugf("rtmprelay start push %s from %s", remoteurl, localurl)
-		err = pushRtmprelay.Start()
-		if err != nil {
+		reqid.Logger(req.Context()).Debugf("push stop return %s", retString)
+		return
+	}
+
+	remoteurls := make([]string, len(urls))
+	for i, url := range urls {
+		if remoteurls[i], err = checkRelayURL(url, pushSchemes); err != nil {
+			res.Status = 400
+			res.Data = err.Error()
+			return
+		}
+	}
+	if isDryRun(req) {
+		res.Data = server.dryRunRelay(req.Context(), oper, keyString, remoteurls)
+		return
+	}
+
+	// a new start replaces every target of the last one
+	for _, old := range server.takeSessionGroup(keyString) {
+		old.Stop()
+	}
+
+	if len(remoteurls) == 1 {
+		reqid.Logger(req.Context()).Debugf("rtmprelay start push %s from %s", remoteurls[0], localurl)
+		if _, err = server.startRelay(req.Context(), keyString, app+"/"+name, localurl, remoteurls[0], nil); err != nil {
 			retString = fmt.Sprintf("push error=%v", err)
+			res.Status = startStatus(err, res.Status)
 		} else {
-			retString = fmt.Sprintf("<h1>push url start %s ok</h1></br>", url)
-			server.session[keyString] = pushRtmprelay
+			retString = fmt.Sprintf("<h1>push url start %s ok</h1></br>", urls[0])
 		}
 
 		res.Data = retString
-		log.Debugf("push start return %s", retString)
+		reqid.Logger(req.Context()).Debugf("push start return %s", retString)
+		return
 	}
+
+	// a fan-out keeps the targets that started, each one reports on its own
+	reqid.Logger(req.Context()).Debugf("rtmprelay start push %v from %s", remoteurls, localurl)
+	targets := server.startPushTargets(req.Context(), keyString, app+"/"+name, localurl, remoteurls)
+	res.Data = targets
+	reqid.Logger(req.Context()).Debugf("push start return %v", targets)
 }
 
 // http://127.0.0.1:8090/control/reset?room=ROOM_NAME
@@ -454,6 +921,7 @@ func (server *Server) handleReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the keys are shared by the apps
 	msg, err := configure.RoomKeys.SetKey(room)
 
 	if err != nil {
@@ -464,7 +932,7 @@ func (server *Server) handleReset(w http.ResponseWriter, r *http.Request) {
 	res.Data = msg
 }
 
-// http://127.0.0.1:8090/control/get?room=ROOM_NAME
+// http://127.0.0.1:8090/control/get?room=ROOM_NAME[&app=live][&format=full]
 func (server *Server) handleGet(w http.ResponseWriter, r *http.Request) {
 	res := &Response{
 		w:      w,
@@ -486,16 +954,34 @@ func (server *Server) handleGet(w http.ResponseWriter, r *http.Request) {
 		res.Data = "url: /control/get?room=<ROOM_NAME>"
 		return
 	}
+	app, err := streamApp(r)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
 
 	msg, err := configure.RoomKeys.GetKey(room)
-	if err != nil {
+	if err == configure.ErrKeyHashed {
+		// only /control/reset can tell a new one
+		res.Status = 409
+		res.Data = err.Error()
+		return
+	} else if err != nil {
 		msg = err.Error()
 		res.Status = 400
+		res.Data = msg
+		return
+	}
+
+	if r.Form.Get("format") == "full" {
+		res.Data = server.describe(app, room, msg, r)
+		return
 	}
 	res.Data = msg
 }
 
-//http://127.0.0.1:8090/control/delete?room=ROOM_NAME
+// http://127.0.0.1:8090/control/delete?room=ROOM_NAME[&app=live]
 func (server *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 	res := &Response{
 		w:      w,
@@ -518,22 +1004,28 @@ func (server *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rtmpStream := server.handler.(*rtmp.RtmpStream)
-	if rtmpStream == nil {
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
 		res.Status = 500
 		res.Data = "Get rtmp stream information error"
 		return
 	}
 
-	key := fmt.Sprintf("live/%s", room)
-	s, ok := rtmpStream.GetStream(key)
+	app, err := streamApp(r)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+	key := fmt.Sprintf("%s/%s", app, room)
+	s, ok := inspector.GetStream(key)
 	if !ok {
 		res.Status = 404
 		res.Data = "No room was found"
 		return
 	}
 
-	s.TransStop()
+	s.TransStopReason(rtmp.ErrRoomDeleted)
 	s.CloseAndComplete()
 
 	if configure.RoomKeys.DeleteChannel(room) {
@@ -543,3 +1035,262 @@ func (server *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 	res.Status = 404
 	res.Data = "room not found"
 }
+
+// http://127.0.0.1:8090/control/rooms[?app=live]
+func (server *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if err := r.ParseForm(); err != nil {
+		res.Status = 400
+		res.Data = "url: /control/rooms"
+		return
+	}
+
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
+		res.Status = 500
+		res.Data = "Get rtmp stream information error"
+		return
+	}
+
+	app, err := streamApp(r)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+
+	names, err := configure.RoomKeys.Rooms()
+	if err != nil {
+		res.Status = 500
+		res.Data = err.Error()
+		return
+	}
+
+	msg := make([]StreamDescriptor, 0, len(names))
+	listed := make(map[string]bool, len(names))
+	for _, name := range names {
+		msg = append(msg, server.describe(app, name, "", r))
+		listed[name] = true
+	}
+	// the aliases are rooms too, without a key of their own
+	if aliaser, ok := inspector.(roomAliaser); ok {
+		for _, a := range aliaser.Aliases() {
+			name := strings.TrimPrefix(a.From, app+"/")
+			if name != a.From && !listed[name] {
+				msg = append(msg, server.describe(app, name, "", r))
+			}
+		}
+	}
+	res.Data = msg
+}
+
+type limits struct {
+	Room        string `json:"room"`
+	PlayerCount int    `json:"player_count"`
+	MaxPlayers  int    `json:"max_players"`
+}
+
+// http://127.0.0.1:8090/control/limits?room=ROOM_NAME[&app=live][&max_players=N]
+func (server *Server) handleLimits(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if err := r.ParseForm(); err != nil {
+		res.Status = 400
+		res.Data = "url: /control/limits?room=<ROOM_NAME>&max_players=<N>"
+		return
+	}
+
+	room := r.Form.Get("room")
+	if len(room) == 0 {
+		res.Status = 400
+		res.Data = "url: /control/limits?room=<ROOM_NAME>&max_players=<N>"
+		return
+	}
+
+	inspector, ok := rtmp.Inspect(server.handler)
+	limiter, hasLimits := inspector.(roomLimits)
+	if !ok || !hasLimits {
+		res.Status = 500
+		res.Data = "Get rtmp stream information error"
+		return
+	}
+
+	app, err := streamApp(r)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+	key := fmt.Sprintf("%s/%s", app, room)
+	if max := r.Form.Get("max_players"); max != "" {
+		n, err := strconv.Atoi(max)
+		if err != nil {
+			res.Status = 400
+			res.Data = "max_players must be a number"
+			return
+		}
+		limiter.SetMaxPlayers(key, n)
+	}
+
+	msg := limits{
+		Room:       room,
+		MaxPlayers: limiter.MaxPlayers(key),
+	}
+	if s, ok := inspector.GetStream(key); ok {
+		msg.PlayerCount = s.PlayerCount()
+	}
+	res.Data = msg
+}
+
+type settings struct {
+	Room string `json:"room"`
+	configure.RoomSettings
+}
+
+// parseSetting reads an optional bool form value, "default" clears
+// the override so the room follows the server config again
+func parseSetting(r *http.Request, name string, v **bool) error {
+	value := r.Form.Get(name)
+	switch value {
+	case "":
+		return nil
+	case "default":
+		*v = nil
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%s must be true, false or default", name)
+	}
+	*v = &b
+	return nil
+}
+
+// http://127.0.0.1:8090/control/settings?room=ROOM_NAME
+// POST http://127.0.0.1:8090/control/settings?room=ROOM_NAME&hls_enabled=false&flv_enabled=true&record=default
+func (server *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if err := r.ParseForm(); err != nil {
+		res.Status = 400
+		res.Data = "url: /control/settings?room=<ROOM_NAME>"
+		return
+	}
+
+	room := r.Form.Get("room")
+	if len(room) == 0 {
+		res.Status = 400
+		res.Data = "url: /control/settings?room=<ROOM_NAME>"
+		return
+	}
+
+	current, err := configure.RoomKeys.GetSettings(room)
+	if err != nil {
+		res.Status = 500
+		res.Data = err.Error()
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		for name, v := range map[string]**bool{
+			"hls_enabled": &current.HlsEnabled,
+			"flv_enabled": &current.FlvEnabled,
+			"record":      &current.Record,
+		} {
+			if err := parseSetting(r, name, v); err != nil {
+				res.Status = 400
+				res.Data = err.Error()
+				return
+			}
+		}
+		if err := configure.RoomKeys.SetSettings(room, current); err != nil {
+			res.Status = 500
+			res.Data = err.Error()
+			return
+		}
+	}
+
+	res.Data = settings{Room: room, RoomSettings: current}
+}
+
+type kicked struct {
+	Room string `json:"room"`
+	Id   string `json:"id"`
+	Addr string `json:"addr,omitempty"`
+}
+
+// http://127.0.0.1:8090/control/kick?room=ROOM_NAME[&app=live]&addr=IP:PORT
+// http://127.0.0.1:8090/control/kick?room=ROOM_NAME[&app=live]&id=PLAYER_ID
+func (server *Server) handleKick(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if err := r.ParseForm(); err != nil {
+		res.Status = 400
+		res.Data = "url: /control/kick?room=<ROOM_NAME>&addr=<IP:PORT>"
+		return
+	}
+
+	room := r.Form.Get("room")
+	id := r.Form.Get("addr")
+	if id == "" {
+		id = r.Form.Get("id")
+	}
+	if len(room) == 0 || len(id) == 0 {
+		res.Status = 400
+		res.Data = "url: /control/kick?room=<ROOM_NAME>&addr=<IP:PORT>"
+		return
+	}
+	app, err := streamApp(r)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
+		res.Status = 500
+		res.Data = "Get rtmp stream information error"
+		return
+	}
+
+	s, ok := inspector.GetStream(fmt.Sprintf("%s/%s", app, room))
+	if !ok {
+		res.Status = 404
+		res.Data = "No room was found"
+		return
+	}
+	player, ok := s.KickPlayer(id)
+	if !ok {
+		res.Status = 404
+		res.Data = "No player was found"
+		return
+	}
+
+	msg := kicked{Room: room, Id: player.Info().UID}
+	if a, ok := player.(rtmp.RemoteAddrer); ok {
+		msg.Addr = a.RemoteAddr()
+	}
+	res.Data = msg
+}
@@ -0,0 +1,509 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/utils/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAuth(t *testing.T) {
+	at := assert.New(t)
+
+	req := httptest.NewRequest("GET", "/control/get?room=a", nil)
+	req.Header.Set("authorization", "secret")
+	at.False(checkAuth("secret", httptest.NewRecorder(), req))
+
+	req = httptest.NewRequest("GET", "/control/get?room=a&api_key=secret", nil)
+	at.False(checkAuth("secret", httptest.NewRecorder(), req))
+
+	req = httptest.NewRequest("GET", "/control/get?room=a&api_key=secret", nil)
+	req.Header.Set("authorization", "wrong")
+	w := httptest.NewRecorder()
+	at.True(checkAuth("secret", w, req))
+	at.Equal(401, w.Code)
+
+	req = httptest.NewRequest("GET", "/control/get?room=a", nil)
+	at.True(checkAuth("secret", httptest.NewRecorder(), req))
+}
+
+func TestPublicHost(t *testing.T) {
+	at := assert.New(t)
+
+	req := httptest.NewRequest("GET", "http://stream.example.com:8090/control/get", nil)
+	at.Equal("stream.example.com:1935", publicHost(":1935", req))
+	at.Equal("stream.example.com:1935", publicHost("0.0.0.0:1935", req))
+	at.Equal("10.0.0.2:1935", publicHost("10.0.0.2:1935", req))
+	at.Equal("[2001:db8::1]:1935", publicHost("[2001:db8::1]:1935", req))
+
+	req = httptest.NewRequest("GET", "http://[2001:db8::2]:8090/control/get", nil)
+	at.Equal("[2001:db8::2]:7001", publicHost("[::]:7001", req))
+}
+
+func TestHandleSettings(t *testing.T) {
+	at := assert.New(t)
+	server := &Server{}
+	// the overrides outlive the test otherwise
+	defer configure.RoomKeys.DeleteChannel("settings_test")
+
+	w := httptest.NewRecorder()
+	server.handleSettings(w, httptest.NewRequest("POST", "/control/settings?room=settings_test&hls_enabled=false", nil))
+	at.Equal(200, w.Code)
+	s, err := configure.RoomKeys.GetSettings("settings_test")
+	at.Nil(err)
+	at.False(s.HLS())
+	at.True(s.FLV())
+	at.Equal(configure.Config.GetBool("flv_archive"), s.Archive())
+
+	w = httptest.NewRecorder()
+	server.handleSettings(w, httptest.NewRequest("POST", "/control/settings?room=settings_test&record=maybe", nil))
+	at.Equal(400, w.Code)
+
+	w = httptest.NewRecorder()
+	server.handleSettings(w, httptest.NewRequest("POST", "/control/settings?room=settings_test&hls_enabled=default&record=true", nil))
+	at.Equal(200, w.Code)
+	s, _ = configure.RoomKeys.GetSettings("settings_test")
+	at.True(s.HLS())
+	at.True(s.Archive())
+
+	w = httptest.NewRecorder()
+	server.handleSettings(w, httptest.NewRequest("GET", "/control/settings?room=settings_test", nil))
+	at.Contains(w.Body.String(), `"record":true`)
+	at.Contains(w.Body.String(), `"hls_enabled":null`)
+}
+
+func TestHandleLimits(t *testing.T) {
+	at := assert.New(t)
+	done := make(chan struct{})
+	defer close(done)
+
+	rtmpStream := rtmp.NewRtmpStream()
+	live := rtmp.NewStream()
+	live.AddReader(rtmp.NewVirReader(&idleConn{done: done}))
+	rtmpStream.GetStreams().Store("live/limits", live)
+	server := &Server{handler: rtmpStream}
+	get := func(url string) (int, limits) {
+		w := httptest.NewRecorder()
+		server.handleLimits(w, httptest.NewRequest("GET", url, nil))
+		var res struct {
+			Data limits `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res.Data
+	}
+
+	// rtmp.max_players_per_stream, unlimited by default
+	code, got := get("/control/limits?room=limits")
+	at.Equal(200, code)
+	at.Equal(limits{Room: "limits"}, got)
+
+	code, got = get("/control/limits?room=limits&max_players=2")
+	at.Equal(200, code)
+	at.Equal(limits{Room: "limits", MaxPlayers: 2}, got)
+	at.Equal(2, rtmpStream.MaxPlayers("live/limits"))
+	at.True(rtmpStream.CanAddPlayer("live/limits"))
+
+	// a negative one removes the override
+	code, got = get("/control/limits?room=limits&max_players=-1")
+	at.Equal(200, code)
+	at.Equal(0, got.MaxPlayers)
+
+	for _, url := range []string{
+		"/control/limits",
+		"/control/limits?room=limits&max_players=many",
+		"/control/limits?room=limits&app=nope",
+	} {
+		code, _ = get(url)
+		at.Equal(400, code, url)
+	}
+}
+
+func TestStreamApp(t *testing.T) {
+	at := assert.New(t)
+
+	req := httptest.NewRequest("GET", "/stats/livestat?room=a", nil)
+	req.ParseForm()
+	app, err := streamApp(req)
+	at.Nil(err)
+	at.Equal("live", app)
+
+	req = httptest.NewRequest("GET", "/stats/livestat?room=a&app=nope", nil)
+	req.ParseForm()
+	_, err = streamApp(req)
+	at.NotNil(err)
+}
+
+func TestStreamDescriptor(t *testing.T) {
+	at := assert.New(t)
+	done := make(chan struct{})
+	defer close(done)
+
+	rtmpStream := rtmp.NewRtmpStream()
+	live := rtmp.NewStream()
+	live.AddReader(rtmp.NewVirReader(&idleConn{done: done}))
+	rtmpStream.GetStreams().Store("tv/a", live)
+	server := &Server{handler: rtmpStream}
+
+	req := httptest.NewRequest("GET", "http://stream.example.com/control/get?room=a", nil)
+	at.Equal(StreamDescriptor{
+		Room:    "a",
+		App:     "tv",
+		Key:     "key",
+		RtmpUrl: "rtmp://stream.example.com:1935/tv/key",
+		FlvUrl:  "http://stream.example.com:7001/tv/a.flv",
+		HlsUrl:  "http://stream.example.com:7002/tv/a.m3u8",
+		Live:    true,
+	}, server.describe("tv", "a", "key", req))
+
+	for key, value := range map[string]string{
+		"rtmp_addr":    "10.0.0.2:1936",
+		"httpflv_addr": "[::]:8001",
+		"hls_addr":     "0.0.0.0:8002",
+	} {
+		old := configure.Config.GetString(key)
+		configure.Config.Set(key, value)
+		defer configure.Config.Set(key, old)
+	}
+	req = httptest.NewRequest("GET", "http://[2001:db8::2]:8090/control/rooms", nil)
+	at.Equal(StreamDescriptor{
+		Room:   "b",
+		App:    "live",
+		FlvUrl: "http://[2001:db8::2]:8001/live/b.flv",
+		HlsUrl: "http://[2001:db8::2]:8002/live/b.m3u8",
+	}, server.describe("live", "b", "", req))
+	at.Equal("rtmp://10.0.0.2:1936/live/key", server.describe("live", "b", "key", req).RtmpUrl)
+
+	// without an rtmp stream nothing is live
+	at.False((&Server{handler: otherHandler{}}).describe("tv", "a", "", req).Live)
+}
+
+func TestHandleRooms(t *testing.T) {
+	at := assert.New(t)
+	done := make(chan struct{})
+	defer close(done)
+
+	for _, name := range []string{"rooms_b", "rooms_a", "rooms_gone"} {
+		_, err := configure.RoomKeys.SetKey(name)
+		at.Nil(err)
+	}
+	// a reset keeps a single entry for the room
+	_, err := configure.RoomKeys.SetKey("rooms_a")
+	at.Nil(err)
+	at.True(configure.RoomKeys.DeleteChannel("rooms_gone"))
+
+	rtmpStream := rtmp.NewRtmpStream()
+	live := rtmp.NewStream()
+	live.AddReader(rtmp.NewVirReader(&idleConn{done: done}))
+	rtmpStream.GetStreams().Store("live/rooms_a", live)
+	// a stream without publisher yet is not live
+	rtmpStream.GetStreams().Store("live/rooms_b", rtmp.NewStream())
+	server := &Server{handler: rtmpStream}
+
+	w := httptest.NewRecorder()
+	server.handleAlias(w, httptest.NewRequest("GET", "/control/alias?from=rooms_c&to=rooms_a", nil))
+	at.Equal(200, w.Code)
+	w = httptest.NewRecorder()
+	server.handleAlias(w, httptest.NewRequest("GET", "/control/alias?from=rooms_a&to=rooms_b", nil))
+	at.Equal(400, w.Code)
+	w = httptest.NewRecorder()
+	server.handleAlias(w, httptest.NewRequest("GET", "/control/alias?from=rooms_d&to=nope/rooms_a", nil))
+	at.Equal(400, w.Code)
+
+	w = httptest.NewRecorder()
+	server.handleRooms(w, httptest.NewRequest("GET", "/control/rooms", nil))
+	at.Equal(200, w.Code)
+	var res struct {
+		Data []StreamDescriptor `json:"data"`
+	}
+	at.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+
+	var got []StreamDescriptor
+	for _, r := range res.Data {
+		if strings.HasPrefix(r.Room, "rooms_") {
+			got = append(got, r)
+		}
+	}
+	if at.Equal(3, len(got)) {
+		at.Equal("rooms_a", got[0].Room)
+		at.True(got[0].Live)
+		at.Empty(got[0].AliasOf)
+		at.Equal("http://example.com:7001/live/rooms_a.flv", got[0].FlvUrl)
+		at.Equal("rooms_b", got[1].Room)
+		at.False(got[1].Live)
+		// the list doesn't give the keys away
+		at.Empty(got[1].Key)
+		at.Empty(got[1].RtmpUrl)
+		// the alias plays rooms_a
+		at.Equal("rooms_c", got[2].Room)
+		at.Equal("live/rooms_a", got[2].AliasOf)
+		at.True(got[2].Live)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleRooms(w, httptest.NewRequest("GET", "/control/rooms?app=nope", nil))
+	at.Equal(400, w.Code)
+}
+
+// otherHandler is an av.Handler which is not an *rtmp.RtmpStream
+type otherHandler struct{}
+
+func (otherHandler) HandleReader(av.ReadCloser)  {}
+func (otherHandler) HandleWriter(av.WriteCloser) {}
+
+func TestForeignHandler(t *testing.T) {
+	at := assert.New(t)
+	server := &Server{handler: otherHandler{}}
+
+	for _, path := range []string{
+		"/stats/livestats",
+		"/stats/livestat?room=foreign",
+		"/stats/summary",
+		"/control/rooms",
+		"/control/limits?room=foreign",
+		"/control/quota?room=foreign",
+		"/control/kick?room=foreign&addr=127.0.0.1:1",
+		"/control/delete?room=foreign",
+		"/control/promote?from=foreign_backup&to=foreign",
+		"/control/metadata?room=foreign&title=Song",
+	} {
+		var route apiRoute
+		for _, r := range apiRoutes {
+			if strings.HasPrefix(path, r.path+"?") || path == r.path {
+				route = r
+			}
+		}
+		w := httptest.NewRecorder()
+		at.NotPanics(func() {
+			route.handle(server, w, httptest.NewRequest("POST", path, nil))
+		}, path)
+		at.Equal(500, w.Code, path)
+
+		var res Response
+		at.Nil(json.Unmarshal(w.Body.Bytes(), &res), path)
+		at.Equal("Get rtmp stream information error", res.Data, path)
+	}
+}
+
+// wrappingHandler hands everything to an RtmpStream, as a handler also
+// recording or fanning out would
+type wrappingHandler struct {
+	inner *rtmp.RtmpStream
+}
+
+func (h wrappingHandler) HandleReader(r av.ReadCloser)    { h.inner.HandleReader(r) }
+func (h wrappingHandler) HandleWriter(w av.WriteCloser)   { h.inner.HandleWriter(w) }
+func (h wrappingHandler) Inspector() rtmp.StreamInspector { return h.inner }
+
+func TestWrappingHandler(t *testing.T) {
+	at := assert.New(t)
+	done := make(chan struct{})
+	defer close(done)
+
+	rtmpStream := rtmp.NewRtmpStream()
+	ready := rtmp.NewStream()
+	ready.AddReader(rtmp.NewVirReader(&idleConn{done: done}))
+	rtmpStream.GetStreams().Store("live/ready", ready)
+	server := &Server{handler: wrappingHandler{inner: rtmpStream}}
+
+	for _, path := range []string{
+		"/stats/livestats",
+		"/stats/livestat?room=ready",
+		"/stats/summary",
+		"/control/rooms",
+		"/control/limits?room=ready&max_players=3",
+		"/control/quota?room=ready&bytes=1000",
+	} {
+		var route apiRoute
+		for _, r := range apiRoutes {
+			if strings.HasPrefix(path, r.path+"?") || path == r.path {
+				route = r
+			}
+		}
+		w := httptest.NewRecorder()
+		route.handle(server, w, httptest.NewRequest("GET", path, nil))
+		at.Equal(200, w.Code, path)
+	}
+
+	// the changes went to the wrapped stream
+	at.Equal(3, rtmpStream.MaxPlayers("live/ready"))
+	_, ok := rtmpStream.QuotaStatus("live/ready")
+	at.True(ok)
+
+	w := httptest.NewRecorder()
+	server.GetLiveStatics(w, httptest.NewRequest("GET", "/stats/livestats", nil))
+	var res struct {
+		Data streams `json:"data"`
+	}
+	at.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+	at.Len(res.Data.Publishers, 1)
+	at.Equal("live/ready", res.Data.Publishers[0].Key)
+	at.Equal(3, res.Data.Publishers[0].MaxPlayers)
+}
+
+func TestSendJsonMarshalError(t *testing.T) {
+	at := assert.New(t)
+
+	w := httptest.NewRecorder()
+	res := &Response{w: w, Status: 200, Data: make(chan int)}
+	_, err := res.SendJson()
+	at.Nil(err)
+	at.Equal(500, w.Code)
+	at.Equal("application/json", w.Header().Get("Content-Type"))
+	at.JSONEq(`{"status": 500, "data": "internal error"}`, w.Body.String())
+}
+
+func TestSummaryWorkers(t *testing.T) {
+	at := assert.New(t)
+	server := &Server{handler: rtmp.NewRtmpStream()}
+	pool := worker.Shared()
+
+	// one job holds its worker, the next ones wait behind it
+	release, done := make(chan struct{}), make(chan struct{}, 3)
+	started := make(chan struct{})
+	at.True(pool.Submit("summary", func() {
+		close(started)
+		<-release
+		done <- struct{}{}
+	}))
+	<-started
+	for i := 0; i < 2; i++ {
+		at.True(pool.Submit("summary", func() { done <- struct{}{} }))
+	}
+
+	get := func() (msg summary) {
+		w := httptest.NewRecorder()
+		server.GetSummary(w, httptest.NewRequest("GET", "/stats/summary", nil))
+		var res struct {
+			Data summary `json:"data"`
+		}
+		at.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+		return res.Data
+	}
+	msg := get()
+	at.Equal(int64(1), msg.Workers.Running)
+	at.Equal(int64(2), msg.Workers.Queued)
+	at.True(msg.Workers.Capacity > 0)
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	msg = get()
+	at.Equal(int64(0), msg.Workers.Queued)
+
+	// the global limits are reported as set
+	configure.Config.Set("rtmp.max_streams", 3)
+	defer configure.Config.Set("rtmp.max_streams", 0)
+	msg = get()
+	at.Equal(3, msg.Capacity.MaxStreams)
+	at.Equal(0, msg.Capacity.Streams)
+}
+
+func TestSummaryTotals(t *testing.T) {
+	at := assert.New(t)
+	server := &Server{handler: rtmp.NewRtmpStream()}
+	r := httptest.NewRequest("GET", "/stats/summary", nil)
+
+	// the speeds add up the audio and video of every connection
+	msg := server.summarize(&streams{
+		Publishers: []stream{
+			{Key: "live/a", VideoBitrate: 2000, AudioBitrate: 128},
+			{Key: "b", VideoBitrate: 1000},
+		},
+		Players: []stream{
+			{Key: "live/a", VideoBitrate: 2000, AudioBitrate: 128},
+			{Key: "live/a", VideoBitrate: 1500, AudioBitrate: 96},
+			{Key: "b", AudioBitrate: 64},
+		},
+		Relays: []relay{{Key: "push:live/a", Running: true}, {Key: "pull:live/c"}},
+	}, r)
+	at.Equal(2, msg.Publishers)
+	at.Equal(3, msg.Players)
+	at.Equal(uint64(3128), msg.InboundSpeed)
+	at.Equal(uint64(3788), msg.OutboundSpeed)
+	at.Equal(1, msg.ActiveRelays)
+	if at.Len(msg.Streams, 2) {
+		at.Equal("live", msg.Streams[0].App)
+		at.Equal("a", msg.Streams[0].Room)
+		at.Equal("b", msg.Streams[1].App)
+		at.Equal("", msg.Streams[1].Room)
+		at.Empty(msg.Streams[0].Key)
+	}
+
+	msg = server.summarize(&streams{}, r)
+	at.Equal(0, msg.Publishers)
+	at.Equal(uint64(0), msg.InboundSpeed)
+	at.NotNil(msg.Streams)
+
+	// a live publisher is counted and listed
+	done := make(chan struct{})
+	defer close(done)
+	rtmpStream := rtmp.NewRtmpStream()
+	live := rtmp.NewStream()
+	live.AddReader(rtmp.NewVirReader(&idleConn{done: done}))
+	rtmpStream.GetStreams().Store("live/summary", live)
+	server = &Server{handler: rtmpStream}
+	w := httptest.NewRecorder()
+	server.GetSummary(w, r)
+	var res struct {
+		Data summary `json:"data"`
+	}
+	at.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+	at.Equal(1, res.Data.Publishers)
+	at.Equal(0, res.Data.Players)
+	if at.Len(res.Data.Streams, 1) {
+		at.Equal("summary", res.Data.Streams[0].Room)
+		at.True(res.Data.Streams[0].Live)
+	}
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	at := assert.New(t)
+	dir, err := ioutil.TempDir("", "livego")
+	at.Nil(err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "api.sock")
+	l, err := net.Listen("unix", socket)
+	if !at.Nil(err) {
+		return
+	}
+	defer l.Close()
+	go NewServer(rtmp.NewRtmpStream(), ":1935").Serve(l, "secret")
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+	get := func(url, key string) *http.Response {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", key)
+		res, err := client.Do(req)
+		at.Nil(err)
+		return res
+	}
+
+	// the host is only there to make a valid url
+	res := get("http://livego/control/get?room=unix", "secret")
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	at.Equal(200, res.StatusCode, string(body))
+
+	res = get("http://livego/control/get?room=unix", "wrong")
+	res.Body.Close()
+	at.Equal(401, res.StatusCode)
+}
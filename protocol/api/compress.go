@@ -0,0 +1,109 @@
+package api
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// minCompressSize is the smallest body worth compressing, below it the
+// headers and the cpu cost more than the bytes saved
+const minCompressSize = 1024
+
+// acceptedEncoding picks gzip, else deflate, from an Accept-Encoding
+// header, empty when the client takes neither
+func acceptedEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[name] = q > 0
+	}
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressWriter compresses the body when it is at least minCompressSize,
+// SendJson writes the body at once so the first Write decides and the
+// status is held until then
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	decided  bool
+	cw       io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		if len(p) >= minCompressSize {
+			h := w.Header()
+			h.Set("Content-Encoding", w.encoding)
+			h.Del("Content-Length")
+			if w.encoding == "gzip" {
+				w.cw = gzip.NewWriter(w.ResponseWriter)
+			} else {
+				w.cw, _ = flate.NewWriter(w.ResponseWriter, flate.DefaultCompression)
+			}
+		}
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.cw != nil {
+		return w.cw.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Close ends the compressed body, or sends the status of an empty one
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		w.decided = true
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.cw != nil {
+		return w.cw.Close()
+	}
+	return nil
+}
+
+// withCompression answers with a gzip or deflate body when the client
+// accepts one, the stats endpoints use it as their payloads grow with the
+// number of streams
+func withCompression(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+		defer cw.Close()
+		next(cw, r)
+	}
+}
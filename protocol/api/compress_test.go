@@ -0,0 +1,74 @@
+package api
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	at := assert.New(t)
+	at.Equal("", acceptedEncoding(""))
+	at.Equal("gzip", acceptedEncoding("gzip"))
+	at.Equal("gzip", acceptedEncoding("deflate, gzip;q=0.5"))
+	at.Equal("deflate", acceptedEncoding("gzip;q=0, deflate"))
+	at.Equal("", acceptedEncoding("br, identity"))
+}
+
+func TestStatsCompression(t *testing.T) {
+	at := assert.New(t)
+	server := &Server{}
+	handle := withCompression(func(w http.ResponseWriter, r *http.Request) {
+		server.GetEvents(w, r)
+	})
+	get := func(url, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		if encoding != "" {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		w := httptest.NewRecorder()
+		handle(w, req)
+		return w
+	}
+
+	for i := 0; i < 64; i++ {
+		events.Emit("live/compressed", events.PlayerJoin, strings.Repeat("rtmp://127.0.0.1/live/compressed ", 4))
+	}
+	plain := get("/stats/events?room=compressed", "")
+	at.Equal(200, plain.Code)
+	at.Empty(plain.Header().Get("Content-Encoding"))
+	at.Equal("Accept-Encoding", plain.Header().Get("Vary"))
+	at.True(plain.Body.Len() >= minCompressSize)
+
+	w := get("/stats/events?room=compressed", "gzip, deflate")
+	at.Equal(200, w.Code)
+	at.Equal("gzip", w.Header().Get("Content-Encoding"))
+	at.True(w.Body.Len() < plain.Body.Len())
+	zr, err := gzip.NewReader(w.Body)
+	at.Nil(err)
+	body, err := ioutil.ReadAll(zr)
+	at.Nil(err)
+	at.Equal(plain.Body.String(), string(body))
+
+	w = get("/stats/events?room=compressed", "deflate")
+	at.Equal("deflate", w.Header().Get("Content-Encoding"))
+	body, err = ioutil.ReadAll(flate.NewReader(w.Body))
+	at.Nil(err)
+	at.Equal(plain.Body.String(), string(body))
+
+	// small payloads and errors go as they are
+	w = get("/stats/events?room=quiet", "gzip")
+	at.Equal(200, w.Code)
+	at.Empty(w.Header().Get("Content-Encoding"))
+	at.JSONEq(`{"status": 200, "data": []}`, w.Body.String())
+	w = get("/stats/events", "gzip")
+	at.Equal(400, w.Code)
+	at.Empty(w.Header().Get("Content-Encoding"))
+}
@@ -0,0 +1,503 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/utils/reqid"
+)
+
+// The /api/v2 control routes take their parameters as a json body, the
+// reads as a query, and answer every outcome with the json envelope, its
+// status and for an error its code: 400 for a bad body, 404 for a relay,
+// room or player that isn't there, 409 for a hashed key, 502 and 504 for
+// a relay that doesn't start. /control/* keeps its query strings and
+// legacy answers.
+const (
+	codeInvalidBody    = "INVALID_BODY"
+	codeInvalidParams  = "INVALID_PARAMS"
+	codeRelayNotFound  = "RELAY_NOT_FOUND"
+	codeRelayFailed    = "RELAY_FAILED"
+	codeRelayTimeout   = "RELAY_TIMEOUT"
+	codeRoomNotFound   = "ROOM_NOT_FOUND"
+	codePlayerNotFound = "PLAYER_NOT_FOUND"
+	codeKeyHashed      = "KEY_HASHED"
+	codeInternal       = "INTERNAL_ERROR"
+
+	// maxControlBody bounds the json body of a v2 call
+	maxControlBody = 64 << 10
+)
+
+// pullRequest is the body of /api/v2/relays/pull/start
+type pullRequest struct {
+	App    string   `json:"app"`
+	Name   string   `json:"name"`
+	URLs   []string `json:"urls"` // the source and its mirrors, by priority
+	DryRun bool     `json:"dry_run"`
+}
+
+// pushRequest is the body of /api/v2/relays/push/start
+type pushRequest struct {
+	App     string   `json:"app"`
+	Name    string   `json:"name"`
+	Targets []string `json:"targets"`
+	DryRun  bool     `json:"dry_run"`
+}
+
+// stopRequest is the body of /api/v2/relays/pull/stop and push/stop
+type stopRequest struct {
+	App    string `json:"app"`
+	Name   string `json:"name"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// relaysStopped lists the relays a stop removed
+type relaysStopped struct {
+	Key     string   `json:"key"`
+	Stopped []string `json:"stopped"`
+}
+
+// decodeBody reads the json body of r into v, unknown fields are refused
+// so a misspelt parameter is not silently ignored
+func decodeBody(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxControlBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid json body: %v", err)
+	}
+	return nil
+}
+
+// badRequest sets res to a 400 of code
+func (res *Response) badRequest(code string, err error) {
+	res.Status = http.StatusBadRequest
+	res.Code = code
+	res.Data = err.Error()
+}
+
+// startFailed sets res to the error of a relay that didn't start, a
+// timeout is a 504 and anything else a 502
+func (res *Response) startFailed(err error) {
+	res.Status = startStatus(err, http.StatusBadGateway)
+	res.Code = codeRelayFailed
+	if res.Status == http.StatusGatewayTimeout {
+		res.Code = codeRelayTimeout
+	}
+	res.Data = err.Error()
+}
+
+// checkStream is the error of an app and name a relay can't use
+func checkStream(app, name string) error {
+	if app == "" || name == "" {
+		return fmt.Errorf("app and name are required")
+	}
+	return nil
+}
+
+// http://127.0.0.1:8090/api/v2/relays/pull/start
+// {"app": "live", "name": "movie", "urls": ["rtmp://..."], "dry_run": false}
+func (server *Server) handlePullStartV2(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	var body pullRequest
+	if err := decodeBody(r, &body); err != nil {
+		res.badRequest(codeInvalidBody, err)
+		return
+	}
+	if err := checkStream(body.App, body.Name); err != nil {
+		res.badRequest(codeInvalidParams, err)
+		return
+	}
+	if len(body.URLs) == 0 {
+		res.badRequest(codeInvalidParams, fmt.Errorf("urls are required"))
+		return
+	}
+	urls, err := checkRelayURLs(body.URLs, pullSchemes)
+	if err != nil {
+		res.badRequest(codeInvalidParams, err)
+		return
+	}
+
+	key := "pull:" + body.App + "/" + body.Name
+	if body.DryRun {
+		res.Data = server.dryRunRelay(r.Context(), "start", key, urls)
+		return
+	}
+	reqid.Logger(r.Context()).Debugf("rtmprelay start pull %s from %v", key, urls)
+	relay, err := server.startRelay(r.Context(), key, body.App+"/"+body.Name, urls[0], server.localUrl(body.App, body.Name), urls[1:])
+	if err != nil {
+		res.startFailed(err)
+		return
+	}
+	res.Data = relayStatOf(key, relay)
+}
+
+// http://127.0.0.1:8090/api/v2/relays/push/start
+// {"app": "live", "name": "movie", "targets": ["rtmp://..."], "dry_run": false}
+func (server *Server) handlePushStartV2(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	var body pushRequest
+	if err := decodeBody(r, &body); err != nil {
+		res.badRequest(codeInvalidBody, err)
+		return
+	}
+	if err := checkStream(body.App, body.Name); err != nil {
+		res.badRequest(codeInvalidParams, err)
+		return
+	}
+	if len(body.Targets) == 0 {
+		res.badRequest(codeInvalidParams, fmt.Errorf("targets are required"))
+		return
+	}
+	remoteurls := make([]string, len(body.Targets))
+	for i, url := range body.Targets {
+		var err error
+		if remoteurls[i], err = checkRelayURL(url, pushSchemes); err != nil {
+			res.badRequest(codeInvalidParams, err)
+			return
+		}
+	}
+
+	key := "push:" + body.App + "/" + body.Name
+	if body.DryRun {
+		res.Data = server.dryRunRelay(r.Context(), "start", key, remoteurls)
+		return
+	}
+	// a new start replaces every target of the last one
+	for _, old := range server.takeSessionGroup(key) {
+		old.Stop()
+	}
+	reqid.Logger(r.Context()).Debugf("rtmprelay start push %s to %v", key, remoteurls)
+	targets := server.startPushTargets(r.Context(), key, body.App+"/"+body.Name, server.localUrl(body.App, body.Name), remoteurls)
+	res.Data = targets
+	// the targets that started are kept, the call only fails when none did
+	for _, t := range targets {
+		if t.Running {
+			return
+		}
+	}
+	res.Status = http.StatusBadGateway
+	res.Code = codeRelayFailed
+}
+
+// handleRelayStopV2 stops the relays of direction, pull or push, of the
+// stream of the body
+func (server *Server) handleRelayStopV2(direction string, w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	var body stopRequest
+	if err := decodeBody(r, &body); err != nil {
+		res.badRequest(codeInvalidBody, err)
+		return
+	}
+	if err := checkStream(body.App, body.Name); err != nil {
+		res.badRequest(codeInvalidParams, err)
+		return
+	}
+
+	key := direction + ":" + body.App + "/" + body.Name
+	if body.DryRun {
+		res.Data = server.dryRunRelay(r.Context(), "stop", key, nil)
+		return
+	}
+	stopped := relaysStopped{Key: key, Stopped: []string{}}
+	for k, relay := range server.takeSessionGroup(key) {
+		stopped.Stopped = append(stopped.Stopped, k)
+		relay.Stop()
+	}
+	sort.Strings(stopped.Stopped)
+	if len(stopped.Stopped) == 0 {
+		res.Status = http.StatusNotFound
+		res.Code = codeRelayNotFound
+		res.Data = fmt.Sprintf("no relay %s", key)
+		return
+	}
+	reqid.Logger(r.Context()).Debugf("rtmprelay stop %v", stopped.Stopped)
+	res.Data = stopped
+}
+
+// http://127.0.0.1:8090/api/v2/relays/pull/stop {"app": "live", "name": "movie"}
+func (server *Server) handlePullStopV2(w http.ResponseWriter, r *http.Request) {
+	server.handleRelayStopV2("pull", w, r)
+}
+
+// http://127.0.0.1:8090/api/v2/relays/push/stop {"app": "live", "name": "movie"}
+func (server *Server) handlePushStopV2(w http.ResponseWriter, r *http.Request) {
+	server.handleRelayStopV2("push", w, r)
+}
+
+// http://127.0.0.1:8090/api/v2/relays
+func (server *Server) GetRelaysV2(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	ret := []relayStat{}
+	for key, relay := range server.sessions() {
+		ret = append(ret, relayStatOf(key, relay))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+	res.Data = ret
+}
+
+// roomKey is the key of a room
+type roomKey struct {
+	Room string `json:"room"`
+	Key  string `json:"key"`
+}
+
+// roomDeleted tells a room was deleted and whether its stream was live
+type roomDeleted struct {
+	Room    string `json:"room"`
+	Stopped bool   `json:"stopped"`
+}
+
+// keyRequest is the body of a POST to /api/v2/rooms/key, the query of a GET
+type keyRequest struct {
+	Room   string `json:"room"`
+	App    string `json:"app"`
+	Format string `json:"format"` // full for the urls of the room
+}
+
+// resetRequest is the body of /api/v2/rooms/reset, the keys are shared
+// by the apps
+type resetRequest struct {
+	Room string `json:"room"`
+}
+
+// roomRequest is the body of /api/v2/rooms/delete
+type roomRequest struct {
+	Room string `json:"room"`
+	App  string `json:"app"`
+}
+
+// kickRequest is the body of /api/v2/rooms/kick, the player is named by
+// its address or its id
+type kickRequest struct {
+	Room string `json:"room"`
+	App  string `json:"app"`
+	Addr string `json:"addr"`
+	Id   string `json:"id"`
+}
+
+// checkRoom is the app of room, once both are checked
+func checkRoom(room, app string) (string, error) {
+	if room == "" {
+		return "", fmt.Errorf("room is required")
+	}
+	return checkApp(app)
+}
+
+// internalError sets res to a 500 of err
+func (res *Response) internalError(err error) {
+	res.Status = http.StatusInternalServerError
+	res.Code = codeInternal
+	res.Data = err.Error()
+}
+
+// http://127.0.0.1:8090/api/v2/rooms/key?room=movie[&app=live][&format=full]
+// POST {"room": "movie", "app": "live", "format": "full"} creates the key
+// of a room that has none, a GET only reads it
+func (server *Server) handleRoomKeyV2(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	var body keyRequest
+	create := r.Method == http.MethodPost
+	if create {
+		if err := decodeBody(r, &body); err != nil {
+			res.badRequest(codeInvalidBody, err)
+			return
+		}
+	} else {
+		q := r.URL.Query()
+		body = keyRequest{Room: q.Get("room"), App: q.Get("app"), Format: q.Get("format")}
+	}
+	app, err := checkRoom(body.Room, body.App)
+	if err != nil {
+		res.badRequest(codeInvalidParams, err)
+		return
+	}
+	if body.Format != "" && body.Format != "full" {
+		res.badRequest(codeInvalidParams, fmt.Errorf("format must be full or empty"))
+		return
+	}
+
+	var key string
+	if create {
+		key, err = configure.RoomKeys.GetKey(body.Room)
+	} else {
+		key, err = configure.RoomKeys.FindKey(body.Room)
+	}
+	switch {
+	case err == configure.ErrNoKey:
+		res.Status = http.StatusNotFound
+		res.Code = codeRoomNotFound
+		res.Data = fmt.Sprintf("no room %s", body.Room)
+		return
+	case err == configure.ErrKeyHashed:
+		// only a reset can tell a new one
+		res.Status = http.StatusConflict
+		res.Code = codeKeyHashed
+		res.Data = err.Error()
+		return
+	case err != nil:
+		res.internalError(err)
+		return
+	}
+	if body.Format == "full" {
+		res.Data = server.describe(app, body.Room, key, r)
+		return
+	}
+	res.Data = roomKey{Room: body.Room, Key: key}
+}
+
+// http://127.0.0.1:8090/api/v2/rooms/reset {"room": "movie"}
+func (server *Server) handleRoomResetV2(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	var body resetRequest
+	if err := decodeBody(r, &body); err != nil {
+		res.badRequest(codeInvalidBody, err)
+		return
+	}
+	if body.Room == "" {
+		res.badRequest(codeInvalidParams, fmt.Errorf("room is required"))
+		return
+	}
+	key, err := configure.RoomKeys.SetKey(body.Room)
+	if err != nil {
+		res.internalError(err)
+		return
+	}
+	res.Data = roomKey{Room: body.Room, Key: key}
+}
+
+// http://127.0.0.1:8090/api/v2/rooms/delete {"room": "movie", "app": "live"}
+func (server *Server) handleRoomDeleteV2(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	var body roomRequest
+	if err := decodeBody(r, &body); err != nil {
+		res.badRequest(codeInvalidBody, err)
+		return
+	}
+	app, err := checkRoom(body.Room, body.App)
+	if err != nil {
+		res.badRequest(codeInvalidParams, err)
+		return
+	}
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
+		res.internalError(fmt.Errorf("Get rtmp stream information error"))
+		return
+	}
+
+	ret := roomDeleted{Room: body.Room}
+	if s, ok := inspector.GetStream(app + "/" + body.Room); ok {
+		s.TransStopReason(rtmp.ErrRoomDeleted)
+		s.CloseAndComplete()
+		ret.Stopped = true
+	}
+	if !configure.RoomKeys.DeleteChannel(body.Room) && !ret.Stopped {
+		res.Status = http.StatusNotFound
+		res.Code = codeRoomNotFound
+		res.Data = fmt.Sprintf("no room %s", body.Room)
+		return
+	}
+	res.Data = ret
+}
+
+// http://127.0.0.1:8090/api/v2/rooms/kick {"room": "movie", "addr": "IP:PORT"}
+func (server *Server) handleRoomKickV2(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	var body kickRequest
+	if err := decodeBody(r, &body); err != nil {
+		res.badRequest(codeInvalidBody, err)
+		return
+	}
+	app, err := checkRoom(body.Room, body.App)
+	if err != nil {
+		res.badRequest(codeInvalidParams, err)
+		return
+	}
+	id := body.Addr
+	if id == "" {
+		id = body.Id
+	}
+	if id == "" {
+		res.badRequest(codeInvalidParams, fmt.Errorf("addr or id is required"))
+		return
+	}
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
+		res.internalError(fmt.Errorf("Get rtmp stream information error"))
+		return
+	}
+
+	s, ok := inspector.GetStream(app + "/" + body.Room)
+	if !ok {
+		res.Status = http.StatusNotFound
+		res.Code = codeRoomNotFound
+		res.Data = fmt.Sprintf("no stream in room %s", body.Room)
+		return
+	}
+	player, ok := s.KickPlayer(id)
+	if !ok {
+		res.Status = http.StatusNotFound
+		res.Code = codePlayerNotFound
+		res.Data = fmt.Sprintf("no player %s", id)
+		return
+	}
+	msg := kicked{Room: body.Room, Id: player.Info().UID}
+	if a, ok := player.(rtmp.RemoteAddrer); ok {
+		msg.Addr = a.RemoteAddr()
+	}
+	res.Data = msg
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/rtmp/rtmprelay"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlV2Relays(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("rtmp_noauth", true)
+	defer configure.Config.Set("rtmp_noauth", false)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer l.Close()
+	go rtmp.NewRtmpServer(rtmp.NewRtmpStream(), nil).Serve(l)
+
+	done := make(chan struct{})
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(flvBody(0, 5))
+		w.(http.Flusher).Flush()
+		<-done
+	}))
+	defer source.Close()
+	defer close(done)
+
+	server := &Server{session: make(map[string]*rtmprelay.RtmpRelay), rtmpAddr: l.Addr().String()}
+	h := server.Handler("secret")
+	serve := func(method, url, body string) (*httptest.ResponseRecorder, json.RawMessage, Response) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("authorization", "secret")
+		r.Header.Set("Content-Type", "application/json")
+		h.ServeHTTP(w, r)
+		var res struct {
+			Response
+			Data json.RawMessage `json:"data"`
+		}
+		at.Nil(json.Unmarshal(w.Body.Bytes(), &res), w.Body.String())
+		return w, res.Data, res.Response
+	}
+
+	// mutations are posts
+	w, _, res := serve("GET", "/api/v2/relays/pull/start", "")
+	at.Equal(405, w.Code)
+	at.Equal("POST", w.Header().Get("Allow"))
+	at.Equal(codeMethodNotAllowed, res.Code)
+
+	w, _, res = serve("POST", "/api/v2/relays/pull/start", `{"app": "live", "name": "v2", "url": "x"}`)
+	at.Equal(400, w.Code)
+	at.Equal(codeInvalidBody, res.Code)
+	w, _, res = serve("POST", "/api/v2/relays/pull/start", `{"app": "live", "name": "v2"}`)
+	at.Equal(400, w.Code)
+	at.Equal(codeInvalidParams, res.Code)
+	w, _, res = serve("POST", "/api/v2/relays/pull/start", `{"app": "live", "name": "v2", "urls": ["ftp://example.com/a"]}`)
+	at.Equal(400, w.Code)
+	at.Equal(codeInvalidParams, res.Code)
+
+	// a pull starts with a 200 and its stats
+	w, data, _ := serve("POST", "/api/v2/relays/pull/start", `{"app": "live", "name": "v2", "urls": ["`+source.URL+`/live/a.flv"]}`)
+	at.Equal(200, w.Code, w.Body.String())
+	var stat relayStat
+	at.Nil(json.Unmarshal(data, &stat))
+	at.Equal("pull:live/v2", stat.Key)
+	at.Equal(source.URL+"/live/a.flv", stat.ActiveSource)
+	at.NotNil(server.sessions()["pull:live/v2"])
+
+	w, data, _ = serve("GET", "/api/v2/relays", "")
+	at.Equal(200, w.Code)
+	var relays []relayStat
+	at.Nil(json.Unmarshal(data, &relays))
+	if at.Len(relays, 1) {
+		at.Equal("pull:live/v2", relays[0].Key)
+	}
+
+	// a stop answers 200 then 404
+	w, data, _ = serve("POST", "/api/v2/relays/pull/stop", `{"app": "live", "name": "v2"}`)
+	at.Equal(200, w.Code)
+	var stopped relaysStopped
+	at.Nil(json.Unmarshal(data, &stopped))
+	at.Equal(relaysStopped{Key: "pull:live/v2", Stopped: []string{"pull:live/v2"}}, stopped)
+	at.Empty(server.sessions())
+	w, _, res = serve("POST", "/api/v2/relays/pull/stop", `{"app": "live", "name": "v2"}`)
+	at.Equal(404, w.Code)
+	at.Equal(codeRelayNotFound, res.Code)
+
+	// a push none of whose targets start is a 502
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	target := "rtmp://" + closed.Addr().String() + "/live/v2"
+	closed.Close()
+	w, data, res = serve("POST", "/api/v2/relays/push/start", `{"app": "live", "name": "v2", "targets": ["`+target+`"]}`)
+	at.Equal(502, w.Code)
+	at.Equal(codeRelayFailed, res.Code)
+	var targets []pushTarget
+	at.Nil(json.Unmarshal(data, &targets))
+	if at.Len(targets, 1) {
+		at.Equal("push:live/v2", targets[0].Key)
+		at.False(targets[0].Running)
+		at.NotEmpty(targets[0].Error)
+	}
+	at.Empty(server.sessions())
+}
+
+func TestControlV2Rooms(t *testing.T) {
+	at := assert.New(t)
+	h := (&Server{handler: rtmp.NewRtmpStream()}).Handler("secret")
+	serve := func(method, url, body string) (int, json.RawMessage, Response) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("authorization", "secret")
+		h.ServeHTTP(w, r)
+		var res struct {
+			Response
+			Data json.RawMessage `json:"data"`
+		}
+		at.Nil(json.Unmarshal(w.Body.Bytes(), &res), w.Body.String())
+		return w.Code, res.Data, res.Response
+	}
+	room := "v2room_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	defer configure.RoomKeys.DeleteChannel(room)
+
+	// a read doesn't create the key
+	code, _, res := serve("GET", "/api/v2/rooms/key?room="+room, "")
+	at.Equal(404, code)
+	at.Equal(codeRoomNotFound, res.Code)
+	code, data, _ := serve("POST", "/api/v2/rooms/key", `{"room": "`+room+`", "app": "live"}`)
+	at.Equal(200, code)
+	var created roomKey
+	at.Nil(json.Unmarshal(data, &created))
+	at.Equal(room, created.Room)
+	at.NotEmpty(created.Key)
+	code, data, _ = serve("GET", "/api/v2/rooms/key?room="+room+"&app=live", "")
+	at.Equal(200, code)
+	var read roomKey
+	at.Nil(json.Unmarshal(data, &read))
+	at.Equal(created, read)
+
+	code, data, _ = serve("POST", "/api/v2/rooms/reset", `{"room": "`+room+`"}`)
+	at.Equal(200, code)
+	var reset roomKey
+	at.Nil(json.Unmarshal(data, &reset))
+	at.NotEmpty(reset.Key)
+	at.NotEqual(created.Key, reset.Key)
+
+	code, data, _ = serve("GET", "/api/v2/rooms/key?room="+room+"&format=full", "")
+	at.Equal(200, code)
+	var desc StreamDescriptor
+	at.Nil(json.Unmarshal(data, &desc))
+	at.Equal(reset.Key, desc.Key)
+	at.Equal("live", desc.App)
+
+	// the bodies are typed and their errors coded
+	code, _, res = serve("POST", "/api/v2/rooms/key", `{"room": ["`+room+`"], "app": {}}`)
+	at.Equal(400, code)
+	at.Equal(codeInvalidBody, res.Code)
+	code, _, res = serve("POST", "/api/v2/rooms/key", `not json`)
+	at.Equal(400, code)
+	at.Equal(codeInvalidBody, res.Code)
+	code, _, res = serve("POST", "/api/v2/rooms/key", `{"app": "live"}`)
+	at.Equal(400, code)
+	at.Equal(codeInvalidParams, res.Code)
+	code, _, res = serve("GET", "/api/v2/rooms/key?room="+room+"&format=short", "")
+	at.Equal(400, code)
+	at.Equal(codeInvalidParams, res.Code)
+	code, _, res = serve("POST", "/api/v2/rooms/reset", `{"room": "`+room+`", "oper": "x"}`)
+	at.Equal(400, code)
+	at.Equal(codeInvalidBody, res.Code)
+	code, _, res = serve("POST", "/api/v2/rooms/kick", `{"room": "`+room+`"}`)
+	at.Equal(400, code)
+	at.Equal(codeInvalidParams, res.Code)
+	code, _, res = serve("GET", "/api/v2/rooms/reset?room="+room, "")
+	at.Equal(405, code)
+	at.Equal(codeMethodNotAllowed, res.Code)
+
+	code, _, res = serve("POST", "/api/v2/rooms/kick", `{"room": "`+room+`", "id": "nobody"}`)
+	at.Equal(404, code)
+	at.Equal(codeRoomNotFound, res.Code)
+
+	// a room without a stream still has its key deleted
+	code, data, _ = serve("POST", "/api/v2/rooms/delete", `{"room": "`+room+`"}`)
+	at.Equal(200, code)
+	var deleted roomDeleted
+	at.Nil(json.Unmarshal(data, &deleted))
+	at.Equal(roomDeleted{Room: room}, deleted)
+	code, _, res = serve("POST", "/api/v2/rooms/delete", `{"room": "`+room+`"}`)
+	at.Equal(404, code)
+	at.Equal(codeRoomNotFound, res.Code)
+	code, _, res = serve("GET", "/api/v2/rooms/key?room="+room, "")
+	at.Equal(404, code)
+	at.Equal(codeRoomNotFound, res.Code)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/SpooderfyBot/live/configure"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	defaultCORSMethods = []string{"GET", "POST", "OPTIONS"}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type"}
+)
+
+// handlerBox keeps the type stored in an atomic.Value the same
+type handlerBox struct {
+	http.Handler
+}
+
+// CORSMiddleware answers preflight requests before any auth runs and adds
+// the CORS headers to every other request from an allowed origin.
+// Without api.cors.allowed_origins it does nothing. The settings are read
+// again when the config is reloaded.
+func CORSMiddleware(next http.Handler) http.Handler {
+	var current atomic.Value
+	load := func() {
+		cfg := configure.CORS{}
+		configure.Config.UnmarshalKey("api.cors", &cfg)
+		if len(cfg.AllowedOrigins) == 0 {
+			current.Store(handlerBox{next})
+			return
+		}
+		log.Info("Using CORS middleware")
+		current.Store(handlerBox{newCORSHandler(cfg, next)})
+	}
+	load()
+	configure.OnReload(load)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current.Load().(handlerBox).ServeHTTP(w, r)
+	})
+}
+
+func newCORSHandler(cfg configure.CORS, next http.Handler) http.Handler {
+	methods := cfg.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := cfg.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(headers, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && originAllowed(cfg.AllowedOrigins, origin)
+
+		if allowed {
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			h.Set("Access-Control-Allow-Origin", origin)
+			if cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+				w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func originAllowed(origins []string, origin string) bool {
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
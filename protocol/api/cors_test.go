@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SpooderfyBot/live/configure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSPreflightSkipsNext(t *testing.T) {
+	at := assert.New(t)
+	called := false
+	h := newCORSHandler(configure.CORS{
+		AllowedOrigins:   []string{"https://bot.example.com"},
+		AllowCredentials: true,
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest("OPTIONS", "/stats/livestats", nil)
+	req.Header.Set("Origin", "https://bot.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	at.False(called)
+	at.Equal(http.StatusNoContent, w.Code)
+	at.Equal("https://bot.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	at.Equal("true", w.Header().Get("Access-Control-Allow-Credentials"))
+	at.Equal("GET, POST, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestCORSUnknownOrigin(t *testing.T) {
+	at := assert.New(t)
+	h := newCORSHandler(configure.CORS{
+		AllowedOrigins: []string{"https://bot.example.com"},
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/stats/livestats", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	at.Equal("", w.Header().Get("Access-Control-Allow-Origin"))
+}
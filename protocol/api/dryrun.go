@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+)
+
+// what a relay call would do, in the action of a dry run
+const (
+	dryRunStart   = "start"
+	dryRunReplace = "replace" // a start stopping the relays already there
+	dryRunStop    = "stop"
+	dryRunNone    = "none" // a stop with no relay to stop
+)
+
+// dryRun is the answer of a /control/push or /control/pull called with
+// dry_run=true, nothing is started nor stopped
+type dryRun struct {
+	DryRun  bool   `json:"dry_run"`
+	Oper    string `json:"oper"`
+	Key     string `json:"key"`
+	Action  string `json:"action"`
+	Exists  bool   `json:"exists"`
+	Targets []ping `json:"targets,omitempty"`
+}
+
+func isDryRun(req *http.Request) bool {
+	ok, _ := strconv.ParseBool(req.Form.Get("dry_run"))
+	return ok
+}
+
+// hasSessionGroup tells whether key or a target of the group key is
+// in the session
+func (server *Server) hasSessionGroup(key string) bool {
+	server.sessionLock.RLock()
+	defer server.sessionLock.RUnlock()
+	for k := range server.session {
+		if k == key || sessionGroup(k) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// dryRunRelay describes the relay call on key for the urls already
+// checked, the targets of a start are pinged at once
+func (server *Server) dryRunRelay(ctx context.Context, oper, key string, urls []string) dryRun {
+	ret := dryRun{DryRun: true, Oper: oper, Key: key, Exists: server.hasSessionGroup(key)}
+	switch {
+	case oper == "stop" && ret.Exists:
+		ret.Action = dryRunStop
+	case oper == "stop":
+		ret.Action = dryRunNone
+	case ret.Exists:
+		ret.Action = dryRunReplace
+	default:
+		ret.Action = dryRunStart
+	}
+	if oper == "stop" {
+		return ret
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultPingTimeout)
+	defer cancel()
+	ret.Targets = make([]ping, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			ret.Targets[i] = pingRelayURL(ctx, url)
+		}(i, url)
+	}
+	wg.Wait()
+	return ret
+}
+
+// pingRelayURL pings an rtmp url like /control/ping, an http-flv or hls
+// source of a pull gets a HEAD request instead
+func pingRelayURL(ctx context.Context, url string) ping {
+	msg := ping{Url: url}
+	start := time.Now()
+	var err error
+	if u, _ := neturl.Parse(url); u != nil && (u.Scheme == "http" || u.Scheme == "https") {
+		err = headURL(ctx, url)
+	} else {
+		_, err = rtmp.Ping(ctx, url)
+	}
+	if err != nil {
+		msg.Error = err.Error()
+		return msg
+	}
+	msg.Reachable = true
+	msg.LatencyMs = time.Since(start).Milliseconds()
+	return msg
+}
+
+func headURL(ctx context.Context, url string) error {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	return nil
+}
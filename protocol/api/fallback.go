@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// the codes of the responses to a request no route takes
+const (
+	codeNotFound         = "NOT_FOUND"
+	codeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+)
+
+// handleNotFound answers the paths of no route with the json envelope
+// instead of the plain text 404 of the mux
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Status: http.StatusNotFound,
+		Code:   codeNotFound,
+		Data:   "no route for " + r.URL.Path,
+	}
+	_, _ = res.SendJson()
+}
+
+// checkMethod answers 405 along with the allowed methods when r doesn't
+// use one of them, HEAD goes wherever GET does
+func checkMethod(allowed []string, w http.ResponseWriter, r *http.Request) bool {
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	for _, m := range allowed {
+		if m == method {
+			return false
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	res := &Response{
+		w:      w,
+		Status: http.StatusMethodNotAllowed,
+		Code:   codeMethodNotAllowed,
+		Data:   r.Method + " is not allowed on " + r.URL.Path,
+	}
+	_, _ = res.SendJson()
+	return true
+}
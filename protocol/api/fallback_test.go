@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackRoutes(t *testing.T) {
+	at := assert.New(t)
+	h := NewServer(rtmp.NewRtmpStream(), ":1935").Handler("secret")
+	serve := func(method, url string) (*httptest.ResponseRecorder, Response) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+		var res Response
+		at.Nil(json.Unmarshal(w.Body.Bytes(), &res), w.Body.String())
+		return w, res
+	}
+
+	// unknown paths get the json envelope
+	for _, url := range []string{"/nowhere", "/control/nowhere?api_key=secret", "/control/get/extra"} {
+		w, res := serve("GET", url)
+		at.Equal(404, w.Code, url)
+		at.Equal("application/json", w.Header().Get("Content-Type"))
+		at.Equal(404, res.Status)
+		at.Equal(codeNotFound, res.Code)
+	}
+
+	// a wrong method is only told once the key is checked
+	w, _ := serve("DELETE", "/control/get?room=fallback")
+	at.Equal(401, w.Code)
+	w, res := serve("DELETE", "/control/get?room=fallback&api_key=secret")
+	at.Equal(405, w.Code)
+	at.Equal("GET, POST", w.Header().Get("Allow"))
+	at.Equal(codeMethodNotAllowed, res.Code)
+	w, _ = serve("POST", "/stats/livestat?api_key=secret")
+	at.Equal(405, w.Code)
+	at.Equal("GET", w.Header().Get("Allow"))
+	w, _ = serve("GET", "/control/reload?api_key=secret")
+	at.Equal(405, w.Code)
+	at.Equal("POST", w.Header().Get("Allow"))
+
+	// the legacy control routes take their params as a query or a form
+	defer configure.RoomKeys.DeleteChannel("fallback")
+	w, _ = serve("POST", "/control/get?room=fallback&api_key=secret")
+	at.Equal(200, w.Code)
+
+	// the right one goes on to the key check
+	w, _ = serve("GET", "/control/get?room=fallback")
+	at.Equal(401, w.Code)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("HEAD", "/control/get?room=fallback", nil))
+	at.Equal(401, w.Code)
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+)
+
+// gopFlusher is implemented by the inspectors able to empty the gop cache
+// of a room, such as *rtmp.RtmpStream
+type gopFlusher interface {
+	FlushGop(key string) error
+}
+
+// http://127.0.0.1:8090/control/flush-gop?room=ROOM_NAME[&app=live]
+func (server *Server) handleFlushGop(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if err := r.ParseForm(); err != nil || r.Form.Get("room") == "" {
+		res.Status = 400
+		res.Data = "url: /control/flush-gop?room=<ROOM_NAME>"
+		return
+	}
+	app, err := streamApp(r)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+
+	inspector, ok := rtmp.Inspect(server.handler)
+	flusher, canFlush := inspector.(gopFlusher)
+	if !ok || !canFlush {
+		res.Status = 500
+		res.Data = "Get rtmp stream information error"
+		return
+	}
+
+	room := r.Form.Get("room")
+	if err := flusher.FlushGop(fmt.Sprintf("%s/%s", app, room)); err != nil {
+		res.Status = 404
+		res.Data = "The room is not live"
+		return
+	}
+	res.Data = room
+}
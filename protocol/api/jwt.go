@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/SpooderfyBot/live/configure"
+
+	jwtmiddleware "github.com/auth0/go-jwt-middleware"
+	"github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// the codes of the responses refusing a request for its token
+const (
+	jwtMissing   = "token_missing"
+	jwtMalformed = "token_malformed"
+	jwtExpired   = "token_expired"
+	jwtInvalid   = "token_invalid"
+)
+
+// jwtExtractor mirrors apiKeyFromRequest, the authorization header is
+// preferred and the jwt query parameter is the fallback
+var jwtExtractor = jwtmiddleware.FromFirst(jwtmiddleware.FromAuthHeader, jwtmiddleware.FromParameter("jwt"))
+
+// jwtError is why a token was refused
+type jwtError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *jwtError) send(w http.ResponseWriter) {
+	if e.status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="livego"`)
+	}
+	res := &Response{w: w, Status: e.status, Code: e.code, Data: e.message}
+	res.SendJson()
+}
+
+// checkJWT validates the token of r with jwt.secret, signed with
+// jwt.algorithm. A missing token answers 401, a token that can't be
+// accepted 403
+func checkJWT(r *http.Request) (*jwt.Token, *jwtError) {
+	raw, err := jwtExtractor(r)
+	if err != nil {
+		return nil, &jwtError{http.StatusUnauthorized, jwtMalformed, err.Error()}
+	}
+	if raw == "" {
+		return nil, &jwtError{http.StatusUnauthorized, jwtMissing, "Required authorization token not found"}
+	}
+
+	method := configure.JWTSigningMethod()
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != method.Alg() {
+			return nil, fmt.Errorf("expected %s signing method but token specified %v", method.Alg(), t.Header["alg"])
+		}
+		return []byte(configure.Config.GetString("jwt.secret")), nil
+	})
+	if err == nil && token.Valid {
+		return token, nil
+	}
+
+	e := &jwtError{http.StatusForbidden, jwtInvalid, "The token isn't valid"}
+	if verr, ok := err.(*jwt.ValidationError); ok {
+		e.message = verr.Error()
+		switch {
+		case verr.Errors&jwt.ValidationErrorMalformed != 0:
+			e.code = jwtMalformed
+		case verr.Errors&jwt.ValidationErrorExpired != 0:
+			e.code = jwtExpired
+		}
+	}
+	return nil, e
+}
+
+func JWTMiddleware(next http.Handler) http.Handler {
+	isJWT := len(configure.Config.GetString("jwt.secret")) > 0
+	if !isJWT {
+		return next
+	}
+
+	log.Info("Using JWT middleware")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, e := checkJWT(r)
+		if e != nil {
+			e.send(w)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "user", token)))
+	})
+}
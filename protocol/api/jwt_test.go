@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWTMiddleware(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("jwt.secret", "secret")
+	defer configure.Config.Set("jwt.secret", "")
+
+	handler := JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	sign := func(claims jwt.MapClaims, secret string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		at.Nil(err)
+		return token
+	}
+	valid := sign(jwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix()}, "secret")
+
+	for _, c := range []struct {
+		name   string
+		auth   string
+		status int
+		code   string
+	}{
+		{"missing", "", 401, jwtMissing},
+		{"not bearer", "Basic " + valid, 401, jwtMalformed},
+		{"garbage", "Bearer garbage", 403, jwtMalformed},
+		{"bad signature", "Bearer " + sign(jwt.MapClaims{}, "other"), 403, jwtInvalid},
+		{"expired", "Bearer " + sign(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}, "secret"), 403, jwtExpired},
+		{"valid", "Bearer " + valid, http.StatusTeapot, ""},
+	} {
+		r := httptest.NewRequest("GET", "/stats/livestats", nil)
+		if c.auth != "" {
+			r.Header.Set("Authorization", c.auth)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		at.Equal(c.status, w.Code, c.name)
+		if c.code == "" {
+			continue
+		}
+
+		at.Equal("application/json", w.Header().Get("Content-Type"), c.name)
+		var res Response
+		at.Nil(json.Unmarshal(w.Body.Bytes(), &res), c.name)
+		at.Equal(c.status, res.Status, c.name)
+		at.Equal(c.code, res.Code, c.name)
+		at.NotEmpty(res.Data, c.name)
+		at.Equal(c.status == 401, w.Header().Get("WWW-Authenticate") != "", c.name)
+	}
+
+	// the token may also be a query parameter
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/stats/livestats?jwt="+valid, nil))
+	at.Equal(http.StatusTeapot, w.Code)
+}
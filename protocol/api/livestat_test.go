@@ -0,0 +1,295 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+	"github.com/SpooderfyBot/live/protocol/rtmp/rtmprelay"
+	"github.com/stretchr/testify/assert"
+)
+
+// idleConn is a publisher connection that never sends anything
+type idleConn struct {
+	done chan struct{}
+}
+
+func (c *idleConn) GetInfo() (string, string, string) {
+	return "live", "ready", "rtmp://127.0.0.1/live/ready"
+}
+
+func (c *idleConn) Close(error) {}
+
+func (c *idleConn) Write(core.ChunkStream) error { return nil }
+
+func (c *idleConn) Read(*core.ChunkStream) error {
+	<-c.done
+	return fmt.Errorf("closed")
+}
+
+// otherReader is a reader the stats don't know about
+type otherReader struct {
+	av.RWBaser
+	done chan struct{}
+}
+
+func (r *otherReader) Info() av.Info { return av.Info{Key: "live/other", UID: "other"} }
+
+func (r *otherReader) Close(error) {}
+
+func (r *otherReader) Read(*av.Packet) error {
+	<-r.done
+	return fmt.Errorf("closed")
+}
+
+func TestGetLiveStat(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	rtmpStream := rtmp.NewRtmpStream()
+	rtmpStream.GetStreams().Store("live/pending", rtmp.NewStream())
+	ready := rtmp.NewStream()
+	ready.AddReader(rtmp.NewVirReader(&idleConn{done: done}))
+	rtmpStream.GetStreams().Store("live/ready", ready)
+	other := rtmp.NewStream()
+	other.AddReader(&otherReader{done: done})
+	rtmpStream.GetStreams().Store("live/other", other)
+
+	server := &Server{handler: rtmpStream}
+	for _, tt := range []struct {
+		name       string
+		url        string
+		status     int
+		retryAfter string
+	}{
+		{"never existed", "/stats/livestat?room=missing", 404, ""},
+		{"not ready", "/stats/livestat?room=pending", 503, notReadyRetryAfter},
+		{"ready", "/stats/livestat?room=ready", 200, ""},
+		{"unknown reader", "/stats/livestat?room=other", 500, ""},
+		{"unknown app", "/stats/livestat?room=ready&app=nope", 400, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			at := assert.New(t)
+			w := httptest.NewRecorder()
+			server.GetLiveStat(w, httptest.NewRequest("GET", tt.url, nil))
+			at.Equal(tt.status, w.Code)
+			at.Equal(tt.retryAfter, w.Header().Get("Retry-After"))
+		})
+	}
+}
+
+// fakePlayer is a player with a known remote address
+type fakePlayer struct {
+	av.RWBaser
+	uid, addr string
+	closed    bool
+}
+
+func (p *fakePlayer) Info() av.Info { return av.Info{Key: "live/kick", UID: p.uid, Inter: true} }
+
+func (p *fakePlayer) Close(error) { p.closed = true }
+
+func (p *fakePlayer) Write(*av.Packet) error { return nil }
+
+func (p *fakePlayer) IsPlayer() bool { return true }
+
+func (p *fakePlayer) RemoteAddr() string { return p.addr }
+
+func TestHandleKick(t *testing.T) {
+	at := assert.New(t)
+
+	rtmpStream := rtmp.NewRtmpStream()
+	s := rtmp.NewStream()
+	rtmpStream.GetStreams().Store("live/kick", s)
+	abusive := &fakePlayer{uid: "a", addr: "10.0.0.1:50000"}
+	polite := &fakePlayer{uid: "b", addr: "10.0.0.2:50000"}
+	s.AddWriter(abusive)
+	s.AddWriter(polite)
+
+	server := &Server{handler: rtmpStream}
+	w := httptest.NewRecorder()
+	server.handleKick(w, httptest.NewRequest("GET", "/control/kick?room=kick&addr=10.0.0.3:50000", nil))
+	at.Equal(404, w.Code)
+
+	w = httptest.NewRecorder()
+	server.handleKick(w, httptest.NewRequest("GET", "/control/kick?room=kick&addr=10.0.0.1:50000", nil))
+	at.Equal(200, w.Code)
+	at.Contains(w.Body.String(), `"addr":"10.0.0.1:50000"`)
+	at.True(abusive.closed)
+	at.False(polite.closed)
+	at.Equal(1, s.PlayerCount())
+
+	w = httptest.NewRecorder()
+	server.handleKick(w, httptest.NewRequest("GET", "/control/kick?room=kick&id=b", nil))
+	at.Equal(200, w.Code)
+	at.Equal(0, s.PlayerCount())
+
+	w = httptest.NewRecorder()
+	server.handleKick(w, httptest.NewRequest("GET", "/control/kick?room=missing&id=b", nil))
+	at.Equal(404, w.Code)
+}
+
+// feedConn is a publisher connection sending chunks then idling
+type feedConn struct {
+	idleConn
+	chunks chan core.ChunkStream
+}
+
+func (c *feedConn) GetInfo() (string, string, string) {
+	return "live", "media", "rtmp://127.0.0.1/live/media"
+}
+
+func (c *feedConn) Read(cs *core.ChunkStream) error {
+	select {
+	case *cs = <-c.chunks:
+		return nil
+	case <-c.done:
+		return fmt.Errorf("closed")
+	}
+}
+
+func TestLiveStatMediaInfo(t *testing.T) {
+	at := assert.New(t)
+	done := make(chan struct{})
+	defer close(done)
+
+	conn := &feedConn{idleConn: idleConn{done: done}, chunks: make(chan core.ChunkStream)}
+	rtmpStream := rtmp.NewRtmpStream()
+	s := rtmp.NewStream()
+	s.AddReader(rtmp.NewVirReader(conn))
+	rtmpStream.GetStreams().Store("live/media", s)
+	server := &Server{handler: rtmpStream}
+
+	get := func() (msg stream) {
+		w := httptest.NewRecorder()
+		server.GetLiveStat(w, httptest.NewRequest("GET", "/stats/livestat?room=media", nil))
+		at.Equal(200, w.Code)
+		var res struct {
+			Data stream `json:"data"`
+		}
+		at.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+		return res.Data
+	}
+
+	// blank until the sequence headers arrive
+	msg := get()
+	at.Equal("", msg.VideoCodec)
+	at.Nil(msg.LastKeyFrameAge)
+
+	sps := []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0xc0, 0x44, 0x00, 0x00, 0x03,
+		0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xc8, 0x3c, 0x60, 0xc6, 0x58}
+	pps := []byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0}
+	conn.chunks <- core.ChunkStream{TypeID: av.TAG_VIDEO, Data: flv.NewAVCSeqHeader(sps, pps)}
+	conn.chunks <- core.ChunkStream{TypeID: av.TAG_AUDIO, Data: flv.NewAACSeqHeader([]byte{0x12, 0x10})}
+	conn.chunks <- core.ChunkStream{TypeID: av.TAG_VIDEO, Data: flv.NewAVCNALU([][]byte{{0x65, 0x88, 0x84}}, true, 0)}
+	// the chunks are unbuffered, the key frame was read once this one is taken
+	conn.chunks <- core.ChunkStream{TypeID: av.TAG_AUDIO, Data: flv.NewAACRaw([]byte{0x21, 0x19})}
+
+	msg = get()
+	at.Equal("avc1.640028", msg.VideoCodec)
+	at.Equal("mp4a.40.2", msg.AudioCodec)
+	at.Equal(1920, msg.Width)
+	at.Equal(1080, msg.Height)
+	at.Equal(25.0, msg.FrameRate)
+	if at.NotNil(msg.LastKeyFrameAge) {
+		at.True(*msg.LastKeyFrameAge >= 0 && *msg.LastKeyFrameAge < 1000)
+	}
+}
+
+func TestLiveStatSourceType(t *testing.T) {
+	at := assert.New(t)
+	done := make(chan struct{})
+	defer close(done)
+
+	rtmpStream := rtmp.NewRtmpStream()
+	for _, name := range []string{"live/direct", "live/pulled", "live/static"} {
+		s := rtmp.NewStream()
+		s.AddReader(rtmp.NewVirReader(&idleConn{done: done}))
+		rtmpStream.GetStreams().Store(name, s)
+	}
+	server := &Server{handler: rtmpStream, session: make(map[string]*rtmprelay.RtmpRelay)}
+	play, publish := "rtmp://127.0.0.1/live/src", "rtmp://127.0.0.1/live/pulled"
+	server.putSession("pull:live/pulled", rtmprelay.NewRtmpRelay(&play, &publish))
+	server.putSession(staticKeyPrefix+"pull:live/static", rtmprelay.NewRtmpRelay(&play, &publish))
+	// a push of a stream doesn't make it relay sourced
+	server.putSession("push:live/direct#0", rtmprelay.NewRtmpRelay(&play, &publish))
+
+	types := map[string]string{}
+	for _, msg := range server.collectStreams(rtmpStream).Publishers {
+		types[msg.Key] = msg.SourceType
+	}
+	at.Equal(map[string]string{
+		"live/direct": "direct",
+		"live/pulled": "relay_pull",
+		"live/static": "relay_pull",
+	}, types)
+
+	w := httptest.NewRecorder()
+	server.GetLiveStat(w, httptest.NewRequest("GET", "/stats/livestat?room=pulled", nil))
+	at.Contains(w.Body.String(), `"source_type":"relay_pull"`)
+}
+
+func TestGetRooms(t *testing.T) {
+	at := assert.New(t)
+	done := make(chan struct{})
+	defer close(done)
+
+	rtmpStream := rtmp.NewRtmpStream()
+	for _, key := range []string{"live/room_b", "live/room_a"} {
+		s := rtmp.NewStream()
+		s.AddReader(rtmp.NewVirReader(&idleConn{done: done}))
+		rtmpStream.GetStreams().Store(key, s)
+	}
+	// players waiting for a publisher
+	rtmpStream.GetStreams().Store("live/room_c", rtmp.NewStream())
+	players := map[string]int{"live/room_a": 2, "live/room_b": 3, "live/room_c": 1}
+	for key, n := range players {
+		s, _ := rtmpStream.GetStream(key)
+		for i := 0; i < n; i++ {
+			s.AddWriter(rtmp.NewVirWriter(&idleConn{done: done}))
+		}
+	}
+	server := &Server{handler: rtmpStream}
+
+	w := httptest.NewRecorder()
+	server.GetRooms(w, httptest.NewRequest("GET", "/stats/rooms", nil))
+	at.Equal(200, w.Code)
+	var res struct {
+		Data []roomStats `json:"data"`
+	}
+	at.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+
+	if at.Len(res.Data, 3) {
+		for i, key := range []string{"live/room_a", "live/room_b", "live/room_c"} {
+			room := res.Data[i]
+			at.Equal(key, room.Key)
+			at.Equal(players[key], room.PlayerCount)
+			at.Len(room.Players, players[key])
+			for _, p := range room.Players {
+				at.Equal(key, p.Key)
+			}
+		}
+		at.Equal("live/room_a", res.Data[0].Publisher.Key)
+		at.Equal("live/room_b", res.Data[1].Publisher.Key)
+		at.Nil(res.Data[2].Publisher)
+	}
+
+	// the totals add up the players
+	msgs := &streams{
+		Publishers: []stream{{Key: "live/x"}},
+		Players: []stream{
+			{Key: "live/x", VideoBitrate: 1000, AudioBitrate: 128},
+			{Key: "live/x", VideoBitrate: 500, AudioBitrate: 64},
+		},
+	}
+	rooms := byRoom(msgs)
+	if at.Len(rooms, 1) {
+		at.Equal(uint64(1692), rooms[0].OutboundKbps)
+		at.Equal(2, rooms[0].PlayerCount)
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+)
+
+// maintenanceState tells whether the server drains and how many live
+// streams are left
+type maintenanceState struct {
+	Maintenance bool `json:"maintenance"`
+	Streams     int  `json:"streams"`
+}
+
+// http://127.0.0.1:8090/control/maintenance?oper=on|off
+func (server *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	switch r.URL.Query().Get("oper") {
+	case "on":
+		rtmp.SetMaintenance(true)
+	case "off":
+		rtmp.SetMaintenance(false)
+	case "":
+	default:
+		res.Status = 400
+		res.Data = "url: /control/maintenance?oper=on|off"
+		return
+	}
+
+	state := maintenanceState{Maintenance: rtmp.Maintenance()}
+	if inspector, ok := rtmp.Inspect(server.handler); ok {
+		if c, ok := inspector.(capacityReporter); ok {
+			state.Streams = c.Capacity().Streams
+		}
+	}
+	res.Data = state
+}
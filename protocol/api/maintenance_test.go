@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/protocol/httpflv"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/testsrc"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenance(t *testing.T) {
+	at := assert.New(t)
+	defer rtmp.SetMaintenance(false)
+	handler := rtmp.NewRtmpStream()
+	server := &Server{handler: handler}
+	reader := testsrc.NewReader("live/draining", "test://live/draining", time.Minute)
+	defer reader.Close(nil)
+	handler.HandleReader(reader)
+	for i := 0; i < 100 && !handler.Publishing("live/draining"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	get := func(url string) (int, maintenanceState) {
+		w := httptest.NewRecorder()
+		server.handleMaintenance(w, httptest.NewRequest("GET", url, nil))
+		var res struct {
+			Data maintenanceState `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res.Data
+	}
+	code, state := get("/control/maintenance")
+	at.Equal(200, code)
+	at.Equal(maintenanceState{Maintenance: false, Streams: 1}, state)
+	code, _ = get("/control/maintenance?oper=maybe")
+	at.Equal(400, code)
+
+	flv := httptest.NewServer(httpflv.NewServer(handler).Handler())
+	defer flv.Close()
+	res, err := http.Get(flv.URL + "/live/draining.flv")
+	at.Nil(err)
+	at.Equal(200, res.StatusCode)
+	res.Body.Close()
+
+	code, state = get("/control/maintenance?oper=on")
+	at.Equal(200, code)
+	at.Equal(maintenanceState{Maintenance: true, Streams: 1}, state)
+
+	// new players are refused, the stream goes on
+	res, err = http.Get(flv.URL + "/live/draining.flv")
+	at.Nil(err)
+	at.Equal(http.StatusServiceUnavailable, res.StatusCode)
+	res.Body.Close()
+	at.True(handler.Publishing("live/draining"))
+
+	w := httptest.NewRecorder()
+	server.GetSummary(w, httptest.NewRequest("GET", "/stats/summary", nil))
+	var summary struct {
+		Data struct {
+			Maintenance bool `json:"maintenance"`
+		} `json:"data"`
+	}
+	at.Nil(json.Unmarshal(w.Body.Bytes(), &summary))
+	at.True(summary.Data.Maintenance)
+
+	code, state = get("/control/maintenance?oper=off")
+	at.Equal(200, code)
+	at.False(state.Maintenance)
+	res, err = http.Get(flv.URL + "/live/draining.flv")
+	at.Nil(err)
+	at.Equal(200, res.StatusCode)
+	res.Body.Close()
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/SpooderfyBot/live/configure"
+)
+
+// SetMediaHandlers gives the http-flv and hls servers the api port serves
+// with api.serve_media, either may be nil when disabled
+func (server *Server) SetMediaHandlers(flv, hls http.Handler) {
+	server.flv = flv
+	server.hls = hls
+}
+
+// withMedia serves the players on the paths of the media ports, with
+// api.serve_media. Players are not authenticated, as on the media ports,
+// the api key and the jwt only guard the api, acl limits them to
+// api.media.allowed_cidrs.
+func (server *Server) withMedia(acl *accessList, api http.Handler) http.Handler {
+	if !configure.Config.GetBool("api.serve_media") || (server.flv == nil && server.hls == nil) {
+		return api
+	}
+
+	// an app named like an api route doesn't shadow it
+	apiPrefixes := map[string]bool{
+		firstSegment(openAPIPath): true,
+		"statics":                 true,
+	}
+	for _, route := range apiRoutes {
+		apiPrefixes[firstSegment(route.path)] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := server.mediaHandler(r.URL.Path, apiPrefixes)
+		if h == nil {
+			h = api
+		} else if acl.check(mediaRoutes, w, r) {
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// mediaHandler picks the media server of path, nil for the api
+func (server *Server) mediaHandler(path string, apiPrefixes map[string]bool) http.Handler {
+	prefix := firstSegment(path)
+	switch {
+	case apiPrefixes[prefix]:
+		return nil
+	// the master playlists and dash manifests
+	case prefix == "hls" || prefix == "dash":
+		return server.hls
+	case prefix == "vod" || prefix == "audio":
+		return server.flv
+	case !configure.CheckAppName(prefix):
+		return nil
+	case strings.HasSuffix(path, ".flv"):
+		return server.flv
+	default:
+		return server.hls
+	}
+}
+
+// firstSegment is control for /control/get
+func firstSegment(path string) string {
+	return strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeMedia(t *testing.T) {
+	at := assert.New(t)
+	server := NewServer(rtmp.NewRtmpStream(), ":1935")
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		})
+	}
+	server.SetMediaHandlers(named("flv"), named("hls"))
+	get := func(h http.Handler, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	// off by default
+	w := get(server.Handler("secret"), "/live/movie.flv")
+	at.Equal(404, w.Code)
+
+	configure.Config.Set("api.serve_media", true)
+	configure.Config.Set("jwt.secret", "jwt")
+	defer configure.Config.Set("api.serve_media", false)
+	defer configure.Config.Set("jwt.secret", "")
+	h := server.Handler("secret")
+
+	// players need neither the api key nor a token
+	for url, name := range map[string]string{
+		"/live/movie.flv":          "flv",
+		"/live/movie.m3u8":         "hls",
+		"/live/movie/1_1.ts":       "hls",
+		"/live/movie/dvr.m3u8":     "hls",
+		"/hls/event/master.m3u8":   "hls",
+		"/dash/live/movie.mpd":     "hls",
+		"/vod/live/movie_1234.flv": "flv",
+		"/audio/live/movie.aac":    "flv",
+	} {
+		w = get(h, url)
+		at.Equal(200, w.Code, url)
+		at.Equal(name, w.Body.String(), url)
+	}
+
+	// the api is still guarded and unknown apps are not media
+	at.Equal(401, get(h, "/control/get?room=movie").Code)
+	at.Equal(401, get(h, "/stats/livestats").Code)
+	w = get(h, "/other/movie.flv")
+	at.NotEqual("flv", w.Body.String())
+
+	// without an hls server its paths are left to the api
+	server.SetMediaHandlers(named("flv"), nil)
+	w = get(server.Handler("secret"), "/live/movie.m3u8")
+	at.NotEqual("hls", w.Body.String())
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+)
+
+// maxMetadataBody bounds the json body of /control/metadata
+const maxMetadataBody = 64 << 10
+
+// metadataParams are the query parameters which are not metadata fields
+var metadataParams = map[string]bool{
+	"room":    true,
+	"app":     true,
+	"api_key": true,
+	"jwt":     true,
+}
+
+type injected struct {
+	Room   string            `json:"room"`
+	Fields map[string]string `json:"fields"`
+}
+
+// metadataFields reads the fields to inject from a json object body or,
+// without one, from the form values
+func metadataFields(r *http.Request) (map[string]string, error) {
+	fields := map[string]string{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxMetadataBody)).Decode(&fields); err != nil {
+			return nil, fmt.Errorf("invalid json body: %v", err)
+		}
+		return fields, nil
+	}
+	for name, values := range r.Form {
+		if !metadataParams[name] && len(values) > 0 {
+			fields[name] = values[0]
+		}
+	}
+	return fields, nil
+}
+
+// http://127.0.0.1:8090/control/metadata?room=ROOM_NAME&title=TITLE[&artist=ARTIST]
+func (server *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		res.Status = 405
+		res.Data = "metadata must be POSTed"
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		res.Status = 400
+		res.Data = "url: /control/metadata?room=<ROOM_NAME>&title=<TITLE>"
+		return
+	}
+
+	room := r.Form.Get("room")
+	if len(room) == 0 {
+		res.Status = 400
+		res.Data = "url: /control/metadata?room=<ROOM_NAME>&title=<TITLE>"
+		return
+	}
+	app, err := streamApp(r)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+	fields, err := metadataFields(r)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+	if len(fields) == 0 {
+		res.Status = 400
+		res.Data = "no metadata field given"
+		return
+	}
+
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
+		res.Status = 500
+		res.Data = "Get rtmp stream information error"
+		return
+	}
+
+	s, ok := inspector.GetStream(fmt.Sprintf("%s/%s", app, room))
+	if !ok || s.GetReader() == nil {
+		res.Status = 404
+		res.Data = "room is not live"
+		return
+	}
+	if err := s.InjectMetadata(fields); err != nil {
+		res.Status = 503
+		res.Data = err.Error()
+		return
+	}
+	res.Data = injected{Room: room, Fields: fields}
+}
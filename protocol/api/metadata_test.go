@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleMetadata(t *testing.T) {
+	at := assert.New(t)
+	done := make(chan struct{})
+	defer close(done)
+
+	rtmpStream := rtmp.NewRtmpStream()
+	live := rtmp.NewStream()
+	live.AddReader(rtmp.NewVirReader(&idleConn{done: done}))
+	rtmpStream.GetStreams().Store("live/radio", live)
+	server := &Server{handler: rtmpStream}
+
+	w := httptest.NewRecorder()
+	server.handleMetadata(w, httptest.NewRequest("GET", "/control/metadata?room=radio&title=Song", nil))
+	at.Equal(405, w.Code)
+
+	w = httptest.NewRecorder()
+	server.handleMetadata(w, httptest.NewRequest("POST", "/control/metadata?room=radio", nil))
+	at.Equal(400, w.Code)
+
+	w = httptest.NewRecorder()
+	server.handleMetadata(w, httptest.NewRequest("POST", "/control/metadata?room=silent&title=Song", nil))
+	at.Equal(404, w.Code)
+
+	w = httptest.NewRecorder()
+	server.handleMetadata(w, httptest.NewRequest("POST", "/control/metadata?room=radio&title=Song&api_key=secret", nil))
+	at.Equal(200, w.Code)
+	var res struct {
+		Data injected `json:"data"`
+	}
+	at.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+	at.Equal(injected{Room: "radio", Fields: map[string]string{"title": "Song"}}, res.Data)
+
+	r := httptest.NewRequest("POST", "/control/metadata?room=radio", strings.NewReader(`{"artist": "Band", "station": "fm"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	server.handleMetadata(w, r)
+	at.Equal(200, w.Code)
+	res.Data = injected{}
+	at.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+	at.Equal(map[string]string{"artist": "Band", "station": "fm"}, res.Data.Fields)
+}
@@ -0,0 +1,752 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/SpooderfyBot/live/configure"
+)
+
+// openAPIPath serves the OpenAPI 3 description of the http api
+const openAPIPath = "/api/openapi.json"
+
+// schema is a json schema object of the spec
+type schema map[string]interface{}
+
+var (
+	stringSchema  = schema{"type": "string"}
+	integerSchema = schema{"type": "integer"}
+	booleanSchema = schema{"type": "boolean"}
+
+	playbackModeSchema = schema{"type": "string", "enum": []string{
+		configure.PlaybackCustom, configure.PlaybackLowLatency, configure.PlaybackSmooth}}
+)
+
+func ref(name string) schema {
+	return schema{"$ref": "#/components/schemas/" + name}
+}
+
+func arrayOf(items schema) schema {
+	return schema{"type": "array", "items": items}
+}
+
+func object(properties schema) schema {
+	return schema{"type": "object", "properties": properties}
+}
+
+func oneOf(schemas ...schema) schema {
+	return schema{"oneOf": schemas}
+}
+
+type apiParam struct {
+	name     string
+	desc     string
+	required bool
+	schema   schema
+}
+
+var (
+	roomParam = apiParam{name: "room", desc: "Name of the room", required: true, schema: stringSchema}
+	appParam  = apiParam{name: "app", desc: "App of the room, live when omitted", schema: stringSchema}
+	// dryRunParam checks a relay call and pings its targets without
+	// starting or stopping anything
+	dryRunParam = apiParam{name: "dry_run", desc: "true to only validate the call and report what it would do", schema: booleanSchema}
+)
+
+// apiDoc documents an entry of apiRoutes
+type apiDoc struct {
+	summary string
+	methods []string // GET when empty
+	params  []apiParam
+	body    schema // the json request body, its fields are not params
+	data    schema // the data of a successful response
+}
+
+// allowedMethods are the methods the route answers as its apiDoc tells,
+// by default GET for the stats and GET and POST for the legacy control
+// routes, which read their params from a query or a form alike
+func (route apiRoute) allowedMethods() []string {
+	if methods := apiDocs[route.path].methods; len(methods) > 0 {
+		return methods
+	}
+	if route.stats {
+		return []string{http.MethodGet}
+	}
+	return []string{http.MethodGet, http.MethodPost}
+}
+
+var apiDocs = map[string]apiDoc{
+	"/control/push": {
+		summary: "Relay a local stream to one or more rtmp targets, 504 when a target does not connect in time",
+		methods: []string{http.MethodGet, http.MethodPost},
+		params: []apiParam{
+			{name: "oper", desc: "start, or stop to stop every target of the stream", required: true, schema: schema{"type": "string", "enum": []string{"start", "stop"}}},
+			{name: "app", desc: "App of the local stream", required: true, schema: stringSchema},
+			{name: "name", desc: "Name of the local stream", required: true, schema: stringSchema},
+			{name: "url", desc: "rtmp:// or rtmps:// target, repeat it to push to several targets. A POST can list them in a json body {\"targets\": [...]} instead", schema: arrayOf(stringSchema)},
+			dryRunParam,
+		},
+		data: oneOf(stringSchema, arrayOf(ref("PushTarget")), ref("DryRun")),
+	},
+	"/control/pull": {
+		summary: "Pull an rtmp, http-flv or hls source into a local stream, 504 when it does not connect in time",
+		params: []apiParam{
+			{name: "oper", desc: "start or stop", required: true, schema: schema{"type": "string", "enum": []string{"start", "stop"}}},
+			{name: "app", desc: "App of the local stream", required: true, schema: stringSchema},
+			{name: "name", desc: "Name of the local stream", required: true, schema: stringSchema},
+			{name: "url", desc: "rtmp://, rtmps://, http(s)://.../NAME.flv or http(s)://.../NAME.m3u8 source, repeated for mirrors read in order when a source fails", required: true, schema: stringSchema},
+			dryRunParam,
+		},
+		data: oneOf(stringSchema, ref("DryRun")),
+	},
+	"/control/ping": {
+		summary: "Check an rtmp ingest is reachable before pushing to it, only the handshake and the connect of the app are done so a wrong stream key is not caught",
+		params: []apiParam{
+			{name: "url", desc: "rtmp:// or rtmps:// url to check", required: true, schema: stringSchema},
+			{name: "timeout", desc: "Time to wait for the server, such as 2s, 5s when omitted and 30s at most", schema: stringSchema},
+		},
+		data: ref("Ping"),
+	},
+	"/control/get": {
+		summary: "Get the publishing key of a room, creating it when needed. With roomkeys.hash only the request creating the key returns it, 409 afterwards",
+		params: []apiParam{
+			roomParam,
+			appParam,
+			{name: "format", desc: "full returns the rtmp, flv and hls urls along with the key", schema: schema{"type": "string", "enum": []string{"full"}}},
+		},
+		data: oneOf(stringSchema, ref("StreamDescriptor")),
+	},
+	"/control/reset": {
+		summary: "Rotate the publishing key of a room, shared by the apps, and return the new one, the live publisher is kept",
+		params:  []apiParam{roomParam},
+		data:    stringSchema,
+	},
+	"/control/limits": {
+		summary: "Get or set the player limit of a room",
+		params: []apiParam{
+			roomParam,
+			appParam,
+			{name: "max_players", desc: "New limit, 0 for no limit", schema: integerSchema},
+		},
+		data: ref("Limits"),
+	},
+	"/control/quota": {
+		summary: "Get or set the bandwidth quota of the rtmp players of a room",
+		params: []apiParam{
+			roomParam,
+			appParam,
+			{name: "bytes", desc: "Bytes sent to the players allowed per period, 0 removes the quota", schema: integerSchema},
+			{name: "period", desc: "Period the quota resets after, such as 1h or 720h, 24h when omitted", schema: stringSchema},
+			{name: "drop", desc: "Drop the players once the quota is exceeded, else only new players are refused", schema: booleanSchema},
+		},
+		data: ref("Quota"),
+	},
+	"/control/settings": {
+		summary: "Get, or POST to change, the output settings of a room",
+		methods: []string{http.MethodGet, http.MethodPost},
+		params: []apiParam{
+			roomParam,
+			{name: "hls_enabled", desc: "true, false or default to follow the server config", schema: stringSchema},
+			{name: "flv_enabled", desc: "true, false or default to follow the server config", schema: stringSchema},
+			{name: "record", desc: "true, false or default to follow the server config", schema: stringSchema},
+		},
+		data: ref("Settings"),
+	},
+	"/control/promote": {
+		summary: "Fail a room over to a backup room: its players are moved onto the stream of the backup without reconnecting and its publisher, if any, is closed. The room follows the backup until a publisher connects to it again. 404 when the backup is not live",
+		params: []apiParam{
+			{name: "from", desc: "Live room taking over, such as ROOM_backup", required: true, schema: stringSchema},
+			{name: "to", desc: "Room whose players are moved", required: true, schema: stringSchema},
+			appParam,
+		},
+		data: ref("Promoted"),
+	},
+	"/control/alias": {
+		summary: "Serve the players of a room with the stream of another one, publishing to the alias is refused. Lists the aliases",
+		params: []apiParam{
+			{name: "from", desc: "Room becoming an alias, omit to only list the aliases", schema: stringSchema},
+			{name: "to", desc: "APP/ROOM or ROOM of the app played instead, empty to remove the alias", schema: stringSchema},
+			appParam,
+		},
+		data: arrayOf(ref("Alias")),
+	},
+	"/control/flush-gop": {
+		summary: "Empty the gop cache of a live room, its publisher and players stay connected and the players joining next start at the following key frame. 404 when the room is not live",
+		params:  []apiParam{roomParam, appParam},
+		data:    stringSchema,
+	},
+	"/control/test-publish": {
+		summary: "Publish color bars and silence to a room for a while, to check its playback without an encoder. Only served with api.test_endpoints, 409 when the room already has a publisher",
+		params: []apiParam{
+			roomParam,
+			appParam,
+			{name: "duration", desc: "How long the pattern is published, such as 30s, 10s when omitted and up to 1h", schema: stringSchema},
+			{name: "oper", desc: "stop ends the pattern published to the room", schema: stringSchema},
+		},
+		data: ref("StreamDescriptor"),
+	},
+	"/control/maintenance": {
+		summary: "Turn the maintenance mode on before a shutdown: new publishers get a \"server draining\" status, new players a 503 or that status, and the live streams go on. Without oper it reports the mode",
+		params: []apiParam{
+			{name: "oper", desc: "on or off", schema: schema{"type": "string", "enum": []string{"on", "off"}}},
+		},
+		data: ref("Maintenance"),
+	},
+	"/control/kick": {
+		summary: "Disconnect a player of a room",
+		params: []apiParam{
+			roomParam,
+			appParam,
+			{name: "addr", desc: "Remote IP:PORT of the player", schema: stringSchema},
+			{name: "id", desc: "Id of the player, when addr is not given", schema: stringSchema},
+		},
+		data: ref("Kicked"),
+	},
+	"/control/delete": {
+		summary: "Stop the stream of a room and delete its key",
+		params:  []apiParam{roomParam, appParam},
+		data:    stringSchema,
+	},
+	"/control/rooms": {
+		summary: "List every provisioned room and whether it is live",
+		params:  []apiParam{appParam},
+		data:    arrayOf(ref("StreamDescriptor")),
+	},
+	"/control/metadata": {
+		summary: "Inject timed metadata into a live room, as onMetaData for flv and rtmp players and ID3 for hls. Every other query parameter, or a json object body, is a field",
+		methods: []string{http.MethodPost},
+		params: []apiParam{
+			roomParam,
+			appParam,
+			{name: "title", desc: "Title of the track being played", schema: stringSchema},
+			{name: "artist", desc: "Artist of the track being played", schema: stringSchema},
+		},
+		data: ref("Metadata"),
+	},
+	"/control/snapshot": {
+		summary: "Dump the relays started through the api, to restore them on another process",
+		data:    ref("Snapshot"),
+	},
+	"/control/restore": {
+		summary: "Start the relays of a snapshot, given as a json body, the ones already running are kept",
+		methods: []string{http.MethodPost},
+		data:    arrayOf(ref("Restored")),
+	},
+	"/control/reload": {
+		summary: "Read the config file again, like a SIGHUP, secrets are redacted in the changes",
+		methods: []string{http.MethodPost},
+		params: []apiParam{
+			{name: "level", desc: "Log level to use until the next reload instead of the one of the file", schema: stringSchema},
+		},
+		data: ref("Reload"),
+	},
+	"/stats/livestats": {
+		summary: "List the publishers, players and relays of the server",
+		data:    ref("Streams"),
+	},
+	"/stats/livestat": {
+		summary: "Get the publisher of a room, 503 with Retry-After while it is set up",
+		params:  []apiParam{roomParam, appParam},
+		data:    ref("Stream"),
+	},
+	"/stats/summary": {
+		summary: "Get the totals of the server",
+		data:    ref("Summary"),
+	},
+	"/stats/events": {
+		summary: "List the last events of a room, oldest first",
+		params:  []apiParam{roomParam, appParam},
+		data:    arrayOf(ref("Event")),
+	},
+	"/stats/blocked": {
+		summary: "List the IPs refused by the rtmp server after failing too many handshakes",
+		data:    arrayOf(ref("BlockedIP")),
+	},
+	"/stats/rooms": {
+		summary: "List the rooms with a publisher or players, each with the publisher stats, its players and their total bitrate",
+		data:    arrayOf(ref("RoomStats")),
+	},
+	"/stats/relay": {
+		summary: "Get the bytes sent, bitrate, uptime and last error of a relay, 404 for an unknown key",
+		params:  []apiParam{{name: "key", desc: "Key of the relay as listed by /stats/livestats, such as push:live/movie", required: true, schema: stringSchema}},
+		data:    ref("RelayStats"),
+	},
+	"/v2/stats/livestats": {
+		summary: "List the publishers, players and relays of the server with the v2 field names",
+		data:    ref("StreamsV2"),
+	},
+	"/v2/stats/livestat": {
+		summary: "Get the publisher of a room with the v2 field names, 503 with Retry-After while it is set up",
+		params:  []apiParam{roomParam, appParam},
+		data:    ref("StreamV2"),
+	},
+	"/v2/stats/summary": {
+		summary: "Get the totals of the server with the v2 field names",
+		data:    ref("SummaryV2"),
+	},
+	"/api/v2/relays": {
+		summary: "List the relays with their bytes sent, bitrate, uptime and last error",
+		data:    arrayOf(ref("RelayStats")),
+	},
+	"/api/v2/relays/pull/start": {
+		summary: "Pull a source into a local stream, 502 when it does not start and 504 when it does not connect in time",
+		methods: []string{http.MethodPost},
+		body: object(schema{
+			"app":     stringSchema,
+			"name":    stringSchema,
+			"urls":    schema{"type": "array", "items": stringSchema, "description": "rtmp://, rtmps://, http(s)://.../NAME.flv or http(s)://.../NAME.m3u8 source followed by its mirrors"},
+			"dry_run": booleanSchema,
+		}),
+		data: oneOf(ref("RelayStats"), ref("DryRun")),
+	},
+	"/api/v2/relays/pull/stop": {
+		summary: "Stop the pull of a local stream, 404 when there is none",
+		methods: []string{http.MethodPost},
+		body:    ref("RelayStop"),
+		data:    oneOf(ref("RelaysStopped"), ref("DryRun")),
+	},
+	"/api/v2/relays/push/start": {
+		summary: "Relay a local stream to rtmp targets replacing its last ones, 502 when none of them starts",
+		methods: []string{http.MethodPost},
+		body: object(schema{
+			"app":     stringSchema,
+			"name":    stringSchema,
+			"targets": arrayOf(stringSchema),
+			"dry_run": booleanSchema,
+		}),
+		data: oneOf(arrayOf(ref("PushTarget")), ref("DryRun")),
+	},
+	"/api/v2/relays/push/stop": {
+		summary: "Stop every push target of a local stream, 404 when there is none",
+		methods: []string{http.MethodPost},
+		body:    ref("RelayStop"),
+		data:    oneOf(ref("RelaysStopped"), ref("DryRun")),
+	},
+	"/api/v2/rooms/key": {
+		summary: "Read the publishing key of a room, 404 when it has none and 409 when it is hashed. A POST creates the key of a room that has none",
+		methods: []string{http.MethodGet, http.MethodPost},
+		params: []apiParam{
+			{name: "room", desc: "Name of the room", required: true, schema: stringSchema},
+			appParam,
+			{name: "format", desc: "full for the urls of the room along with its key", schema: schema{"type": "string", "enum": []string{"full"}}},
+		},
+		body: object(schema{
+			"room":   stringSchema,
+			"app":    stringSchema,
+			"format": schema{"type": "string", "enum": []string{"full"}},
+		}),
+		data: oneOf(ref("RoomKey"), ref("StreamDescriptor")),
+	},
+	"/api/v2/rooms/reset": {
+		summary: "Rotate the publishing key of a room and return the new one",
+		methods: []string{http.MethodPost},
+		body:    object(schema{"room": stringSchema}),
+		data:    ref("RoomKey"),
+	},
+	"/api/v2/rooms/delete": {
+		summary: "Stop the stream of a room and delete its key, 404 when the room has neither",
+		methods: []string{http.MethodPost},
+		body:    ref("RoomRequest"),
+		data:    ref("RoomDeleted"),
+	},
+	"/api/v2/rooms/kick": {
+		summary: "Disconnect a player of a room by address or id, 404 when the room has no stream or the player is not found",
+		methods: []string{http.MethodPost},
+		body: object(schema{
+			"room": stringSchema,
+			"app":  stringSchema,
+			"addr": stringSchema,
+			"id":   stringSchema,
+		}),
+		data: ref("Kicked"),
+	},
+}
+
+var apiSchemas = schema{
+	"Error": object(schema{
+		"status": integerSchema,
+		"code": schema{"type": "string", "description": "Why a token was refused, the path or method is unknown, or an /api/v2 call failed",
+			"enum": []string{jwtMissing, jwtMalformed, jwtExpired, jwtInvalid, codeNotFound, codeMethodNotAllowed,
+				codeInvalidBody, codeInvalidParams, codeRelayNotFound, codeRelayFailed, codeRelayTimeout,
+				codeRoomNotFound, codePlayerNotFound, codeKeyHashed, codeInternal}},
+		"data": schema{"type": "string", "description": "What went wrong"},
+	}),
+	"StreamDescriptor": object(schema{
+		"room":     stringSchema,
+		"app":      stringSchema,
+		"key":      stringSchema,
+		"rtmp_url": schema{"type": "string", "description": "Publishing url, only along with the key"},
+		"flv_url":  stringSchema,
+		"hls_url":  stringSchema,
+		"live":     booleanSchema,
+		"alias_of": stringSchema,
+	}),
+	"RoomRequest": object(schema{
+		"room": stringSchema,
+		"app":  stringSchema,
+	}),
+	"RoomKey": object(schema{
+		"room": stringSchema,
+		"key":  stringSchema,
+	}),
+	"RoomDeleted": object(schema{
+		"room":    stringSchema,
+		"stopped": schema{"type": "boolean", "description": "Whether the room had a live stream"},
+	}),
+	"RelayStop": object(schema{
+		"app":     stringSchema,
+		"name":    stringSchema,
+		"dry_run": booleanSchema,
+	}),
+	"RelaysStopped": object(schema{
+		"key":     stringSchema,
+		"stopped": arrayOf(stringSchema),
+	}),
+	"Alias": object(schema{
+		"from": stringSchema,
+		"to":   stringSchema,
+	}),
+	"PushTarget": object(schema{
+		"key":     stringSchema,
+		"url":     stringSchema,
+		"running": booleanSchema,
+		"error":   stringSchema,
+	}),
+	"Metadata": object(schema{
+		"room":   stringSchema,
+		"fields": schema{"type": "object", "additionalProperties": stringSchema},
+	}),
+	"Snapshot": object(schema{
+		"sessions": arrayOf(object(schema{
+			"key":         stringSchema,
+			"direction":   schema{"type": "string", "enum": []string{"push", "pull"}},
+			"app":         stringSchema,
+			"name":        stringSchema,
+			"source_url":  stringSchema,
+			"target_url":  stringSchema,
+			"mirror_urls": arrayOf(stringSchema),
+		})),
+	}),
+	"Reload": object(schema{
+		"changed":  arrayOf(ref("ConfigChange")),
+		"deferred": arrayOf(ref("ConfigChange")),
+		"level":    stringSchema,
+	}),
+	"ConfigChange": object(schema{
+		"key": stringSchema,
+		"old": schema{},
+		"new": schema{},
+	}),
+	"Restored": object(schema{
+		"key":     stringSchema,
+		"running": booleanSchema,
+		"existed": booleanSchema,
+		"error":   stringSchema,
+	}),
+	"Limits": object(schema{
+		"room":         stringSchema,
+		"player_count": integerSchema,
+		"max_players":  integerSchema,
+	}),
+	"DryRun": object(schema{
+		"dry_run": booleanSchema,
+		"oper":    stringSchema,
+		"key":     stringSchema,
+		"action":  schema{"type": "string", "enum": []string{dryRunStart, dryRunReplace, dryRunStop, dryRunNone}},
+		"exists":  booleanSchema,
+		"targets": arrayOf(ref("Ping")),
+	}),
+	"Ping": object(schema{
+		"url":        stringSchema,
+		"reachable":  booleanSchema,
+		"latency_ms": integerSchema,
+		"error":      stringSchema,
+	}),
+	"Promoted": object(schema{
+		"from":               stringSchema,
+		"to":                 stringSchema,
+		"players":            integerSchema,
+		"replaced_publisher": booleanSchema,
+	}),
+	"Quota": object(schema{
+		"room":         stringSchema,
+		"bytes":        integerSchema,
+		"period":       stringSchema,
+		"drop_players": booleanSchema,
+		"used":         integerSchema,
+		"remaining":    integerSchema,
+		"reset_at":     schema{"type": "string", "format": "date-time"},
+	}),
+	"Settings": object(schema{
+		"room":        stringSchema,
+		"hls_enabled": schema{"type": "boolean", "nullable": true},
+		"flv_enabled": schema{"type": "boolean", "nullable": true},
+		"record":      schema{"type": "boolean", "nullable": true},
+	}),
+	"Kicked": object(schema{
+		"room": stringSchema,
+		"id":   stringSchema,
+		"addr": stringSchema,
+	}),
+	"BufferStats": object(schema{
+		"size":               integerSchema,
+		"packets":            integerSchema,
+		"bytes":              integerSchema,
+		"high_water_packets": integerSchema,
+		"high_water_bytes":   integerSchema,
+		"lagging":            booleanSchema,
+	}),
+	"Stream": object(schema{
+		"key":                   stringSchema,
+		"id":                    stringSchema,
+		"addr":                  stringSchema,
+		"url":                   stringSchema,
+		"stream_id":             integerSchema,
+		"video_total_bytes":     integerSchema,
+		"video_speed":           schema{"type": "integer", "deprecated": true},
+		"audio_total_bytes":     integerSchema,
+		"audio_speed":           schema{"type": "integer", "deprecated": true},
+		"video_bitrate_kbps":    integerSchema,
+		"audio_bitrate_kbps":    integerSchema,
+		"player_count":          integerSchema,
+		"max_players":           integerSchema,
+		"hls_segments":          integerSchema,
+		"hls_write_failures":    integerSchema,
+		"source_type":           schema{"type": "string", "enum": []string{sourceDirect, sourceRelayPull}},
+		"max_bitrate_kbps":      integerSchema,
+		"near_max_bitrate":      booleanSchema,
+		"quota_remaining_bytes": integerSchema,
+		"discontinuities":       integerSchema,
+		"reordered_packets":     integerSchema,
+		"last_keyframe_age_ms":  schema{"type": "integer", "nullable": true},
+		"video_codec":           stringSchema,
+		"audio_codec":           stringSchema,
+		"width":                 integerSchema,
+		"height":                integerSchema,
+		"framerate":             schema{"type": "number"},
+		"buffer":                ref("BufferStats"),
+		"recording":             ref("Recording"),
+	}),
+	"Relay": object(schema{
+		"key":         stringSchema,
+		"group":       stringSchema,
+		"play_url":    stringSchema,
+		"publish_url": stringSchema,
+		"running":     booleanSchema,
+	}),
+	"RoomStats": object(schema{
+		"key":           stringSchema,
+		"publisher":     ref("Stream"),
+		"players":       arrayOf(ref("Stream")),
+		"player_count":  integerSchema,
+		"outbound_kbps": integerSchema,
+	}),
+	"RelayStats": object(schema{
+		"key":           stringSchema,
+		"group":         stringSchema,
+		"play_url":      stringSchema,
+		"publish_url":   stringSchema,
+		"running":       booleanSchema,
+		"bytes_relayed": integerSchema,
+		"bitrate_kbps":  integerSchema,
+		"started_at":    schema{"type": "string", "format": "date-time"},
+		"uptime_ms":     integerSchema,
+		"last_error":    stringSchema,
+		"resyncs":       schema{"type": "integer", "description": "Times corrupt FLV tags of the source were skipped"},
+		"active_source": stringSchema,
+		"mirrors":       arrayOf(stringSchema),
+	}),
+	"Streams": object(schema{
+		"publishers": arrayOf(ref("Stream")),
+		"players":    arrayOf(ref("Stream")),
+		"relays":     arrayOf(ref("Relay")),
+	}),
+	"Summary": object(schema{
+		"publishers":     integerSchema,
+		"players":        integerSchema,
+		"inbound_speed":  integerSchema,
+		"outbound_speed": integerSchema,
+		"active_relays":  integerSchema,
+		"playback_mode":  playbackModeSchema,
+		"maintenance":    schema{"type": "boolean", "description": "new publishers and players are refused"},
+		"workers":        ref("WorkerStats"),
+		"capacity":       ref("Capacity"),
+		"streams":        arrayOf(ref("StreamDescriptor")),
+	}),
+	"StreamV2": object(schema{
+		"key":            stringSchema,
+		"id":             stringSchema,
+		"remote_addr":    stringSchema,
+		"url":            stringSchema,
+		"rtmp_stream_id": integerSchema,
+		"video": object(schema{
+			"codec":                stringSchema,
+			"width":                integerSchema,
+			"height":               integerSchema,
+			"frame_rate":           schema{"type": "number"},
+			"bitrate_kbps":         integerSchema,
+			"bytes":                integerSchema,
+			"last_keyframe_age_ms": schema{"type": "integer", "nullable": true},
+		}),
+		"audio": object(schema{
+			"codec":        stringSchema,
+			"bitrate_kbps": integerSchema,
+			"bytes":        integerSchema,
+		}),
+		"players": object(schema{
+			"count": integerSchema,
+			"max":   integerSchema,
+		}),
+		"bitrate_limit": object(schema{
+			"max_kbps": integerSchema,
+			"near_max": booleanSchema,
+		}),
+		"quota_remaining_bytes": integerSchema,
+		"discontinuities":       integerSchema,
+		"reordered_packets":     integerSchema,
+		"hls_segments":          integerSchema,
+		"hls_write_failures":    integerSchema,
+		"source_type":           schema{"type": "string", "enum": []string{sourceDirect, sourceRelayPull}},
+		"buffer":                ref("BufferStats"),
+		"recording":             ref("Recording"),
+	}),
+	"StreamsV2": object(schema{
+		"publishers": arrayOf(ref("StreamV2")),
+		"players":    arrayOf(ref("StreamV2")),
+		"relays":     arrayOf(ref("Relay")),
+	}),
+	"SummaryV2": object(schema{
+		"publishers":    integerSchema,
+		"players":       integerSchema,
+		"inbound_kbps":  integerSchema,
+		"outbound_kbps": integerSchema,
+		"active_relays": integerSchema,
+		"playback_mode": playbackModeSchema,
+		"maintenance":   schema{"type": "boolean", "description": "new publishers and players are refused"},
+		"workers":       ref("WorkerStats"),
+		"capacity":      ref("Capacity"),
+		"streams":       arrayOf(ref("StreamDescriptor")),
+	}),
+	"Recording": object(schema{
+		"file":     stringSchema,
+		"segments": integerSchema,
+	}),
+	"Maintenance": object(schema{
+		"maintenance": booleanSchema,
+		"streams":     schema{"type": "integer", "description": "live streams left to drain"},
+	}),
+	"Capacity": object(schema{
+		"streams":         integerSchema,
+		"max_streams":     schema{"type": "integer", "description": "rtmp.max_streams, 0 when unlimited"},
+		"connections":     schema{"type": "integer", "description": "open rtmp connections and http-flv players"},
+		"max_connections": schema{"type": "integer", "description": "rtmp.max_connections, 0 when unlimited"},
+	}),
+	"WorkerStats": object(schema{
+		"workers":    integerSchema,
+		"capacity":   integerSchema,
+		"queued":     integerSchema,
+		"running":    integerSchema,
+		"dropped":    integerSchema,
+		"saturation": schema{"type": "integer", "description": "percent of the queues in use, jobs are dropped at 100"},
+	}),
+	"BlockedIP": object(schema{
+		"ip":       stringSchema,
+		"failures": integerSchema,
+		"until":    schema{"type": "string", "format": "date-time"},
+	}),
+	"Event": object(schema{
+		"time":   schema{"type": "string", "format": "date-time"},
+		"key":    stringSchema,
+		"type":   stringSchema,
+		"reason": stringSchema,
+	}),
+}
+
+// operation describes one method of a route
+func (doc apiDoc) operation(method string) schema {
+	params := make([]schema, 0, len(doc.params))
+	for _, p := range doc.params {
+		if doc.body != nil && method != http.MethodGet {
+			// a route with a body only takes its params as a query on a GET
+			break
+		}
+		params = append(params, schema{
+			"name":        p.name,
+			"in":          "query",
+			"description": p.desc,
+			"required":    p.required,
+			"schema":      p.schema,
+		})
+	}
+	errorResponse := schema{
+		"description": "The error envelope",
+		"content": schema{
+			"application/json": schema{"schema": ref("Error")},
+		},
+	}
+	op := schema{
+		"summary":    doc.summary,
+		"parameters": params,
+		"responses": schema{
+			"200": schema{
+				"description": "The response envelope",
+				"content": schema{
+					"application/json": schema{"schema": object(schema{
+						"status": integerSchema,
+						"data":   doc.data,
+					})},
+				},
+			},
+			"default": errorResponse,
+		},
+	}
+	if doc.body != nil && method != http.MethodGet {
+		op["requestBody"] = schema{
+			"required": true,
+			"content": schema{
+				"application/json": schema{"schema": doc.body},
+			},
+		}
+	}
+	return op
+}
+
+// openAPISpec builds the spec from apiRoutes and their apiDocs
+func openAPISpec() schema {
+	paths := schema{}
+	for _, route := range apiRoutes {
+		doc := apiDocs[route.path]
+		item := schema{}
+		for _, m := range route.allowedMethods() {
+			item[strings.ToLower(m)] = doc.operation(m)
+		}
+		paths[route.path] = item
+	}
+
+	return schema{
+		"openapi": "3.0.3",
+		"info": schema{
+			"title":   "livego http api",
+			"version": "1.0",
+		},
+		"paths": paths,
+		"components": schema{
+			"schemas": apiSchemas,
+			"securitySchemes": schema{
+				"apiKeyHeader": schema{"type": "apiKey", "in": "header", "name": "authorization"},
+				"apiKeyQuery":  schema{"type": "apiKey", "in": "query", "name": "api_key"},
+			},
+		},
+		"security": []schema{
+			{"apiKeyHeader": []string{}},
+			{"apiKeyQuery": []string{}},
+		},
+	}
+}
+
+// http://127.0.0.1:8090/api/openapi.json
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAPISpec())
+}
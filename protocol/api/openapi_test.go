@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// refs collects the $ref values found anywhere in v
+func refs(v interface{}, found map[string]bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if s, ok := e.(string); ok && k == "$ref" {
+				found[s] = true
+			}
+			refs(e, found)
+		}
+	case []interface{}:
+		for _, e := range v {
+			refs(e, found)
+		}
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	at := assert.New(t)
+
+	// every route is documented and every doc is served
+	at.Equal(len(apiRoutes), len(apiDocs))
+	for _, route := range apiRoutes {
+		doc, ok := apiDocs[route.path]
+		if at.True(ok, "%s is not documented", route.path) {
+			at.NotEmpty(doc.summary, route.path)
+			at.NotNil(doc.data, route.path)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	serveOpenAPI(w, httptest.NewRequest("GET", openAPIPath, nil))
+	at.Equal(200, w.Code)
+	at.Equal("application/json", w.Header().Get("Content-Type"))
+
+	var spec map[string]interface{}
+	at.Nil(json.Unmarshal(w.Body.Bytes(), &spec))
+	at.Equal("3.0.3", spec["openapi"])
+
+	paths := spec["paths"].(map[string]interface{})
+	at.Equal(len(apiRoutes), len(paths))
+	push := paths["/control/push"].(map[string]interface{})
+	at.Contains(push, "get")
+	at.Contains(push, "post")
+
+	found := map[string]bool{}
+	refs(spec, found)
+	at.True(found["#/components/schemas/Error"])
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for r := range found {
+		at.Contains(schemas, strings.TrimPrefix(r, "#/components/schemas/"))
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+)
+
+const (
+	// defaultPingTimeout bounds a ping without a timeout parameter
+	defaultPingTimeout = 5 * time.Second
+	maxPingTimeout     = 30 * time.Second
+)
+
+type ping struct {
+	Url       string `json:"url"`
+	Reachable bool   `json:"reachable"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// http://127.0.0.1:8090/control/ping?url=rtmp://HOST/APP/KEY[&timeout=5s]
+func (server *Server) handlePing(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if err := r.ParseForm(); err != nil || r.Form.Get("url") == "" {
+		res.Status = 400
+		res.Data = "url: /control/ping?url=<RTMP_URL>[&timeout=<DURATION>]"
+		return
+	}
+	url, err := checkRelayURL(r.Form.Get("url"), pushSchemes)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+	timeout := defaultPingTimeout
+	if t := r.Form.Get("timeout"); t != "" {
+		if timeout, err = time.ParseDuration(t); err != nil || timeout <= 0 || timeout > maxPingTimeout {
+			res.Status = 400
+			res.Data = fmt.Sprintf("timeout must be a duration up to %s", maxPingTimeout)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	msg := ping{Url: url}
+	latency, err := rtmp.Ping(ctx, url)
+	if err != nil {
+		msg.Error = err.Error()
+	} else {
+		msg.Reachable = true
+		msg.LatencyMs = latency.Milliseconds()
+	}
+	res.Data = msg
+}
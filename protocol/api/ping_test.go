@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	neturl "net/url"
+	"testing"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlePing(t *testing.T) {
+	at := assert.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer l.Close()
+	rtmpStream := rtmp.NewRtmpStream()
+	go rtmp.NewRtmpServer(rtmpStream, nil).Serve(l)
+
+	// accepts the connection and never completes the rtmp handshake
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer silent.Close()
+	go func() {
+		for {
+			c, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	closed.Close()
+
+	server := &Server{handler: rtmpStream}
+	get := func(query string) (int, ping) {
+		w := httptest.NewRecorder()
+		server.handlePing(w, httptest.NewRequest("GET", "/control/ping?"+query, nil))
+		var res struct {
+			Data ping `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res.Data
+	}
+
+	url := "rtmp://" + l.Addr().String() + "/live/key"
+	code, msg := get("url=" + neturl.QueryEscape(url))
+	at.Equal(200, code)
+	at.True(msg.Reachable)
+	at.Equal(url, msg.Url)
+	at.Empty(msg.Error)
+	// nothing was published
+	_, published := rtmpStream.GetStreams().Load("live/key")
+	at.False(published)
+
+	code, msg = get("timeout=200ms&url=" + neturl.QueryEscape("rtmp://"+silent.Addr().String()+"/live/key"))
+	at.Equal(200, code)
+	at.False(msg.Reachable)
+	at.NotEmpty(msg.Error)
+
+	code, msg = get("url=" + neturl.QueryEscape("rtmp://"+closed.Addr().String()+"/live/key"))
+	at.Equal(200, code)
+	at.False(msg.Reachable)
+	at.NotEmpty(msg.Error)
+
+	for _, query := range []string{
+		"",
+		"url=" + neturl.QueryEscape("http://"+l.Addr().String()+"/live/key.flv"),
+		"timeout=1m&url=" + neturl.QueryEscape(url),
+		"timeout=soon&url=" + neturl.QueryEscape(url),
+	} {
+		code, _ = get(query)
+		at.Equal(400, code, query)
+	}
+}
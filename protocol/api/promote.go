@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+)
+
+// roomPromoter is implemented by the inspectors able to bind a room to the
+// stream of another one, such as *rtmp.RtmpStream
+type roomPromoter interface {
+	Promote(from, to string) (rtmp.Promotion, error)
+}
+
+type promoted struct {
+	From              string `json:"from"`
+	To                string `json:"to"`
+	Players           int    `json:"players"`
+	ReplacedPublisher bool   `json:"replaced_publisher"`
+}
+
+// http://127.0.0.1:8090/control/promote?from=ROOM_backup&to=ROOM[&app=live]
+func (server *Server) handlePromote(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if err := r.ParseForm(); err != nil || r.Form.Get("from") == "" || r.Form.Get("to") == "" {
+		res.Status = 400
+		res.Data = "url: /control/promote?from=<BACKUP_ROOM>&to=<ROOM>"
+		return
+	}
+	app, err := streamApp(r)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+
+	inspector, ok := rtmp.Inspect(server.handler)
+	promoter, canPromote := inspector.(roomPromoter)
+	if !ok || !canPromote {
+		res.Status = 500
+		res.Data = "Get rtmp stream information error"
+		return
+	}
+
+	from, to := r.Form.Get("from"), r.Form.Get("to")
+	p, err := promoter.Promote(fmt.Sprintf("%s/%s", app, from), fmt.Sprintf("%s/%s", app, to))
+	switch err {
+	case nil:
+	case rtmp.ErrNotLive:
+		res.Status = 404
+		res.Data = "The from room is not live"
+		return
+	default:
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+	res.Data = promoted{
+		From:              from,
+		To:                to,
+		Players:           p.Players,
+		ReplacedPublisher: p.ReplacedPublisher,
+	}
+}
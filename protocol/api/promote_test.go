@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlePromote(t *testing.T) {
+	at := assert.New(t)
+	done := make(chan struct{})
+	defer close(done)
+
+	rtmpStream := rtmp.NewRtmpStream()
+	rtmpStream.HandleReader(rtmp.NewVirReader(&idleConn{done: done}))
+	server := &Server{handler: rtmpStream}
+	get := func(url string) (int, promoted) {
+		w := httptest.NewRecorder()
+		server.handlePromote(w, httptest.NewRequest("GET", url, nil))
+		var res struct {
+			Data promoted `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res.Data
+	}
+
+	for url, status := range map[string]int{
+		"/control/promote":                             400,
+		"/control/promote?from=ready":                  400,
+		"/control/promote?from=ready&to=ready":         400,
+		"/control/promote?from=ready&to=room&app=nope": 400,
+		"/control/promote?from=missing&to=room":        404,
+	} {
+		code, _ := get(url)
+		at.Equal(status, code, url)
+	}
+
+	// the publisher starts reading in the background
+	var msg promoted
+	at.Eventually(func() bool {
+		var code int
+		code, msg = get("/control/promote?from=ready&to=room")
+		return code == 200
+	}, time.Second, 10*time.Millisecond)
+	at.Equal(promoted{From: "ready", To: "room"}, msg)
+
+	ready, _ := rtmpStream.GetStream("live/ready")
+	room, _ := rtmpStream.GetStream("live/room")
+	at.True(ready == room)
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+)
+
+// defaultQuotaPeriod is the period of a quota set without one
+const defaultQuotaPeriod = 24 * time.Hour
+
+type quota struct {
+	Room        string     `json:"room"`
+	Bytes       int64      `json:"bytes"`
+	Period      string     `json:"period,omitempty"`
+	DropPlayers bool       `json:"drop_players"`
+	Used        int64      `json:"used"`
+	Remaining   int64      `json:"remaining"`
+	ResetAt     *time.Time `json:"reset_at,omitempty"`
+}
+
+// parseQuota reads the quota to set, ok is false when bytes is not given
+func parseQuota(r *http.Request) (q rtmp.Quota, ok bool, err error) {
+	bytes := r.Form.Get("bytes")
+	if bytes == "" {
+		return q, false, nil
+	}
+	if q.Bytes, err = strconv.ParseInt(bytes, 10, 64); err != nil || q.Bytes < 0 {
+		return q, false, fmt.Errorf("bytes must be a positive number")
+	}
+	q.Period = defaultQuotaPeriod
+	if period := r.Form.Get("period"); period != "" {
+		if q.Period, err = time.ParseDuration(period); err != nil || q.Period < 0 {
+			return q, false, fmt.Errorf("period must be a duration such as 24h")
+		}
+	}
+	if drop := r.Form.Get("drop"); drop != "" {
+		if q.DropPlayers, err = strconv.ParseBool(drop); err != nil {
+			return q, false, fmt.Errorf("drop must be true or false")
+		}
+	}
+	return q, true, nil
+}
+
+// http://127.0.0.1:8090/control/quota?room=ROOM_NAME[&app=live][&bytes=N&period=24h&drop=true]
+func (server *Server) handleQuota(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if err := r.ParseForm(); err != nil {
+		res.Status = 400
+		res.Data = "url: /control/quota?room=<ROOM_NAME>&bytes=<N>&period=<DURATION>"
+		return
+	}
+	room := r.Form.Get("room")
+	if len(room) == 0 {
+		res.Status = 400
+		res.Data = "url: /control/quota?room=<ROOM_NAME>&bytes=<N>&period=<DURATION>"
+		return
+	}
+	app, err := streamApp(r)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+	q, set, err := parseQuota(r)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+
+	inspector, ok := rtmp.Inspect(server.handler)
+	quotas, hasQuotas := inspector.(roomQuotas)
+	if !ok || !hasQuotas {
+		res.Status = 500
+		res.Data = "Get rtmp stream information error"
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s", app, room)
+	if set {
+		quotas.SetQuota(key, q)
+	}
+	msg := quota{Room: room}
+	if status, ok := quotas.QuotaStatus(key); ok {
+		msg.Bytes = status.Bytes
+		msg.DropPlayers = status.DropPlayers
+		msg.Used = status.Used
+		msg.Remaining = status.Remaining
+		if status.Period > 0 {
+			msg.Period = status.Period.String()
+			msg.ResetAt = &status.ResetAt
+		}
+	}
+	res.Data = msg
+}
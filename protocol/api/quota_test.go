@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleQuota(t *testing.T) {
+	at := assert.New(t)
+	rtmpStream := rtmp.NewRtmpStream()
+	server := &Server{handler: rtmpStream}
+
+	get := func(url string) (int, quota) {
+		w := httptest.NewRecorder()
+		server.handleQuota(w, httptest.NewRequest("GET", url, nil))
+		var res struct {
+			Data quota `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res.Data
+	}
+
+	code, msg := get("/control/quota?room=metered")
+	at.Equal(200, code)
+	at.Equal(quota{Room: "metered"}, msg)
+
+	code, msg = get("/control/quota?room=metered&bytes=1000000&period=1h&drop=true")
+	at.Equal(200, code)
+	at.Equal(int64(1000000), msg.Bytes)
+	at.Equal("1h0m0s", msg.Period)
+	at.True(msg.DropPlayers)
+	at.Equal(int64(1000000), msg.Remaining)
+	at.NotNil(msg.ResetAt)
+	status, ok := rtmpStream.QuotaStatus("live/metered")
+	at.True(ok)
+	at.Equal(int64(1000000), status.Bytes)
+
+	// the period defaults to a day
+	_, msg = get("/control/quota?room=metered&bytes=500")
+	at.Equal("24h0m0s", msg.Period)
+	at.False(msg.DropPlayers)
+
+	for _, url := range []string{
+		"/control/quota",
+		"/control/quota?room=metered&bytes=-1",
+		"/control/quota?room=metered&bytes=lots",
+		"/control/quota?room=metered&bytes=1&period=monthly",
+		"/control/quota?room=metered&bytes=1&drop=maybe",
+	} {
+		code, _ = get(url)
+		at.Equal(400, code, url)
+	}
+
+	_, msg = get("/control/quota?room=metered&bytes=0")
+	at.Equal(quota{Room: "metered"}, msg)
+	_, ok = rtmpStream.QuotaStatus("live/metered")
+	at.False(ok)
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// sweepInterval is how often the buckets back to full are dropped
+const sweepInterval = time.Minute
+
+// rateLimiter is a token bucket per caller, a rate <= 0 disables it
+type rateLimiter struct {
+	lock    sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// set changes the rate and burst, the buckets keep their tokens up to
+// the new burst
+func (l *rateLimiter) set(rate float64, burst int) {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.rate = rate
+	l.burst = float64(burst)
+}
+
+// take consumes a token of key, if there is none it returns false and the
+// time to wait until the next one is available
+func (l *rateLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.rate <= 0 {
+		return true, 0
+	}
+	if now.Sub(l.swept) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops the buckets refilled up to the burst, they are the same as
+// the new one a caller coming back gets. The callers are client
+// addresses, without it a scan of many of them would grow the map forever.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// caller is the bucket of r, the address of its client as the access
+// list tells it since every caller presents the same api key
+func caller(acl *accessList, r *http.Request) string {
+	if ip := acl.clientIP(r); ip != nil {
+		return ip.String()
+	}
+	// the peers of the unix socket share one
+	return "unix"
+}
+
+func (l *rateLimiter) check(key string, w http.ResponseWriter) bool {
+	ok, wait := l.take(key, time.Now())
+	if ok {
+		return false
+	}
+
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	res := &Response{
+		w:      w,
+		Data:   "Too Many Requests",
+		Status: http.StatusTooManyRequests,
+	}
+	_, _ = res.SendJson()
+	return true
+}
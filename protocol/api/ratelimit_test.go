@@ -0,0 +1,60 @@
+package api
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	at := assert.New(t)
+	l := newRateLimiter(2, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.take("key", now)
+		at.True(ok)
+	}
+	ok, wait := l.take("key", now)
+	at.False(ok)
+	at.Equal(500*time.Millisecond, wait)
+
+	ok, _ = l.take("other", now)
+	at.True(ok)
+
+	ok, _ = l.take("key", now.Add(500*time.Millisecond))
+	at.True(ok)
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	at := assert.New(t)
+	l := newRateLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		ok, _ := l.take("key", time.Now())
+		at.True(ok)
+	}
+}
+
+func TestRateLimiterForgetsIdleCallers(t *testing.T) {
+	at := assert.New(t)
+	l := newRateLimiter(0.01, 3)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		l.take("drained", now)
+	}
+	for i := 0; i < 100; i++ {
+		l.take(strconv.Itoa(i), now)
+	}
+	at.Len(l.buckets, 101)
+
+	// the callers back to a full bucket are dropped, not the drained one
+	ok, _ := l.take("new", now.Add(2*time.Minute))
+	at.True(ok)
+	at.Len(l.buckets, 2)
+	ok, _ = l.take("drained", now.Add(2*time.Minute))
+	at.True(ok)
+	ok, _ = l.take("drained", now.Add(2*time.Minute))
+	at.False(ok)
+}
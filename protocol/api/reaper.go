@@ -0,0 +1,69 @@
+package api
+
+import (
+	"strings"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultReapInterval = time.Minute
+	defaultReapGrace    = 5 * time.Minute
+)
+
+// reapInterval is relay.reap_interval, read before each sweep so it
+// follows reloads
+func reapInterval() time.Duration {
+	if s := configure.Config.GetFloat64("relay.reap_interval"); s > 0 {
+		return time.Duration(s * float64(time.Second))
+	}
+	return defaultReapInterval
+}
+
+// reapGrace is relay.reap_grace, how long a stopped relay stays listed
+func reapGrace() time.Duration {
+	if s := configure.Config.GetFloat64("relay.reap_grace"); s > 0 {
+		return time.Duration(s * float64(time.Second))
+	}
+	return defaultReapGrace
+}
+
+// reapSessions sweeps the session every reapInterval
+func (server *Server) reapSessions() {
+	for {
+		<-time.After(reapInterval())
+		server.reapStopped(time.Now(), reapGrace())
+	}
+}
+
+// reapStopped removes the relays of /control/push and /control/pull that
+// stopped by themselves, their source gone or their target refusing them,
+// at least grace before now. The static relays are restarted instead.
+func (server *Server) reapStopped(now time.Time, grace time.Duration) (reaped []string) {
+	server.sessionLock.Lock()
+	defer server.sessionLock.Unlock()
+	for key, r := range server.session {
+		if strings.HasPrefix(key, staticKeyPrefix) || r.IsStart() {
+			continue
+		}
+		stats := r.Stats()
+		if stats.Stopped.IsZero() || now.Sub(stats.Stopped) < grace {
+			continue
+		}
+		delete(server.session, key)
+		reaped = append(reaped, key)
+		reason := key + " stopped " + now.Sub(stats.Stopped).Round(time.Second).String() + " ago"
+		if stats.LastError != "" {
+			reason += ": " + stats.LastError
+		}
+		log.Infof("relay %s reaped", key)
+		if r.Key != "" {
+			events.Emit(r.Key, events.RelayReap, reason)
+		}
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+	"github.com/SpooderfyBot/live/protocol/rtmp/rtmprelay"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReapStopped(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("rtmp_noauth", true)
+	defer configure.Config.Set("rtmp_noauth", false)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer l.Close()
+	go rtmp.NewRtmpServer(rtmp.NewRtmpStream(), nil).Serve(l)
+
+	// the source ends after a few frames and the pull fails with it
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(flvBody(0, 5))
+	}))
+	defer source.Close()
+
+	server := &Server{session: make(map[string]*rtmprelay.RtmpRelay), rtmpAddr: l.Addr().String()}
+	begin := time.Now()
+	w := httptest.NewRecorder()
+	server.handlePull(w, httptest.NewRequest("GET", "/control/pull?oper=start&app=live&name=reaped"+
+		"&url="+neturl.QueryEscape(source.URL+"/live/a.flv"), nil))
+	r := server.sessions()["pull:live/reaped"]
+	if !at.NotNil(r) {
+		return
+	}
+	defer r.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for r.IsStart() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	at.False(r.IsStart())
+	stopped := r.Stats().Stopped
+	at.False(stopped.IsZero())
+
+	// kept within the grace, removed after it
+	grace := 300 * time.Millisecond
+	at.Empty(server.reapStopped(stopped.Add(100*time.Millisecond), grace))
+	at.NotNil(server.sessions()["pull:live/reaped"])
+	at.Equal([]string{"pull:live/reaped"}, server.reapStopped(stopped.Add(grace), grace))
+	at.Nil(server.sessions()["pull:live/reaped"])
+
+	reaps := 0
+	for _, e := range events.Get("live/reaped") {
+		if e.Type == events.RelayReap && !e.Time.Before(begin) {
+			reaps++
+		}
+	}
+	at.Equal(1, reaps)
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+	"github.com/SpooderfyBot/live/protocol/rtmp/rtmprelay"
+	"github.com/SpooderfyBot/live/utils/worker"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// staticKeyPrefix marks session entries created from the config,
+	// these are never touched by /control/push or /control/pull
+	staticKeyPrefix = "static:"
+
+	staticRelayCheckInterval = 5 * time.Second
+
+	// groupSep separates the target index from the key of a push group,
+	// push:APP/NAME#0 and push:APP/NAME#1 are both in push:APP/NAME
+	groupSep = "#"
+
+	// source_type of the publishers in the stats
+	sourceDirect    = "direct"
+	sourceRelayPull = "relay_pull"
+
+	// maxPushTargetsBody bounds the json body of a fan-out push
+	maxPushTargetsBody = 64 << 10
+)
+
+type relay struct {
+	Key        string `json:"key"`
+	Group      string `json:"group,omitempty"`
+	PlayUrl    string `json:"play_url"`
+	PublishUrl string `json:"publish_url"`
+	Running    bool   `json:"running"`
+}
+
+var (
+	pushSchemes = []string{"rtmp", "rtmps"}
+	// a pull also reads http-flv and hls outputs
+	pullSchemes = []string{"rtmp", "rtmps", "http", "https"}
+)
+
+// pushTarget is the outcome of one target of a fan-out push
+type pushTarget struct {
+	Key     string `json:"key"`
+	Url     string `json:"url"`
+	Running bool   `json:"running"`
+	Error   string `json:"error,omitempty"`
+}
+
+// pushTargets returns the urls of a push, the repeated url form values
+// or the targets of a json body
+func pushTargets(req *http.Request) ([]string, error) {
+	urls := req.Form["url"]
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		return urls, nil
+	}
+
+	var body struct {
+		Targets []string `json:"targets"`
+	}
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxPushTargetsBody)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid json body: %v", err)
+	}
+	return append(urls, body.Targets...), nil
+}
+
+func groupSessionKey(group string, i int) string {
+	return group + groupSep + strconv.Itoa(i)
+}
+
+// sessionGroup is the push group of a session key, empty when
+// the key is a single relay
+func sessionGroup(key string) string {
+	if i := strings.LastIndex(key, groupSep); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// takeSessionGroup removes and returns the relay of key and
+// every relay of the group key, by their session keys
+func (server *Server) takeSessionGroup(key string) map[string]*rtmprelay.RtmpRelay {
+	server.sessionLock.Lock()
+	defer server.sessionLock.Unlock()
+	ret := map[string]*rtmprelay.RtmpRelay{}
+	for k, r := range server.session {
+		if k == key || sessionGroup(k) == key {
+			ret[k] = r
+			delete(server.session, k)
+		}
+	}
+	return ret
+}
+
+// checkRelayURL parses a relay url given to the api, it must be a url of
+// one of schemes with a host, on relay.allowed_hosts when that is set.
+// The returned url is the normalized one to hand to the relay.
+func checkRelayURL(raw string, schemes []string) (string, error) {
+	u, err := neturl.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %v", err)
+	}
+	known := false
+	for _, scheme := range schemes {
+		known = known || u.Scheme == scheme
+	}
+	if !known {
+		return "", fmt.Errorf("invalid url scheme=%q, want %s", u.Scheme, strings.Join(schemes, " or "))
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("invalid url %q, missing host", raw)
+	}
+
+	allowed := configure.Config.GetStringSlice("relay.allowed_hosts")
+	if len(allowed) == 0 {
+		return u.String(), nil
+	}
+	for _, host := range allowed {
+		if strings.EqualFold(host, u.Hostname()) || strings.EqualFold(host, u.Host) {
+			return u.String(), nil
+		}
+	}
+	return "", fmt.Errorf("host=%s is not an allowed relay host", u.Host)
+}
+
+// checkRelayURLs is checkRelayURL of each of raws
+func checkRelayURLs(raws []string, schemes []string) ([]string, error) {
+	ret := make([]string, 0, len(raws))
+	for _, raw := range raws {
+		u, err := checkRelayURL(raw, schemes)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, u)
+	}
+	return ret, nil
+}
+
+// startStaticRelays registers the static_push and static_pull relays of the
+// config in the session and keeps them running.
+func (server *Server) startStaticRelays() {
+	pushList := configure.StaticRelays{}
+	configure.Config.UnmarshalKey("static_push", &pushList)
+	pullList := configure.StaticRelays{}
+	configure.Config.UnmarshalKey("static_pull", &pullList)
+
+	var relays []*rtmprelay.RtmpRelay
+	for _, item := range pushList {
+		localurl := server.localUrl(item.App, item.Name)
+		remoteurl := item.URL
+		r := rtmprelay.NewRtmpRelay(&localurl, &remoteurl)
+		r.Key = item.App + "/" + item.Name
+		server.putSession(staticKeyPrefix+"push:"+r.Key, r)
+		relays = append(relays, r)
+	}
+	for _, item := range pullList {
+		localurl := server.localUrl(item.App, item.Name)
+		remoteurl := item.URL
+		r := rtmprelay.NewRtmpRelay(&remoteurl, &localurl)
+		r.Key = item.App + "/" + item.Name
+		server.putSession(staticKeyPrefix+"pull:"+r.Key, r)
+		relays = append(relays, r)
+	}
+
+	if len(relays) == 0 {
+		return
+	}
+
+	log.Infof("Starting %d static relays", len(relays))
+	go server.keepStaticRelays(relays)
+}
+
+// keepStaticRelays starts the relays that are not running every
+// staticRelayCheckInterval, the starts run on the shared worker pool so a
+// relay slow to connect doesn't hold back the others
+func (server *Server) keepStaticRelays(relays []*rtmprelay.RtmpRelay) {
+	pending := make([]int32, len(relays))
+	first := true
+	for {
+		for i, r := range relays {
+			if r.IsStart() || !atomic.CompareAndSwapInt32(&pending[i], 0, 1) {
+				continue
+			}
+			if !first {
+				events.Emit(r.Key, events.RelayRetry, r.PlayUrl+" -> "+r.PublishUrl)
+			}
+			r, done := r, &pending[i]
+			job := func() {
+				defer atomic.StoreInt32(done, 0)
+				if err := r.Start(); err != nil {
+					log.Debugf("static relay %s -> %s start error: %v", r.PlayUrl, r.PublishUrl, err)
+				}
+			}
+			if !worker.Shared().Submit(r.Key, job) {
+				atomic.StoreInt32(done, 0)
+				log.Warningf("static relay %s: worker queue full, retrying later", r.Key)
+			}
+		}
+		first = false
+		<-time.After(staticRelayCheckInterval)
+	}
+}
+
+// sourceType tells a stream published by a client from one fed by a pull
+// relay of the session
+func (server *Server) sourceType(key string) string {
+	server.sessionLock.RLock()
+	defer server.sessionLock.RUnlock()
+	if _, ok := server.session["pull:"+key]; ok {
+		return sourceRelayPull
+	}
+	if _, ok := server.session[staticKeyPrefix+"pull:"+key]; ok {
+		return sourceRelayPull
+	}
+	return sourceDirect
+}
+
+// startRelay starts a relay of the stream streamKey from playUrl to
+// publishUrl and stores it under key once it started
+func (server *Server) startRelay(ctx context.Context, key, streamKey, playUrl, publishUrl string, mirrors []string) (*rtmprelay.RtmpRelay, error) {
+	r := rtmprelay.NewRtmpRelay(&playUrl, &publishUrl)
+	r.Mirrors = mirrors
+	r.Key = streamKey
+	if err := r.StartContext(ctx); err != nil {
+		return nil, err
+	}
+	server.putSession(key, r)
+	return r, nil
+}
+
+// startPushTargets pushes localurl to each of remoteurls, a single target
+// is stored under key and several under the group key
+func (server *Server) startPushTargets(ctx context.Context, key, streamKey, localurl string, remoteurls []string) []pushTarget {
+	targets := make([]pushTarget, len(remoteurls))
+	for i := range remoteurls {
+		targets[i] = pushTarget{Key: key, Url: remoteurls[i]}
+		if len(remoteurls) > 1 {
+			targets[i].Key = groupSessionKey(key, i)
+		}
+		if _, err := server.startRelay(ctx, targets[i].Key, streamKey, localurl, remoteurls[i], nil); err != nil {
+			targets[i].Error = err.Error()
+			continue
+		}
+		targets[i].Running = true
+	}
+	return targets
+}
+
+// putSession stores r under key, a relay it replaces is stopped
+// so two starts of the same key don't leak one of them
+func (server *Server) putSession(key string, r *rtmprelay.RtmpRelay) {
+	server.sessionLock.Lock()
+	old, found := server.session[key]
+	server.session[key] = r
+	server.sessionLock.Unlock()
+	if found && old != r {
+		old.Stop()
+	}
+}
+
+// takeSession removes and returns the relay of key
+func (server *Server) takeSession(key string) (*rtmprelay.RtmpRelay, bool) {
+	server.sessionLock.Lock()
+	defer server.sessionLock.Unlock()
+	r, found := server.session[key]
+	if found {
+		delete(server.session, key)
+	}
+	return r, found
+}
+
+// sessions returns a snapshot of the relays, safe to range over
+func (server *Server) sessions() map[string]*rtmprelay.RtmpRelay {
+	server.sessionLock.RLock()
+	defer server.sessionLock.RUnlock()
+	ret := make(map[string]*rtmprelay.RtmpRelay, len(server.session))
+	for key, r := range server.session {
+		ret[key] = r
+	}
+	return ret
+}
+
+func (server *Server) localUrl(app, name string) string {
+	return "rtmp://" + localRtmpHost(server.rtmpAddr) + "/" + app + "/" + name
+}
+
+// localRtmpHost returns the host:port to dial to reach our own RTMP
+// listener bound on addr, a wildcard bind is reached through loopback
+func localRtmpHost(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// no port, the client uses the default one
+		return addr
+	}
+
+	switch host {
+	case "", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,330 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+	"github.com/SpooderfyBot/live/protocol/rtmp/rtmprelay"
+	"github.com/SpooderfyBot/live/utils/pio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalRtmpHost(t *testing.T) {
+	at := assert.New(t)
+
+	at.Equal("127.0.0.1:1935", localRtmpHost(":1935"))
+	at.Equal("127.0.0.1:1935", localRtmpHost("0.0.0.0:1935"))
+	at.Equal("10.1.2.3:1936", localRtmpHost("10.1.2.3:1936"))
+	at.Equal("[::1]:1935", localRtmpHost("[::]:1935"))
+	at.Equal("[2001:db8::5]:1935", localRtmpHost("[2001:db8::5]:1935"))
+	at.Equal("media.local:1935", localRtmpHost("media.local:1935"))
+}
+
+func TestLocalUrl(t *testing.T) {
+	at := assert.New(t)
+
+	server := &Server{rtmpAddr: "[2001:db8::5]:1935"}
+	at.Equal("rtmp://[2001:db8::5]:1935/live/movie", server.localUrl("live", "movie"))
+
+	server = &Server{rtmpAddr: ":1935"}
+	at.Equal("rtmp://127.0.0.1:1935/live/movie", server.localUrl("live", "movie"))
+}
+
+func TestCheckRelayURL(t *testing.T) {
+	at := assert.New(t)
+
+	for _, raw := range []string{"", "http://example.com/live/a", "rtmp:///live/a", "rtmp://%zz/live", "example.com/live/a"} {
+		_, err := checkRelayURL(raw, pushSchemes)
+		at.NotNil(err, raw)
+	}
+
+	u, err := checkRelayURL("https://cdn.example.com/live/a.m3u8", pullSchemes)
+	at.Nil(err)
+	at.Equal("https://cdn.example.com/live/a.m3u8", u)
+
+	u, err = checkRelayURL(" rtmps://backup.example.com:443/live/a ", pushSchemes)
+	at.Nil(err)
+	at.Equal("rtmps://backup.example.com:443/live/a", u)
+
+	configure.Config.Set("relay.allowed_hosts", []string{"backup.example.com"})
+	defer configure.Config.Set("relay.allowed_hosts", nil)
+
+	_, err = checkRelayURL("rtmp://BACKUP.example.com/live/a", pushSchemes)
+	at.Nil(err)
+	_, err = checkRelayURL("http://169.254.169.254/live/a.flv", pullSchemes)
+	at.NotNil(err)
+}
+
+func TestHandlePushBadURL(t *testing.T) {
+	at := assert.New(t)
+
+	server := &Server{session: make(map[string]*rtmprelay.RtmpRelay), rtmpAddr: ":1935"}
+	for _, raw := range []string{"http://example.com/live/a", "rtmp://%zz/live"} {
+		w := httptest.NewRecorder()
+		server.handlePush(w, httptest.NewRequest("GET", "/control/push?oper=start&app=live&name=a&url="+neturl.QueryEscape(raw), nil))
+		at.Equal(400, w.Code, raw)
+		at.Contains(w.Body.String(), "invalid url")
+	}
+	at.Empty(server.sessions())
+}
+
+func TestPushTargets(t *testing.T) {
+	at := assert.New(t)
+
+	req := httptest.NewRequest("POST", "/control/push?url=rtmp://a/live/x&url=rtmp://b/live/x", nil)
+	at.Nil(req.ParseForm())
+	urls, err := pushTargets(req)
+	at.Nil(err)
+	at.Equal([]string{"rtmp://a/live/x", "rtmp://b/live/x"}, urls)
+
+	req = httptest.NewRequest("POST", "/control/push", strings.NewReader(`{"targets":["rtmp://a/live/x","rtmps://b/live/x"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	at.Nil(req.ParseForm())
+	urls, err = pushTargets(req)
+	at.Nil(err)
+	at.Equal([]string{"rtmp://a/live/x", "rtmps://b/live/x"}, urls)
+
+	req = httptest.NewRequest("POST", "/control/push", strings.NewReader(`{"targets":`))
+	req.Header.Set("Content-Type", "application/json")
+	at.Nil(req.ParseForm())
+	_, err = pushTargets(req)
+	at.NotNil(err)
+}
+
+func TestHandlePushGroup(t *testing.T) {
+	at := assert.New(t)
+
+	// nothing listens there, every relay fails to start right away
+	server := &Server{session: make(map[string]*rtmprelay.RtmpRelay), rtmpAddr: "127.0.0.1:1"}
+	w := httptest.NewRecorder()
+	server.handlePush(w, httptest.NewRequest("GET", "/control/push?oper=start&app=live&name=a&url=rtmp://127.0.0.1:1/live/a&url=rtmp://127.0.0.1:1/live/b", nil))
+	at.Equal(200, w.Code)
+	var res struct {
+		Data []pushTarget `json:"data"`
+	}
+	at.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+	if at.Equal(2, len(res.Data)) {
+		at.Equal("push:live/a#0", res.Data[0].Key)
+		at.Equal("rtmp://127.0.0.1:1/live/b", res.Data[1].Url)
+		at.False(res.Data[1].Running)
+		at.NotEmpty(res.Data[1].Error)
+	}
+	at.Empty(server.sessions())
+
+	play, publish := "rtmp://127.0.0.1/live/a", "rtmp://127.0.0.1/live/b"
+	server.putSession("push:live/a#0", rtmprelay.NewRtmpRelay(&play, &publish))
+	server.putSession("push:live/a#1", rtmprelay.NewRtmpRelay(&play, &publish))
+	server.putSession("push:live/ab", rtmprelay.NewRtmpRelay(&play, &publish))
+	at.Equal("push:live/a", sessionGroup("push:live/a#1"))
+	at.Equal("", sessionGroup("push:live/ab"))
+
+	w = httptest.NewRecorder()
+	server.handlePush(w, httptest.NewRequest("GET", "/control/push?oper=stop&app=live&name=a", nil))
+	at.Contains(w.Body.String(), "stop")
+	sessions := server.sessions()
+	at.Equal(1, len(sessions))
+	_, found := sessions["push:live/ab"]
+	at.True(found)
+}
+
+func TestHandlePullTimeout(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("relay.start_timeout", 1)
+	defer configure.Config.Set("relay.start_timeout", 0)
+
+	// accepts the connection and never completes the rtmp handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer listener.Close()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	server := &Server{session: make(map[string]*rtmprelay.RtmpRelay), rtmpAddr: ":1935"}
+	w := httptest.NewRecorder()
+	url := "rtmp://" + listener.Addr().String() + "/live/silent"
+	server.handlePull(w, httptest.NewRequest("GET", "/control/pull?oper=start&app=live&name=silent&url="+neturl.QueryEscape(url), nil))
+	at.Equal(504, w.Code)
+	at.Empty(server.sessions())
+}
+
+func TestGetRelay(t *testing.T) {
+	at := assert.New(t)
+	server := &Server{session: make(map[string]*rtmprelay.RtmpRelay), rtmpAddr: ":1935"}
+	get := func(url string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		server.GetRelay(w, httptest.NewRequest("GET", url, nil))
+		var res struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res.Data
+	}
+
+	code, _ := get("/stats/relay")
+	at.Equal(400, code)
+	code, _ = get("/stats/relay?key=push:live/nope")
+	at.Equal(404, code)
+
+	// nothing listens there, the relay keeps why it didn't start
+	play, publish := "rtmp://127.0.0.1:1/live/a", "rtmp://127.0.0.1:1/live/b"
+	r := rtmprelay.NewRtmpRelay(&play, &publish)
+	at.NotNil(r.Start())
+	server.putSession("push:live/a#0", r)
+
+	code, msg := get("/stats/relay?key=" + neturl.QueryEscape("push:live/a#0"))
+	at.Equal(200, code)
+	at.Equal("push:live/a", msg["group"])
+	at.Equal(false, msg["running"])
+	at.Equal(float64(0), msg["bytes_relayed"])
+	at.NotEmpty(msg["last_error"])
+	at.NotContains(msg, "started_at")
+}
+
+func TestRelayDryRun(t *testing.T) {
+	at := assert.New(t)
+	server := &Server{session: make(map[string]*rtmprelay.RtmpRelay), rtmpAddr: "127.0.0.1:1"}
+	get := func(handle func(http.ResponseWriter, *http.Request), url string) (int, dryRun) {
+		w := httptest.NewRecorder()
+		handle(w, httptest.NewRequest("GET", url, nil))
+		var res struct {
+			Data dryRun `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res.Data
+	}
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	closed.Close()
+	code, res := get(server.handlePush, "/control/push?oper=start&app=live&name=a&dry_run=true&url=rtmp://"+closed.Addr().String()+"/live/a")
+	at.Equal(200, code)
+	at.True(res.DryRun)
+	at.Equal("push:live/a", res.Key)
+	at.Equal(dryRunStart, res.Action)
+	if at.Equal(1, len(res.Targets)) {
+		at.False(res.Targets[0].Reachable)
+		at.NotEmpty(res.Targets[0].Error)
+	}
+	at.Empty(server.sessions())
+
+	code, _ = get(server.handlePush, "/control/push?oper=start&app=live&name=a&dry_run=true&url=http://example.com/live/a")
+	at.Equal(400, code)
+
+	play, publish := "rtmp://127.0.0.1/live/a", "rtmp://127.0.0.1/live/b"
+	server.putSession("pull:live/b", rtmprelay.NewRtmpRelay(&play, &publish))
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer source.Close()
+	_, res = get(server.handlePull, "/control/pull?oper=start&app=live&name=b&dry_run=true&url="+neturl.QueryEscape(source.URL+"/live/b.flv"))
+	at.Equal(dryRunReplace, res.Action)
+	if at.Equal(1, len(res.Targets)) {
+		at.True(res.Targets[0].Reachable)
+	}
+
+	_, res = get(server.handlePull, "/control/pull?oper=stop&app=live&name=b&url=x&dry_run=true")
+	at.Equal(dryRunStop, res.Action)
+	at.Empty(res.Targets)
+	at.Equal(1, len(server.sessions()))
+}
+
+// flvBody is an http-flv stream of an avc sequence header and key frames
+// from ts on
+func flvBody(ts uint32, frames int) []byte {
+	tag := func(timestamp uint32, data []byte) []byte {
+		b := []byte{av.TAG_VIDEO, 0, 0, 0, 0, 0, 0, byte(timestamp >> 24), 0, 0, 0}
+		pio.PutU24BE(b[1:4], uint32(len(data)))
+		pio.PutU24BE(b[4:7], timestamp&0xffffff)
+		b = append(b, data...)
+		return append(b, 0, 0, 0, 0)
+	}
+	body := []byte{'F', 'L', 'V', 0x01, 0x01, 0, 0, 0, 9, 0, 0, 0, 0}
+	body = append(body, tag(ts, flv.NewAVCSeqHeader([]byte{0x67, 0x42, 0x00, 0x1e}, []byte{0x68, 0xce}))...)
+	for i := 0; i < frames; i++ {
+		body = append(body, tag(ts+uint32(i*40), flv.NewAVCNALU([][]byte{{0x65, 0x88}}, true, 0))...)
+	}
+	return body
+}
+
+func TestPullFailover(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("rtmp_noauth", true)
+	defer configure.Config.Set("rtmp_noauth", false)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer l.Close()
+	rtmpStream := rtmp.NewRtmpStream()
+	go rtmp.NewRtmpServer(rtmpStream, nil).Serve(l)
+
+	// the primary ends after a few frames, the secondary keeps going
+	done := make(chan struct{})
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(flvBody(0, 5))
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(flvBody(90000, 5))
+		w.(http.Flusher).Flush()
+		<-done
+	}))
+	defer secondary.Close()
+	defer close(done)
+
+	server := &Server{session: make(map[string]*rtmprelay.RtmpRelay), rtmpAddr: l.Addr().String()}
+	begin := time.Now()
+	w := httptest.NewRecorder()
+	server.handlePull(w, httptest.NewRequest("GET", "/control/pull?oper=start&app=live&name=mirrored"+
+		"&url="+neturl.QueryEscape(primary.URL+"/live/a.flv")+"&url="+neturl.QueryEscape(secondary.URL+"/live/b.flv"), nil))
+	at.Contains(w.Body.String(), "start "+primary.URL+"/live/a.flv ok")
+	r := server.sessions()["pull:live/mirrored"]
+	if !at.NotNil(r) {
+		return
+	}
+	defer r.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.ActiveSource() != secondary.URL+"/live/b.flv" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	at.Equal(secondary.URL+"/live/b.flv", r.ActiveSource())
+	at.True(r.IsStart())
+	at.Equal(sourceRelayPull, server.sourceType("live/mirrored"))
+
+	w = httptest.NewRecorder()
+	server.GetRelay(w, httptest.NewRequest("GET", "/stats/relay?key="+neturl.QueryEscape("pull:live/mirrored"), nil))
+	var res struct {
+		Data relayStat `json:"data"`
+	}
+	at.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+	at.True(res.Data.Running)
+	at.Equal(secondary.URL+"/live/b.flv", res.Data.ActiveSource)
+	at.Equal([]string{secondary.URL + "/live/b.flv"}, res.Data.Mirrors)
+
+	failovers := 0
+	for _, e := range events.Get("live/mirrored") {
+		if e.Type == events.RelayFailover && !e.Time.Before(begin) {
+			failovers++
+		}
+	}
+	at.Equal(1, failovers)
+	// the local stream was published once, by the relay
+	_, published := rtmpStream.GetStreams().Load("live/mirrored")
+	at.True(published)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp/rtmprelay"
+)
+
+// relayStat is a relay of /stats/livestats with its counters
+type relayStat struct {
+	relay
+	BytesRelayed uint64     `json:"bytes_relayed"`
+	BitrateKbps  uint64     `json:"bitrate_kbps"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	UptimeMs     int64      `json:"uptime_ms"`
+	LastError    string     `json:"last_error,omitempty"`
+	Resyncs      uint64     `json:"resyncs"` // corrupt input of the source skipped
+	// the play url read and the ones a pull fails over to
+	ActiveSource string   `json:"active_source"`
+	Mirrors      []string `json:"mirrors,omitempty"`
+}
+
+// http://127.0.0.1:8090/stats/relay?key=push:live/123
+func (server *Server) GetRelay(w http.ResponseWriter, req *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if req.ParseForm() != nil || req.Form.Get("key") == "" {
+		res.Status = 400
+		res.Data = "url: /stats/relay?key=<RELAY_KEY>"
+		return
+	}
+
+	key := req.Form.Get("key")
+	server.sessionLock.RLock()
+	r, ok := server.session[key]
+	server.sessionLock.RUnlock()
+	if !ok {
+		res.Status = 404
+		res.Data = "No relay was found"
+		return
+	}
+
+	res.Data = relayStatOf(key, r)
+}
+
+// relayStatOf is the relayStat of r stored under key
+func relayStatOf(key string, r *rtmprelay.RtmpRelay) relayStat {
+	stats := r.Stats()
+	msg := relayStat{
+		relay: relay{
+			Key:        key,
+			Group:      sessionGroup(key),
+			PlayUrl:    r.PlayUrl,
+			PublishUrl: r.PublishUrl,
+			Running:    stats.Running,
+		},
+		BytesRelayed: stats.Bytes,
+		BitrateKbps:  stats.BitrateKbps,
+		UptimeMs:     int64(stats.Uptime / time.Millisecond),
+		LastError:    stats.LastError,
+		Resyncs:      stats.Resyncs,
+		ActiveSource: r.ActiveSource(),
+		Mirrors:      r.Mirrors,
+	}
+	if !stats.Started.IsZero() {
+		msg.StartedAt = &stats.Started
+	}
+	return msg
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/utils/reqid"
+	log "github.com/sirupsen/logrus"
+)
+
+// http://127.0.0.1:8090/control/reload[?level=debug], reads the config file
+// again like a SIGHUP and reports the settings applied and the ones left
+// until restart. level overrides the log level until the next reload.
+func (server *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		res.Status = 405
+		res.Data = "the reload must be POSTed"
+		return
+	}
+
+	level := r.FormValue("level")
+	if level != "" {
+		if _, err := log.ParseLevel(level); err != nil {
+			res.Status = 400
+			res.Data = err.Error()
+			return
+		}
+	}
+
+	result, err := configure.ReloadChanges(level)
+	if err != nil {
+		reqid.Logger(r.Context()).Error("control reload: ", err)
+		res.Status = 500
+		res.Data = err.Error()
+		return
+	}
+	res.Data = result
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadRateLimit(t *testing.T) {
+	at := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "livego-api-reload")
+	at.Nil(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "livego.yaml")
+	at.Nil(ioutil.WriteFile(file, []byte("rate_limit:\n  control_rate: 1\n  control_burst: 2\n"+
+		"redis_pwd: hunter2\n"), 0644))
+
+	oldFile := configure.Config.GetString("config_file")
+	configure.Config.Set("config_file", file)
+	defer func() {
+		// Set would shadow what later reloads merge, the limits are reset
+		// the way they were raised
+		ioutil.WriteFile(file, []byte("rate_limit:\n  control_rate: 0\n  control_burst: 0\n"), 0644)
+		configure.Reload()
+		configure.Config.Set("config_file", oldFile)
+	}()
+
+	server := &Server{handler: rtmp.NewRtmpStream()}
+	h := server.Handler("secret")
+	from := func(addr, method, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, nil)
+		r.RemoteAddr = addr
+		r.Header.Set("Authorization", "secret")
+		h.ServeHTTP(w, r)
+		return w
+	}
+	do := func(method, url string) *httptest.ResponseRecorder {
+		return from("192.0.2.1:1234", method, url)
+	}
+
+	at.Equal(405, do("GET", "/control/reload").Code)
+	for i := 0; i < 5; i++ {
+		at.Equal(200, do("GET", "/control/rooms").Code)
+	}
+
+	w := do("POST", "/control/reload")
+	at.Equal(200, w.Code)
+	var res struct {
+		Data configure.ReloadResult `json:"data"`
+	}
+	at.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+	changed := map[string]configure.ConfigChange{}
+	for _, c := range res.Data.Changed {
+		changed[c.Key] = c
+	}
+	at.Equal(float64(1), changed["rate_limit.control_rate"].New)
+	at.Equal(float64(2), changed["rate_limit.control_burst"].New)
+	if at.Len(res.Data.Deferred, 1) {
+		at.Equal("redis_pwd", res.Data.Deferred[0].Key)
+		at.Equal("<redacted>", res.Data.Deferred[0].New)
+	}
+	at.NotContains(w.Body.String(), "hunter2")
+
+	// the new burst applies right away
+	at.Equal(200, do("GET", "/control/rooms").Code)
+	at.Equal(200, do("GET", "/control/rooms").Code)
+	at.Equal(429, do("GET", "/control/rooms").Code)
+	// each client has its own, whatever key they share
+	at.Equal(200, from("192.0.2.2:1234", "GET", "/control/rooms").Code)
+	at.Equal(429, from("192.0.2.1:4321", "GET", "/control/rooms").Code)
+}
+
+func TestReloadLevel(t *testing.T) {
+	at := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "livego-api-reload")
+	at.Nil(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "livego.yaml")
+	at.Nil(ioutil.WriteFile(file, []byte("level: info\n"), 0644))
+
+	oldFile, oldLevel := configure.Config.GetString("config_file"), log.GetLevel()
+	configure.Config.Set("config_file", file)
+	defer func() {
+		configure.Config.Set("config_file", oldFile)
+		log.SetLevel(oldLevel)
+	}()
+
+	server := &Server{handler: rtmp.NewRtmpStream()}
+	reload := func(url string) (int, configure.ReloadResult) {
+		w := httptest.NewRecorder()
+		server.handleReload(w, httptest.NewRequest("POST", url, nil))
+		var res struct {
+			Data configure.ReloadResult `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res.Data
+	}
+
+	code, _ := reload("/control/reload?level=chatty")
+	at.Equal(400, code)
+
+	// the level holds until the next reload, which goes back to the file
+	code, res := reload("/control/reload?level=debug")
+	at.Equal(200, code)
+	at.Equal("debug", res.Level)
+	at.Equal(log.DebugLevel, log.GetLevel())
+
+	_, res = reload("/control/reload")
+	at.Equal("info", res.Level)
+	at.Equal(log.InfoLevel, log.GetLevel())
+}
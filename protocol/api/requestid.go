@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/utils/reqid"
+)
+
+// RequestIDMiddleware tags each request with the X-Request-ID it came
+// with, or a new one, with api.trace_requests. The id is echoed back and
+// is a field of the lines logged for the request, relays it starts
+// included. An id unfit for the logs is replaced.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !configure.Config.GetBool("api.trace_requests") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id := r.Header.Get(reqid.Header)
+		if !reqid.Valid(id) {
+			id = reqid.New()
+		}
+		w.Header().Set(reqid.Header, id)
+		next.ServeHTTP(w, r.WithContext(reqid.NewContext(r.Context(), id)))
+	})
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net"
+	"net/http/httptest"
+	neturl "net/url"
+	"testing"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/rtmp/rtmprelay"
+	"github.com/SpooderfyBot/live/utils/reqid"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	at := assert.New(t)
+	hook := test.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	level := log.GetLevel()
+	log.SetLevel(log.DebugLevel)
+	defer log.SetLevel(level)
+
+	// nothing listens there, the relay fails to connect
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	url := "rtmp://" + listener.Addr().String() + "/live/gone"
+	listener.Close()
+
+	server := &Server{
+		handler:  rtmp.NewRtmpStream(),
+		session:  make(map[string]*rtmprelay.RtmpRelay),
+		rtmpAddr: ":1935",
+	}
+	h := server.Handler("secret")
+	pull := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/control/pull?oper=start&app=live&name=gone&url="+neturl.QueryEscape(url), nil)
+		r.Header.Set("Authorization", "secret")
+		if id != "" {
+			r.Header.Set(reqid.Header, id)
+		}
+		h.ServeHTTP(w, r)
+		return w
+	}
+	logged := func(id string) (lines []string) {
+		for _, e := range hook.AllEntries() {
+			if e.Data[reqid.Field] == id {
+				lines = append(lines, e.Message)
+			}
+		}
+		return
+	}
+
+	// off by default
+	w := pull("bot-42")
+	at.Equal(400, w.Code)
+	at.Empty(w.Header().Get(reqid.Header))
+	at.Empty(logged("bot-42"))
+
+	configure.Config.Set("api.trace_requests", true)
+	defer configure.Config.Set("api.trace_requests", false)
+	hook.Reset()
+	w = pull("bot-42")
+	at.Equal(400, w.Code)
+	at.Equal("bot-42", w.Header().Get(reqid.Header))
+	lines := logged("bot-42")
+	at.Contains(lines, "api request")
+	at.Contains(lines, "control pull: oper=start, app=live, name=gone, urls=["+url+"]")
+	// the relay logs its failed connect under the id of the pull
+	at.Contains(lines, "connectPlayClient.Start url="+url+" error")
+
+	// an id is made up when missing or unfit for the logs
+	for _, id := range []string{"", "bot 42\n"} {
+		w = pull(id)
+		got := w.Header().Get(reqid.Header)
+		at.NotEmpty(got)
+		at.NotEqual(id, got)
+		at.Contains(logged(got), "api request")
+	}
+}
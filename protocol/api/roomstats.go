@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+)
+
+// roomStats is a room of /stats/rooms, the publisher and players of
+// /stats/livestats with the same key
+type roomStats struct {
+	Key          string   `json:"key"`
+	Publisher    *stream  `json:"publisher"` // nil while the players wait for one
+	Players      []stream `json:"players"`
+	PlayerCount  int      `json:"player_count"`
+	OutboundKbps uint64   `json:"outbound_kbps"` // of the players
+}
+
+// byRoom pivots the collected streams by key, sorted by key
+func byRoom(msgs *streams) []roomStats {
+	rooms := make(map[string]*roomStats)
+	room := func(key string) *roomStats {
+		r, ok := rooms[key]
+		if !ok {
+			r = &roomStats{Key: key, Players: []stream{}}
+			rooms[key] = r
+		}
+		return r
+	}
+	for i := range msgs.Publishers {
+		room(msgs.Publishers[i].Key).Publisher = &msgs.Publishers[i]
+	}
+	for _, p := range msgs.Players {
+		r := room(p.Key)
+		r.Players = append(r.Players, p)
+		r.PlayerCount++
+		r.OutboundKbps += p.VideoBitrate + p.AudioBitrate
+	}
+
+	ret := make([]roomStats, 0, len(rooms))
+	for _, r := range rooms {
+		ret = append(ret, *r)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Key < ret[j].Key })
+	return ret
+}
+
+// http://127.0.0.1:8090/stats/rooms
+func (server *Server) GetRooms(w http.ResponseWriter, req *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
+		res.Status = 500
+		res.Data = "Get rtmp stream information error"
+		return
+	}
+
+	res.Data = byRoom(server.collectStreams(inspector))
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/rtmp/rtmprelay"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionConcurrent(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("rtmp_noauth", true)
+	defer configure.Config.Set("rtmp_noauth", false)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer l.Close()
+	go rtmp.NewRtmpServer(rtmp.NewRtmpStream(), nil).Serve(l)
+
+	done := make(chan struct{})
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(flvBody(0, 5))
+		w.(http.Flusher).Flush()
+		<-done
+	}))
+	defer source.Close()
+	defer close(done)
+
+	server := &Server{session: make(map[string]*rtmprelay.RtmpRelay), rtmpAddr: l.Addr().String()}
+	h := server.Handler("secret")
+	serve := func(method, url, body string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("authorization", "secret")
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	pull := func(name string) string {
+		return `{"app": "live", "name": "` + name + `", "urls": ["` + source.URL + `/live/a.flv"]}`
+	}
+	stop := func(name string) string {
+		return `{"app": "live", "name": "` + name + `"}`
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		// distinct keys and one shared key are started, stopped and listed at once
+		name := fmt.Sprintf("session%d", i)
+		go func() {
+			defer wg.Done()
+			at.Equal(200, serve("POST", "/api/v2/relays/pull/start", pull(name)))
+			at.Equal(200, serve("POST", "/api/v2/relays/pull/start", pull("shared")))
+		}()
+		go func() {
+			defer wg.Done()
+			code := serve("POST", "/api/v2/relays/pull/stop", stop("shared"))
+			at.True(code == 200 || code == 404, code)
+		}()
+		go func() {
+			defer wg.Done()
+			at.Equal(200, serve("GET", "/api/v2/relays", ""))
+		}()
+	}
+	wg.Wait()
+	defer serve("POST", "/api/v2/relays/pull/stop", stop("shared"))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/api/v2/relays", nil)
+	r.Header.Set("authorization", "secret")
+	h.ServeHTTP(w, r)
+	var res struct {
+		Data []relayStat `json:"data"`
+	}
+	at.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+	listed := map[string]bool{}
+	for _, stat := range res.Data {
+		listed[stat.Key] = true
+	}
+	for i := 0; i < 10; i++ {
+		at.True(listed[fmt.Sprintf("pull:live/session%d", i)], i)
+	}
+
+	at.Equal(200, serve("POST", "/api/v2/relays/pull/stop", stop("session0")))
+	at.Equal(404, serve("POST", "/api/v2/relays/pull/stop", stop("session0")))
+	for i := 1; i < 10; i++ {
+		at.Equal(200, serve("POST", "/api/v2/relays/pull/stop", stop(fmt.Sprintf("session%d", i))))
+	}
+}
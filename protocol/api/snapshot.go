@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp/rtmprelay"
+	"github.com/SpooderfyBot/live/utils/reqid"
+)
+
+// maxSnapshotBody bounds the json body of /control/restore
+const maxSnapshotBody = 1 << 20
+
+// sessionState is a relay of the session, enough to start it again on
+// another process. The local end is rebuilt from app and name on restore
+// as the rtmp address may have changed.
+type sessionState struct {
+	Key       string `json:"key"`
+	Direction string `json:"direction"` // push or pull
+	App       string `json:"app"`
+	Name      string `json:"name"`
+	SourceUrl string `json:"source_url"`
+	TargetUrl string `json:"target_url"`
+	// MirrorUrls are the sources a pull fails over to
+	MirrorUrls []string `json:"mirror_urls,omitempty"`
+}
+
+type snapshot struct {
+	Sessions []sessionState `json:"sessions"`
+}
+
+// restored is the outcome of restoring one session
+type restored struct {
+	Key     string `json:"key"`
+	Running bool   `json:"running"`
+	Existed bool   `json:"existed,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// takeSnapshot dumps the relays started through the api, the static ones
+// come back from the config
+func (server *Server) takeSnapshot() snapshot {
+	ret := snapshot{Sessions: []sessionState{}}
+	for key, r := range server.sessions() {
+		if strings.HasPrefix(key, staticKeyPrefix) {
+			continue
+		}
+		direction := strings.SplitN(key, ":", 2)[0]
+		app, name := r.Key, ""
+		if i := strings.Index(r.Key, "/"); i >= 0 {
+			app, name = r.Key[:i], r.Key[i+1:]
+		}
+		ret.Sessions = append(ret.Sessions, sessionState{
+			Key:        key,
+			Direction:  direction,
+			App:        app,
+			Name:       name,
+			SourceUrl:  r.PlayUrl,
+			TargetUrl:  r.PublishUrl,
+			MirrorUrls: r.Mirrors,
+		})
+	}
+	sort.Slice(ret.Sessions, func(i, j int) bool {
+		return ret.Sessions[i].Key < ret.Sessions[j].Key
+	})
+	return ret
+}
+
+// relayUrls checks a dumped session and returns the play and publish
+// urls to start it with
+func (server *Server) relayUrls(state sessionState) (play, publish string, err error) {
+	if state.App == "" || state.Name == "" {
+		return "", "", fmt.Errorf("missing app or name")
+	}
+	// the key is the session key or one of its group, with a suffix
+	if key := state.Direction + ":" + state.App + "/" + state.Name; state.Key != key && sessionGroup(state.Key) != key {
+		return "", "", fmt.Errorf("key does not match %s:%s/%s", state.Direction, state.App, state.Name)
+	}
+	local := server.localUrl(state.App, state.Name)
+	switch state.Direction {
+	case "push":
+		publish, err = checkRelayURL(state.TargetUrl, pushSchemes)
+		return local, publish, err
+	case "pull":
+		play, err = checkRelayURL(state.SourceUrl, pullSchemes)
+		return play, local, err
+	}
+	return "", "", fmt.Errorf("invalid direction=%q, want push or pull", state.Direction)
+}
+
+// restoreSession starts state unless the same relay is already running
+func (server *Server) restoreSession(req *http.Request, state sessionState) restored {
+	ret := restored{Key: state.Key}
+	play, publish, err := server.relayUrls(state)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	if old, found := server.sessions()[state.Key]; found &&
+		old.PlayUrl == play && old.PublishUrl == publish && old.IsStart() {
+		ret.Running, ret.Existed = true, true
+		return ret
+	}
+
+	var mirrors []string
+	if state.Direction == "pull" {
+		if mirrors, err = checkRelayURLs(state.MirrorUrls, pullSchemes); err != nil {
+			ret.Error = err.Error()
+			return ret
+		}
+	}
+
+	r := rtmprelay.NewRtmpRelay(&play, &publish)
+	r.Mirrors = mirrors
+	r.Key = state.App + "/" + state.Name
+	if err := r.StartContext(req.Context()); err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	server.putSession(state.Key, r)
+	ret.Running = true
+	return ret
+}
+
+// http://127.0.0.1:8090/control/snapshot
+func (server *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	res.Data = server.takeSnapshot()
+}
+
+// http://127.0.0.1:8090/control/restore with the data of /control/snapshot,
+// or its whole response, as the POST body
+func (server *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		res.Status = 405
+		res.Data = "the snapshot must be POSTed"
+		return
+	}
+
+	var body struct {
+		snapshot
+		Data *snapshot `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSnapshotBody)).Decode(&body); err != nil {
+		res.Status = 400
+		res.Data = fmt.Sprintf("invalid json body: %v", err)
+		return
+	}
+	sessions := body.Sessions
+	if body.Data != nil {
+		sessions = body.Data.Sessions
+	}
+
+	// the relays start in parallel, each one is bounded by relay.start_timeout
+	results := make([]restored, len(sessions))
+	var wg sync.WaitGroup
+	for i := range sessions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = server.restoreSession(r, sessions[i])
+		}(i)
+	}
+	wg.Wait()
+	reqid.Logger(r.Context()).Debugf("control restore: %v", results)
+	res.Data = results
+}
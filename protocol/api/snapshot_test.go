@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/rtmp/rtmprelay"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("rtmp_noauth", true)
+	defer configure.Config.Set("rtmp_noauth", false)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer l.Close()
+	go rtmp.NewRtmpServer(rtmp.NewRtmpStream(), nil).Serve(l)
+
+	old := &Server{session: make(map[string]*rtmprelay.RtmpRelay), rtmpAddr: ":1935"}
+	source, local := "rtmp://"+l.Addr().String()+"/live/upstream", old.localUrl("live", "restored")
+	pull := rtmprelay.NewRtmpRelay(&source, &local)
+	pull.Key = "live/restored"
+	old.putSession("pull:live/restored", pull)
+	static := "rtmp://127.0.0.1/live/static"
+	old.putSession(staticKeyPrefix+"push:live/static", rtmprelay.NewRtmpRelay(&static, &static))
+
+	w := httptest.NewRecorder()
+	old.handleSnapshot(w, httptest.NewRequest("GET", "/control/snapshot", nil))
+	at.Equal(200, w.Code)
+	dump := w.Body.String()
+	var res struct {
+		Data snapshot `json:"data"`
+	}
+	at.Nil(json.Unmarshal([]byte(dump), &res))
+	at.Equal([]sessionState{{
+		Key:       "pull:live/restored",
+		Direction: "pull",
+		App:       "live",
+		Name:      "restored",
+		SourceUrl: source,
+		TargetUrl: "rtmp://127.0.0.1:1935/live/restored",
+	}}, res.Data.Sessions)
+
+	// the new process listens somewhere else
+	server := &Server{session: make(map[string]*rtmprelay.RtmpRelay), rtmpAddr: l.Addr().String()}
+	restore := func(body string) []restored {
+		w := httptest.NewRecorder()
+		server.handleRestore(w, httptest.NewRequest("POST", "/control/restore", strings.NewReader(body)))
+		at.Equal(200, w.Code)
+		var res struct {
+			Data []restored `json:"data"`
+		}
+		at.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+		return res.Data
+	}
+
+	at.Equal([]restored{{Key: "pull:live/restored", Running: true}}, restore(dump))
+	r := server.sessions()["pull:live/restored"]
+	if at.NotNil(r) {
+		defer r.Stop()
+		at.Equal("rtmp://"+l.Addr().String()+"/live/restored", r.PublishUrl)
+	}
+
+	// restoring twice keeps the running relay
+	at.Equal([]restored{{Key: "pull:live/restored", Running: true, Existed: true}}, restore(dump))
+	at.Equal(r, server.sessions()["pull:live/restored"])
+
+	got := restore(`{"sessions": [{"key": "pull:live/x", "direction": "pull", "app": "live", "name": "x", "source_url": "ftp://a/b"}]}`)
+	if at.Equal(1, len(got)) {
+		at.False(got[0].Running)
+		at.Contains(got[0].Error, "invalid url scheme")
+	}
+
+	w = httptest.NewRecorder()
+	server.handleRestore(w, httptest.NewRequest("GET", "/control/restore", nil))
+	at.Equal(405, w.Code)
+}
+
+func TestRelayUrlsKey(t *testing.T) {
+	at := assert.New(t)
+	server := &Server{session: make(map[string]*rtmprelay.RtmpRelay), rtmpAddr: ":1935"}
+	state := func(key string) sessionState {
+		return sessionState{Key: key, Direction: "push", App: "live", Name: "movie", TargetUrl: "rtmp://example.com/live/movie"}
+	}
+
+	for _, key := range []string{"push:live/movie", "push:live/movie#0", "push:live/movie#12"} {
+		_, publish, err := server.relayUrls(state(key))
+		at.Nil(err, key)
+		at.Equal("rtmp://example.com/live/movie", publish, key)
+	}
+	// a key of another stream that shares the prefix is not restored under this one
+	for _, key := range []string{"push:live/movie2", "push:live/movies#0", "push:live/movie/x", "pull:live/movie", "push:live/mov"} {
+		_, _, err := server.relayUrls(state(key))
+		if at.NotNil(err, key) {
+			at.Contains(err.Error(), "key does not match", key)
+		}
+	}
+}
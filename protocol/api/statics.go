@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/SpooderfyBot/live/configure"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultStaticsDir = "statics"
+
+// staticsFS serves the files of root, refusing any name resolving outside
+// of it through .. segments or symlinks
+type staticsFS struct {
+	root string
+}
+
+func (fs staticsFS) Open(name string) (http.File, error) {
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return nil, os.ErrNotExist
+		}
+	}
+
+	full := filepath.Join(fs.root, filepath.FromSlash(path.Clean("/"+name)))
+	real, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	if real != fs.root && !strings.HasPrefix(real, fs.root+string(filepath.Separator)) {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(real)
+}
+
+// newStaticsHandler serves dir, resolved to an absolute path once
+func newStaticsHandler(dir string) (http.Handler, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(root); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	return http.FileServer(staticsFS{root: root}), nil
+}
+
+// mountStatics serves api.statics.dir under /statics/ when
+// api.statics.enabled is set
+func mountStatics(mux *http.ServeMux) {
+	cfg := configure.Statics{}
+	configure.Config.UnmarshalKey("api.statics", &cfg)
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = defaultStaticsDir
+	}
+
+	h, err := newStaticsHandler(cfg.Dir)
+	if err != nil {
+		log.Error("Static files disabled: ", err)
+		return
+	}
+	log.Info("Serving static files from ", cfg.Dir)
+	mux.Handle("/statics/", http.StripPrefix("/statics/", h))
+}
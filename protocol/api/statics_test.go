@@ -0,0 +1,59 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatics(t *testing.T) {
+	at := assert.New(t)
+
+	base, err := ioutil.TempDir("", "statics")
+	at.Nil(err)
+	defer os.RemoveAll(base)
+	dir := filepath.Join(base, "statics")
+	at.Nil(os.Mkdir(dir, 0755))
+	at.Nil(ioutil.WriteFile(filepath.Join(dir, "player.js"), []byte("play()"), 0644))
+	at.Nil(ioutil.WriteFile(filepath.Join(base, "secret.txt"), []byte("secret"), 0644))
+	at.Nil(os.Symlink(base, filepath.Join(dir, "up")))
+
+	// disabled by default
+	mux := http.NewServeMux()
+	mountStatics(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/statics/player.js", nil))
+	at.Equal(404, w.Code)
+
+	configure.Config.Set("api.statics.enabled", true)
+	configure.Config.Set("api.statics.dir", dir)
+	defer configure.Config.Set("api.statics.enabled", false)
+	defer configure.Config.Set("api.statics.dir", "")
+	mux = http.NewServeMux()
+	mountStatics(mux)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/statics/player.js", nil))
+	at.Equal(200, w.Code)
+	at.Equal("play()", w.Body.String())
+
+	// a symlink out of the directory is not followed
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/statics/up/secret.txt", nil))
+	at.Equal(404, w.Code)
+
+	fs := staticsFS{root: dir}
+	_, err = fs.Open("../secret.txt")
+	at.NotNil(err)
+	_, err = fs.Open("/up/../../secret.txt")
+	at.NotNil(err)
+
+	_, err = newStaticsHandler(filepath.Join(base, "missing"))
+	at.NotNil(err)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/testsrc"
+)
+
+const (
+	defaultTestDuration = 10 * time.Second
+	maxTestDuration     = time.Hour
+)
+
+// testPattern is the test pattern published to key, nil if there is none.
+// It is found before its stream started.
+func testPattern(inspector rtmp.StreamInspector, key string) *testsrc.Reader {
+	s, found := inspector.GetStream(key)
+	if !found {
+		return nil
+	}
+	if reader, ok := s.GetReader().(*testsrc.Reader); ok && reader.Alive() {
+		return reader
+	}
+	return nil
+}
+
+// http://127.0.0.1:8090/control/test-publish?room=ROOM_NAME[&app=live][&duration=10s][&oper=stop]
+func (server *Server) handleTestPublish(w http.ResponseWriter, r *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if !configure.Config.GetBool("api.test_endpoints") {
+		res.Status = 404
+		res.Data = "test endpoints are disabled, see api.test_endpoints"
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.Form.Get("room") == "" {
+		res.Status = 400
+		res.Data = "url: /control/test-publish?room=<ROOM_NAME>&duration=10s"
+		return
+	}
+	app, err := streamApp(r)
+	if err != nil {
+		res.Status = 400
+		res.Data = err.Error()
+		return
+	}
+
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
+		res.Status = 500
+		res.Data = "Get rtmp stream information error"
+		return
+	}
+
+	room := r.Form.Get("room")
+	key := fmt.Sprintf("%s/%s", app, room)
+	if r.Form.Get("oper") == "stop" {
+		reader := testPattern(inspector, key)
+		if reader == nil {
+			res.Status = 404
+			res.Data = "The room has no test pattern"
+			return
+		}
+		reader.Close(nil)
+		res.Data = room
+		return
+	}
+
+	duration := defaultTestDuration
+	if d := r.Form.Get("duration"); d != "" {
+		if duration, err = time.ParseDuration(d); err != nil || duration <= 0 || duration > maxTestDuration {
+			res.Status = 400
+			res.Data = fmt.Sprintf("duration must be a duration such as 10s, up to %v", maxTestDuration)
+			return
+		}
+	}
+	if a, ok := inspector.(rtmp.Aliaser); ok {
+		if _, aliased := a.Alias(key); aliased {
+			res.Status = 400
+			res.Data = rtmp.ErrAliasPublish.Error()
+			return
+		}
+	}
+	if c, ok := inspector.(rtmp.PublishChecker); ok && c.Publishing(key) || testPattern(inspector, key) != nil {
+		res.Status = 409
+		res.Data = "The room already has a publisher"
+		return
+	}
+
+	reader := testsrc.NewReader(key, "test://"+r.Host+"/"+key, duration)
+	server.handler.HandleReader(reader)
+	res.Data = server.describe(app, room, "", r)
+}
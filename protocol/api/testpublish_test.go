@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/httpflv"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTestPublish(t *testing.T) {
+	at := assert.New(t)
+	handler := rtmp.NewRtmpStream()
+	server := &Server{handler: handler}
+	get := func(url string) (int, StreamDescriptor) {
+		w := httptest.NewRecorder()
+		server.handleTestPublish(w, httptest.NewRequest("GET", url, nil))
+		var res struct {
+			Data StreamDescriptor `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res.Data
+	}
+
+	// off by default
+	code, _ := get("/control/test-publish?room=loopback")
+	at.Equal(404, code)
+
+	configure.Config.Set("api.test_endpoints", true)
+	defer configure.Config.Set("api.test_endpoints", false)
+	code, _ = get("/control/test-publish?room=loopback&duration=1s2")
+	at.Equal(400, code)
+	code, msg := get("/control/test-publish?room=loopback&duration=1m")
+	at.Equal(200, code)
+	at.True(msg.Live)
+	code, _ = get("/control/test-publish?room=loopback")
+	at.Equal(409, code)
+
+	// a player gets the sequence headers, then a key frame and audio
+	flv := httptest.NewServer(httpflv.NewServer(handler).Handler())
+	defer flv.Close()
+	res, err := http.Get(flv.URL + "/live/loopback.flv")
+	at.Nil(err)
+	defer res.Body.Close()
+	at.Equal(200, res.StatusCode)
+	body := bufio.NewReader(res.Body)
+	_, err = io.ReadFull(body, make([]byte, 9+4))
+	at.Nil(err)
+	var tags [][]byte
+	keyFrame, audio := false, false
+	for len(tags) < 64 && !(keyFrame && audio) {
+		header := make([]byte, 11)
+		if _, err := io.ReadFull(body, header); !at.Nil(err) {
+			break
+		}
+		data := make([]byte, int(header[1])<<16|int(header[2])<<8|int(header[3])+4)
+		if _, err := io.ReadFull(body, data); !at.Nil(err) {
+			break
+		}
+		switch {
+		case header[0] == av.TAG_VIDEO && data[0] == 0x17 && data[1] == av.AVC_NALU:
+			keyFrame = true
+		case header[0] == av.TAG_AUDIO && data[1] == av.AAC_RAW:
+			audio = true
+		}
+		tags = append(tags, append(header[:1], data[:2]...))
+	}
+	at.True(keyFrame)
+	at.True(audio)
+	at.Equal([]byte{av.TAG_VIDEO, 0x17, av.AVC_SEQHDR}, tags[0])
+	at.Equal(byte(av.TAG_AUDIO), tags[1][0])
+
+	// stopping it ends the stream of the player
+	code, _ = get("/control/test-publish?room=loopback&oper=stop")
+	at.Equal(200, code)
+	_, err = ioutil.ReadAll(body)
+	at.Nil(err)
+	code, _ = get("/control/test-publish?room=loopback&oper=stop")
+	at.Equal(404, code)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+)
+
+// StreamDescriptor is how clients reach a room, the one shape every
+// endpoint listing rooms answers with. Key and the publishing RtmpUrl are
+// only set where the caller may see the key.
+type StreamDescriptor struct {
+	Room    string `json:"room"`
+	App     string `json:"app"`
+	Key     string `json:"key,omitempty"`
+	RtmpUrl string `json:"rtmp_url,omitempty"`
+	FlvUrl  string `json:"flv_url"`
+	HlsUrl  string `json:"hls_url"`
+	Live    bool   `json:"live"`
+	AliasOf string `json:"alias_of,omitempty"` // app/room played instead
+}
+
+// publicHost returns the host:port clients should use to reach a listener
+// bound on addr. A listener without a host, or bound on every interface,
+// is reached through the host the API request came in on.
+func publicHost(addr string, r *http.Request) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// describe builds the descriptor of app/room, the urls use the listen
+// addresses of the config as seen by r and live tells if the room, or the
+// one it is an alias of, has a publisher. key is left out when empty.
+func (server *Server) describe(app, room, key string, r *http.Request) StreamDescriptor {
+	flvHost := publicHost(configure.Config.GetString("httpflv_addr"), r)
+	hlsHost := publicHost(configure.Config.GetString("hls_addr"), r)
+
+	ret := StreamDescriptor{
+		Room:   room,
+		App:    app,
+		Key:    key,
+		FlvUrl: "http://" + flvHost + "/" + app + "/" + room + ".flv",
+		HlsUrl: "http://" + hlsHost + "/" + app + "/" + room + ".m3u8",
+	}
+	if key != "" {
+		ret.RtmpUrl = "rtmp://" + publicHost(configure.Config.GetString("rtmp_addr"), r) + "/" + app + "/" + key
+	}
+	if inspector, ok := rtmp.Inspect(server.handler); ok {
+		key := app + "/" + room
+		if aliaser, ok := inspector.(rtmp.Aliaser); ok {
+			if to, aliased := aliaser.Alias(key); aliased {
+				ret.AliasOf, key = to, to
+			}
+		}
+		s, found := inspector.GetStream(key)
+		ret.Live = found && s.GetReader() != nil
+	}
+	return ret
+}
+
+// defaultApp is the app of the rooms when a request doesn't name one
+const defaultApp = "live"
+
+// streamApp returns the app form value, defaulting to live,
+// once checked it is a configured app
+func streamApp(r *http.Request) (string, error) {
+	return checkApp(r.Form.Get("app"))
+}
+
+// checkApp is app, live when empty, once checked it is a configured app
+func checkApp(app string) (string, error) {
+	if app == "" {
+		return defaultApp, nil
+	}
+	if !configure.CheckAppName(app) {
+		return "", fmt.Errorf("application name=%s is not configured", app)
+	}
+	return app, nil
+}
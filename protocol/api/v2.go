@@ -0,0 +1,230 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/utils/worker"
+)
+
+// The /v2/stats routes collect their data like the /stats ones and only
+// serialize it differently. A stream maps from v1 to v2 as:
+//
+//	key                   -> key
+//	id                    -> id
+//	addr                  -> remote_addr
+//	url                   -> url
+//	stream_id             -> rtmp_stream_id
+//	video_total_bytes     -> video.bytes
+//	video_speed           -> dropped, use video.bitrate_kbps
+//	audio_total_bytes     -> audio.bytes
+//	audio_speed           -> dropped, use audio.bitrate_kbps
+//	video_bitrate_kbps    -> video.bitrate_kbps
+//	audio_bitrate_kbps    -> audio.bitrate_kbps
+//	player_count          -> players.count, publishers only
+//	max_players           -> players.max, publishers only
+//	hls_segments          -> hls_segments
+//	hls_write_failures    -> hls_write_failures
+//	source_type           -> source_type
+//	max_bitrate_kbps      -> bitrate_limit.max_kbps
+//	near_max_bitrate      -> bitrate_limit.near_max
+//	quota_remaining_bytes -> quota_remaining_bytes
+//	discontinuities       -> discontinuities
+//	reordered_packets     -> reordered_packets
+//	last_keyframe_age_ms  -> video.last_keyframe_age_ms
+//	video_codec           -> video.codec
+//	audio_codec           -> audio.codec
+//	width                 -> video.width
+//	height                -> video.height
+//	framerate             -> video.frame_rate
+//	buffer                -> buffer
+//	recording             -> recording
+//
+// and in the summary inbound_speed and outbound_speed become inbound_kbps
+// and outbound_kbps.
+type streamV2 struct {
+	Key              string            `json:"key"`
+	Id               string            `json:"id"`
+	RemoteAddr       string            `json:"remote_addr,omitempty"`
+	Url              string            `json:"url"`
+	RtmpStreamId     uint32            `json:"rtmp_stream_id"`
+	Video            videoV2           `json:"video"`
+	Audio            audioV2           `json:"audio"`
+	Players          *playersV2        `json:"players,omitempty"`
+	BitrateLimit     *bitrateLimitV2   `json:"bitrate_limit,omitempty"`
+	Quota            *int64            `json:"quota_remaining_bytes,omitempty"`
+	Discontinuities  uint64            `json:"discontinuities"`
+	ReorderedPackets uint64            `json:"reordered_packets"`
+	HlsSegments      int               `json:"hls_segments,omitempty"`
+	HlsWriteFailures uint64            `json:"hls_write_failures,omitempty"`
+	SourceType       string            `json:"source_type,omitempty"`
+	Buffer           *rtmp.BufferStats `json:"buffer,omitempty"`
+	Recording        *flv.Recording    `json:"recording,omitempty"`
+}
+
+type videoV2 struct {
+	Codec           string  `json:"codec"`
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+	FrameRate       float64 `json:"frame_rate"`
+	BitrateKbps     uint64  `json:"bitrate_kbps"`
+	Bytes           uint64  `json:"bytes"`
+	LastKeyFrameAge *int64  `json:"last_keyframe_age_ms"`
+}
+
+type audioV2 struct {
+	Codec       string `json:"codec"`
+	BitrateKbps uint64 `json:"bitrate_kbps"`
+	Bytes       uint64 `json:"bytes"`
+}
+
+type playersV2 struct {
+	Count int `json:"count"`
+	Max   int `json:"max,omitempty"`
+}
+
+type bitrateLimitV2 struct {
+	MaxKbps uint64 `json:"max_kbps"`
+	NearMax bool   `json:"near_max"`
+}
+
+type streamsV2 struct {
+	Publishers []streamV2 `json:"publishers"`
+	Players    []streamV2 `json:"players"`
+	Relays     []relay    `json:"relays"`
+}
+
+type summaryV2 struct {
+	Publishers   int                `json:"publishers"`
+	Players      int                `json:"players"`
+	InboundKbps  uint64             `json:"inbound_kbps"`
+	OutboundKbps uint64             `json:"outbound_kbps"`
+	ActiveRelays int                `json:"active_relays"`
+	PlaybackMode string             `json:"playback_mode"`
+	Maintenance  bool               `json:"maintenance"`
+	Workers      worker.Stats       `json:"workers"`
+	Capacity     rtmp.Capacity      `json:"capacity"`
+	Streams      []StreamDescriptor `json:"streams"`
+}
+
+// v2 converts msg to its v2 shape, players counts only make sense for
+// a publisher
+func (msg *stream) v2(publisher bool) streamV2 {
+	ret := streamV2{
+		Key:          msg.Key,
+		Id:           msg.Id,
+		RemoteAddr:   msg.Addr,
+		Url:          msg.Url,
+		RtmpStreamId: msg.StreamId,
+		Video: videoV2{
+			Codec:           msg.VideoCodec,
+			Width:           msg.Width,
+			Height:          msg.Height,
+			FrameRate:       msg.FrameRate,
+			BitrateKbps:     msg.VideoBitrate,
+			Bytes:           msg.VideoTotalBytes,
+			LastKeyFrameAge: msg.LastKeyFrameAge,
+		},
+		Audio: audioV2{
+			Codec:       msg.AudioCodec,
+			BitrateKbps: msg.AudioBitrate,
+			Bytes:       msg.AudioTotalBytes,
+		},
+		Quota:            msg.QuotaRemaining,
+		Discontinuities:  msg.Discontinuities,
+		ReorderedPackets: msg.ReorderedPackets,
+		HlsSegments:      msg.HlsSegments,
+		HlsWriteFailures: msg.HlsWriteFails,
+		SourceType:       msg.SourceType,
+		Buffer:           msg.Buffer,
+		Recording:        msg.Recording,
+	}
+	if publisher {
+		ret.Players = &playersV2{Count: msg.PlayerCount, Max: msg.MaxPlayers}
+	}
+	if msg.MaxBitrate > 0 {
+		ret.BitrateLimit = &bitrateLimitV2{MaxKbps: msg.MaxBitrate, NearMax: msg.NearMaxBitrate}
+	}
+	return ret
+}
+
+func (msgs *streams) v2() streamsV2 {
+	ret := streamsV2{
+		Publishers: make([]streamV2, 0, len(msgs.Publishers)),
+		Players:    make([]streamV2, 0, len(msgs.Players)),
+		Relays:     msgs.Relays,
+	}
+	for i := range msgs.Publishers {
+		ret.Publishers = append(ret.Publishers, msgs.Publishers[i].v2(true))
+	}
+	for i := range msgs.Players {
+		ret.Players = append(ret.Players, msgs.Players[i].v2(false))
+	}
+	return ret
+}
+
+func (msg summary) v2() summaryV2 {
+	return summaryV2{
+		Publishers:   msg.Publishers,
+		Players:      msg.Players,
+		InboundKbps:  msg.InboundSpeed,
+		OutboundKbps: msg.OutboundSpeed,
+		ActiveRelays: msg.ActiveRelays,
+		PlaybackMode: msg.PlaybackMode,
+		Maintenance:  msg.Maintenance,
+		Workers:      msg.Workers,
+		Capacity:     msg.Capacity,
+		Streams:      msg.Streams,
+	}
+}
+
+// http://127.0.0.1:8090/v2/stats/livestat?room=xyz[&app=live]
+func (server *Server) GetLiveStatV2(w http.ResponseWriter, req *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	if msg := server.liveStat(w, req, res); msg != nil {
+		res.Data = msg.v2(true)
+	}
+}
+
+// http://127.0.0.1:8090/v2/stats/livestats
+func (server *Server) GetLiveStaticsV2(w http.ResponseWriter, req *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
+		res.Status = 500
+		res.Data = "Get rtmp stream information error"
+		return
+	}
+	res.Data = server.collectStreams(inspector).v2()
+}
+
+// http://127.0.0.1:8090/v2/stats/summary
+func (server *Server) GetSummaryV2(w http.ResponseWriter, req *http.Request) {
+	res := &Response{
+		w:      w,
+		Data:   nil,
+		Status: 200,
+	}
+	defer res.SendJson()
+
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
+		res.Status = 500
+		res.Data = "Get rtmp stream information error"
+		return
+	}
+	res.Data = server.summarize(server.collectStreams(inspector), req).v2()
+}
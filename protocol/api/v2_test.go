@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamV2(t *testing.T) {
+	at := assert.New(t)
+	age, quota := int64(40), int64(1000)
+	msg := stream{
+		Key:             "live/room",
+		Id:              "uid",
+		Addr:            "127.0.0.1:1935",
+		StreamId:        1,
+		VideoTotalBytes: 2000,
+		VideoSpeed:      3,
+		AudioTotalBytes: 500,
+		VideoBitrate:    2500,
+		AudioBitrate:    128,
+		PlayerCount:     2,
+		MaxPlayers:      10,
+		MaxBitrate:      3000,
+		NearMaxBitrate:  true,
+		QuotaRemaining:  &quota,
+		Discontinuities: 2,
+		LastKeyFrameAge: &age,
+		VideoCodec:      "H264",
+		AudioCodec:      "AAC",
+		Width:           1280,
+		Height:          720,
+		FrameRate:       30,
+	}
+
+	b, err := json.Marshal(msg.v2(true))
+	at.Nil(err)
+	at.JSONEq(`{
+		"key": "live/room",
+		"id": "uid",
+		"remote_addr": "127.0.0.1:1935",
+		"url": "",
+		"rtmp_stream_id": 1,
+		"video": {"codec": "H264", "width": 1280, "height": 720, "frame_rate": 30,
+			"bitrate_kbps": 2500, "bytes": 2000, "last_keyframe_age_ms": 40},
+		"audio": {"codec": "AAC", "bitrate_kbps": 128, "bytes": 500},
+		"players": {"count": 2, "max": 10},
+		"bitrate_limit": {"max_kbps": 3000, "near_max": true},
+		"quota_remaining_bytes": 1000,
+		"discontinuities": 2,
+		"reordered_packets": 0
+	}`, string(b))
+
+	// players don't have players nor a bitrate limit
+	msg.MaxBitrate = 0
+	v2 := msg.v2(false)
+	at.Nil(v2.Players)
+	at.Nil(v2.BitrateLimit)
+}
+
+func TestStatsVersions(t *testing.T) {
+	at := assert.New(t)
+	done := make(chan struct{})
+	defer close(done)
+
+	rtmpStream := rtmp.NewRtmpStream()
+	rtmpStream.GetStreams().Store("live/pending", rtmp.NewStream())
+	ready := rtmp.NewStream()
+	ready.AddReader(rtmp.NewVirReader(&idleConn{done: done}))
+	rtmpStream.GetStreams().Store("live/ready", ready)
+	server := &Server{handler: rtmpStream}
+	get := func(handle func(*Server, http.ResponseWriter, *http.Request), url string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		handle(server, w, httptest.NewRequest("GET", url, nil))
+		var res struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res.Data
+	}
+
+	// both versions share the errors
+	code, _ := get((*Server).GetLiveStatV2, "/v2/stats/livestat?room=missing")
+	at.Equal(404, code)
+	code, _ = get((*Server).GetLiveStatV2, "/v2/stats/livestat?room=pending")
+	at.Equal(503, code)
+
+	code, v1 := get((*Server).GetLiveStat, "/stats/livestat?room=ready")
+	at.Equal(200, code)
+	code, v2 := get((*Server).GetLiveStatV2, "/v2/stats/livestat?room=ready")
+	at.Equal(200, code)
+	at.Equal(v1["key"], v2["key"])
+	at.Equal(v1["stream_id"], v2["rtmp_stream_id"])
+	at.Equal(v1["video_bitrate_kbps"], v2["video"].(map[string]interface{})["bitrate_kbps"])
+	at.NotContains(v2, "video_speed")
+
+	code, v1 = get((*Server).GetSummary, "/stats/summary")
+	at.Equal(200, code)
+	code, v2 = get((*Server).GetSummaryV2, "/v2/stats/summary")
+	at.Equal(200, code)
+	at.Equal(v1["publishers"], v2["publishers"])
+	at.Equal(v1["inbound_speed"], v2["inbound_kbps"])
+	at.NotContains(v2, "inbound_speed")
+	// only the room with a publisher is listed, the same in both
+	at.Equal([]interface{}{map[string]interface{}{
+		"room":    "ready",
+		"app":     "live",
+		"flv_url": "http://example.com:7001/live/ready.flv",
+		"hls_url": "http://example.com:7002/live/ready.m3u8",
+		"live":    true,
+	}}, v1["streams"])
+	at.Equal(v1["streams"], v2["streams"])
+
+	code, v2 = get((*Server).GetLiveStaticsV2, "/v2/stats/livestats")
+	at.Equal(200, code)
+	at.Len(v2["publishers"], 1)
+}
@@ -3,8 +3,14 @@ package hls
 import (
 	"bytes"
 	"container/list"
+	"context"
 	"fmt"
 	"sync"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+
+	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -16,33 +22,80 @@ var (
 )
 
 type TSCacheItem struct {
-	id   string
-	num  int
-	lock sync.RWMutex
-	ll   *list.List
-	lm   map[string]TSItem
+	id        string
+	num       int
+	lock      sync.RWMutex
+	ll        *list.List
+	lm        map[string]TSItem
+	createdAt time.Time
+	dvr       int // ms of segments kept for the dvr playlist
+	total     int // ms of segments kept
+	evicted   int // discontinuities of the segments already evicted
+	// published is closed and replaced on each new segment
+	published chan struct{}
 }
 
 func NewTSCacheItem(id string) *TSCacheItem {
 	return &TSCacheItem{
-		id:  id,
-		ll:  list.New(),
-		num: maxTSCacheNum,
-		lm:  make(map[string]TSItem),
+		id:        id,
+		ll:        list.New(),
+		num:       windowSize(),
+		dvr:       configure.Config.GetInt("hls.dvr_window"),
+		lm:        make(map[string]TSItem),
+		createdAt: time.Now(),
+		published: make(chan struct{}),
+	}
+}
+
+// windowSize is the configured number of segments kept per stream
+func windowSize() int {
+	if n := configure.Config.GetInt("hls.window_size"); n > 0 {
+		return n
 	}
+	return maxTSCacheNum
 }
 
 func (tcCacheItem *TSCacheItem) ID() string {
 	return tcCacheItem.id
 }
 
-// TODO: found data race, fix it
 func (tcCacheItem *TSCacheItem) GenM3U8PlayList() ([]byte, error) {
+	tcCacheItem.lock.RLock()
+	defer tcCacheItem.lock.RUnlock()
+	// the request can ask to wait for a segment with _HLS_msn
+	return tcCacheItem.genPlayList(tcCacheItem.liveFront(), "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES\n"), nil
+}
+
+// GenDVRPlayList lists every segment kept for the dvr window, so players
+// can seek back within the broadcast
+func (tcCacheItem *TSCacheItem) GenDVRPlayList() ([]byte, error) {
+	tcCacheItem.lock.RLock()
+	defer tcCacheItem.lock.RUnlock()
+	return tcCacheItem.genPlayList(tcCacheItem.ll.Front(), "#EXT-X-PLAYLIST-TYPE:EVENT\n"), nil
+}
+
+// liveFront is the first segment of the live window, older ones
+// are only kept for the dvr playlist
+func (tcCacheItem *TSCacheItem) liveFront() *list.Element {
+	e := tcCacheItem.ll.Back()
+	for i := 1; i < tcCacheItem.num && e != nil && e.Prev() != nil; i++ {
+		e = e.Prev()
+	}
+	return e
+}
+
+func (tcCacheItem *TSCacheItem) genPlayList(front *list.Element, tags string) []byte {
 	var seq int
 	var getSeq bool
 	var maxDuration int
+	discontinuitySeq := tcCacheItem.evicted
+	for e := tcCacheItem.ll.Front(); e != nil && e != front; e = e.Next() {
+		if tcCacheItem.lm[e.Value.(string)].Discontinuity {
+			discontinuitySeq++
+		}
+	}
 	m3u8body := bytes.NewBuffer(nil)
-	for e := tcCacheItem.ll.Front(); e != nil; e = e.Next() {
+	for e := front; e != nil; e = e.Next() {
 		key := e.Value.(string)
 		v, ok := tcCacheItem.lm[key]
 		if ok {
@@ -53,32 +106,114 @@ func (tcCacheItem *TSCacheItem) GenM3U8PlayList() ([]byte, error) {
 				getSeq = true
 				seq = v.SeqNum
 			}
+			if v.Discontinuity {
+				m3u8body.WriteString("#EXT-X-DISCONTINUITY\n")
+			}
 			fmt.Fprintf(m3u8body, "#EXTINF:%.3f,\n%s\n", float64(v.Duration)/float64(1000), v.Name)
 		}
 	}
 	w := bytes.NewBuffer(nil)
 	fmt.Fprintf(w,
-		"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n\n",
-		maxDuration/1000+1, seq)
+		"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-ALLOW-CACHE:NO\n%s#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n\n",
+		tags, maxDuration/1000+1, seq)
+	if discontinuitySeq > 0 {
+		// players need it to line up the discontinuities as the window slides
+		fmt.Fprintf(w, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discontinuitySeq)
+	}
 	w.Write(m3u8body.Bytes())
-	return w.Bytes(), nil
+	return w.Bytes()
 }
 
+// SetItem publishes a complete segment and evicts the oldest ones that are
+// neither in the live window nor needed to cover the dvr window. A segment
+// is published once, a reader of key never sees it change
 func (tcCacheItem *TSCacheItem) SetItem(key string, item TSItem) {
-	if tcCacheItem.ll.Len() == tcCacheItem.num {
+	tcCacheItem.lock.Lock()
+	defer tcCacheItem.lock.Unlock()
+	if _, ok := tcCacheItem.lm[key]; ok {
+		log.Warningf("[%s] segment %s already published, dropping the new one", tcCacheItem.id, key)
+		return
+	}
+	tcCacheItem.lm[key] = item
+	tcCacheItem.ll.PushBack(key)
+	tcCacheItem.total += item.Duration
+	close(tcCacheItem.published)
+	tcCacheItem.published = make(chan struct{})
+	for tcCacheItem.ll.Len() > tcCacheItem.num {
 		e := tcCacheItem.ll.Front()
-		tcCacheItem.ll.Remove(e)
 		k := e.Value.(string)
+		if tcCacheItem.total-tcCacheItem.lm[k].Duration < tcCacheItem.dvr {
+			break
+		}
+		tcCacheItem.ll.Remove(e)
+		if tcCacheItem.lm[k].Discontinuity {
+			tcCacheItem.evicted++
+		}
+		tcCacheItem.total -= tcCacheItem.lm[k].Duration
 		delete(tcCacheItem.lm, k)
 	}
-	tcCacheItem.lm[key] = item
-	tcCacheItem.ll.PushBack(key)
+}
+
+// LastSeq is the sequence number of the last segment, -1 when there is none
+func (tcCacheItem *TSCacheItem) LastSeq() int {
+	tcCacheItem.lock.RLock()
+	defer tcCacheItem.lock.RUnlock()
+	return tcCacheItem.lastSeq()
+}
+
+func (tcCacheItem *TSCacheItem) lastSeq() int {
+	if e := tcCacheItem.ll.Back(); e != nil {
+		return tcCacheItem.lm[e.Value.(string)].SeqNum
+	}
+	return -1
+}
+
+// WaitSeq blocks until the segment of sequence number msn, or a later one,
+// is published and tells if it was. It gives up when ctx is done or after
+// timeout.
+func (tcCacheItem *TSCacheItem) WaitSeq(ctx context.Context, msn int, timeout time.Duration) bool {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	for {
+		tcCacheItem.lock.RLock()
+		last, published := tcCacheItem.lastSeq(), tcCacheItem.published
+		tcCacheItem.lock.RUnlock()
+		if last >= msn {
+			return true
+		}
+		select {
+		case <-published:
+		case <-ctx.Done():
+			return false
+		case <-t.C:
+			return false
+		}
+	}
 }
 
 func (tcCacheItem *TSCacheItem) GetItem(key string) (TSItem, error) {
+	tcCacheItem.lock.RLock()
+	defer tcCacheItem.lock.RUnlock()
 	item, ok := tcCacheItem.lm[key]
 	if !ok {
 		return item, ErrNoKey
 	}
 	return item, nil
 }
+
+// Len is the number of segments currently held in memory
+func (tcCacheItem *TSCacheItem) Len() int {
+	tcCacheItem.lock.RLock()
+	defer tcCacheItem.lock.RUnlock()
+	return tcCacheItem.ll.Len()
+}
+
+// Clear frees all the segments, once the stream is gone
+func (tcCacheItem *TSCacheItem) Clear() {
+	tcCacheItem.lock.Lock()
+	defer tcCacheItem.lock.Unlock()
+	tcCacheItem.ll.Init()
+	tcCacheItem.lm = make(map[string]TSItem)
+	tcCacheItem.total = 0
+	tcCacheItem.evicted = 0
+}
@@ -0,0 +1,163 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowSize(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("hls.window_size", 2)
+	defer configure.Config.Set("hls.window_size", 0)
+
+	c := NewTSCacheItem("live/movie")
+	for i := 1; i <= 5; i++ {
+		name := fmt.Sprintf("/live/movie/%d.ts", i)
+		c.SetItem(name, NewTSItem(name, 3000, i, []byte{0x47}))
+
+		body, err := c.GenM3U8PlayList()
+		at.Nil(err)
+		at.True(strings.Count(string(body), ".ts\n") <= 2)
+		at.True(len(c.lm) <= 2)
+	}
+
+	_, err := c.GetItem("/live/movie/3.ts")
+	at.Equal(ErrNoKey, err)
+	_, err = c.GetItem("/live/movie/5.ts")
+	at.Nil(err)
+	body, _ := c.GenM3U8PlayList()
+	at.True(strings.Contains(string(body), "#EXT-X-MEDIA-SEQUENCE:4\n"))
+}
+
+func TestSegmentDuration(t *testing.T) {
+	at := assert.New(t)
+	at.Equal(int64(duration), segmentDuration())
+	configure.Config.Set("hls.segment_duration", 1000)
+	defer configure.Config.Set("hls.segment_duration", 0)
+	at.Equal(int64(1000), segmentDuration())
+}
+
+func TestDVRWindow(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("hls.window_size", 2)
+	configure.Config.Set("hls.dvr_window", 7000)
+	defer configure.Config.Set("hls.window_size", 0)
+	defer configure.Config.Set("hls.dvr_window", 0)
+
+	c := NewTSCacheItem("live/movie")
+	for i := 1; i <= 5; i++ {
+		name := fmt.Sprintf("/live/movie/%d.ts", i)
+		c.SetItem(name, NewTSItem(name, 3000, i, []byte{0x47}))
+	}
+
+	// 3 segments cover the 7s window, the oldest ones aged out
+	at.Equal(3, c.Len())
+	_, err := c.GetItem("/live/movie/2.ts")
+	at.Equal(ErrNoKey, err)
+
+	body, _ := c.GenM3U8PlayList()
+	at.Equal(2, strings.Count(string(body), ".ts\n"))
+	at.True(strings.Contains(string(body), "#EXT-X-MEDIA-SEQUENCE:4\n"))
+
+	body, _ = c.GenDVRPlayList()
+	at.Equal(3, strings.Count(string(body), ".ts\n"))
+	at.True(strings.Contains(string(body), "#EXT-X-PLAYLIST-TYPE:EVENT\n"))
+	at.True(strings.Contains(string(body), "#EXT-X-MEDIA-SEQUENCE:3\n"))
+}
+
+func TestDiscontinuitySequence(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("hls.window_size", 2)
+	defer configure.Config.Set("hls.window_size", 0)
+
+	c := NewTSCacheItem("live/movie")
+	for i := 1; i <= 4; i++ {
+		name := fmt.Sprintf("/live/movie/%d.ts", i)
+		item := NewTSItem(name, 3000, i, []byte{0x47})
+		item.Discontinuity = i == 2
+		c.SetItem(name, item)
+	}
+
+	// the discontinuity aged out with segment 2, players still count it
+	body, _ := c.GenM3U8PlayList()
+	at.False(strings.Contains(string(body), "#EXT-X-DISCONTINUITY\n"))
+	at.True(strings.Contains(string(body), "#EXT-X-DISCONTINUITY-SEQUENCE:1\n"))
+}
+
+func TestSegmentsPublishedWhole(t *testing.T) {
+	at := assert.New(t)
+	const segments, size = 20, 188 * 50
+
+	c := NewTSCacheItem("live/whole")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// built slowly in one buffer reused for every segment, like Source
+		buf := bytes.NewBuffer(nil)
+		for seq := 1; seq <= segments; seq++ {
+			for buf.Len() < size {
+				buf.Write(bytes.Repeat([]byte{byte(seq)}, 188*10))
+				time.Sleep(100 * time.Microsecond)
+			}
+			name := fmt.Sprintf("/live/whole/%d.ts", seq)
+			c.SetItem(name, NewTSItem(name, 1000, seq, buf.Bytes()))
+			buf.Reset()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var short, mixed, seen int64
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				runtime.Gosched()
+				body, _ := c.GenM3U8PlayList()
+				for _, line := range strings.Split(string(body), "\n") {
+					if !strings.HasSuffix(line, ".ts") {
+						continue
+					}
+					item, err := c.GetItem(line)
+					if err != nil {
+						continue // evicted since the playlist was made
+					}
+					atomic.AddInt64(&seen, 1)
+					if len(item.Data) != size {
+						atomic.AddInt64(&short, 1)
+					}
+					if bytes.Count(item.Data, []byte{byte(item.SeqNum)}) != len(item.Data) {
+						atomic.AddInt64(&mixed, 1)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	at.True(seen > 0)
+	at.Equal(int64(0), short)
+	at.Equal(int64(0), mixed)
+
+	// a published segment is never replaced
+	name := fmt.Sprintf("/live/whole/%d.ts", segments)
+	c.SetItem(name, NewTSItem(name, 1000, segments, []byte{0x47}))
+	item, err := c.GetItem(name)
+	at.Nil(err)
+	at.Equal(size, len(item.Data))
+	at.Equal(c.num, c.ll.Len())
+}
@@ -0,0 +1,104 @@
+package hls
+
+import (
+	"fmt"
+
+	"github.com/SpooderfyBot/live/av"
+)
+
+// the flv ids of the codecs in the errors, the ones of the enhanced rtmp
+// headers are named by their fourcc
+var (
+	videoCodecNames = map[uint8]string{
+		2:  "h263",
+		3:  "screen video",
+		4:  "vp6",
+		5:  "vp6 alpha",
+		6:  "screen video 2",
+		12: "hevc",
+	}
+	audioCodecNames = map[uint8]string{
+		av.SOUND_MP3:                   "mp3",
+		av.SOUND_NELLYMOSER_16KHZ_MONO: "nellymoser",
+		av.SOUND_NELLYMOSER_8KHZ_MONO:  "nellymoser",
+		av.SOUND_NELLYMOSER:            "nellymoser",
+		av.SOUND_ALAW:                  "g711 a-law",
+		av.SOUND_MULAW:                 "g711 mu-law",
+		av.SOUND_SPEEX:                 "speex",
+		13:                             "opus",
+	}
+)
+
+const (
+	// enhanced rtmp flags the fourcc video headers with the top bit and
+	// the audio ones with this sound format
+	videoExHeader = 0x80
+	audioExHeader = 9
+)
+
+// CodecError tells the stream has a codec the hls muxer can't carry, its
+// hls is skipped while the other outputs go on
+type CodecError struct {
+	Video bool
+	Codec string
+}
+
+// checkCodec is the error of a packet of a codec the muxer can't carry,
+// read from its flv tag header before it is demuxed
+func checkCodec(p *av.Packet) *CodecError {
+	if len(p.Data) == 0 || p.IsMetadata {
+		return nil
+	}
+	if p.IsVideo && p.Data[0]&videoExHeader == 0 && p.Data[0]&0x0f == av.VIDEO_H264 {
+		return nil
+	}
+	if p.IsAudio && p.Data[0]>>4 == av.SOUND_AAC {
+		return nil
+	}
+	if !p.IsVideo && !p.IsAudio {
+		return nil
+	}
+	return newCodecError(p)
+}
+
+func newCodecError(p *av.Packet) *CodecError {
+	e := &CodecError{Video: p.IsVideo}
+	if len(p.Data) == 0 {
+		return e
+	}
+	if p.IsVideo {
+		if p.Data[0]&videoExHeader != 0 && len(p.Data) >= 5 {
+			e.Codec = string(p.Data[1:5])
+		} else {
+			e.Codec = videoCodecNames[p.Data[0]&0x0f]
+		}
+	} else {
+		if p.Data[0]>>4 == audioExHeader && len(p.Data) >= 5 {
+			e.Codec = string(p.Data[1:5])
+		} else {
+			e.Codec = audioCodecNames[p.Data[0]>>4]
+		}
+	}
+	if e.Codec == "" {
+		if p.IsVideo {
+			e.Codec = fmt.Sprintf("codec id %d", p.Data[0]&0x0f)
+		} else {
+			e.Codec = fmt.Sprintf("sound format %d", p.Data[0]>>4)
+		}
+	}
+	return e
+}
+
+func (e *CodecError) Error() string {
+	if e.Video {
+		return fmt.Sprintf("hls is not available for this stream, its %s video can't be carried, only h264 is", e.Codec)
+	}
+	return fmt.Sprintf("hls is not available for this stream, its %s audio can't be carried, only aac is", e.Codec)
+}
+
+func (e *CodecError) Unwrap() error {
+	if e.Video {
+		return ErrNoSupportVideoCodec
+	}
+	return ErrNoSupportAudioCodec
+}
@@ -0,0 +1,110 @@
+package hls
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/protocol/httpflv"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/testsrc"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// hevcSource passes the test pattern off as hevc, the flv codec id 12
+type hevcSource struct {
+	*testsrc.Reader
+}
+
+func (r hevcSource) Read(p *av.Packet) error {
+	if err := r.Reader.Read(p); err != nil || !p.IsVideo {
+		return err
+	}
+	p.Data = append([]byte{p.Data[0]&0xf0 | 12}, p.Data[1:]...)
+	return flv.NewDemuxer().DemuxH(p)
+}
+
+func TestCodecError(t *testing.T) {
+	at := assert.New(t)
+	for _, c := range []struct {
+		p     *av.Packet
+		codec string
+	}{
+		{&av.Packet{IsVideo: true, Data: []byte{0x1c, 0, 0, 0, 0}}, "hevc"},
+		{&av.Packet{IsVideo: true, Data: []byte{0x90, 'h', 'v', 'c', '1'}}, "hvc1"},
+		{&av.Packet{IsVideo: true, Data: []byte{0x1e, 0, 0, 0, 0}}, "codec id 14"},
+		{&av.Packet{IsAudio: true, Data: []byte{0xd0}}, "opus"},
+		{&av.Packet{IsAudio: true, Data: []byte{0x90, 'O', 'p', 'u', 's'}}, "Opus"},
+		{&av.Packet{IsAudio: true, Data: []byte{0x2f}}, "mp3"},
+	} {
+		err := newCodecError(c.p)
+		at.Equal(c.codec, err.Codec)
+		at.Contains(err.Error(), c.codec)
+	}
+	at.True(errors.Is(newCodecError(&av.Packet{IsVideo: true, Data: []byte{0x1c}}), ErrNoSupportVideoCodec))
+	at.True(errors.Is(newCodecError(&av.Packet{IsAudio: true, Data: []byte{0xd0}}), ErrNoSupportAudioCodec))
+}
+
+func TestUnsupportedCodec(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("hls.segment_duration", 500)
+	defer configure.Config.Set("hls.segment_duration", 0)
+
+	handler := rtmp.NewRtmpStream()
+	server := &Server{conns: &sync.Map{}}
+	publish := func(r av.ReadCloser) {
+		handler.HandleReader(r)
+		handler.HandleWriter(server.GetWriter(r.Info()))
+	}
+	h264 := testsrc.NewReader("live/h264", "test://live/h264", time.Minute)
+	defer h264.Close(nil)
+	publish(h264)
+	hevc := testsrc.NewReader("live/hevc", "test://live/hevc", time.Minute)
+	defer hevc.Close(nil)
+	publish(hevcSource{hevc})
+
+	// the h264 stream gets its segments, the second key frame cuts one
+	playlist := func(key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.handle(w, httptest.NewRequest("GET", "/"+key+".m3u8", nil))
+		return w
+	}
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 150; i++ {
+		if w = playlist("live/h264"); w.Code == 200 && strings.Contains(w.Body.String(), ".ts\n") {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	at.Equal(200, w.Code)
+	at.Contains(w.Body.String(), ".ts\n")
+	at.Nil(server.getConn("live/h264").Unsupported())
+
+	// the hevc one has none, its playlist is refused
+	w = playlist("live/hevc")
+	at.Equal(http.StatusUnsupportedMediaType, w.Code)
+	at.Contains(w.Body.String(), "hevc video can't be carried")
+	at.Equal(0, server.SegmentCount("live/hevc"))
+
+	// while its flv plays
+	flv := httptest.NewServer(httpflv.NewServer(handler).Handler())
+	defer flv.Close()
+	res, err := http.Get(flv.URL + "/live/hevc.flv")
+	at.Nil(err)
+	defer res.Body.Close()
+	at.Equal(200, res.StatusCode)
+	header := make([]byte, 9+4+11+1)
+	_, err = io.ReadFull(res.Body, header)
+	at.Nil(err)
+	at.Equal(byte(av.TAG_VIDEO), header[13])
+	at.Equal(byte(0x1c), header[24], "hevc key frame")
+}
@@ -0,0 +1,75 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// mpdDuration formats ms as an xsd:duration
+func mpdDuration(ms int) string {
+	return fmt.Sprintf("PT%.3fS", float64(ms)/1000)
+}
+
+// GenMPD builds a live DASH manifest over the same ts segments as the
+// m3u8 playlist (mp2t profile), so DASH costs no extra muxing
+func (tcCacheItem *TSCacheItem) GenMPD() ([]byte, error) {
+	tcCacheItem.lock.RLock()
+	defer tcCacheItem.lock.RUnlock()
+	var seq int
+	var getSeq bool
+	var maxDuration, window, size int
+	timeline := bytes.NewBuffer(nil)
+	urls := bytes.NewBuffer(nil)
+	for e := tcCacheItem.liveFront(); e != nil; e = e.Next() {
+		key := e.Value.(string)
+		v, ok := tcCacheItem.lm[key]
+		if ok {
+			if v.Duration > maxDuration {
+				maxDuration = v.Duration
+			}
+			if !getSeq {
+				getSeq = true
+				seq = v.SeqNum
+			}
+			window += v.Duration
+			size += len(v.Data)
+			fmt.Fprintf(timeline, "          <S t=\"%d\" d=\"%d\"/>\n", v.Start, v.Duration)
+			fmt.Fprintf(urls, "        <SegmentURL media=\"%s\"/>\n", v.Name)
+		}
+	}
+	if !getSeq {
+		return nil, ErrNoKey
+	}
+	bandwidth := 0
+	if window > 0 {
+		bandwidth = size * 8 * 1000 / window
+	}
+
+	w := bytes.NewBuffer(nil)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:mp2t-simple:2011" type="dynamic"
+  availabilityStartTime="%s" publishTime="%s" minimumUpdatePeriod="%s" minBufferTime="%s"
+  timeShiftBufferDepth="%s" suggestedPresentationDelay="%s">
+  <Period id="0" start="PT0S">
+    <AdaptationSet mimeType="video/mp2t" segmentAlignment="true">
+      <Representation id="0" bandwidth="%d">
+        <SegmentList timescale="1000" startNumber="%d">
+          <SegmentTimeline>
+`,
+		tcCacheItem.createdAt.UTC().Format(time.RFC3339),
+		time.Now().UTC().Format(time.RFC3339),
+		mpdDuration(maxDuration), mpdDuration(maxDuration),
+		mpdDuration(window), mpdDuration(2*maxDuration),
+		bandwidth, seq)
+	w.Write(timeline.Bytes())
+	fmt.Fprint(w, "          </SegmentTimeline>\n")
+	w.Write(urls.Bytes())
+	fmt.Fprint(w, `        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>
+`)
+	return w.Bytes(), nil
+}
@@ -0,0 +1,39 @@
+package hls
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenMPD(t *testing.T) {
+	at := assert.New(t)
+	c := NewTSCacheItem("live/movie")
+	_, err := c.GenMPD()
+	at.Equal(ErrNoKey, err)
+
+	for i, name := range []string{"/live/movie/1.ts", "/live/movie/2.ts"} {
+		item := NewTSItem(name, 3000, i+1, make([]byte, 1500))
+		item.Start = i * 3000
+		c.SetItem(name, item)
+	}
+	body, err := c.GenMPD()
+	at.Nil(err)
+	mpd := string(body)
+	at.True(strings.Contains(mpd, `type="dynamic"`))
+	at.True(strings.Contains(mpd, `startNumber="1"`))
+	at.True(strings.Contains(mpd, `<S t="3000" d="3000"/>`))
+	at.True(strings.Contains(mpd, `<SegmentURL media="/live/movie/2.ts"/>`))
+	at.True(strings.Contains(mpd, `bandwidth="4000"`))
+}
+
+func TestParseMpd(t *testing.T) {
+	at := assert.New(t)
+	server := &Server{}
+	key, err := server.parseMpd("/dash/live/movie.mpd")
+	at.Nil(err)
+	at.Equal("live/movie", key)
+	_, err = server.parseMpd("/dash/movie.mpd")
+	at.NotNil(err)
+}
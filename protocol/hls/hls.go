@@ -39,6 +39,24 @@ type Server struct {
 	conns    *sync.Map
 }
 
+// segmentDuration is the configured target duration of a segment in ms,
+// segments are only cut on key frames so they can run longer
+func segmentDuration() int64 {
+	if d := configure.Config.GetInt64("hls.segment_duration"); d > 0 {
+		return d
+	}
+	return duration
+}
+
+// blockTimeout bounds the wait of a blocking playlist request, it is
+// hls.block_timeout in ms or three segment durations when unset
+func blockTimeout() time.Duration {
+	if d := configure.Config.GetInt64("hls.block_timeout"); d > 0 {
+		return time.Duration(d) * time.Millisecond
+	}
+	return 3 * time.Duration(segmentDuration()) * time.Millisecond
+}
+
 func NewServer() *Server {
 	ret := &Server{
 		conns: &sync.Map{},
@@ -48,19 +66,25 @@ func NewServer() *Server {
 }
 
 func (server *Server) Serve(listener net.Listener) error {
+	server.listener = listener
+	http.Serve(listener, server.Handler())
+	return nil
+}
+
+// Handler is the hls server as served by Serve
+func (server *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		server.handle(w, r)
 	})
-	server.listener = listener
-	http.Serve(listener, mux)
-	return nil
+	return mux
 }
 
 func (server *Server) GetWriter(info av.Info) av.WriteCloser {
 	var s *Source
 	v, ok := server.conns.Load(info.Key)
-	if !ok {
+	// a publisher coming back must not reuse the segments of the last one
+	if !ok || v.(*Source).isClosed() {
 		log.Debug("new hls source")
 		s = NewSource(info)
 		server.conns.Store(info.Key, s)
@@ -70,23 +94,50 @@ func (server *Server) GetWriter(info av.Info) av.WriteCloser {
 	return s
 }
 
+// getConn returns the live source of key, a source whose stream ended
+// is only returned when hls_keep_after_end is set
 func (server *Server) getConn(key string) *Source {
 	v, ok := server.conns.Load(key)
 	if !ok {
 		return nil
 	}
-	return v.(*Source)
+	s := v.(*Source)
+	if s.isClosed() && !configure.Config.GetBool("hls_keep_after_end") {
+		return nil
+	}
+	return s
 }
 
+// SegmentCount is the number of segments held in memory for key
+func (server *Server) SegmentCount(key string) int {
+	s := server.getConn(key)
+	if s == nil {
+		return 0
+	}
+	return s.SegmentCount()
+}
+
+// WriteFailures is the number of failed segment writes of key
+func (server *Server) WriteFailures(key string) uint64 {
+	s := server.getConn(key)
+	if s == nil {
+		return 0
+	}
+	return s.WriteFailures()
+}
+
+// checkStop sweeps the sources whose stream ended or went idle
+// without closing them, and frees their segments
 func (server *Server) checkStop() {
 	for {
 		<-time.After(5 * time.Second)
 
 		server.conns.Range(func(key, val interface{}) bool {
 			v := val.(*Source)
-			if !v.Alive() && !configure.Config.GetBool("hls_keep_after_end") {
+			if (v.isClosed() || !v.Alive()) && !configure.Config.GetBool("hls_keep_after_end") {
 				log.Debug("check stop and remove: ", v.Info())
 				server.conns.Delete(key)
+				v.cleanup()
 			}
 			return true
 		})
@@ -101,18 +152,60 @@ func (server *Server) handle(w http.ResponseWriter, r *http.Request) {
 	}
 	switch path.Ext(r.URL.Path) {
 	case ".m3u8":
-		key, _ := server.parseM3u8(r.URL.Path)
+		if group, ok := parseMaster(r.URL.Path); ok {
+			server.serveMaster(w, group)
+			return
+		}
+		// /APP/ROOM/dvr.m3u8 is the time-shift variant of /APP/ROOM.m3u8
+		dvr := path.Base(r.URL.Path) == "dvr.m3u8"
+		if dvr && configure.Config.GetInt("hls.dvr_window") <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+		var key string
+		if dvr {
+			key, _ = server.parseTs(r.URL.Path)
+		} else {
+			key, _ = server.parseM3u8(r.URL.Path)
+		}
 		conn := server.getConn(key)
 		if conn == nil {
-			http.Error(w, ErrNoPublisher.Error(), http.StatusForbidden)
+			http.Error(w, ErrNoPublisher.Error(), http.StatusNotFound)
+			return
+		}
+		if err := conn.Unsupported(); err != nil {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
 			return
 		}
 		tsCache := conn.GetCacheInc()
 		if tsCache == nil {
-			http.Error(w, ErrNoPublisher.Error(), http.StatusForbidden)
+			http.Error(w, ErrNoPublisher.Error(), http.StatusNotFound)
 			return
 		}
-		body, err := tsCache.GenM3U8PlayList()
+		// _HLS_msn asks for the playlist once it lists that segment, the
+		// current one is served when it takes longer than blockTimeout
+		if msn := r.URL.Query().Get("_HLS_msn"); msn != "" && !dvr {
+			n, err := strconv.Atoi(msn)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+				return
+			}
+			if n > tsCache.LastSeq()+2 {
+				http.Error(w, "_HLS_msn is too far ahead", http.StatusBadRequest)
+				return
+			}
+			if !tsCache.WaitSeq(r.Context(), n, blockTimeout()) && r.Context().Err() != nil {
+				// the client went away
+				return
+			}
+		}
+		var body []byte
+		var err error
+		if dvr {
+			body, err = tsCache.GenDVRPlayList()
+		} else {
+			body, err = tsCache.GenM3U8PlayList()
+		}
 		if err != nil {
 			log.Debug("GenM3U8PlayList error: ", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -124,18 +217,54 @@ func (server *Server) handle(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/x-mpegURL")
 		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
 		w.Write(body)
+	case ".mpd":
+		if !configure.Config.GetBool("dash.enabled") {
+			http.NotFound(w, r)
+			return
+		}
+		key, err := server.parseMpd(r.URL.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		conn := server.getConn(key)
+		if conn == nil {
+			http.Error(w, ErrNoPublisher.Error(), http.StatusNotFound)
+			return
+		}
+		if err := conn.Unsupported(); err != nil {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		tsCache := conn.GetCacheInc()
+		if tsCache == nil {
+			http.Error(w, ErrNoPublisher.Error(), http.StatusNotFound)
+			return
+		}
+		body, err := tsCache.GenMPD()
+		if err != nil {
+			log.Debug("GenMPD error: ", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", "application/dash+xml")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
 	case ".ts":
 		key, _ := server.parseTs(r.URL.Path)
 		conn := server.getConn(key)
 		if conn == nil {
-			http.Error(w, ErrNoPublisher.Error(), http.StatusForbidden)
+			http.Error(w, ErrNoPublisher.Error(), http.StatusNotFound)
 			return
 		}
 		tsCache := conn.GetCacheInc()
 		item, err := tsCache.GetItem(r.URL.Path)
 		if err != nil {
 			log.Debug("GetItem error: ", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -145,12 +274,35 @@ func (server *Server) handle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (server *Server) serveMaster(w http.ResponseWriter, group string) {
+	body, err := server.GenMasterPlayList(group)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
+
 func (server *Server) parseM3u8(pathstr string) (key string, err error) {
 	pathstr = strings.TrimLeft(pathstr, "/")
 	key = strings.Split(pathstr, path.Ext(pathstr))[0]
 	return
 }
 
+// parseMpd maps /dash/APP/ROOM.mpd to the APP/ROOM key
+func (server *Server) parseMpd(pathstr string) (key string, err error) {
+	pathstr = strings.TrimPrefix(pathstr, "/dash/")
+	key = strings.TrimSuffix(pathstr, path.Ext(pathstr))
+	if strings.Count(key, "/") != 1 {
+		err = fmt.Errorf("invalid path=%s", pathstr)
+	}
+	return
+}
+
 func (server *Server) parseTs(pathstr string) (key string, err error) {
 	pathstr = strings.TrimLeft(pathstr, "/")
 	paths := strings.SplitN(pathstr, "/", 3)
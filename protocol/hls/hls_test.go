@@ -0,0 +1,296 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/container/ts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClosedSourceIsGone(t *testing.T) {
+	at := assert.New(t)
+	server := &Server{conns: &sync.Map{}}
+	source := server.GetWriter(av.Info{Key: "live/movie"}).(*Source)
+	for i := 1; i <= 2; i++ {
+		name := fmt.Sprintf("/live/movie/%d.ts", i)
+		source.tsCache.SetItem(name, NewTSItem(name, 3000, i, []byte{0x47}))
+	}
+	at.Equal(2, server.SegmentCount("live/movie"))
+
+	w := httptest.NewRecorder()
+	server.handle(w, httptest.NewRequest("GET", "/live/movie.m3u8", nil))
+	at.Equal(http.StatusOK, w.Code)
+
+	source.Close(fmt.Errorf("publisher left"))
+	at.Equal(0, server.SegmentCount("live/movie"))
+	// the sender of the source frees its segments
+	at.True(waitFor(func() bool { return source.SegmentCount() == 0 }))
+
+	w = httptest.NewRecorder()
+	server.handle(w, httptest.NewRequest("GET", "/live/movie.m3u8", nil))
+	at.Equal(http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	server.handle(w, httptest.NewRequest("GET", "/live/movie/1.ts", nil))
+	at.Equal(http.StatusNotFound, w.Code)
+
+	// a new publisher gets a fresh source
+	at.NotEqual(source, server.GetWriter(av.Info{Key: "live/movie"}))
+}
+
+func TestAACConfigChange(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("hls.segment_duration", 1000)
+	defer configure.Config.Set("hls.segment_duration", 0)
+
+	source := NewSource(av.Info{Key: "live/aac"})
+	defer source.Close(nil)
+
+	sps := []byte{0x67, 0x42, 0x00, 0x1e, 0xab}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	// the muxer only pads key frames of more than one ts packet right
+	idr := append([]byte{0x65, 0x88, 0x84}, bytes.Repeat([]byte{0x21}, 400)...)
+	video := func(ts uint32, data []byte) {
+		at.Nil(source.Write(&av.Packet{IsVideo: true, TimeStamp: ts, Data: data}))
+	}
+	audio := func(ts uint32, data []byte) {
+		at.Nil(source.Write(&av.Packet{IsAudio: true, TimeStamp: ts, Data: data}))
+	}
+
+	video(0, flv.NewAVCSeqHeader(sps, pps))
+	// 44.1kHz stereo
+	audio(0, flv.NewAACSeqHeader([]byte{0x12, 0x10}))
+	video(0, flv.NewAVCNALU([][]byte{idr}, true, 0))
+	for ts := uint32(0); ts < 600; ts += 23 {
+		audio(ts, flv.NewAACRaw([]byte{0x21, 0x19}))
+	}
+	// encoders resend the same header, it is not a change
+	audio(600, flv.NewAACSeqHeader([]byte{0x12, 0x10}))
+	// the publisher switches to 48kHz
+	audio(620, flv.NewAACSeqHeader([]byte{0x11, 0x90}))
+	for ts := uint32(620); ts < 1700; ts += 21 {
+		audio(ts, flv.NewAACRaw([]byte{0x21, 0x19}))
+	}
+	video(1700, flv.NewAVCNALU([][]byte{idr}, true, 0))
+
+	var body string
+	for i := 0; i < 100 && strings.Count(body, ".ts\n") < 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+		b, _ := source.GetCacheInc().GenM3U8PlayList()
+		body = string(b)
+	}
+	at.Equal(2, strings.Count(body, ".ts\n"), body)
+
+	at.Equal(1, strings.Count(body, "#EXT-X-DISCONTINUITY\n"))
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if line == "#EXT-X-DISCONTINUITY" {
+			// the second segment starts with the new config
+			at.True(strings.HasSuffix(lines[i+2], "_2.ts"), lines[i+2])
+		}
+	}
+}
+
+func TestAVCConfigChange(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("hls.segment_duration", 1000)
+	defer configure.Config.Set("hls.segment_duration", 0)
+
+	source := NewSource(av.Info{Key: "live/avc"})
+	defer source.Close(nil)
+
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := append([]byte{0x65, 0x88, 0x84}, bytes.Repeat([]byte{0x21}, 400)...)
+	video := func(ts uint32, data []byte) {
+		at.Nil(source.Write(&av.Packet{IsVideo: true, TimeStamp: ts, Data: data}))
+	}
+
+	video(0, flv.NewAVCSeqHeader([]byte{0x67, 0x42, 0x00, 0x1e, 0xab}, pps))
+	video(0, flv.NewAVCNALU([][]byte{idr}, true, 0))
+	video(300, flv.NewAVCNALU([][]byte{{0x41, 0x9a, 0x02}}, false, 0))
+	// the encoder restarts at another resolution, before the segment is due
+	video(600, flv.NewAVCSeqHeader([]byte{0x67, 0x64, 0x00, 0x28, 0xac}, pps))
+	video(600, flv.NewAVCNALU([][]byte{idr}, true, 0))
+	video(1650, flv.NewAVCNALU([][]byte{{0x41, 0x9a, 0x02}}, false, 0))
+	video(1700, flv.NewAVCNALU([][]byte{idr}, true, 0))
+
+	var body string
+	for i := 0; i < 100 && strings.Count(body, ".ts\n") < 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+		b, _ := source.GetCacheInc().GenM3U8PlayList()
+		body = string(b)
+	}
+	at.Equal(2, strings.Count(body, ".ts\n"), body)
+
+	at.Equal(1, strings.Count(body, "#EXT-X-DISCONTINUITY\n"), body)
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if line == "#EXT-X-DISCONTINUITY" {
+			// the second segment starts with the new config
+			at.True(strings.HasSuffix(lines[i+2], "_2.ts"), lines[i+2])
+		}
+	}
+}
+
+func TestPublisherResumed(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("hls.segment_duration", 1000)
+	defer configure.Config.Set("hls.segment_duration", 0)
+
+	source := NewSource(av.Info{Key: "live/resumed"})
+	defer source.Close(nil)
+
+	sps := []byte{0x67, 0x42, 0x00, 0x1e, 0xab}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := append([]byte{0x65, 0x88, 0x84}, bytes.Repeat([]byte{0x21}, 400)...)
+	video := func(ts uint32, data []byte) {
+		at.Nil(source.Write(&av.Packet{IsVideo: true, TimeStamp: ts, Data: data}))
+	}
+	audio := func(ts uint32, data []byte) {
+		at.Nil(source.Write(&av.Packet{IsAudio: true, TimeStamp: ts, Data: data}))
+	}
+	publish := func(from, to uint32) {
+		video(from, flv.NewAVCSeqHeader(sps, pps))
+		audio(from, flv.NewAACSeqHeader([]byte{0x12, 0x10}))
+		video(from, flv.NewAVCNALU([][]byte{idr}, true, 0))
+		for ts := from; ts < to; ts += 23 {
+			audio(ts, flv.NewAACRaw([]byte{0x21, 0x19}))
+		}
+	}
+
+	publish(0, 600)
+	// the encoder reconnects within the grace window, its clock moved on
+	source.PublisherResumed()
+	publish(5000, 6100)
+	video(6100, flv.NewAVCNALU([][]byte{idr}, true, 0))
+
+	var body string
+	for i := 0; i < 100 && strings.Count(body, ".ts\n") < 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+		b, _ := source.GetCacheInc().GenM3U8PlayList()
+		body = string(b)
+	}
+	at.Equal(2, strings.Count(body, ".ts\n"), body)
+
+	at.Equal(1, strings.Count(body, "#EXT-X-DISCONTINUITY\n"), body)
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if line == "#EXT-X-DISCONTINUITY" {
+			// the second segment is the first of the new publisher
+			at.True(strings.HasSuffix(lines[i+2], "_2.ts"), lines[i+2])
+		}
+	}
+}
+
+func TestTimedMetadata(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("hls.segment_duration", 1000)
+	defer configure.Config.Set("hls.segment_duration", 0)
+
+	source := NewSource(av.Info{Key: "live/id3"})
+	defer source.Close(nil)
+
+	sps := []byte{0x67, 0x42, 0x00, 0x1e, 0xab}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := append([]byte{0x65, 0x88, 0x84}, bytes.Repeat([]byte{0x21}, 400)...)
+	video := func(ts uint32, data []byte) {
+		at.Nil(source.Write(&av.Packet{IsVideo: true, TimeStamp: ts, Data: data}))
+	}
+
+	// the onMetaData of the encoder is not timed metadata
+	at.Nil(source.Write(&av.Packet{IsMetadata: true, Data: []byte{0x02}}))
+	video(0, flv.NewAVCSeqHeader(sps, pps))
+	video(0, flv.NewAVCNALU([][]byte{idr}, true, 0))
+	at.Nil(source.Write(&av.Packet{
+		IsMetadata: true,
+		TimeStamp:  500,
+		Header:     &av.TimedMetadata{Fields: map[string]string{"title": "Song"}},
+	}))
+	video(1100, flv.NewAVCNALU([][]byte{{0x41, 0x9a, 0x02}}, false, 0))
+	video(1200, flv.NewAVCNALU([][]byte{idr}, true, 0))
+
+	var body string
+	for i := 0; i < 100 && !strings.Contains(body, ".ts\n"); i++ {
+		time.Sleep(20 * time.Millisecond)
+		b, _ := source.GetCacheInc().GenM3U8PlayList()
+		body = string(b)
+	}
+	var segment []byte
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasSuffix(line, ".ts") {
+			item, err := source.GetCacheInc().GetItem(line)
+			at.Nil(err)
+			segment = item.Data
+		}
+	}
+	at.NotNil(segment, body)
+
+	tags := 0
+	for i := 0; i+188 <= len(segment); i += 188 {
+		pkt := segment[i : i+188]
+		if pkt[1]&0x1f == 0x01 && pkt[2] == 0x02 {
+			at.True(bytes.Contains(pkt, ts.NewID3(map[string]string{"title": "Song"})))
+			tags++
+		}
+	}
+	at.Equal(1, tags)
+}
+
+func TestBlockingPlaylist(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("hls.block_timeout", 200)
+	defer configure.Config.Set("hls.block_timeout", 0)
+
+	server := &Server{conns: &sync.Map{}}
+	source := server.GetWriter(av.Info{Key: "live/block"}).(*Source)
+	defer source.Close(nil)
+	publish := func(seq int) {
+		name := fmt.Sprintf("/live/block/%d.ts", seq)
+		source.tsCache.SetItem(name, NewTSItem(name, 3000, seq, []byte{0x47}))
+	}
+	publish(1)
+
+	// the next segment doesn't come, the current playlist is served
+	begin := time.Now()
+	w := httptest.NewRecorder()
+	server.handle(w, httptest.NewRequest("GET", "/live/block.m3u8?_HLS_msn=2", nil))
+	at.True(time.Since(begin) >= 200*time.Millisecond)
+	at.Equal(http.StatusOK, w.Code)
+	body := w.Body.String()
+	at.True(strings.HasPrefix(body, "#EXTM3U\n"), body)
+	at.Contains(body, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES\n")
+	at.Contains(body, "/live/block/1.ts\n")
+
+	// it does
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		publish(2)
+	}()
+	w = httptest.NewRecorder()
+	server.handle(w, httptest.NewRequest("GET", "/live/block.m3u8?_HLS_msn=2", nil))
+	at.Equal(http.StatusOK, w.Code)
+	at.Contains(w.Body.String(), "/live/block/2.ts\n")
+
+	// a client going away is let go at once
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	begin = time.Now()
+	w = httptest.NewRecorder()
+	server.handle(w, httptest.NewRequest("GET", "/live/block.m3u8?_HLS_msn=3", nil).WithContext(ctx))
+	at.True(time.Since(begin) < 100*time.Millisecond)
+	at.Empty(w.Body.String())
+
+	w = httptest.NewRecorder()
+	server.handle(w, httptest.NewRequest("GET", "/live/block.m3u8?_HLS_msn=9", nil))
+	at.Equal(http.StatusBadRequest, w.Code)
+}
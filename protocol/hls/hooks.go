@@ -0,0 +1,103 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/utils/worker"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	hookTimeout = 5 * time.Second
+)
+
+// Segment describes a segment the muxer just finalized, duration is in ms
+type Segment struct {
+	Key      string `json:"key"`
+	Name     string `json:"name"`
+	SeqNum   int    `json:"seq"`
+	Duration int    `json:"duration"`
+	Size     int    `json:"size"`
+}
+
+// segmentFn is a callback of OnSegment, its pointer tells it apart
+type segmentFn struct {
+	f func(Segment)
+}
+
+var (
+	hookClient  = &http.Client{Timeout: hookTimeout}
+	segmentLock sync.RWMutex
+	segmentFns  []*segmentFn
+)
+
+// OnSegment registers f to be called for every finalized segment, calls
+// for a stream are made in order from the shared worker pool. The returned
+// func unregisters f, the segments already handed to the pool still get it.
+func OnSegment(f func(Segment)) func() {
+	fn := &segmentFn{f: f}
+	segmentLock.Lock()
+	segmentFns = append(segmentFns, fn)
+	segmentLock.Unlock()
+	return func() {
+		segmentLock.Lock()
+		defer segmentLock.Unlock()
+		// notifySegment keeps reading the slice it got
+		fns := make([]*segmentFn, 0, len(segmentFns))
+		for _, registered := range segmentFns {
+			if registered != fn {
+				fns = append(fns, registered)
+			}
+		}
+		segmentFns = fns
+	}
+}
+
+// notifySegment hands seg to the callbacks and the hooks.on_segment
+// webhook without ever blocking the muxer
+func notifySegment(seg Segment) {
+	segmentLock.RLock()
+	fns := segmentFns
+	segmentLock.RUnlock()
+	url := configure.Config.GetString("hooks.on_segment")
+	if len(fns) == 0 && url == "" {
+		return
+	}
+
+	job := func() {
+		for _, fn := range fns {
+			fn.f(seg)
+		}
+		if url != "" {
+			if err := postSegment(url, seg); err != nil {
+				log.Warningf("[%s] on_segment hook error: %v", seg.Key, err)
+			}
+		}
+	}
+	if !worker.Shared().Submit(seg.Key, job) {
+		log.Warningf("[%s] on_segment hook queue full, dropping %s", seg.Key, seg.Name)
+	}
+}
+
+func postSegment(url string, seg Segment) error {
+	b, err := json.Marshal(seg)
+	if err != nil {
+		return err
+	}
+	resp, err := hookClient.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
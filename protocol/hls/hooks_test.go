@@ -0,0 +1,63 @@
+package hls
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegmentHook(t *testing.T) {
+	at := assert.New(t)
+
+	// the segments of the other tests may be posted too
+	posted := make(chan Segment, 3)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var seg Segment
+		at.Nil(json.NewDecoder(r.Body).Decode(&seg))
+		if seg.Key == "live/hooks" {
+			posted <- seg
+		}
+	}))
+	defer ts.Close()
+	url := configure.Config.GetString("hooks.on_segment")
+	configure.Config.Set("hooks.on_segment", ts.URL)
+	defer configure.Config.Set("hooks.on_segment", url)
+
+	called := make(chan Segment, 3)
+	unregister := OnSegment(func(seg Segment) {
+		if seg.Key == "live/hooks" {
+			called <- seg
+		}
+	})
+	defer unregister()
+
+	// a synthetic stream, each cut after 3s of media closes a segment
+	source := NewSource(av.Info{Key: "live/hooks"})
+	defer source.Close(nil)
+	source.cut(0)
+	for i := 1; i <= 3; i++ {
+		source.stat.update(true, uint32((i-1)*3000))
+		source.stat.update(true, uint32(i*3000))
+		source.cut(uint32(i * 3000))
+	}
+
+	for i := 1; i <= 3; i++ {
+		for _, ch := range []chan Segment{called, posted} {
+			select {
+			case seg := <-ch:
+				at.Equal("live/hooks", seg.Key)
+				at.Equal(i, seg.SeqNum)
+				at.Equal(3000, seg.Duration)
+				at.True(seg.Size > 0)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("segment %d hook not fired", i)
+			}
+		}
+	}
+}
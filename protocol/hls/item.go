@@ -1,12 +1,21 @@
 package hls
 
+// TSItem is a finished segment, it is built in a buffer of the Source and
+// only handed to the cache once complete. Data is never written again, so
+// readers share it without copying
 type TSItem struct {
 	Name     string
 	SeqNum   int
 	Duration int
+	Start    int // ms since the first segment of the stream
 	Data     []byte
+
+	// Discontinuity is set on the first segment after a change of the
+	// encoding parameters, such as a new aac sequence header
+	Discontinuity bool
 }
 
+// NewTSItem copies b, the buffer it was built in is reused for the next one
 func NewTSItem(name string, duration, seqNum int, b []byte) TSItem {
 	var item TSItem
 	item.Name = name
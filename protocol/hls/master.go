@@ -0,0 +1,114 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/amf"
+)
+
+// masterPrefix serves the master playlist of a group of rooms at
+// /hls/GROUP/master.m3u8
+const masterPrefix = "/hls/"
+
+// parseMaster returns the group of a /hls/GROUP/master.m3u8 path
+func parseMaster(pathstr string) (group string, ok bool) {
+	if !strings.HasPrefix(pathstr, masterPrefix) || !strings.HasSuffix(pathstr, "/master.m3u8") {
+		return "", false
+	}
+	group = strings.TrimSuffix(strings.TrimPrefix(pathstr, masterPrefix), "/master.m3u8")
+	if group == "" || strings.Contains(group, "/") {
+		return "", false
+	}
+	return group, true
+}
+
+// groupRenditions lists the APP/ROOM keys of group from hls.groups,
+// viper lower cases the group names
+func groupRenditions(group string) []string {
+	return configure.Config.GetStringMapStringSlice("hls.groups")[strings.ToLower(group)]
+}
+
+// setResolution keeps the frame size announced by the onMetaData of the
+// encoder, the timed metadata injected mid stream carries none
+func (source *Source) setResolution(p *av.Packet) {
+	if _, ok := p.Header.(*av.TimedMetadata); ok {
+		return
+	}
+	vs, _ := (&amf.Decoder{}).DecodeBatch(bytes.NewReader(p.Data), amf.AMF0)
+	for _, v := range vs {
+		meta, ok := v.(amf.Object)
+		if !ok {
+			continue
+		}
+		width, _ := meta["width"].(float64)
+		height, _ := meta["height"].(float64)
+		if width > 0 && height > 0 {
+			atomic.StoreUint32(&source.width, uint32(width))
+			atomic.StoreUint32(&source.height, uint32(height))
+		}
+	}
+}
+
+// Resolution is the frame size from the onMetaData of the encoder, zero
+// when it did not send one
+func (source *Source) Resolution() (width, height int) {
+	return int(atomic.LoadUint32(&source.width)), int(atomic.LoadUint32(&source.height))
+}
+
+// bandwidth is the peak and the average bit rate of the segments in the
+// live window, zero before the first segment
+func (tcCacheItem *TSCacheItem) bandwidth() (peak, average int) {
+	tcCacheItem.lock.RLock()
+	defer tcCacheItem.lock.RUnlock()
+	var window, size int
+	for e := tcCacheItem.liveFront(); e != nil; e = e.Next() {
+		v, ok := tcCacheItem.lm[e.Value.(string)]
+		if !ok || v.Duration <= 0 {
+			continue
+		}
+		if rate := len(v.Data) * 8 * 1000 / v.Duration; rate > peak {
+			peak = rate
+		}
+		window += v.Duration
+		size += len(v.Data)
+	}
+	if window > 0 {
+		average = size * 8 * 1000 / window
+	}
+	return
+}
+
+// GenMasterPlayList lists the live rooms of group as variants of one
+// master playlist, each room being a rendition pushed by the publisher.
+// The bandwidths are measured on the segments served, not transcoded.
+func (server *Server) GenMasterPlayList(group string) ([]byte, error) {
+	variants := bytes.NewBuffer(nil)
+	for _, key := range groupRenditions(group) {
+		conn := server.getConn(key)
+		if conn == nil {
+			continue
+		}
+		peak, average := conn.GetCacheInc().bandwidth()
+		if peak == 0 {
+			continue
+		}
+		fmt.Fprintf(variants, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d", peak, average)
+		if width, height := conn.Resolution(); width > 0 {
+			fmt.Fprintf(variants, ",RESOLUTION=%dx%d", width, height)
+		}
+		fmt.Fprintf(variants, "\n/%s.m3u8\n", key)
+	}
+	if variants.Len() == 0 {
+		return nil, ErrNoPublisher
+	}
+
+	w := bytes.NewBuffer(nil)
+	fmt.Fprint(w, "#EXTM3U\n#EXT-X-VERSION:3\n")
+	w.Write(variants.Bytes())
+	return w.Bytes(), nil
+}
@@ -0,0 +1,62 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/amf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMasterPlayList(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("hls.groups", map[string][]string{
+		"event": {"live/event_high", "live/event_low", "live/event_offline"},
+	})
+	defer configure.Config.Set("hls.groups", nil)
+
+	server := &Server{conns: &sync.Map{}}
+	for key, size := range map[string]int{"live/event_high": 3000, "live/event_low": 750} {
+		source := server.GetWriter(av.Info{Key: key}).(*Source)
+		defer source.Close(nil)
+		for i := 1; i <= 2; i++ {
+			name := fmt.Sprintf("/%s/%d.ts", key, i)
+			// the second segment is twice the size of the first
+			source.tsCache.SetItem(name, NewTSItem(name, 2000, i, make([]byte, size*i)))
+		}
+	}
+
+	b := bytes.NewBuffer(nil)
+	(&amf.Encoder{}).EncodeAmf0String(b, "onMetaData", true)
+	(&amf.Encoder{}).EncodeAmf0EcmaArray(b, amf.Object{"width": 1280.0, "height": 720.0}, true)
+	server.getConn("live/event_high").setResolution(&av.Packet{IsMetadata: true, Data: b.Bytes()})
+
+	w := httptest.NewRecorder()
+	server.handle(w, httptest.NewRequest("GET", "/hls/event/master.m3u8", nil))
+	at.Equal(http.StatusOK, w.Code)
+	at.Equal("application/x-mpegURL", w.Header().Get("Content-Type"))
+	at.Equal("#EXTM3U\n#EXT-X-VERSION:3\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=24000,AVERAGE-BANDWIDTH=18000,RESOLUTION=1280x720\n/live/event_high.m3u8\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=6000,AVERAGE-BANDWIDTH=4500\n/live/event_low.m3u8\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	server.handle(w, httptest.NewRequest("GET", "/hls/unknown/master.m3u8", nil))
+	at.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestParseMaster(t *testing.T) {
+	at := assert.New(t)
+	group, ok := parseMaster("/hls/event/master.m3u8")
+	at.True(ok)
+	at.Equal("event", group)
+	_, ok = parseMaster("/live/event/master.m3u8")
+	at.False(ok)
+	_, ok = parseMaster("/hls/a/b/master.m3u8")
+	at.False(ok)
+}
@@ -0,0 +1,110 @@
+package hls
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+	"github.com/SpooderfyBot/live/utils/worker"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPersistRetries = 3
+	defaultPersistBackoff = 100 * time.Millisecond
+)
+
+// segmentWriter stores the finished segments outside of the memory cache
+type segmentWriter interface {
+	WriteSegment(name string, data []byte) error
+}
+
+// dirWriter writes a segment under the directory at its path, such as
+// DIR/live/movie/1600000000_1.ts
+type dirWriter string
+
+func (dir dirWriter) WriteSegment(name string, data []byte) error {
+	path := filepath.Join(string(dir), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// persister copies the segments of a stream to hls.persist.dir from the
+// worker pool, the playlist is always served from memory. A failed write
+// is retried hls.persist.retries times, waiting hls.persist.backoff ms
+// doubling each time, after which the stream is kept in memory only.
+type persister struct {
+	key      string
+	w        segmentWriter
+	retries  int
+	backoff  time.Duration
+	disabled int32
+	failures uint64
+}
+
+// newPersister is nil when hls.persist.dir is unset
+func newPersister(key string) *persister {
+	dir := configure.Config.GetString("hls.persist.dir")
+	if dir == "" {
+		return nil
+	}
+	p := &persister{
+		key:     key,
+		w:       dirWriter(dir),
+		retries: defaultPersistRetries,
+		backoff: defaultPersistBackoff,
+	}
+	if configure.Config.IsSet("hls.persist.retries") {
+		p.retries = configure.Config.GetInt("hls.persist.retries")
+	}
+	if ms := configure.Config.GetInt("hls.persist.backoff"); ms > 0 {
+		p.backoff = time.Duration(ms) * time.Millisecond
+	}
+	return p
+}
+
+// persist queues the write of item, the muxer never waits for the disk
+func (p *persister) persist(item TSItem) {
+	if p == nil || atomic.LoadInt32(&p.disabled) == 1 {
+		return
+	}
+	if !worker.Shared().Submit(p.key, func() { p.write(item) }) {
+		atomic.AddUint64(&p.failures, 1)
+		log.Warningf("[%s] hls write queue full, %s only kept in memory", p.key, item.Name)
+	}
+}
+
+func (p *persister) write(item TSItem) {
+	backoff := p.backoff
+	for attempt := 0; atomic.LoadInt32(&p.disabled) == 0; attempt++ {
+		err := p.w.WriteSegment(item.Name, item.Data)
+		if err == nil {
+			return
+		}
+		atomic.AddUint64(&p.failures, 1)
+		events.Emit(p.key, events.HLSWriteFail, item.Name+": "+err.Error())
+		if attempt >= p.retries {
+			atomic.StoreInt32(&p.disabled, 1)
+			log.Errorf("[%s] hls segment %s not written: %v, the stream is kept in memory only", p.key, item.Name, err)
+			return
+		}
+		log.Warningf("[%s] hls segment %s write error: %v, retrying in %v", p.key, item.Name, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Failures is the number of failed segment writes
+func (p *persister) Failures() uint64 {
+	if p == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&p.failures)
+}
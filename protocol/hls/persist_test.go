@@ -0,0 +1,112 @@
+package hls
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingWriter fails every write
+type failingWriter struct {
+	lock  sync.Mutex
+	calls int
+}
+
+func (w *failingWriter) WriteSegment(string, []byte) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.calls++
+	return fmt.Errorf("no space left on device")
+}
+
+func (w *failingWriter) count() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.calls
+}
+
+func TestPersistFailure(t *testing.T) {
+	at := assert.New(t)
+	begin := time.Now()
+
+	source := NewSource(av.Info{Key: "live/persist_fail"})
+	defer source.Close(nil)
+	writer := &failingWriter{}
+	source.persist = &persister{key: "live/persist_fail", w: writer, retries: 2, backoff: time.Millisecond}
+
+	source.cut(0)
+	for i := 1; i <= 3; i++ {
+		source.stat.update(true, uint32((i-1)*3000))
+		source.stat.update(true, uint32(i*3000))
+		source.cut(uint32(i * 3000))
+		if i == 1 {
+			// the first segment is tried three times before the disk is given up
+			at.True(waitFor(func() bool { return source.WriteFailures() == 3 }))
+		}
+	}
+
+	// the stream goes on from memory, the disk isn't tried anymore
+	body, _ := source.GetCacheInc().GenM3U8PlayList()
+	at.Equal(3, strings.Count(string(body), ".ts\n"), string(body))
+	time.Sleep(50 * time.Millisecond)
+	at.Equal(3, writer.count())
+	at.Equal(uint64(3), source.WriteFailures())
+
+	var failures int
+	for _, e := range events.Get("live/persist_fail") {
+		if e.Type == events.HLSWriteFail && !e.Time.Before(begin) {
+			failures++
+		}
+	}
+	at.Equal(3, failures)
+}
+
+func TestPersistDir(t *testing.T) {
+	at := assert.New(t)
+	dir, err := ioutil.TempDir("", "hls")
+	at.Nil(err)
+	defer os.RemoveAll(dir)
+
+	source := NewSource(av.Info{Key: "live/persist"})
+	defer source.Close(nil)
+	source.persist = &persister{key: "live/persist", w: dirWriter(dir), retries: defaultPersistRetries, backoff: defaultPersistBackoff}
+	source.cut(0)
+	source.stat.update(true, 0)
+	source.stat.update(true, 3000)
+	source.cut(3000)
+
+	body, _ := source.GetCacheInc().GenM3U8PlayList()
+	var name string
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.HasSuffix(line, ".ts") {
+			name = line
+		}
+	}
+	item, err := source.GetCacheInc().GetItem(name)
+	at.Nil(err)
+	at.True(waitFor(func() bool {
+		b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		return err == nil && string(b) == string(item.Data)
+	}))
+	at.Equal(uint64(0), source.WriteFailures())
+}
+
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
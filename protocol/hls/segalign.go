@@ -0,0 +1,61 @@
+package hls
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/SpooderfyBot/live/configure"
+)
+
+// jitterMs is the slack allowed to the key frame timestamps of a publisher
+// before a key frame is considered off the cadence of its gop
+const jitterMs = 100
+
+// segmentAligner cuts the segments of hls.align_segments on the key frames
+// crossing a multiple of the segment duration of the publisher timeline, so
+// the renditions of one encoder start their segments on the same frames
+type segmentAligner struct {
+	info     string
+	duration int64
+	lastKey  int64
+	hasKey   bool
+	warned   bool
+}
+
+func newSegmentAligner(info string, duration int64) *segmentAligner {
+	if !configure.Config.GetBool("hls.align_segments") {
+		return nil
+	}
+	return &segmentAligner{info: info, duration: duration}
+}
+
+// keyFrame reports whether the key frame at ts starts a new segment, the
+// current one having started at segStart
+func (a *segmentAligner) keyFrame(ts, segStart int64) bool {
+	if a.hasKey {
+		a.checkGop(ts - a.lastKey)
+	}
+	a.hasKey = true
+	a.lastKey = ts
+	return a.boundary(ts) > a.boundary(segStart)
+}
+
+// boundary is the index of the last segment boundary at or before ts, a
+// key frame arriving slightly early still counts for the boundary after it
+func (a *segmentAligner) boundary(ts int64) int64 {
+	return (ts + jitterMs) / a.duration
+}
+
+// checkGop warns once when the gop of the publisher cannot fit a whole
+// number of times in the segment duration, the renditions then cut on
+// whichever key frame follows the boundary and may not line up
+func (a *segmentAligner) checkGop(gop int64) {
+	if a.warned || gop <= jitterMs {
+		return
+	}
+	r := a.duration % gop
+	if gop <= a.duration+jitterMs && (r <= jitterMs || gop-r <= jitterMs) {
+		return
+	}
+	a.warned = true
+	log.Warningf("[%v] gop of %dms can not be aligned on segments of %dms", a.info, gop, a.duration)
+}
@@ -0,0 +1,43 @@
+package hls
+
+import (
+	"testing"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegmentAligner(t *testing.T) {
+	at := assert.New(t)
+	at.Nil(newSegmentAligner("live/off", 2000))
+	configure.Config.Set("hls.align_segments", true)
+	defer configure.Config.Set("hls.align_segments", false)
+
+	// a key frame every second, a few ms off, from 500ms on
+	a := newSegmentAligner("live/aligned", 2000)
+	var cuts []int64
+	segStart := int64(500)
+	a.keyFrame(segStart, segStart)
+	for _, ts := range []int64{1502, 2497, 3500, 4503, 5498, 6500, 7501} {
+		if a.keyFrame(ts, segStart) {
+			cuts = append(cuts, ts)
+			segStart = ts
+		}
+	}
+	// the cuts follow the 2000ms boundaries, not 2000ms after 500
+	at.Equal([]int64{2497, 4503, 6500}, cuts)
+	at.False(a.warned)
+
+	// a gop of 1500ms does not fit in 2000ms segments
+	a = newSegmentAligner("live/misaligned", 2000)
+	for _, ts := range []int64{0, 1500, 3000} {
+		a.keyFrame(ts, 0)
+	}
+	at.True(a.warned)
+
+	// neither does one longer than the segments
+	a = newSegmentAligner("live/long", 2000)
+	a.keyFrame(0, 0)
+	a.keyFrame(4000, 0)
+	at.True(a.warned)
+}
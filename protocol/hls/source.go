@@ -3,9 +3,12 @@ package hls
 import (
 	"bytes"
 	"fmt"
-	"github.com/SpooderfyBot/live/configure"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/SpooderfyBot/live/configure"
+
 	"github.com/SpooderfyBot/live/av"
 	"github.com/SpooderfyBot/live/container/flv"
 	"github.com/SpooderfyBot/live/container/ts"
@@ -22,49 +25,76 @@ const (
 	h264_default_hz uint64 = 90
 )
 
+// resumeMarker is queued between the packets of a publisher and those of
+// the one resuming the stream after it
+var resumeMarker = &av.Packet{}
+
 type Source struct {
 	av.RWBaser
-	seq         int
-	info        av.Info
-	bwriter     *bytes.Buffer
-	btswriter   *bytes.Buffer
-	demuxer     *flv.Demuxer
-	muxer       *ts.Muxer
-	pts, dts    uint64
-	stat        *status
-	align       *align
-	cache       *audioCache
-	tsCache     *TSCacheItem
-	tsparser    *parser.CodecParser
-	closed      bool
-	packetQueue chan *av.Packet
+	seq             int
+	elapsed         int
+	segmentDuration int64
+	info            av.Info
+	bwriter         *bytes.Buffer
+	btswriter       *bytes.Buffer
+	demuxer         *flv.Demuxer
+	muxer           *ts.Muxer
+	pts, dts        uint64
+	stat            *status
+	align           *align
+	aligner         *segmentAligner
+	cache           *audioCache
+	tsCache         *TSCacheItem
+	persist         *persister // nil when the segments stay in memory
+	tsparser        *parser.CodecParser
+	aacConfig       []byte
+	avcConfig       []byte
+	discontinuity   bool
+	width, height   uint32       // set from the onMetaData of the encoder
+	unsupported     atomic.Value // *CodecError, once a codec can't be muxed
+	closed          int32        // 1 once closed, written by Close and the sender
+	stopOnce        sync.Once
+	stop            chan struct{} // closed to have the sender free the segments
+	packetQueue     chan *av.Packet
 }
 
 func NewSource(info av.Info) *Source {
 	info.Inter = true
 	s := &Source{
-		info:        info,
-		align:       &align{},
-		stat:        newStatus(),
-		RWBaser:     av.NewRWBaser(time.Second * 10),
-		cache:       newAudioCache(),
-		demuxer:     flv.NewDemuxer(),
-		muxer:       ts.NewMuxer(),
-		tsCache:     NewTSCacheItem(info.Key),
-		tsparser:    parser.NewCodecParser(),
-		bwriter:     bytes.NewBuffer(make([]byte, 100*1024)),
-		packetQueue: make(chan *av.Packet, maxQueueNum),
+		info:            info,
+		align:           &align{},
+		stat:            newStatus(),
+		RWBaser:         av.NewRWBaser(time.Second * 10),
+		cache:           newAudioCache(),
+		demuxer:         flv.NewDemuxer(),
+		muxer:           ts.NewMuxer(),
+		tsCache:         NewTSCacheItem(info.Key),
+		persist:         newPersister(info.Key),
+		segmentDuration: segmentDuration(),
+		tsparser:        parser.NewCodecParser(),
+		bwriter:         bytes.NewBuffer(make([]byte, 100*1024)),
+		stop:            make(chan struct{}),
+		packetQueue:     make(chan *av.Packet, maxQueueNum),
 	}
+	s.aligner = newSegmentAligner(info.String(), s.segmentDuration)
+	s.muxer.EnableID3()
 	go func() {
 		err := s.SendPacket()
 		if err != nil {
 			log.Debug("send packet error: ", err)
-			s.closed = true
+			atomic.StoreInt32(&s.closed, 1)
 		}
+		// the writers belong to this goroutine, it frees them once told to
+		<-s.stop
+		s.free()
 	}()
 	return s
 }
 
+func (source *Source) isClosed() bool {
+	return atomic.LoadInt32(&source.closed) == 1
+}
+
 func (source *Source) GetCacheInc() *TSCacheItem {
 	return source.tsCache
 }
@@ -99,22 +129,22 @@ func (source *Source) DropPacket(pktQue chan *av.Packet, info av.Info) {
 
 func (source *Source) Write(p *av.Packet) (err error) {
 	err = nil
-	if source.closed {
+	if source.isClosed() {
 		err = fmt.Errorf("hls source closed")
 		return
 	}
 	source.SetPreTime()
-	defer func() {
-		if e := recover(); e != nil {
-			err = fmt.Errorf("hls source has already been closed:%v", e)
-		}
-	}()
 	if len(source.packetQueue) >= maxQueueNum-24 {
 		source.DropPacket(source.packetQueue, source.info)
-	} else {
-		if !source.closed {
-			source.packetQueue <- p
-		}
+		return
+	}
+	// the demuxer strips the tag header, the packets sent from the gop
+	// cache are shared with the other writers
+	cp := *p
+	select {
+	case source.packetQueue <- &cp:
+	case <-source.stop:
+		err = fmt.Errorf("hls source closed")
 	}
 	return
 }
@@ -129,41 +159,69 @@ func (source *Source) SendPacket() error {
 
 	log.Debugf("[%v] hls sender start", source.info)
 	for {
-		if source.closed {
+		if source.isClosed() {
 			return fmt.Errorf("closed")
 		}
 
-		p, ok := <-source.packetQueue
-		if ok {
-			if p.IsMetadata {
-				continue
-			}
+		var p *av.Packet
+		select {
+		case p = <-source.packetQueue:
+		case <-source.stop:
+			return fmt.Errorf("closed")
+		}
+		if source.Unsupported() != nil {
+			continue
+		}
+		if p == resumeMarker {
+			source.publisherResumed()
+			continue
+		}
+		if p.IsMetadata {
+			source.setResolution(p)
+			source.muxMetadata(p)
+			continue
+		}
 
-			err := source.demuxer.Demux(p)
-			if err == flv.ErrAvcEndSEQ {
-				log.Warning(err)
-				continue
-			} else {
-				if err != nil {
-					log.Warning(err)
-					return err
-				}
-			}
-			compositionTime, isSeq, err := source.parse(p)
+		if e := checkCodec(p); e != nil {
+			source.exclude(e)
+			continue
+		}
+		err := source.demuxer.Demux(p)
+		if err == flv.ErrAvcEndSEQ {
+			log.Warning(err)
+			continue
+		} else {
 			if err != nil {
 				log.Warning(err)
+				return err
 			}
-			if err != nil || isSeq {
-				continue
-			}
-			if source.btswriter != nil {
-				source.stat.update(p.IsVideo, p.TimeStamp)
-				source.calcPtsDts(p.IsVideo, p.TimeStamp, uint32(compositionTime))
-				source.tsMux(p)
-			}
-		} else {
-			return fmt.Errorf("closed")
 		}
+		compositionTime, isSeq, err := source.parse(p)
+		if err != nil {
+			log.Warning(err)
+		}
+		if err != nil || isSeq {
+			continue
+		}
+		if source.btswriter != nil {
+			source.stat.update(p.IsVideo, p.TimeStamp)
+			source.calcPtsDts(p.IsVideo, p.TimeStamp, uint32(compositionTime))
+			source.tsMux(p)
+		}
+	}
+}
+
+// PublisherResumed is called by the stream when a publisher comes back
+// within rtmp.publish_grace, its timestamps carry on from a different
+// point than the ones of the last publisher
+func (source *Source) PublisherResumed() {
+	if source.isClosed() {
+		return
+	}
+	select {
+	case source.packetQueue <- resumeMarker:
+	case <-source.stop:
+		log.Debug("hls source closed before the publisher resumed")
 	}
 }
 
@@ -171,36 +229,73 @@ func (source *Source) Info() (ret av.Info) {
 	return source.info
 }
 
+// cleanup stops the source and has its sender free the segments, it is
+// safe to call it both from Close and from the server sweeper
 func (source *Source) cleanup() {
-	close(source.packetQueue)
+	atomic.StoreInt32(&source.closed, 1)
+	source.stopOnce.Do(func() {
+		close(source.stop)
+	})
+}
+
+// free drops the buffers of a stopped source, only the sender calls it
+func (source *Source) free() {
 	source.bwriter = nil
 	source.btswriter = nil
 	source.cache = nil
-	source.tsCache = nil
+	// keep the cache around for readers still holding it, just empty it
+	source.tsCache.Clear()
 }
 
 func (source *Source) Close(err error) {
 	log.Debug("hls source closed: ", source.info)
-	if !source.closed && !configure.Config.GetBool("hls_keep_after_end") {
+	if !configure.Config.GetBool("hls_keep_after_end") {
 		source.cleanup()
 	}
-	source.closed = true
+	atomic.StoreInt32(&source.closed, 1)
 }
 
-func (source *Source) cut() {
+// exclude stops the muxing of a stream with a codec the segments can't
+// carry, rather than making a playlist of broken segments
+func (source *Source) exclude(err *CodecError) {
+	log.Warningf("[%v] %v, its hls is skipped", source.info, err)
+	source.unsupported.Store(err)
+	source.btswriter = nil
+	source.tsCache.Clear()
+}
+
+// Unsupported is the codec that keeps the stream out of hls, nil when it
+// is muxed
+func (source *Source) Unsupported() error {
+	if err, ok := source.unsupported.Load().(*CodecError); ok {
+		return err
+	}
+	return nil
+}
+
+// SegmentCount is the number of segments held in memory
+// WriteFailures is the number of segment writes to hls.persist.dir that
+// failed
+func (source *Source) WriteFailures() uint64 {
+	return source.persist.Failures()
+}
+
+func (source *Source) SegmentCount() int {
+	return source.tsCache.Len()
+}
+
+// cut is called on each key frame at ts, it starts a new segment once the
+// current one is long enough or, with hls.align_segments, once ts crosses a
+// segment boundary
+func (source *Source) cut(ts uint32) {
 	newf := true
 	if source.btswriter == nil {
 		source.btswriter = bytes.NewBuffer(nil)
-	} else if source.btswriter != nil && source.stat.durationMs() >= duration {
-		source.flushAudio()
-
-		source.seq++
-		filename := fmt.Sprintf("/%s/%d.ts", source.info.Key, time.Now().Unix())
-		item := NewTSItem(filename, int(source.stat.durationMs()), source.seq, source.btswriter.Bytes())
-		source.tsCache.SetItem(filename, item)
-
-		source.btswriter.Reset()
-		source.stat.resetAndNew()
+		if source.aligner != nil {
+			source.aligner.keyFrame(int64(ts), int64(ts))
+		}
+	} else if source.endsSegment(int64(ts)) {
+		source.flushSegment()
 	} else {
 		newf = false
 	}
@@ -210,6 +305,78 @@ func (source *Source) cut() {
 	}
 }
 
+func (source *Source) endsSegment(ts int64) bool {
+	if source.aligner == nil {
+		return source.stat.durationMs() >= source.segmentDuration
+	}
+	cut := source.aligner.keyFrame(ts, source.stat.firstTimestamp)
+	return cut && source.stat.hasSetFirstTs
+}
+
+// flushSegment ends the current segment and hands it to the cache
+func (source *Source) flushSegment() {
+	source.flushAudio()
+
+	source.seq++
+	// the seq keeps names unique when segments are shorter than a second
+	filename := fmt.Sprintf("/%s/%d_%d.ts", source.info.Key, time.Now().Unix(), source.seq)
+	item := NewTSItem(filename, int(source.stat.durationMs()), source.seq, source.btswriter.Bytes())
+	item.Start = source.elapsed
+	item.Discontinuity = source.discontinuity
+	source.discontinuity = false
+	source.elapsed += item.Duration
+	source.tsCache.SetItem(filename, item)
+	source.persist.persist(item)
+	notifySegment(Segment{
+		Key:      source.info.Key,
+		Name:     filename,
+		SeqNum:   item.SeqNum,
+		Duration: item.Duration,
+		Size:     len(item.Data),
+	})
+
+	source.btswriter.Reset()
+	source.stat.resetAndNew()
+}
+
+// audioChanged is called when the publisher sends an aac sequence header
+// different from the one in use, players have to reset their audio
+// decoder.
+func (source *Source) audioChanged() {
+	log.Infof("[%v] aac sequence header changed", source.info)
+	source.breakSegment()
+}
+
+// videoChanged is called when the publisher sends an avc sequence header
+// different from the one in use, such as after a resolution change,
+// players have to reset their video decoder.
+func (source *Source) videoChanged() {
+	log.Infof("[%v] avc sequence header changed", source.info)
+	source.breakSegment()
+}
+
+// publisherResumed is called between the packets of a publisher and the
+// ones of the publisher resuming the stream, players have to reset their
+// timeline.
+func (source *Source) publisherResumed() {
+	log.Infof("[%v] publisher resumed", source.info)
+	source.breakSegment()
+}
+
+// breakSegment puts the frames cached so far in a segment of their own,
+// the next one is marked as a discontinuity.
+func (source *Source) breakSegment() {
+	if source.btswriter == nil {
+		return
+	}
+	if source.stat.hasSetFirstTs {
+		source.flushSegment()
+		source.btswriter.Write(source.muxer.PAT())
+		source.btswriter.Write(source.muxer.PMT(av.SOUND_AAC, true))
+	}
+	source.discontinuity = true
+}
+
 func (source *Source) parse(p *av.Packet) (int32, bool, error) {
 	var compositionTime int32
 	var ah av.AudioPacketHeader
@@ -221,6 +388,11 @@ func (source *Source) parse(p *av.Packet) (int32, bool, error) {
 		}
 		compositionTime = vh.CompositionTime()
 		if vh.IsKeyFrame() && vh.IsSeq() {
+			if source.avcConfig != nil && !bytes.Equal(source.avcConfig, p.Data) {
+				// frames still cached use the old config, mux them first
+				source.videoChanged()
+			}
+			source.avcConfig = append(source.avcConfig[:0], p.Data...)
 			return compositionTime, true, source.tsparser.Parse(p, source.bwriter)
 		}
 	} else {
@@ -229,6 +401,11 @@ func (source *Source) parse(p *av.Packet) (int32, bool, error) {
 			return compositionTime, false, ErrNoSupportAudioCodec
 		}
 		if ah.AACPacketType() == av.AAC_SEQHDR {
+			if source.aacConfig != nil && !bytes.Equal(source.aacConfig, p.Data) {
+				// frames still cached use the old config, mux them first
+				source.audioChanged()
+			}
+			source.aacConfig = append(source.aacConfig[:0], p.Data...)
 			return compositionTime, true, source.tsparser.Parse(p, source.bwriter)
 		}
 	}
@@ -239,7 +416,7 @@ func (source *Source) parse(p *av.Packet) (int32, bool, error) {
 	p.Data = source.bwriter.Bytes()
 
 	if p.IsVideo && vh.IsKeyFrame() {
-		source.cut()
+		source.cut(p.TimeStamp)
 	}
 	return compositionTime, false, nil
 }
@@ -269,6 +446,20 @@ func (source *Source) muxAudio(limit byte) error {
 	return source.muxer.Mux(&p, source.btswriter)
 }
 
+// muxMetadata writes the metadata injected into the stream as an ID3 tag of
+// the segment, the onMetaData of the encoder is left out
+func (source *Source) muxMetadata(p *av.Packet) error {
+	meta, ok := p.Header.(*av.TimedMetadata)
+	if !ok || source.btswriter == nil {
+		return nil
+	}
+	return source.muxer.Mux(&av.Packet{
+		IsMetadata: true,
+		TimeStamp:  p.TimeStamp,
+		Data:       ts.NewID3(meta.Fields),
+	}, source.btswriter)
+}
+
 func (source *Source) tsMux(p *av.Packet) error {
 	if p.IsVideo {
 		return source.muxer.Mux(p, source.btswriter)
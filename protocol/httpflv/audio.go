@@ -0,0 +1,245 @@
+package httpflv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/parser/aac"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/utils/uid"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// audioPrefix serves the audio track of a room as ADTS at
+// /audio/APP/NAME.aac, for players that take a plain aac stream
+const audioPrefix = "/audio/"
+
+// audioTagHeaderLen is the sound format byte and the aac packet type
+const audioTagHeaderLen = 2
+
+// AudioWriter sends the aac frames of a stream wrapped in ADTS headers,
+// the video and the metadata are dropped
+type AudioWriter struct {
+	Uid string
+	av.RWBaser
+	app, title, url string
+	remoteAddr      string
+	closed          int32 // 1 once closed, written by Close and the sender
+	closeOnce       sync.Once
+	closedChan      chan struct{}
+	sent            chan struct{} // closed once the sender stopped writing
+	ctx             http.ResponseWriter
+	packetQueue     chan *av.Packet
+	parser          *aac.Parser
+	buf             bytes.Buffer
+}
+
+func NewAudioWriter(app, title, url string, ctx http.ResponseWriter) *AudioWriter {
+	ret := &AudioWriter{
+		Uid:         uid.NewId(),
+		app:         app,
+		title:       title,
+		url:         url,
+		ctx:         ctx,
+		RWBaser:     av.NewRWBaser(time.Second * 10),
+		closedChan:  make(chan struct{}),
+		sent:        make(chan struct{}),
+		packetQueue: make(chan *av.Packet, maxQueueNum),
+		parser:      aac.NewParser(),
+	}
+	go func() {
+		defer close(ret.sent)
+		err := ret.SendPacket()
+		if err != nil {
+			log.Debug("SendPacket error: ", err)
+			ret.Close(err)
+		}
+	}()
+	return ret
+}
+
+func (audioWriter *AudioWriter) Write(p *av.Packet) (err error) {
+	if audioWriter.isClosed() {
+		return fmt.Errorf("audiowrite source closed")
+	}
+	if !p.IsAudio || p.IsMetadata {
+		return nil
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("AudioWriter has already been closed:%v", e)
+		}
+	}()
+
+	// a listener falling behind loses frames, never the config they need
+	if len(audioWriter.packetQueue) >= maxQueueNum-24 && !isSeqHeader(p) {
+		return nil
+	}
+	audioWriter.packetQueue <- p
+	return
+}
+
+func (audioWriter *AudioWriter) SendPacket() error {
+	for {
+		p, ok := <-audioWriter.packetQueue
+		if !ok || audioWriter.isClosed() {
+			return fmt.Errorf("closed")
+		}
+		audioWriter.RWBaser.SetPreTime()
+		ah, ok := p.Header.(av.AudioPacketHeader)
+		if !ok || ah.SoundFormat() != av.SOUND_AAC || len(p.Data) <= audioTagHeaderLen {
+			continue
+		}
+		audioWriter.RWBaser.RecTimeStamp(p.TimeStamp+audioWriter.BaseTimeStamp(), av.TAG_AUDIO)
+		audioWriter.buf.Reset()
+		err := audioWriter.parser.Parse(p.Data[audioTagHeaderLen:], ah.AACPacketType(), &audioWriter.buf)
+		if err != nil {
+			// frames ahead of the sequence header can't be framed
+			log.Debug("adts error: ", err)
+			continue
+		}
+		if audioWriter.buf.Len() == 0 {
+			continue
+		}
+		if _, err := audioWriter.ctx.Write(audioWriter.buf.Bytes()); err != nil {
+			return err
+		}
+		if f, ok := audioWriter.ctx.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// Wait blocks until the writer is closed or the client went away,
+// in which case the writer is closed so the stream drops it. Like the
+// flv writer it returns once the sender is done with the response writer.
+func (audioWriter *AudioWriter) Wait(ctx context.Context) {
+	select {
+	case <-audioWriter.closedChan:
+	case <-ctx.Done():
+		audioWriter.Close(ctx.Err())
+	}
+	<-audioWriter.sent
+}
+
+func (audioWriter *AudioWriter) isClosed() bool {
+	return atomic.LoadInt32(&audioWriter.closed) == 1
+}
+
+func (audioWriter *AudioWriter) Close(error) {
+	log.Debug("http audio closed")
+	audioWriter.closeOnce.Do(func() {
+		atomic.StoreInt32(&audioWriter.closed, 1)
+		close(audioWriter.packetQueue)
+		close(audioWriter.closedChan)
+	})
+}
+
+func (audioWriter *AudioWriter) IsPlayer() bool {
+	return true
+}
+
+func (audioWriter *AudioWriter) RemoteAddr() string {
+	return audioWriter.remoteAddr
+}
+
+func (audioWriter *AudioWriter) Info() (ret av.Info) {
+	ret.UID = audioWriter.Uid
+	ret.URL = audioWriter.url
+	ret.Key = audioWriter.app + "/" + audioWriter.title
+	ret.Inter = true
+	return
+}
+
+// liveStream is the stream playing for key, that of the target of an alias
+func (server *Server) liveStream(key string) (*rtmp.Stream, bool) {
+	if a, ok := server.handler.(rtmp.Aliaser); ok {
+		if to, aliased := a.Alias(key); aliased {
+			key = to
+		}
+	}
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
+		return nil, false
+	}
+	v, ok := inspector.GetStreams().Load(key)
+	if !ok {
+		return nil, false
+	}
+	s, ok := v.(*rtmp.Stream)
+	if !ok || s.GetReader() == nil {
+		return nil, false
+	}
+	return s, true
+}
+
+func (server *Server) serveAudio(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, audioPrefix)
+	if !strings.HasSuffix(path, ".aac") {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	path = strings.TrimSuffix(path, ".aac")
+	paths := strings.SplitN(path, "/", 2)
+	if len(paths) != 2 || paths[0] == "" || paths[1] == "" {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	s, ok := server.liveStream(path)
+	if !ok {
+		http.Error(w, "invalid path", http.StatusNotFound)
+		return
+	}
+	// the codec is known from the sequence header the publisher sent
+	if codec := s.MediaInfo().AudioCodec; !strings.HasPrefix(codec, "mp4a.") {
+		http.Error(w, "the stream has no aac audio", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if settings, err := configure.RoomKeys.GetSettings(paths[1]); err == nil && !settings.FLV() {
+		http.Error(w, "flv is disabled for this room", http.StatusForbidden)
+		return
+	}
+
+	if rtmp.Maintenance() {
+		http.Error(w, "server draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	rtmp.OpenConn()
+	defer rtmp.CloseConn()
+	if rtmp.OverConnections() {
+		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+		return
+	}
+
+	if limiter, ok := server.handler.(rtmp.PlayerLimiter); ok && !limiter.CanAddPlayer(path) {
+		http.Error(w, "too many players", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "audio/aac")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Del("Content-Encoding")
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	writer := NewAudioWriter(paths[0], paths[1], r.URL.String(), w)
+	writer.remoteAddr = r.RemoteAddr
+
+	server.handler.HandleWriter(writer)
+	writer.Wait(r.Context())
+}
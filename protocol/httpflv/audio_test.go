@@ -0,0 +1,93 @@
+package httpflv
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/testsrc"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// videoOnly drops the audio of the test pattern
+type videoOnly struct {
+	*testsrc.Reader
+}
+
+func (r videoOnly) Read(p *av.Packet) error {
+	for {
+		if err := r.Reader.Read(p); err != nil || !p.IsAudio {
+			return err
+		}
+	}
+}
+
+func TestServeAudio(t *testing.T) {
+	at := assert.New(t)
+	handler := rtmp.NewRtmpStream()
+	server := NewServer(handler)
+	tone := testsrc.NewReader("live/tone", "test://live/tone", time.Minute)
+	defer tone.Close(nil)
+	handler.HandleReader(tone)
+	mute := testsrc.NewReader("live/mute", "test://live/mute", time.Minute)
+	defer mute.Close(nil)
+	handler.HandleReader(videoOnly{mute})
+
+	// the codecs are known once the sequence headers went through
+	for i := 0; i < 100; i++ {
+		s, ok := server.liveStream("live/mute")
+		if ok && s.MediaInfo().VideoCodec != "" {
+			if s, ok = server.liveStream("live/tone"); ok && s.MediaInfo().AudioCodec != "" {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	srv := httptest.NewServer(server.Handler())
+	defer srv.Close()
+	for url, code := range map[string]int{
+		"/audio/live/mute.aac":   http.StatusUnsupportedMediaType,
+		"/audio/live/nobody.aac": http.StatusNotFound,
+		"/audio/live/tone.flv":   http.StatusBadRequest,
+		"/audio/tone.aac":        http.StatusBadRequest,
+	} {
+		res, err := http.Get(srv.URL + url)
+		if at.Nil(err, url) {
+			at.Equal(code, res.StatusCode, url)
+			res.Body.Close()
+		}
+	}
+
+	res, err := http.Get(srv.URL + "/audio/live/tone.aac")
+	at.Nil(err)
+	defer res.Body.Close()
+	at.Equal(200, res.StatusCode)
+	at.Equal("audio/aac", res.Header.Get("Content-Type"))
+
+	// back to back ADTS frames of the mono 44.1kHz AAC-LC silence
+	body := bufio.NewReader(res.Body)
+	for i := 0; i < 10; i++ {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(body, header); !at.Nil(err) {
+			return
+		}
+		at.Equal(byte(0xff), header[0])
+		at.Equal(byte(0xf1), header[1], "mpeg-4, no crc")
+		at.Equal(byte(1), header[2]>>6, "aac lc")
+		at.Equal(byte(4), header[2]>>2&0x0f, "44100Hz")
+		at.Equal(byte(1), (header[2]&0x01)<<2|header[3]>>6, "mono")
+		frameLen := int(header[3]&0x03)<<11 | int(header[4])<<3 | int(header[5])>>5
+		if !at.True(frameLen > 7) {
+			return
+		}
+		_, err = io.ReadFull(body, make([]byte, frameLen-7))
+		at.Nil(err)
+	}
+}
@@ -0,0 +1,51 @@
+package httpflv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/protocol/testsrc"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxPlayers(t *testing.T) {
+	at := assert.New(t)
+	handler := rtmp.NewRtmpStream()
+	handler.SetMaxPlayers("live/full", 1)
+	src := testsrc.NewReader("live/full", "test://live/full", time.Minute)
+	defer src.Close(nil)
+	handler.HandleReader(src)
+
+	srv := httptest.NewServer(NewServer(handler).Handler())
+	defer srv.Close()
+	first, err := http.Get(srv.URL + "/live/full.flv")
+	if !at.Nil(err) {
+		return
+	}
+	defer first.Body.Close()
+	at.Equal(200, first.StatusCode)
+	s, _ := handler.GetStream("live/full")
+	for i := 0; i < 100 && s.PlayerCount() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	at.Equal(1, s.PlayerCount())
+
+	// the stream is full
+	second, err := http.Get(srv.URL + "/live/full.flv")
+	if at.Nil(err) {
+		at.Equal(http.StatusServiceUnavailable, second.StatusCode)
+		second.Body.Close()
+	}
+
+	// until its limit is lifted
+	handler.SetMaxPlayers("live/full", 0)
+	third, err := http.Get(srv.URL + "/live/full.flv")
+	if at.Nil(err) {
+		at.Equal(200, third.StatusCode)
+		third.Body.Close()
+	}
+}
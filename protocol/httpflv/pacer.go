@@ -0,0 +1,63 @@
+package httpflv
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const rateInterval = 5 * time.Second
+
+// pacer spreads the writes of one connection so it stays under maxRate
+// bits per second, it also measures the rate actually sent.
+type pacer struct {
+	maxRate uint64
+	start   time.Time
+	sent    uint64
+
+	rateStart time.Time
+	rateBytes uint64
+	rate      uint64
+}
+
+func newPacer(maxRate uint64) *pacer {
+	now := time.Now()
+	return &pacer{
+		maxRate:   maxRate,
+		start:     now,
+		rateStart: now,
+	}
+}
+
+// delay records n written bytes and returns how long to wait before the
+// next write, it is always 0 without a max rate
+func (p *pacer) delay(n int, now time.Time) time.Duration {
+	p.rateBytes += uint64(n)
+	if elapsed := now.Sub(p.rateStart); elapsed >= rateInterval {
+		atomic.StoreUint64(&p.rate, p.rateBytes*8*uint64(time.Second)/uint64(elapsed))
+		p.rateBytes = 0
+		p.rateStart = now
+	}
+
+	if p.maxRate == 0 {
+		return 0
+	}
+
+	p.sent += uint64(n)
+	expected := time.Duration(p.sent * 8 * uint64(time.Second) / p.maxRate)
+	elapsed := now.Sub(p.start)
+	if elapsed > expected+time.Second {
+		// the connection was idle, don't let it burst to catch up
+		p.start = now
+		p.sent = 0
+		return 0
+	}
+	if expected > elapsed {
+		return expected - elapsed
+	}
+	return 0
+}
+
+// Rate is the measured rate in bits per second
+func (p *pacer) Rate() uint64 {
+	return atomic.LoadUint64(&p.rate)
+}
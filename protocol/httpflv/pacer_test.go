@@ -0,0 +1,79 @@
+package httpflv
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacerDelay(t *testing.T) {
+	at := assert.New(t)
+	// 8000 bits per second is 1000 bytes per second
+	p := newPacer(8000)
+	now := p.start
+
+	at.Equal(500*time.Millisecond, p.delay(500, now))
+	now = now.Add(200 * time.Millisecond)
+	at.Equal(800*time.Millisecond, p.delay(500, now))
+
+	// a connection that keeps up isn't delayed
+	now = now.Add(800 * time.Millisecond)
+	at.Equal(time.Duration(0), p.delay(0, now))
+
+	// after an idle second it restarts instead of bursting to catch up
+	now = now.Add(3 * time.Second)
+	at.Equal(time.Duration(0), p.delay(1000, now))
+	at.Equal(time.Second, p.delay(1000, now))
+
+	at.Equal(time.Duration(0), newPacer(0).delay(1<<20, now))
+}
+
+func TestPacerHoldsRate(t *testing.T) {
+	at := assert.New(t)
+	const maxRate = 2500000
+	p := newPacer(maxRate)
+	now := p.start
+
+	// sending as fast as the delays let it, the bytes take as long as the
+	// cap says and the measured rate is the cap
+	sent := 0
+	for i := 0; i < 2000; i++ {
+		n := 1000 + i%7*100
+		sent += n
+		now = now.Add(p.delay(n, now))
+	}
+	want := time.Duration(uint64(sent) * 8 * uint64(time.Second) / maxRate)
+	at.InDelta(float64(want), float64(now.Sub(p.start)), float64(time.Millisecond))
+	at.InEpsilon(maxRate, p.Rate(), 0.01)
+}
+
+func TestFLVWriterPaced(t *testing.T) {
+	at := assert.New(t)
+	// 80 kbps is 10 KB a second
+	configure.Config.Set("httpflv_maxrate", 80)
+	defer configure.Config.Set("httpflv_maxrate", 0)
+	rec := &flushRecorder{header: http.Header{}}
+	writer := NewFLVWriter("live", "paced", "/live/paced.flv", rec)
+	defer writer.Close(nil)
+	at.Equal(uint64(80000), writer.MaxRate())
+
+	begin := time.Now()
+	for i := 0; i < 4; i++ {
+		at.Nil(writer.Write(&av.Packet{IsAudio: true, Data: make([]byte, 1000-headerLen-4)}))
+	}
+	for i := 0; i < 100; i++ {
+		if _, size := rec.state(); size == 13+4000 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, size := rec.state()
+	at.Equal(13+4000, size)
+	// the last tag waits out the three before it
+	at.True(time.Since(begin) >= 300*time.Millisecond, time.Since(begin))
+}
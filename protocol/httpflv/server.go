@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
 	"github.com/SpooderfyBot/live/protocol/rtmp"
 
 	log "github.com/sirupsen/logrus"
@@ -17,8 +18,11 @@ type Server struct {
 }
 
 type stream struct {
-	Key string `json:"key"`
-	Id  string `json:"id"`
+	Key     string `json:"key"`
+	Id      string `json:"id"`
+	Addr    string `json:"addr,omitempty"`
+	MaxRate uint64 `json:"max_rate,omitempty"`
+	Rate    uint64 `json:"rate,omitempty"`
 }
 
 type streams struct {
@@ -33,6 +37,14 @@ func NewServer(h av.Handler) *Server {
 }
 
 func (server *Server) Serve(l net.Listener) error {
+	if err := http.Serve(l, server.Handler()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Handler is the http-flv server as served by Serve
+func (server *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		server.handleConn(w, r)
@@ -40,37 +52,45 @@ func (server *Server) Serve(l net.Listener) error {
 	mux.HandleFunc("/streams", func(w http.ResponseWriter, r *http.Request) {
 		server.getStream(w, r)
 	})
-	if err := http.Serve(l, mux); err != nil {
-		return err
+	mux.HandleFunc(audioPrefix, server.serveAudio)
+	if configure.Config.GetBool("flv_vod") {
+		mux.HandleFunc(vodPrefix, server.serveVOD)
 	}
-	return nil
+	return mux
 }
 
 // 获取发布和播放器的信息
 func (server *Server) getStreams(w http.ResponseWriter, r *http.Request) *streams {
-	rtmpStream := server.handler.(*rtmp.RtmpStream)
-	if rtmpStream == nil {
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
 		return nil
 	}
 	msgs := new(streams)
 
-	rtmpStream.GetStreams().Range(func(key, val interface{}) bool {
+	inspector.GetStreams().Range(func(key, val interface{}) bool {
 		if s, ok := val.(*rtmp.Stream); ok {
 			if s.GetReader() != nil {
-				msg := stream{key.(string), s.GetReader().Info().UID}
+				msg := stream{Key: key.(string), Id: s.GetReader().Info().UID}
 				msgs.Publishers = append(msgs.Publishers, msg)
 			}
 		}
 		return true
 	})
 
-	rtmpStream.GetStreams().Range(func(key, val interface{}) bool {
+	inspector.GetStreams().Range(func(key, val interface{}) bool {
 		ws := val.(*rtmp.Stream).GetWs()
 
 		ws.Range(func(k, v interface{}) bool {
 			if pw, ok := v.(*rtmp.PackWriterCloser); ok {
 				if pw.GetWriter() != nil {
-					msg := stream{key.(string), pw.GetWriter().Info().UID}
+					msg := stream{Key: key.(string), Id: pw.GetWriter().Info().UID}
+					if a, ok := pw.GetWriter().(rtmp.RemoteAddrer); ok {
+						msg.Addr = a.RemoteAddr()
+					}
+					if fw, ok := pw.GetWriter().(*FLVWriter); ok {
+						msg.MaxRate = fw.MaxRate()
+						msg.Rate = fw.Rate()
+					}
 					msgs.Players = append(msgs.Players, msg)
 				}
 			}
@@ -85,6 +105,7 @@ func (server *Server) getStreams(w http.ResponseWriter, r *http.Request) *stream
 func (server *Server) getStream(w http.ResponseWriter, r *http.Request) {
 	msgs := server.getStreams(w, r)
 	if msgs == nil {
+		http.Error(w, "invalid handler", http.StatusInternalServerError)
 		return
 	}
 	resp, _ := json.Marshal(msgs)
@@ -115,6 +136,13 @@ func (server *Server) handleConn(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 判断视屏流是否发布,如果没有发布,直接返回404
+	// an alias is live when its target is
+	live := path
+	if a, ok := server.handler.(rtmp.Aliaser); ok {
+		if to, aliased := a.Alias(path); aliased {
+			live = to
+		}
+	}
 	msgs := server.getStreams(w, r)
 	if msgs == nil || len(msgs.Publishers) == 0 {
 		http.Error(w, "invalid path", http.StatusNotFound)
@@ -122,7 +150,7 @@ func (server *Server) handleConn(w http.ResponseWriter, r *http.Request) {
 	} else {
 		include := false
 		for _, item := range msgs.Publishers {
-			if item.Key == path {
+			if item.Key == live {
 				include = true
 				break
 			}
@@ -133,11 +161,39 @@ func (server *Server) handleConn(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if settings, err := configure.RoomKeys.GetSettings(paths[1]); err == nil && !settings.FLV() {
+		http.Error(w, "flv is disabled for this room", http.StatusForbidden)
+		return
+	}
+
+	if rtmp.Maintenance() {
+		http.Error(w, "server draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	// the player is counted along the rtmp connections while it plays
+	rtmp.OpenConn()
+	defer rtmp.CloseConn()
+	if rtmp.OverConnections() {
+		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+		return
+	}
+
+	if limiter, ok := server.handler.(rtmp.PlayerLimiter); ok && !limiter.CanAddPlayer(path) {
+		http.Error(w, "too many players", http.StatusServiceUnavailable)
+		return
+	}
+
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "*")
+	// a live stream is never compressed nor cached, whatever the client accepts
+	w.Header().Set("Content-Type", "video/x-flv")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Del("Content-Encoding")
 	writer := NewFLVWriter(paths[0], paths[1], url, w)
+	writer.remoteAddr = r.RemoteAddr
 
 	server.handler.HandleWriter(writer)
-	writer.Wait()
+	writer.Wait(r.Context())
 }
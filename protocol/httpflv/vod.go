@@ -0,0 +1,129 @@
+package httpflv
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/container/flv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// vodPrefix serves the recordings of flv_dir at /vod/APP/FILE.flv
+const vodPrefix = "/vod/"
+
+type cachedIndex struct {
+	size    int64
+	modTime time.Time
+	index   *flv.Index
+}
+
+// vodIndexes caches the key frame index of each recording, a recording
+// still growing is indexed again
+var vodIndexes = struct {
+	sync.Mutex
+	m map[string]cachedIndex
+}{m: map[string]cachedIndex{}}
+
+func vodIndex(name string, f *os.File, fi os.FileInfo) (*flv.Index, error) {
+	vodIndexes.Lock()
+	c, ok := vodIndexes.m[name]
+	vodIndexes.Unlock()
+	if ok && c.size == fi.Size() && c.modTime.Equal(fi.ModTime()) {
+		return c.index, nil
+	}
+
+	idx, err := flv.BuildIndex(io.NewSectionReader(f, 0, fi.Size()))
+	if err != nil {
+		return nil, err
+	}
+	vodIndexes.Lock()
+	vodIndexes.m[name] = cachedIndex{size: fi.Size(), modTime: fi.ModTime(), index: idx}
+	vodIndexes.Unlock()
+	return idx, nil
+}
+
+// vodFile resolves /vod/APP/FILE.flv inside flv_dir
+func vodFile(urlPath string) (string, bool) {
+	name := strings.TrimPrefix(urlPath, vodPrefix)
+	if path.Ext(name) != ".flv" {
+		return "", false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", false
+		}
+	}
+	dir := configure.Config.GetString("flv_dir")
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name))), true
+}
+
+// serveVOD serves a recording with Range requests, or from the key frame
+// before ?start=SECONDS for players seeking by time
+func (server *Server) serveVOD(w http.ResponseWriter, r *http.Request) {
+	name, ok := vodFile(r.URL.Path)
+	if !ok {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "video/x-flv")
+	start := r.URL.Query().Get("start")
+	if start == "" {
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+		return
+	}
+
+	seconds, err := strconv.ParseFloat(start, 64)
+	if err != nil || seconds < 0 {
+		http.Error(w, "invalid start", http.StatusBadRequest)
+		return
+	}
+	idx, err := vodIndex(name, f, fi)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, ok := idx.Seek(uint32(seconds * 1000))
+	if !ok {
+		http.Error(w, "no key frame to seek to", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	// the header and sequence headers, then the tags from the key frame on
+	parts := append([]flv.Range{}, idx.Preamble...)
+	parts = append(parts, flv.Range{Offset: offset, Len: idx.Size - offset})
+	readers := make([]io.Reader, len(parts))
+	length := int64(0)
+	for i, p := range parts {
+		readers[i] = io.NewSectionReader(f, p.Offset, p.Len)
+		length += p.Len
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, io.MultiReader(readers...)); err != nil {
+		log.Debug("serve vod error: ", err)
+	}
+}
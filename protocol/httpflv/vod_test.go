@@ -0,0 +1,127 @@
+package httpflv
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/container/flv"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordFile writes live/movie.flv to dir: the sequence headers, then key
+// frames at 0, 1000 and 2000ms each followed by an inter frame
+func recordFile(t *testing.T, dir string) []byte {
+	name := filepath.Join(dir, "live", "movie.flv")
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := flv.NewFLVWriter("live", "movie", "", f)
+	packets := []*av.Packet{
+		{IsVideo: true, Data: []byte{0x17, 0x00, 0, 0, 0}},
+		{IsAudio: true, Data: []byte{0xaf, 0x00, 0x12, 0x10}},
+	}
+	for ts := uint32(0); ts < 3000; ts += 500 {
+		frame := byte(0x27)
+		if ts%1000 == 0 {
+			frame = 0x17
+		}
+		packets = append(packets, &av.Packet{IsVideo: true, TimeStamp: ts,
+			Data: []byte{frame, 0x01, 0, 0, 0, 1, 2, 3, 4, 5}})
+	}
+	for _, p := range packets {
+		if err := w.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close(nil)
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestServeVOD(t *testing.T) {
+	at := assert.New(t)
+	dir, err := ioutil.TempDir("", "vod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configure.Config.Set("flv_dir", dir)
+	defer configure.Config.Set("flv_dir", "tmp")
+	file := recordFile(t, dir)
+	server := NewServer(nil)
+
+	get := func(url, rng string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", url, nil)
+		if rng != "" {
+			r.Header.Set("Range", rng)
+		}
+		w := httptest.NewRecorder()
+		server.serveVOD(w, r)
+		return w
+	}
+
+	w := get("/vod/live/movie.flv", "")
+	at.Equal(http.StatusOK, w.Code)
+	at.Equal("bytes", w.Header().Get("Accept-Ranges"))
+	at.Equal(file, w.Body.Bytes())
+
+	w = get("/vod/live/movie.flv", "bytes=0-12")
+	at.Equal(http.StatusPartialContent, w.Code)
+	at.Equal(file[:13], w.Body.Bytes())
+
+	w = get("/vod/live/movie.flv", "bytes=100-")
+	at.Equal(http.StatusPartialContent, w.Code)
+	at.Equal(file[100:], w.Body.Bytes())
+
+	w = get("/vod/live/movie.flv", "bytes=100000-")
+	at.Equal(http.StatusRequestedRangeNotSatisfiable, w.Code)
+
+	// header 13 bytes, sequence headers 20 and 19, frames 25 each
+	w = get("/vod/live/movie.flv?start=1.5", "")
+	at.Equal(http.StatusOK, w.Code)
+	at.Equal(append(append([]byte{}, file[:52]...), file[102:]...), w.Body.Bytes())
+	at.Equal("152", w.Header().Get("Content-Length"))
+
+	w = get("/vod/live/movie.flv?start=10", "")
+	at.Equal(file[:52], w.Body.Bytes()[:52])
+	at.Equal(file[152:], w.Body.Bytes()[52:])
+
+	at.Equal(http.StatusNotFound, get("/vod/live/missing.flv", "").Code)
+	at.Equal(http.StatusBadRequest, get("/vod/live/../../etc/passwd.flv", "").Code)
+	at.Equal(http.StatusBadRequest, get("/vod/live/movie.flv?start=x", "").Code)
+}
+
+func TestVODIndexCache(t *testing.T) {
+	at := assert.New(t)
+	dir, err := ioutil.TempDir("", "vod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	recordFile(t, dir)
+	name := filepath.Join(dir, "live", "movie.flv")
+
+	f, _ := os.Open(name)
+	defer f.Close()
+	fi, _ := f.Stat()
+	idx, err := vodIndex(name, f, fi)
+	at.Nil(err)
+	at.Len(idx.KeyFrames, 3)
+	again, _ := vodIndex(name, f, fi)
+	at.True(idx == again)
+}
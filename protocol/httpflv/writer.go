@@ -1,12 +1,17 @@
 package httpflv
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
 	"github.com/SpooderfyBot/live/protocol/amf"
+	"github.com/SpooderfyBot/live/utils/logsample"
 	"github.com/SpooderfyBot/live/utils/pio"
 	"github.com/SpooderfyBot/live/utils/uid"
 
@@ -22,11 +27,15 @@ type FLVWriter struct {
 	Uid string
 	av.RWBaser
 	app, title, url string
+	remoteAddr      string
 	buf             []byte
-	closed          bool
+	closed          int32 // 1 once closed, written by Close and the sender
+	closeOnce       sync.Once
 	closedChan      chan struct{}
+	sent            chan struct{} // closed once the sender stopped writing
 	ctx             http.ResponseWriter
 	packetQueue     chan *av.Packet
+	pacer           *pacer
 }
 
 func NewFLVWriter(app, title, url string, ctx http.ResponseWriter) *FLVWriter {
@@ -38,26 +47,29 @@ func NewFLVWriter(app, title, url string, ctx http.ResponseWriter) *FLVWriter {
 		ctx:         ctx,
 		RWBaser:     av.NewRWBaser(time.Second * 10),
 		closedChan:  make(chan struct{}),
+		sent:        make(chan struct{}),
 		buf:         make([]byte, headerLen),
 		packetQueue: make(chan *av.Packet, maxQueueNum),
+		pacer:       newPacer(configure.Config.GetUint64("httpflv_maxrate") * 1000),
 	}
 
 	if _, err := ret.ctx.Write([]byte{0x46, 0x4c, 0x56, 0x01, 0x05, 0x00, 0x00, 0x00, 0x09}); err != nil {
 		log.Errorf("Error on response writer")
-		ret.closed = true
+		atomic.StoreInt32(&ret.closed, 1)
 	}
 	pio.PutI32BE(ret.buf[:4], 0)
 	if _, err := ret.ctx.Write(ret.buf[:4]); err != nil {
 		log.Errorf("Error on response writer")
-		ret.closed = true
+		atomic.StoreInt32(&ret.closed, 1)
 	}
+	ret.flush()
 	go func() {
+		defer close(ret.sent)
 		err := ret.SendPacket()
 		if err != nil {
 			log.Debug("SendPacket error: ", err)
-			ret.closed = true
+			ret.Close(err)
 		}
-
 	}()
 	return ret
 }
@@ -70,7 +82,7 @@ func (flvWriter *FLVWriter) DropPacket(pktQue chan *av.Packet, info av.Info) {
 			videoPkt, ok := tmpPkt.Header.(av.VideoPacketHeader)
 			// dont't drop sps config and dont't drop key frame
 			if ok && (videoPkt.IsSeq() || videoPkt.IsKeyFrame()) {
-				log.Debug("insert keyframe to queue")
+				logsample.Debug("httpflv keep keyframe").Debug("insert keyframe to queue")
 				pktQue <- tmpPkt
 			}
 
@@ -82,16 +94,28 @@ func (flvWriter *FLVWriter) DropPacket(pktQue chan *av.Packet, info av.Info) {
 		}
 		// try to don't drop audio
 		if ok && tmpPkt.IsAudio {
-			log.Debug("insert audio to queue")
+			logsample.Debug("httpflv keep audio").Debug("insert audio to queue")
 			pktQue <- tmpPkt
 		}
 	}
-	log.Debug("packet queue len: ", len(pktQue))
+	logsample.Debug("httpflv queue len").Debug("packet queue len: ", len(pktQue))
+}
+
+// isSeqHeader tells an avc or aac sequence header, the decoder config a
+// player needs before the frames following it
+func isSeqHeader(p *av.Packet) bool {
+	if vh, ok := p.Header.(av.VideoPacketHeader); ok && p.IsVideo {
+		return vh.IsSeq()
+	}
+	if ah, ok := p.Header.(av.AudioPacketHeader); ok && !p.IsMetadata {
+		return ah.SoundFormat() == av.SOUND_AAC && ah.AACPacketType() == av.AAC_SEQHDR
+	}
+	return false
 }
 
 func (flvWriter *FLVWriter) Write(p *av.Packet) (err error) {
 	err = nil
-	if flvWriter.closed {
+	if flvWriter.isClosed() {
 		err = fmt.Errorf("flvwrite source closed")
 		return
 	}
@@ -104,6 +128,10 @@ func (flvWriter *FLVWriter) Write(p *av.Packet) (err error) {
 
 	if len(flvWriter.packetQueue) >= maxQueueNum-24 {
 		flvWriter.DropPacket(flvWriter.packetQueue, flvWriter.Info())
+		// the player could not decode anything after a lost config
+		if isSeqHeader(p) && len(flvWriter.packetQueue) < maxQueueNum {
+			flvWriter.packetQueue <- p
+		}
 	} else {
 		flvWriter.packetQueue <- p
 	}
@@ -114,7 +142,7 @@ func (flvWriter *FLVWriter) Write(p *av.Packet) (err error) {
 func (flvWriter *FLVWriter) SendPacket() error {
 	for {
 		p, ok := <-flvWriter.packetQueue
-		if ok {
+		if ok && !flvWriter.isClosed() {
 			flvWriter.RWBaser.SetPreTime()
 			h := flvWriter.buf[:headerLen]
 			typeID := av.TAG_VIDEO
@@ -156,6 +184,16 @@ func (flvWriter *FLVWriter) SendPacket() error {
 			if _, err := flvWriter.ctx.Write(h[:4]); err != nil {
 				return err
 			}
+			flvWriter.flush()
+
+			// packets keep queueing while we sleep, DropPacket takes care of
+			// a queue that fills up so the publisher is never blocked
+			if d := flvWriter.pacer.delay(preDataLen+4, time.Now()); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-flvWriter.closedChan:
+				}
+			}
 		} else {
 			return fmt.Errorf("closed")
 		}
@@ -163,20 +201,56 @@ func (flvWriter *FLVWriter) SendPacket() error {
 	}
 }
 
-func (flvWriter *FLVWriter) Wait() {
+// flush pushes each tag to the viewer right away instead of letting the
+// http server buffer it
+func (flvWriter *FLVWriter) flush() {
+	if f, ok := flvWriter.ctx.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Wait blocks until the writer is closed or the client went away,
+// in which case the writer is closed so the stream drops it. It returns
+// once the sender is done with the response writer, which the handler
+// must not touch after returning.
+func (flvWriter *FLVWriter) Wait(ctx context.Context) {
 	select {
 	case <-flvWriter.closedChan:
-		return
+	case <-ctx.Done():
+		flvWriter.Close(ctx.Err())
 	}
+	<-flvWriter.sent
+}
+
+func (flvWriter *FLVWriter) isClosed() bool {
+	return atomic.LoadInt32(&flvWriter.closed) == 1
 }
 
 func (flvWriter *FLVWriter) Close(error) {
 	log.Debug("http flv closed")
-	if !flvWriter.closed {
+	flvWriter.closeOnce.Do(func() {
+		atomic.StoreInt32(&flvWriter.closed, 1)
 		close(flvWriter.packetQueue)
 		close(flvWriter.closedChan)
-	}
-	flvWriter.closed = true
+	})
+}
+
+// MaxRate is the configured cap in bits per second, 0 is unlimited
+func (flvWriter *FLVWriter) MaxRate() uint64 {
+	return flvWriter.pacer.maxRate
+}
+
+// Rate is the measured send rate in bits per second
+func (flvWriter *FLVWriter) Rate() uint64 {
+	return flvWriter.pacer.Rate()
+}
+
+func (flvWriter *FLVWriter) IsPlayer() bool {
+	return true
+}
+
+func (flvWriter *FLVWriter) RemoteAddr() string {
+	return flvWriter.remoteAddr
 }
 
 func (flvWriter *FLVWriter) Info() (ret av.Info) {
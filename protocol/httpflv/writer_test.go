@@ -0,0 +1,89 @@
+package httpflv
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type flushRecorder struct {
+	lock    sync.Mutex
+	header  http.Header
+	body    bytes.Buffer
+	flushes int
+}
+
+func (f *flushRecorder) Header() http.Header {
+	return f.header
+}
+
+func (f *flushRecorder) Write(b []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.body.Write(b)
+}
+
+func (f *flushRecorder) WriteHeader(int) {}
+
+func (f *flushRecorder) Flush() {
+	f.lock.Lock()
+	f.flushes++
+	f.lock.Unlock()
+}
+
+func (f *flushRecorder) state() (int, int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.flushes, f.body.Len()
+}
+
+func TestFLVWriterFlushesEachTag(t *testing.T) {
+	at := assert.New(t)
+	rec := &flushRecorder{header: http.Header{}}
+
+	writer := NewFLVWriter("live", "movie", "/live/movie.flv", rec)
+	flushes, size := rec.state()
+	at.Equal(1, flushes)
+	at.Equal(13, size)
+
+	p := &av.Packet{IsAudio: true, Data: []byte{0xaf, 0x01, 0x00}}
+	at.Nil(writer.Write(p))
+
+	for i := 0; i < 50; i++ {
+		if flushes, _ = rec.state(); flushes == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	flushes, size = rec.state()
+	at.Equal(2, flushes)
+	at.Equal(13+headerLen+3+4, size)
+}
+
+func TestFLVWriterClosesOnDisconnect(t *testing.T) {
+	at := assert.New(t)
+	rec := &flushRecorder{header: http.Header{}}
+	writer := NewFLVWriter("live", "movie", "/live/movie.flv", rec)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		writer.Wait(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return after the client went away")
+	}
+	at.NotNil(writer.Write(&av.Packet{IsAudio: true, Data: []byte{0xaf, 0x01}}))
+}
@@ -0,0 +1,91 @@
+package rtmp
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrSelfAlias  = fmt.Errorf("a room can't be an alias of itself")
+	ErrAliasChain = fmt.Errorf("the target is itself an alias")
+	ErrAliased    = fmt.Errorf("the room is the target of an alias")
+)
+
+// Aliaser is implemented by the handlers serving some rooms with the
+// stream of another, such as *RtmpStream
+type Aliaser interface {
+	// Alias returns the key whose stream key is served with
+	Alias(key string) (string, bool)
+}
+
+// loadAliases sets the rtmp.aliases of the config, the bad ones are
+// logged and skipped
+func (rs *RtmpStream) loadAliases() {
+	var aliases []configure.Alias
+	configure.Config.UnmarshalKey("rtmp.aliases", &aliases)
+	for _, a := range aliases {
+		if err := rs.SetAlias(a.From, a.To); err != nil {
+			log.Warningf("rtmp alias %s -> %s: %v", a.From, a.To, err)
+		}
+	}
+}
+
+// SetAlias makes the players of from, an app/room key, play the stream
+// of to instead, an empty to removes the alias. Publishing to an alias
+// is refused. Players already attached stay where they are.
+func (rs *RtmpStream) SetAlias(from, to string) error {
+	rs.aliasLock.Lock()
+	defer rs.aliasLock.Unlock()
+
+	if to == "" {
+		delete(rs.aliases, from)
+		return nil
+	}
+	if from == to {
+		return ErrSelfAlias
+	}
+	if _, ok := rs.aliases[to]; ok {
+		return ErrAliasChain
+	}
+	for _, target := range rs.aliases {
+		if target == from {
+			return ErrAliased
+		}
+	}
+	rs.aliases[from] = to
+	log.Infof("[%v] is an alias of %v", from, to)
+	events.Emit(from, events.Alias, from+" -> "+to)
+	return nil
+}
+
+func (rs *RtmpStream) Alias(key string) (string, bool) {
+	rs.aliasLock.RLock()
+	defer rs.aliasLock.RUnlock()
+	to, ok := rs.aliases[key]
+	return to, ok
+}
+
+// Aliases lists the aliases by key
+func (rs *RtmpStream) Aliases() []configure.Alias {
+	rs.aliasLock.RLock()
+	defer rs.aliasLock.RUnlock()
+	ret := make([]configure.Alias, 0, len(rs.aliases))
+	for from, to := range rs.aliases {
+		ret = append(ret, configure.Alias{From: from, To: to})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].From < ret[j].From })
+	return ret
+}
+
+// playKey is the key the players of key are attached to
+func (rs *RtmpStream) playKey(key string) string {
+	if to, ok := rs.Alias(key); ok {
+		return to
+	}
+	return key
+}
@@ -0,0 +1,37 @@
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlias(t *testing.T) {
+	at := assert.New(t)
+
+	rs := NewRtmpStream()
+	at.Equal(ErrSelfAlias, rs.SetAlias("live/featured", "live/featured"))
+	at.Nil(rs.SetAlias("live/featured", "live/target"))
+	at.Equal(ErrAliasChain, rs.SetAlias("live/other", "live/featured"))
+	at.Equal(ErrAliased, rs.SetAlias("live/target", "live/other"))
+	at.Nil(rs.SetAlias("live/gone", "live/target"))
+	at.Nil(rs.SetAlias("live/gone", ""))
+	at.Equal([]configure.Alias{{From: "live/featured", To: "live/target"}}, rs.Aliases())
+
+	publisher := &keyedReader{newChanReader("publisher"), "live/target"}
+	rs.HandleReader(publisher)
+	defer publisher.Close(nil)
+	viewer := newKeyedWriter("live/featured", "viewer")
+	rs.HandleWriter(viewer)
+
+	// the player of the alias is attached to the target
+	_, found := rs.GetStream("live/featured")
+	at.False(found)
+	publisher.packets <- av.Packet{IsAudio: true, TimeStamp: 100}
+	publisher.packets <- av.Packet{IsAudio: true, TimeStamp: 140}
+	at.True(waitFor(received(viewer, 140)))
+	at.Nil(viewer.closed())
+}
@@ -0,0 +1,118 @@
+package rtmp
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+)
+
+const (
+	defaultHandshakeTimeout = 10 * time.Second
+	defaultBanTime          = 60 * time.Second
+)
+
+// handshakeTimeout is the time a connection has to become a publisher
+// or a player, read from rtmp.handshake_timeout in ms
+func handshakeTimeout() time.Duration {
+	if ms := configure.Config.GetInt("rtmp.handshake_timeout"); ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return defaultHandshakeTimeout
+}
+
+func banTime() time.Duration {
+	if s := configure.Config.GetInt("rtmp.ban_time"); s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	return defaultBanTime
+}
+
+// BlockedIP is an address refused after failing too many handshakes
+type BlockedIP struct {
+	IP       string    `json:"ip"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+type banEntry struct {
+	failures int
+	since    time.Time // start of the ban_time window the failures are counted in
+	until    time.Time
+}
+
+// banList counts the handshake failures of each IP
+type banList struct {
+	lock    sync.Mutex
+	entries map[string]*banEntry
+}
+
+var bans = &banList{entries: map[string]*banEntry{}}
+
+// hostOf is the IP of a client, blank on a unix socket where every client
+// comes through the same local proxy and can't be told apart
+func hostOf(addr net.Addr) string {
+	if addr.Network() == "unix" {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// blocked reports whether ip is banned at now, forgetting stale entries
+func (l *banList) blocked(ip string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	e, ok := l.entries[ip]
+	if !ok {
+		return false
+	}
+	if now.Before(e.until) {
+		return true
+	}
+	if now.Sub(e.since) >= banTime() {
+		delete(l.entries, ip)
+	}
+	return false
+}
+
+// fail counts a failed handshake of ip, banning it past rtmp.ban_threshold
+func (l *banList) fail(ip string, now time.Time) {
+	threshold := configure.Config.GetInt("rtmp.ban_threshold")
+	if threshold <= 0 || ip == "" {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	e, ok := l.entries[ip]
+	if !ok || now.Sub(e.since) >= banTime() {
+		e = &banEntry{since: now}
+		l.entries[ip] = e
+	}
+	e.failures++
+	if e.failures >= threshold {
+		e.until = now.Add(banTime())
+	}
+}
+
+// BlockedIPs lists the addresses currently refused by the rtmp server
+func BlockedIPs() []BlockedIP {
+	now := time.Now()
+	bans.lock.Lock()
+	defer bans.lock.Unlock()
+	ret := []BlockedIP{}
+	for ip, e := range bans.entries {
+		if now.Before(e.until) {
+			ret = append(ret, BlockedIP{IP: ip, Failures: e.failures, Until: e.until})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].IP < ret[j].IP
+	})
+	return ret
+}
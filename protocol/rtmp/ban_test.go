@@ -0,0 +1,100 @@
+package rtmp
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandshakeTimeout(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("rtmp.handshake_timeout", 200)
+	defer configure.Config.Set("rtmp.handshake_timeout", 0)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer l.Close()
+	go NewRtmpServer(NewRtmpStream(), nil).Serve(l)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	at.Nil(err)
+	defer c.Close()
+
+	// the client never sends its handshake
+	start := time.Now()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = c.Read(make([]byte, 1))
+	at.Equal(io.EOF, err)
+	at.True(time.Since(start) < time.Second, "closed after %v", time.Since(start))
+}
+
+func TestBanList(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("rtmp.ban_threshold", 2)
+	configure.Config.Set("rtmp.ban_time", 60)
+	defer configure.Config.Set("rtmp.ban_threshold", 0)
+	defer configure.Config.Set("rtmp.ban_time", 0)
+
+	l := &banList{entries: map[string]*banEntry{}}
+	now := time.Now()
+	l.fail("10.0.0.1", now)
+	at.False(l.blocked("10.0.0.1", now))
+	l.fail("10.0.0.1", now.Add(time.Second))
+	at.True(l.blocked("10.0.0.1", now.Add(time.Second)))
+	at.False(l.blocked("10.0.0.2", now))
+
+	// the ban is lifted ban_time after the last failure
+	at.True(l.blocked("10.0.0.1", now.Add(60*time.Second)))
+	at.False(l.blocked("10.0.0.1", now.Add(62*time.Second)))
+	at.Empty(l.entries)
+
+	// failures further apart than ban_time don't add up
+	l.fail("10.0.0.1", now)
+	l.fail("10.0.0.1", now.Add(61*time.Second))
+	at.False(l.blocked("10.0.0.1", now.Add(61*time.Second)))
+
+	// clients of a unix socket are never banned
+	unix := &net.UnixAddr{Name: "@", Net: "unix"}
+	at.Equal("", hostOf(unix))
+	l.fail(hostOf(unix), now)
+	l.fail(hostOf(unix), now)
+	at.False(l.blocked(hostOf(unix), now))
+}
+
+func TestBannedClientRefused(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("rtmp.handshake_timeout", 100)
+	configure.Config.Set("rtmp.ban_threshold", 1)
+	defer configure.Config.Set("rtmp.handshake_timeout", 0)
+	defer configure.Config.Set("rtmp.ban_threshold", 0)
+	defer func() { bans.entries = map[string]*banEntry{} }()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer l.Close()
+	go NewRtmpServer(NewRtmpStream(), nil).Serve(l)
+
+	// a garbage handshake fails right away
+	c, err := net.Dial("tcp", l.Addr().String())
+	at.Nil(err)
+	c.Write(make([]byte, 1537))
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	io.Copy(ioutil.Discard, c)
+	c.Close()
+
+	at.True(waitFor(func() bool { return len(BlockedIPs()) == 1 }))
+	at.Equal("127.0.0.1", BlockedIPs()[0].IP)
+
+	c, err = net.Dial("tcp", l.Addr().String())
+	at.Nil(err)
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = c.Read(make([]byte, 1))
+	at.Equal(io.EOF, err)
+}
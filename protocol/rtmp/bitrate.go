@@ -0,0 +1,65 @@
+package rtmp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+)
+
+const (
+	defaultBitrateWindow = 10 * time.Second
+
+	// a publisher is reported near its limit past this percent of it
+	bitrateNearPercent = 90
+)
+
+// bitrateGuard disconnects a publisher sustaining more than limit kbit/s,
+// read from rtmp.max_publish_bitrate_kbps, for window, read from
+// rtmp.max_publish_bitrate_window in seconds. A limit of 0 disables it
+type bitrateGuard struct {
+	limit  uint64
+	window int64 // ms
+	above  int64 // ms the rate first went past the limit, 0 when below it
+}
+
+func newBitrateGuard() bitrateGuard {
+	g := bitrateGuard{window: int64(defaultBitrateWindow / time.Millisecond)}
+	if kbps := configure.Config.GetInt("rtmp.max_publish_bitrate_kbps"); kbps > 0 {
+		g.limit = uint64(kbps)
+	}
+	if s := configure.Config.GetInt("rtmp.max_publish_bitrate_window"); s > 0 {
+		g.window = int64(s) * 1000
+	}
+	return g
+}
+
+// check is called with each sample of bw, taken at nowInMS. A sample is
+// the rate over the SAVE_STATICS_INTERVAL before it, the first one above
+// the limit already counts for that long
+func (g *bitrateGuard) check(bw *StaticsBW, nowInMS int64) error {
+	if g.limit == 0 {
+		return nil
+	}
+	kbps := bw.VideoBitrateKbps + bw.AudioBitrateKbps
+	if kbps <= g.limit {
+		g.above = 0
+		return nil
+	}
+	if g.above == 0 {
+		g.above = nowInMS
+	}
+	if nowInMS-g.above+SAVE_STATICS_INTERVAL >= g.window {
+		return fmt.Errorf("bitrate %dkbps above the %dkbps limit for %ds",
+			kbps, g.limit, (nowInMS-g.above+SAVE_STATICS_INTERVAL)/1000)
+	}
+	return nil
+}
+
+// near tells whether the last sample of bw is close to the limit
+func (g *bitrateGuard) near(bw *StaticsBW) bool {
+	if g.limit == 0 {
+		return false
+	}
+	return (bw.VideoBitrateKbps+bw.AudioBitrateKbps)*100 >= g.limit*bitrateNearPercent
+}
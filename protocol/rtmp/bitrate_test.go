@@ -0,0 +1,104 @@
+package rtmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBitrateGuard(t *testing.T) {
+	at := assert.New(t)
+	g := bitrateGuard{limit: 1000, window: 10000}
+	bw := &StaticsBW{}
+
+	// 25kB every 100ms is 2000kbps, fed from a synthetic clock, the first
+	// sample also counts the packet it started from
+	var err error
+	now := int64(1000)
+	feed := func(ms int64) {
+		for end := now + ms; now < end && err == nil; now += 100 {
+			bw.save(1, 25000, true, now)
+			if bw.LastTimestamp == now {
+				err = g.check(bw, now)
+			}
+		}
+	}
+	feed(5100)
+	at.Nil(err)
+	at.True(bw.VideoBitrateKbps > 2000)
+	at.True(g.near(bw))
+
+	// a sample below the limit starts the window over
+	bw.save(1, 0, true, now+5000)
+	at.Nil(g.check(bw, now+5000))
+	at.Equal(uint64(0), bw.VideoBitrateKbps)
+	at.False(g.near(bw))
+	now += 5100
+
+	feed(9000)
+	at.Nil(err)
+	feed(2000)
+	if at.NotNil(err) {
+		at.Equal("bitrate 2000kbps above the 1000kbps limit for 10s", err.Error())
+	}
+
+	at.False((&bitrateGuard{}).near(bw))
+	at.Nil((&bitrateGuard{}).check(bw, now))
+}
+
+// burstConn is a publisher sending large video chunks as fast as it's read
+type burstConn struct {
+	closed bool
+}
+
+func (c *burstConn) GetInfo() (string, string, string) {
+	return "live", "burst", "rtmp://127.0.0.1/live/burst"
+}
+
+func (c *burstConn) Close(error) { c.closed = true }
+
+func (c *burstConn) Write(core.ChunkStream) error { return nil }
+
+func (c *burstConn) Read(cs *core.ChunkStream) error {
+	*cs = core.ChunkStream{TypeID: av.TAG_VIDEO, Data: make([]byte, 65536)}
+	return nil
+}
+
+func TestVirReaderBitrateLimit(t *testing.T) {
+	at := assert.New(t)
+	// the history of live/burst keeps the events of the earlier runs
+	begin := time.Now()
+	configure.Config.Set("rtmp.max_publish_bitrate_kbps", 100)
+	configure.Config.Set("rtmp.max_publish_bitrate_window", 5)
+	defer configure.Config.Set("rtmp.max_publish_bitrate_kbps", 0)
+	defer configure.Config.Set("rtmp.max_publish_bitrate_window", 0)
+
+	conn := &burstConn{}
+	v := NewVirReader(conn)
+	var p av.Packet
+	at.Nil(v.Read(&p))
+	limit, near := v.BitrateLimit()
+	at.Equal(uint64(100), limit)
+	at.False(near)
+
+	// pretend the first packet came an interval ago so the next is sampled
+	v.ReadBWInfo.LastTimestamp -= SAVE_STATICS_INTERVAL
+	at.NotNil(v.Read(&p))
+	at.True(conn.closed)
+	_, near = v.BitrateLimit()
+	at.True(near)
+
+	limits := 0
+	for _, e := range events.Get("live/burst") {
+		if e.Type == events.PublishLimit && !e.Time.Before(begin) {
+			limits++
+		}
+	}
+	at.Equal(1, limits)
+}
@@ -3,6 +3,8 @@ package cache
 import (
 	"github.com/SpooderfyBot/live/av"
 	"github.com/SpooderfyBot/live/configure"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type Cache struct {
@@ -10,18 +12,22 @@ type Cache struct {
 	videoSeq *SpecialCache
 	audioSeq *SpecialCache
 	metadata *SpecialCache
+	media    mediaInfo
 }
 
 func NewCache() *Cache {
 	return &Cache{
-		gop:      NewGopCache(configure.Config.GetInt("gop_num")),
+		gop:      NewGopCache(configure.PlaybackSettings().GopNum),
 		videoSeq: NewSpecialCache(),
 		audioSeq: NewSpecialCache(),
 		metadata: NewSpecialCache(),
 	}
 }
 
-func (cache *Cache) Write(p av.Packet) {
+// Write caches p, it returns the stream whose sequence header changed,
+// "video" or "audio", when p is a new decoder config of the publisher
+func (cache *Cache) Write(p av.Packet) (changed string) {
+	cache.media.update(&p)
 	if p.IsMetadata {
 		cache.metadata.Write(&p)
 		return
@@ -31,6 +37,12 @@ func (cache *Cache) Write(p av.Packet) {
 			if ok {
 				if ah.SoundFormat() == av.SOUND_AAC &&
 					ah.AACPacketType() == av.AAC_SEQHDR {
+					// players joining from now on get the new header, the
+					// ones already playing receive it in the stream
+					if cache.audioSeq.differs(&p) {
+						log.Infof("aac sequence header changed mid stream")
+						changed = "audio"
+					}
 					cache.audioSeq.Write(&p)
 					return
 				} else {
@@ -42,6 +54,12 @@ func (cache *Cache) Write(p av.Packet) {
 			vh, ok := p.Header.(av.VideoPacketHeader)
 			if ok {
 				if vh.IsSeq() {
+					// the gop cached was encoded with the old config
+					if cache.videoSeq.differs(&p) {
+						log.Infof("avc sequence header changed mid stream")
+						cache.gop.Reset()
+						changed = "video"
+					}
 					cache.videoSeq.Write(&p)
 					return
 				}
@@ -52,6 +70,12 @@ func (cache *Cache) Write(p av.Packet) {
 		}
 	}
 	cache.gop.Write(&p)
+	return
+}
+
+// MediaInfo describes the stream from the packets written so far
+func (cache *Cache) MediaInfo() MediaInfo {
+	return cache.media.get()
 }
 
 func (cache *Cache) Send(w av.WriteCloser) error {
@@ -73,3 +97,14 @@ func (cache *Cache) Send(w av.WriteCloser) error {
 
 	return nil
 }
+
+// FlushGop empties the cached gops, the sequence headers and metadata are
+// kept. Players joining get no frames until the next key frame
+func (cache *Cache) FlushGop() {
+	cache.gop.Reset()
+}
+
+// HasGop tells whether a gop is cached, starting with a key frame
+func (cache *Cache) HasGop() bool {
+	return cache.gop.start
+}
@@ -118,3 +118,14 @@ func (gopCache *GopCache) sendTo(w av.WriteCloser) error {
 func (gopCache *GopCache) Send(w av.WriteCloser) error {
 	return gopCache.sendTo(w)
 }
+
+// Reset empties the cached gops, caching starts again on the next key
+// frame
+func (gopCache *GopCache) Reset() {
+	for _, g := range gopCache.gops {
+		if g != nil {
+			g.reset()
+		}
+	}
+	gopCache.start = false
+}
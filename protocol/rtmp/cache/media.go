@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/parser/aac"
+	"github.com/SpooderfyBot/live/parser/h264"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// flv tag headers in front of the avc and aac sequence headers
+const (
+	videoTagHeaderLen = 5
+	audioTagHeaderLen = 2
+)
+
+// MediaInfo describes the stream from its sequence headers, the codec
+// fields are blank until the publisher sent them
+type MediaInfo struct {
+	VideoCodec   string
+	AudioCodec   string
+	Width        int
+	Height       int
+	FrameRate    float64
+	LastKeyFrame time.Time
+}
+
+type mediaInfo struct {
+	lock sync.RWMutex
+	info MediaInfo
+}
+
+func (m *mediaInfo) get() MediaInfo {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.info
+}
+
+func (m *mediaInfo) update(p *av.Packet) {
+	if p.IsVideo {
+		vh, ok := p.Header.(av.VideoPacketHeader)
+		if !ok || vh.CodecID() != av.VIDEO_H264 || len(p.Data) < videoTagHeaderLen {
+			return
+		}
+		if vh.IsSeq() {
+			sps, err := h264.ParseAVCConfig(p.Data[videoTagHeaderLen:])
+			if err != nil {
+				log.Debug("parse avc sequence header error: ", err)
+				return
+			}
+			m.lock.Lock()
+			m.info.VideoCodec = sps.Codec()
+			m.info.Width, m.info.Height = sps.Width, sps.Height
+			m.info.FrameRate = sps.FrameRate
+			m.lock.Unlock()
+		} else if vh.IsKeyFrame() {
+			m.lock.Lock()
+			m.info.LastKeyFrame = time.Now()
+			m.lock.Unlock()
+		}
+		return
+	}
+
+	ah, ok := p.Header.(av.AudioPacketHeader)
+	if !ok || ah.SoundFormat() != av.SOUND_AAC || ah.AACPacketType() != av.AAC_SEQHDR ||
+		len(p.Data) < audioTagHeaderLen {
+		return
+	}
+	codec, err := aac.Codec(p.Data[audioTagHeaderLen:])
+	if err != nil {
+		log.Debug("parse aac sequence header error: ", err)
+		return
+	}
+	m.lock.Lock()
+	m.info.AudioCodec = codec
+	m.lock.Unlock()
+}
@@ -39,6 +39,11 @@ func (specialCache *SpecialCache) Write(p *av.Packet) {
 	specialCache.full = true
 }
 
+// differs tells whether p replaces a cached packet with other data
+func (specialCache *SpecialCache) differs(p *av.Packet) bool {
+	return specialCache.full && !bytes.Equal(specialCache.p.Data, p.Data)
+}
+
 func (specialCache *SpecialCache) Send(w av.WriteCloser) error {
 	if !specialCache.full {
 		return nil
@@ -0,0 +1,122 @@
+package rtmp
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/SpooderfyBot/live/configure"
+)
+
+// connections counts the open rtmp connections and http-flv players
+var connections int64
+
+// Capacity is the load of the server against rtmp.max_streams and
+// rtmp.max_connections, a max of 0 is unlimited
+type Capacity struct {
+	Streams        int `json:"streams"`
+	MaxStreams     int `json:"max_streams"`
+	Connections    int `json:"connections"`
+	MaxConnections int `json:"max_connections"`
+}
+
+// maxStreams is rtmp.max_streams, read on each publish so it follows
+// reloads
+func maxStreams() int {
+	return configure.Config.GetInt("rtmp.max_streams")
+}
+
+// maxConnections is rtmp.max_connections, read on each new player so it
+// follows reloads
+func maxConnections() int {
+	return configure.Config.GetInt("rtmp.max_connections")
+}
+
+// OpenConn counts a new connection until the CloseConn it must be paired
+// with
+func OpenConn() {
+	atomic.AddInt64(&connections, 1)
+}
+
+func CloseConn() {
+	atomic.AddInt64(&connections, -1)
+}
+
+// Connections is the number of connections open
+func Connections() int {
+	return int(atomic.LoadInt64(&connections))
+}
+
+// OverConnections tells whether the connections open, the new one
+// included, exceed rtmp.max_connections. New players are then refused.
+func OverConnections() bool {
+	max := maxConnections()
+	return max > 0 && Connections() > max
+}
+
+// countedConn is a net.Conn counted from its accept to its close
+type countedConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func newCountedConn(c net.Conn) *countedConn {
+	OpenConn()
+	return &countedConn{Conn: c}
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(CloseConn)
+	return c.Conn.Close()
+}
+
+// StreamLimiter is implemented by handlers that cap the live streams
+// of the server, such as *RtmpStream
+type StreamLimiter interface {
+	CanPublish(key string) bool
+}
+
+// SetMaxStreams overrides rtmp.max_streams for the streams of rs, 0 means
+// unlimited and a negative value removes the override
+func (rs *RtmpStream) SetMaxStreams(n int) {
+	rs.streamsMax.Store(n)
+}
+
+// maxStreams is the override of SetMaxStreams, rtmp.max_streams without
+func (rs *RtmpStream) maxStreams() int {
+	if n, ok := rs.streamsMax.Load().(int); ok && n >= 0 {
+		return n
+	}
+	return maxStreams()
+}
+
+// CanPublish tells if a publisher of key fits in rtmp.max_streams, one
+// replacing the live publisher of key takes its slot
+func (rs *RtmpStream) CanPublish(key string) bool {
+	max := rs.maxStreams()
+	if max <= 0 || rs.Publishing(key) {
+		return true
+	}
+	return rs.LiveStreams() < max
+}
+
+// LiveStreams counts the streams read from their own publisher
+func (rs *RtmpStream) LiveStreams() (n int) {
+	rs.streams.Range(func(key, val interface{}) bool {
+		if rs.Publishing(key.(string)) {
+			n++
+		}
+		return true
+	})
+	return
+}
+
+// Capacity is the current load of the server and its limits
+func (rs *RtmpStream) Capacity() Capacity {
+	return Capacity{
+		Streams:        rs.LiveStreams(),
+		MaxStreams:     rs.maxStreams(),
+		Connections:    Connections(),
+		MaxConnections: maxConnections(),
+	}
+}
@@ -0,0 +1,151 @@
+package rtmp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxStreams(t *testing.T) {
+	at := assert.New(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer listener.Close()
+	handler := NewRtmpStream()
+	// the servers of the other tests still read rtmp.max_streams
+	handler.SetMaxStreams(1)
+	go NewRtmpServer(handler, nil).Serve(listener)
+	publish := func(room string) *core.ConnClient {
+		key, err := configure.RoomKeys.SetKey(room)
+		at.Nil(err)
+		c := core.NewConnClient()
+		at.Nil(c.Start("rtmp://"+listener.Addr().String()+"/live/"+key, av.PUBLISH))
+		return c
+	}
+
+	first := publish("capacity_first")
+	defer first.Close(nil)
+	at.True(waitFor(func() bool { return handler.Publishing("live/capacity_first") }))
+	at.Equal(Capacity{Streams: 1, MaxStreams: 1, Connections: Connections()}, handler.Capacity())
+
+	// the second stream is one too many
+	second := publish("capacity_second")
+	status := lastStatus(second)
+	at.Equal("NetStream.Publish.Rejected", status["code"])
+	at.Equal("the server reached its max streams", status["description"])
+	second.Close(nil)
+	at.False(handler.Publishing("live/capacity_second"))
+
+	// until the first one ends
+	first.Close(nil)
+	at.True(waitFor(func() bool { return handler.LiveStreams() == 0 }))
+	third := publish("capacity_second")
+	defer third.Close(nil)
+	at.True(waitFor(func() bool { return handler.Publishing("live/capacity_second") }))
+
+	// and lifting the limit lets more in
+	handler.SetMaxStreams(0)
+	fourth := publish("capacity_first")
+	defer fourth.Close(nil)
+	at.True(waitFor(func() bool { return handler.Publishing("live/capacity_first") }))
+}
+
+func TestMaxConnections(t *testing.T) {
+	at := assert.New(t)
+	// the connections are counted for the whole process, so is their limit
+	defer configure.Config.Set("rtmp.max_connections", 0)
+	// the connections of the previous tests are closed by now
+	at.True(waitFor(func() bool { return Connections() == 0 }))
+	// the publisher and one player
+	configure.Config.Set("rtmp.max_connections", 2)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer listener.Close()
+	handler := NewRtmpStream()
+	go NewRtmpServer(handler, nil).Serve(listener)
+	key, err := configure.RoomKeys.SetKey("capacity_players")
+	at.Nil(err)
+	url := "rtmp://" + listener.Addr().String() + "/live/"
+
+	publisher := core.NewConnClient()
+	at.Nil(publisher.Start(url+key, av.PUBLISH))
+	defer publisher.Close(nil)
+	at.True(waitFor(func() bool { return handler.Publishing("live/capacity_players") }))
+	s, _ := handler.GetStream("live/capacity_players")
+
+	play := func() *core.ConnClient {
+		c := core.NewConnClient()
+		at.Nil(c.Start(url+"capacity_players", av.PLAY))
+		return c
+	}
+	player := play()
+	at.True(waitFor(func() bool { return s.PlayerCount() == 1 }))
+	at.Equal(2, Connections())
+
+	refused := play()
+	status := lastStatus(refused)
+	at.Equal("NetStream.Play.Failed", status["code"])
+	at.Equal("the server reached its max connections", status["description"])
+	refused.Close(nil)
+	at.True(waitFor(func() bool { return Connections() == 2 }))
+	at.Equal(1, s.PlayerCount())
+
+	// a player leaving frees its slot
+	player.Close(nil)
+	at.True(waitFor(func() bool { return Connections() == 1 }))
+	next := play()
+	defer next.Close(nil)
+	// the stream drops the player that left on its next packet, the new
+	// one is added all the same
+	at.True(waitFor(func() bool { return s.PlayerCount() == 2 }))
+	at.Equal(2, Connections())
+}
+
+func TestMaxPlayers(t *testing.T) {
+	at := assert.New(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer listener.Close()
+	handler := NewRtmpStream()
+	go NewRtmpServer(handler, nil).Serve(listener)
+	key, err := configure.RoomKeys.SetKey("capacity_limit")
+	at.Nil(err)
+	url := "rtmp://" + listener.Addr().String() + "/live/"
+	handler.SetMaxPlayers("live/capacity_limit", 1)
+	at.Equal(1, handler.MaxPlayers("live/capacity_limit"))
+
+	publisher := core.NewConnClient()
+	at.Nil(publisher.Start(url+key, av.PUBLISH))
+	defer publisher.Close(nil)
+	at.True(waitFor(func() bool { return handler.Publishing("live/capacity_limit") }))
+	s, _ := handler.GetStream("live/capacity_limit")
+
+	player := core.NewConnClient()
+	at.Nil(player.Start(url+"capacity_limit", av.PLAY))
+	defer player.Close(nil)
+	at.True(waitFor(func() bool { return s.PlayerCount() == 1 }))
+	at.False(handler.CanAddPlayer("live/capacity_limit"))
+
+	// the second player is disconnected without being added
+	refused := core.NewConnClient()
+	at.Nil(refused.Start(url+"capacity_limit", av.PLAY))
+	lastStatus(refused)
+	refused.Close(nil)
+	at.Equal(1, s.PlayerCount())
+
+	// removing the override falls back to rtmp.max_players_per_stream,
+	// unlimited by default
+	handler.SetMaxPlayers("live/capacity_limit", -1)
+	at.Equal(0, handler.MaxPlayers("live/capacity_limit"))
+	at.True(handler.CanAddPlayer("live/capacity_limit"))
+	next := core.NewConnClient()
+	at.Nil(next.Start(url+"capacity_limit", av.PLAY))
+	defer next.Close(nil)
+	at.True(waitFor(func() bool { return s.PlayerCount() == 2 }))
+}
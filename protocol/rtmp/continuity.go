@@ -0,0 +1,62 @@
+package rtmp
+
+import (
+	"sync/atomic"
+
+	"github.com/SpooderfyBot/live/av"
+)
+
+// maxTimestampGap is the largest step in ms between two packets of a track
+// that isn't counted as a discontinuity, an encoder sends several frames
+// a second even for a still picture
+const maxTimestampGap = 1000
+
+// continuity counts the timestamp gaps and reorders of a publisher, audio
+// and video are checked on their own as they interleave loosely. It is
+// written by the reader and read by the stats.
+type continuity struct {
+	discontinuities uint64
+	reordered       uint64
+
+	lastAudio, lastVideo uint32
+	hasAudio, hasVideo   bool
+}
+
+// check looks at the timestamp of p against the last one of its track
+func (c *continuity) check(p *av.Packet) {
+	var last *uint32
+	var seen *bool
+	switch {
+	case p.IsVideo:
+		last, seen = &c.lastVideo, &c.hasVideo
+	case p.IsAudio:
+		last, seen = &c.lastAudio, &c.hasAudio
+	default:
+		return
+	}
+
+	if *seen {
+		switch {
+		case p.TimeStamp < *last:
+			atomic.AddUint64(&c.reordered, 1)
+			// the last timestamp stays, the next in order packet is fine
+			return
+		case p.TimeStamp-*last > maxTimestampGap:
+			atomic.AddUint64(&c.discontinuities, 1)
+		}
+	}
+	*last, *seen = p.TimeStamp, true
+}
+
+// ContinuityStats are the counts of a publisher since it connected
+type ContinuityStats struct {
+	Discontinuities  uint64
+	ReorderedPackets uint64
+}
+
+func (c *continuity) stats() ContinuityStats {
+	return ContinuityStats{
+		Discontinuities:  atomic.LoadUint64(&c.discontinuities),
+		ReorderedPackets: atomic.LoadUint64(&c.reordered),
+	}
+}
@@ -0,0 +1,41 @@
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContinuity(t *testing.T) {
+	at := assert.New(t)
+
+	c := &continuity{}
+	video := func(timestamps ...uint32) {
+		for _, ts := range timestamps {
+			c.check(&av.Packet{IsVideo: true, TimeStamp: ts})
+		}
+	}
+	audio := func(timestamps ...uint32) {
+		for _, ts := range timestamps {
+			c.check(&av.Packet{IsAudio: true, TimeStamp: ts})
+		}
+	}
+
+	// the tracks interleave loosely, each is in order on its own
+	video(0, 40, 80)
+	audio(0, 23, 46, 69, 92)
+	video(120)
+	c.check(&av.Packet{IsMetadata: true})
+	at.Equal(ContinuityStats{}, c.stats())
+
+	// two late video frames, the next in order one is fine again
+	video(200, 160, 180, 240)
+	at.Equal(ContinuityStats{ReorderedPackets: 2}, c.stats())
+
+	// the encoder stalled for a few seconds
+	audio(5000)
+	video(240+maxTimestampGap, 240+2*maxTimestampGap+1)
+	at.Equal(ContinuityStats{Discontinuities: 2, ReorderedPackets: 2}, c.stats())
+}
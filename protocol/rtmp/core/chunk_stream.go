@@ -28,11 +28,15 @@ func (chunkStream *ChunkStream) full() bool {
 	return chunkStream.got
 }
 
-func (chunkStream *ChunkStream) new(pool *pool.Pool) {
+func (chunkStream *ChunkStream) new(pool *pool.Pool) error {
+	if chunkStream.Length > maxMessageSize {
+		return fmt.Errorf("%w: %d bytes, max %d", ErrMessageTooLarge, chunkStream.Length, maxMessageSize)
+	}
 	chunkStream.got = false
 	chunkStream.index = 0
 	chunkStream.remain = chunkStream.Length
 	chunkStream.Data = pool.Get(int(chunkStream.Length))
+	return nil
 }
 
 func (chunkStream *ChunkStream) writeHeader(w *ReadWriter) error {
@@ -146,7 +150,9 @@ func (chunkStream *ChunkStream) readChunk(r *ReadWriter, chunkSize uint32, pool
 		} else {
 			chunkStream.exted = false
 		}
-		chunkStream.new(pool)
+		if err := chunkStream.new(pool); err != nil {
+			return err
+		}
 	case 1:
 		chunkStream.Format = chunkStream.tmpFromat
 		timeStamp, _ := r.ReadUintBE(3)
@@ -160,7 +166,9 @@ func (chunkStream *ChunkStream) readChunk(r *ReadWriter, chunkSize uint32, pool
 		}
 		chunkStream.timeDelta = timeStamp
 		chunkStream.Timestamp += timeStamp
-		chunkStream.new(pool)
+		if err := chunkStream.new(pool); err != nil {
+			return err
+		}
 	case 2:
 		chunkStream.Format = chunkStream.tmpFromat
 		timeStamp, _ := r.ReadUintBE(3)
@@ -172,7 +180,9 @@ func (chunkStream *ChunkStream) readChunk(r *ReadWriter, chunkSize uint32, pool
 		}
 		chunkStream.timeDelta = timeStamp
 		chunkStream.Timestamp += timeStamp
-		chunkStream.new(pool)
+		if err := chunkStream.new(pool); err != nil {
+			return err
+		}
 	case 3:
 		if chunkStream.remain == 0 {
 			switch chunkStream.Format {
@@ -190,7 +200,9 @@ func (chunkStream *ChunkStream) readChunk(r *ReadWriter, chunkSize uint32, pool
 				}
 				chunkStream.Timestamp += timedet
 			}
-			chunkStream.new(pool)
+			if err := chunkStream.new(pool); err != nil {
+				return err
+			}
 		} else {
 			if chunkStream.exted {
 				b, err := r.Peek(4)
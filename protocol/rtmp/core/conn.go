@@ -2,11 +2,15 @@ package core
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"net"
 	"time"
 
 	"github.com/SpooderfyBot/live/utils/pio"
 	"github.com/SpooderfyBot/live/utils/pool"
+
+	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -19,6 +23,45 @@ const (
 	idSetPeerBandwidth
 )
 
+const (
+	DefaultChunkSize uint32 = 128
+	MaxChunkSize     uint32 = 0xFFFFFF
+)
+
+// DefaultMaxMessageSize is the largest message read by default, far above
+// the key frames of any sane bitrate
+const DefaultMaxMessageSize uint32 = 8 * 1024 * 1024
+
+// ErrMessageTooLarge is returned reading a message longer than the max
+// message size, the connection is closed
+var ErrMessageTooLarge = errors.New("rtmp message too large")
+
+// maxMessageSize is checked against the length of every message header,
+// before its buffer is allocated
+var maxMessageSize = DefaultMaxMessageSize
+
+// SetMaxMessageSize sets the largest message read on every connection
+func SetMaxMessageSize(size uint32) error {
+	if size < 1 || size > MaxChunkSize {
+		return fmt.Errorf("invalid max message size %d, it must be within 1 and %d", size, MaxChunkSize)
+	}
+	maxMessageSize = size
+	return nil
+}
+
+// outChunkSize is announced with a Set Chunk Size message on new connections
+var outChunkSize = DefaultChunkSize
+
+// SetChunkSize sets the chunk size used to write on new connections, it must
+// be within 1 and MaxChunkSize as a chunk can't be bigger than a message.
+func SetChunkSize(size uint32) error {
+	if size < 1 || size > MaxChunkSize {
+		return fmt.Errorf("invalid chunk size %d, it must be within 1 and %d", size, MaxChunkSize)
+	}
+	outChunkSize = size
+	return nil
+}
+
 type Conn struct {
 	net.Conn
 	chunkSize           uint32
@@ -62,6 +105,10 @@ func (conn *Conn) Read(c *ChunkStream) error {
 		cs.tmpFromat = format
 		cs.CSID = csid
 		err := cs.readChunk(conn.rw, conn.remoteChunkSize, conn.pool)
+		if errors.Is(err, ErrMessageTooLarge) {
+			log.Warningf("closing %v: %v", conn.RemoteAddr(), err)
+			conn.Close()
+		}
 		if err != nil {
 			return err
 		}
@@ -2,12 +2,15 @@ package core
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
 	neturl "net/url"
 	"strings"
+	"time"
 
 	"github.com/SpooderfyBot/live/av"
 	"github.com/SpooderfyBot/live/protocol/amf"
@@ -30,6 +33,8 @@ var (
 )
 
 type ConnClient struct {
+	// TLSConfig is used to dial rtmps urls, nil uses the system roots
+	TLSConfig  *tls.Config
 	done       bool
 	transID    int
 	url        string
@@ -209,6 +214,23 @@ func (connClient *ConnClient) writePlayMsg() error {
 }
 
 func (connClient *ConnClient) Start(url string, method string) error {
+	return connClient.StartContext(context.Background(), url, method)
+}
+
+// StartContext is Start giving up once ctx is done, in which case the
+// partial connection is closed and ctx.Err() returned
+func (connClient *ConnClient) StartContext(ctx context.Context, url string, method string) error {
+	return connClient.start(ctx, url, method, false)
+}
+
+// ConnectContext only does the handshake and the connect message of
+// StartContext, it checks the server takes the app without creating a
+// stream. Close releases the connection.
+func (connClient *ConnClient) ConnectContext(ctx context.Context, url string) error {
+	return connClient.start(ctx, url, "", true)
+}
+
+func (connClient *ConnClient) start(ctx context.Context, url string, method string, connectOnly bool) (err error) {
 	u, err := neturl.Parse(url)
 	if err != nil {
 		return err
@@ -222,28 +244,23 @@ func (connClient *ConnClient) Start(url string, method string) error {
 	connClient.app = ps[0]
 	connClient.title = ps[1]
 	connClient.query = u.RawQuery
-	connClient.tcurl = "rtmp://" + u.Host + "/" + connClient.app
-	port := ":1935"
-	host := u.Host
-	localIP := ":0"
-	var remoteIP string
-	if strings.Index(host, ":") != -1 {
-		host, port, err = net.SplitHostPort(host)
-		if err != nil {
-			return err
+	connClient.tcurl = u.Scheme + "://" + u.Host + "/" + connClient.app
+	port := u.Port()
+	if port == "" {
+		port = "1935"
+		if u.Scheme == "rtmps" {
+			port = "443"
 		}
-		port = ":" + port
 	}
-	ips, err := net.LookupIP(host)
+	host := u.Hostname()
+	localIP := ":0"
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
 	log.Debugf("ips: %v, host: %v", ips, host)
 	if err != nil {
 		log.Warning(err)
 		return err
 	}
-	remoteIP = ips[rand.Intn(len(ips))].String()
-	if strings.Index(remoteIP, ":") == -1 {
-		remoteIP += port
-	}
+	remoteIP := net.JoinHostPort(ips[rand.Intn(len(ips))].IP.String(), port)
 
 	local, err := net.ResolveTCPAddr("tcp", localIP)
 	if err != nil {
@@ -256,12 +273,55 @@ func (connClient *ConnClient) Start(url string, method string) error {
 		log.Warning(err)
 		return err
 	}
-	conn, err := net.DialTCP("tcp", local, remote)
+	dialer := &net.Dialer{LocalAddr: local}
+	tcpConn, err := dialer.DialContext(ctx, "tcp", remote.String())
 	if err != nil {
 		log.Warning(err)
 		return err
 	}
 
+	// the handshakes and the connect messages are bound by ctx too, its
+	// end unblocks them through the deadline of the connection
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			tcpConn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+		if err == nil {
+			tcpConn.SetDeadline(time.Time{})
+			return
+		}
+		tcpConn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	var conn net.Conn = tcpConn
+	if u.Scheme == "rtmps" {
+		tlsConfig := &tls.Config{}
+		if connClient.TLSConfig != nil {
+			tlsConfig = connClient.TLSConfig.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = host
+		}
+		tlsConn := tls.Client(tcpConn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			log.Warning(err)
+			return err
+		}
+		conn = tlsConn
+	}
+
 	log.Debug("connection:", "local:", conn.LocalAddr(), "remote:", conn.RemoteAddr())
 
 	connClient.conn = NewConn(conn, 4*1024)
@@ -271,10 +331,20 @@ func (connClient *ConnClient) Start(url string, method string) error {
 		return err
 	}
 
+	if outChunkSize != DefaultChunkSize {
+		c := connClient.conn.NewSetChunkSize(outChunkSize)
+		if err := connClient.conn.Write(&c); err != nil {
+			return err
+		}
+	}
+
 	log.Debug("writeConnectMsg....")
 	if err := connClient.writeConnectMsg(); err != nil {
 		return err
 	}
+	if connectOnly {
+		return nil
+	}
 	log.Debug("writeCreateStreamMsg....")
 	if err := connClient.writeCreateStreamMsg(); err != nil {
 		log.Debug("writeCreateStreamMsg error", err)
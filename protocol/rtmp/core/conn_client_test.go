@@ -0,0 +1,109 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "livego test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestConnClientRtmps(t *testing.T) {
+	at := assert.New(t)
+	cert, pool := selfSignedCert(t)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	at.Nil(err)
+	defer listener.Close()
+
+	published := make(chan string, 1)
+	go func() {
+		netconn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer netconn.Close()
+		conn := NewConn(netconn, 4*1024)
+		if err := conn.HandshakeServer(); err != nil {
+			return
+		}
+		connServer := NewConnServer(conn)
+		if err := connServer.ReadMsg(); err != nil {
+			return
+		}
+		_, name, _ := connServer.GetInfo()
+		published <- name
+	}()
+
+	client := NewConnClient()
+	client.TLSConfig = &tls.Config{RootCAs: pool}
+	at.Nil(client.Start("rtmps://"+listener.Addr().String()+"/live/secure", av.PUBLISH))
+	defer client.Close(nil)
+
+	select {
+	case name := <-published:
+		at.Equal("secure", name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish over rtmps timed out")
+	}
+}
+
+func TestConnClientRtmpsRejectsUnknownCert(t *testing.T) {
+	cert, _ := selfSignedCert(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if c, err := listener.Accept(); err == nil {
+			c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+
+	client := NewConnClient()
+	assert.NotNil(t, client.Start("rtmps://"+listener.Addr().String()+"/live/secure", av.PUBLISH))
+}
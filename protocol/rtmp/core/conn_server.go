@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/SpooderfyBot/live/av"
 	"github.com/SpooderfyBot/live/protocol/amf"
@@ -73,6 +74,14 @@ type ConnServer struct {
 	decoder       *amf.Decoder
 	encoder       *amf.Encoder
 	bytesw        *bytes.Buffer
+
+	// the chunk stream and stream of the publish or play command,
+	// the ones the closing onStatus is sent on
+	cmdCSID     uint32
+	cmdStreamID uint32
+	// players are written from their own goroutine, the closing
+	// onStatus must not interleave with a packet
+	writeLock sync.Mutex
 }
 
 func NewConnServer(conn *Conn) *ConnServer {
@@ -135,12 +144,37 @@ func (connServer *ConnServer) connect(vs []interface{}) error {
 	return nil
 }
 
-func (connServer *ConnServer) releaseStream(vs []interface{}) error {
-	return nil
+// fcCommand reads the transaction id and the stream name of the
+// releaseStream, FCPublish and FCUnpublish commands FMLE like encoders send
+// around publish, they wait for an answer to each of them
+func (connServer *ConnServer) fcCommand(vs []interface{}) (name string) {
+	for k, v := range vs {
+		switch v.(type) {
+		case string:
+			if k == 2 {
+				name = v.(string)
+			}
+		case float64:
+			connServer.transactionID = int(v.(float64))
+		}
+	}
+	return
 }
 
-func (connServer *ConnServer) fcPublish(vs []interface{}) error {
-	return nil
+// fcResp answers a releaseStream, FCPublish or FCUnpublish with a _result
+// and, for the last two, the onFCPublish or onFCUnpublish the encoder
+// waits for
+func (connServer *ConnServer) fcResp(cur *ChunkStream, onStatus, code, name string) error {
+	if err := connServer.writeMsg(cur.CSID, cur.StreamID, "_result", connServer.transactionID, nil); err != nil {
+		return err
+	}
+	if onStatus == "" {
+		return nil
+	}
+	event := make(amf.Object)
+	event["code"] = code
+	event["description"] = name
+	return connServer.writeMsg(cur.CSID, cur.StreamID, onStatus, 0, nil, event)
 }
 
 func (connServer *ConnServer) connectResp(cur *ChunkStream) error {
@@ -148,7 +182,7 @@ func (connServer *ConnServer) connectResp(cur *ChunkStream) error {
 	connServer.conn.Write(&c)
 	c = connServer.conn.NewSetPeerBandwidth(2500000)
 	connServer.conn.Write(&c)
-	c = connServer.conn.NewSetChunkSize(uint32(1024))
+	c = connServer.conn.NewSetChunkSize(outChunkSize)
 	connServer.conn.Write(&c)
 
 	resp := make(amf.Object)
@@ -259,6 +293,12 @@ func (connServer *ConnServer) handleCmdMsg(c *ChunkStream) error {
 			if err = connServer.connect(vs[1:]); err != nil {
 				return err
 			}
+			if !connectAllowed(connServer.ConnInfo.TcUrl) {
+				if err = connServer.connectRejected(c); err != nil {
+					return err
+				}
+				return fmt.Errorf("%w, tcUrl=%q", ErrConnectRejected, connServer.ConnInfo.TcUrl)
+			}
 			if err = connServer.connectResp(c); err != nil {
 				return err
 			}
@@ -273,6 +313,7 @@ func (connServer *ConnServer) handleCmdMsg(c *ChunkStream) error {
 			if err = connServer.publishOrPlay(vs[1:]); err != nil {
 				return err
 			}
+			connServer.cmdCSID, connServer.cmdStreamID = c.CSID, c.StreamID
 			if err = connServer.publishResp(c); err != nil {
 				return err
 			}
@@ -283,6 +324,7 @@ func (connServer *ConnServer) handleCmdMsg(c *ChunkStream) error {
 			if err = connServer.publishOrPlay(vs[1:]); err != nil {
 				return err
 			}
+			connServer.cmdCSID, connServer.cmdStreamID = c.CSID, c.StreamID
 			if err = connServer.playResp(c); err != nil {
 				return err
 			}
@@ -290,10 +332,20 @@ func (connServer *ConnServer) handleCmdMsg(c *ChunkStream) error {
 			connServer.isPublisher = false
 			log.Debug("handle play req done")
 		case cmdFcpublish:
-			connServer.fcPublish(vs)
+			name := connServer.fcCommand(vs[1:])
+			if err = connServer.fcResp(c, "onFCPublish", "NetStream.Publish.Start", name); err != nil {
+				return err
+			}
 		case cmdReleaseStream:
-			connServer.releaseStream(vs)
+			connServer.fcCommand(vs[1:])
+			if err = connServer.fcResp(c, "", "", ""); err != nil {
+				return err
+			}
 		case cmdFCUnpublish:
+			name := connServer.fcCommand(vs[1:])
+			if err = connServer.fcResp(c, "onFCUnpublish", "NetStream.Unpublish.Success", name); err != nil {
+				return err
+			}
 		case cmdDeleteStream:
 		default:
 			log.Debug("no support command=", vs[0].(string))
@@ -327,6 +379,8 @@ func (connServer *ConnServer) IsPublisher() bool {
 }
 
 func (connServer *ConnServer) Write(c ChunkStream) error {
+	connServer.writeLock.Lock()
+	defer connServer.writeLock.Unlock()
 	if c.TypeID == av.TAG_SCRIPTDATAAMF0 ||
 		c.TypeID == av.TAG_SCRIPTDATAAMF3 {
 		var err error
@@ -339,6 +393,8 @@ func (connServer *ConnServer) Write(c ChunkStream) error {
 }
 
 func (connServer *ConnServer) Flush() error {
+	connServer.writeLock.Lock()
+	defer connServer.writeLock.Unlock()
 	return connServer.conn.Flush()
 }
 
@@ -353,6 +409,18 @@ func (connServer *ConnServer) GetInfo() (app string, name string, url string) {
 	return
 }
 
+// RemoteAddr is the address of the peer, it identifies a player
+func (connServer *ConnServer) RemoteAddr() string {
+	return connServer.conn.RemoteAddr().String()
+}
+
+// Close closes the connection, a *StatusError is sent to the client first
+// once it published or played
 func (connServer *ConnServer) Close(err error) {
+	if e, ok := err.(*StatusError); ok && connServer.done {
+		if werr := connServer.sendStatus(e); werr != nil {
+			log.Debug("send close status err: ", werr)
+		}
+	}
 	connServer.conn.Close()
 }
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/SpooderfyBot/live/protocol/amf"
+	"github.com/SpooderfyBot/live/utils/pool"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newBufConn(b *bytes.Buffer) *Conn {
+	return &Conn{
+		pool:                pool.NewPool(),
+		rw:                  NewReadWriter(b, 1024),
+		chunkSize:           DefaultChunkSize,
+		remoteChunkSize:     DefaultChunkSize,
+		windowAckSize:       2500000,
+		remoteWindowAckSize: 2500000,
+		chunks:              make(map[uint32]ChunkStream),
+	}
+}
+
+func TestConnectRespSetsChunkSize(t *testing.T) {
+	at := assert.New(t)
+
+	at.NotNil(SetChunkSize(0))
+	at.NotNil(SetChunkSize(MaxChunkSize + 1))
+	at.Nil(SetChunkSize(4096))
+	defer SetChunkSize(DefaultChunkSize)
+
+	out := bytes.NewBuffer(nil)
+	connServer := NewConnServer(newBufConn(out))
+	at.Nil(connServer.connectResp(&ChunkStream{CSID: 3}))
+
+	reader := newBufConn(bytes.NewBuffer(out.Bytes()))
+	var found bool
+	for i := 0; i < 3; i++ {
+		var c ChunkStream
+		at.Nil(reader.Read(&c))
+		if c.TypeID == idSetChunkSize {
+			found = true
+			at.Equal(uint32(4096), binary.BigEndian.Uint32(c.Data))
+		}
+	}
+	at.True(found)
+	at.Equal(uint32(4096), connServer.conn.chunkSize)
+}
+
+func TestAllowedConnectHosts(t *testing.T) {
+	at := assert.New(t)
+	SetAllowedConnectHosts([]string{"live.example.com", "10.0.0.5:1936"})
+	defer SetAllowedConnectHosts(nil)
+
+	connect := func(tcUrl string) (string, error) {
+		b := bytes.NewBuffer(nil)
+		encoder := &amf.Encoder{}
+		encoder.Encode(b, "connect", amf.AMF0)
+		encoder.Encode(b, 1.0, amf.AMF0)
+		encoder.Encode(b, amf.Object{"app": "live", "tcUrl": tcUrl}, amf.AMF0)
+
+		out := bytes.NewBuffer(nil)
+		connServer := NewConnServer(newBufConn(out))
+		err := connServer.handleCmdMsg(&ChunkStream{CSID: 3, TypeID: 20, Data: b.Bytes()})
+
+		// the last message written answers the connect
+		reader := newBufConn(bytes.NewBuffer(out.Bytes()))
+		var name string
+		for {
+			var c ChunkStream
+			if reader.Read(&c) != nil {
+				break
+			}
+			if c.TypeID == 20 {
+				vs, _ := (&amf.Decoder{}).DecodeBatch(bytes.NewReader(c.Data), amf.AMF0)
+				name = vs[0].(string)
+			}
+		}
+		return name, err
+	}
+
+	name, err := connect("rtmp://LIVE.example.com/live")
+	at.Nil(err)
+	at.Equal("_result", name)
+	_, err = connect("rtmp://10.0.0.5:1936/live")
+	at.Nil(err)
+
+	for _, tcUrl := range []string{"rtmp://evil.example.com/live", "rtmp://10.0.0.5/live", ""} {
+		name, err = connect(tcUrl)
+		at.True(errors.Is(err, ErrConnectRejected), tcUrl)
+		at.Equal("onStatus", name)
+	}
+
+	SetAllowedConnectHosts(nil)
+	_, err = connect("rtmp://evil.example.com/live")
+	at.Nil(err)
+}
+
+func TestFMLEPublishSequence(t *testing.T) {
+	at := assert.New(t)
+
+	out := bytes.NewBuffer(nil)
+	connServer := NewConnServer(newBufConn(out))
+	// send runs a command the way FMLE sends it and returns the command
+	// messages the server answered with
+	send := func(args ...interface{}) [][]interface{} {
+		b := bytes.NewBuffer(nil)
+		for _, arg := range args {
+			_, err := (&amf.Encoder{}).Encode(b, arg, amf.AMF0)
+			at.Nil(err)
+		}
+		from := out.Len()
+		at.Nil(connServer.handleCmdMsg(&ChunkStream{CSID: 3, TypeID: 20, Data: b.Bytes()}))
+
+		reader := newBufConn(bytes.NewBuffer(out.Bytes()[from:]))
+		var msgs [][]interface{}
+		for {
+			var c ChunkStream
+			if reader.Read(&c) != nil {
+				return msgs
+			}
+			if c.TypeID == 20 {
+				vs, _ := (&amf.Decoder{}).DecodeBatch(bytes.NewReader(c.Data), amf.AMF0)
+				msgs = append(msgs, vs)
+			}
+		}
+	}
+
+	msgs := send("connect", 1.0, amf.Object{
+		"app":      "live",
+		"type":     "nonprivate",
+		"flashVer": "FMLE/3.0 (compatible; FMSc/1.0)",
+		"swfUrl":   "rtmp://127.0.0.1/live",
+		"tcUrl":    "rtmp://127.0.0.1/live",
+	})
+	if at.Equal(1, len(msgs)) {
+		at.Equal([]interface{}{"_result", 1.0}, msgs[0][:2])
+	}
+
+	msgs = send("releaseStream", 2.0, nil, "movie")
+	if at.Equal(1, len(msgs)) {
+		at.Equal([]interface{}{"_result", 2.0, nil}, msgs[0])
+	}
+
+	msgs = send("FCPublish", 3.0, nil, "movie")
+	if at.Equal(2, len(msgs)) {
+		at.Equal([]interface{}{"_result", 3.0, nil}, msgs[0])
+		at.Equal("onFCPublish", msgs[1][0])
+		at.Equal(amf.Object{"code": "NetStream.Publish.Start", "description": "movie"}, msgs[1][3])
+	}
+
+	msgs = send("createStream", 4.0, nil)
+	if at.Equal(1, len(msgs)) {
+		at.Equal([]interface{}{"_result", 4.0, nil, 1.0}, msgs[0])
+	}
+
+	msgs = send("publish", 5.0, nil, "movie", "live")
+	if at.Equal(1, len(msgs)) {
+		at.Equal("onStatus", msgs[0][0])
+		at.Equal("NetStream.Publish.Start", msgs[0][3].(amf.Object)["code"])
+	}
+	at.True(connServer.done)
+	at.True(connServer.IsPublisher())
+	app, name, _ := connServer.GetInfo()
+	at.Equal("live", app)
+	at.Equal("movie", name)
+}
@@ -2,7 +2,10 @@ package core
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"net"
+	"runtime"
 	"testing"
 
 	"github.com/SpooderfyBot/live/utils/pool"
@@ -250,3 +253,32 @@ func TestConnWrite(t *testing.T) {
 	conn.Flush()
 	at.Equal(wr.Bytes(), []byte{0x4, 0x0, 0x0, 0xa0, 0x0, 0x0, 0x4, 0x8, 0x0, 0x0, 0x0, 0x0, 0x1, 0x2, 0x3, 0x4})
 }
+
+func TestConnReadTooLarge(t *testing.T) {
+	at := assert.New(t)
+	at.Nil(SetMaxMessageSize(1024 * 1024))
+	defer SetMaxMessageSize(DefaultMaxMessageSize)
+	at.NotNil(SetMaxMessageSize(0))
+
+	// a video message announcing 16MiB, followed by a single chunk
+	data := []byte{
+		0x06, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0x09, 0x01, 0x00, 0x00, 0x00,
+	}
+	data = append(data, make([]byte, 128)...)
+	server, client := net.Pipe()
+	defer client.Close()
+	go client.Write(data)
+
+	conn := NewConn(server, 1024)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	var c ChunkStream
+	err := conn.Read(&c)
+	runtime.ReadMemStats(&after)
+	at.True(errors.Is(err, ErrMessageTooLarge), "%v", err)
+	at.True(after.TotalAlloc-before.TotalAlloc < 1024*1024, "allocated %d bytes", after.TotalAlloc-before.TotalAlloc)
+
+	// the connection was closed
+	_, err = client.Write([]byte{0})
+	at.Equal(io.ErrClosedPipe, err)
+}
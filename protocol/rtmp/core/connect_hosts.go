@@ -0,0 +1,52 @@
+package core
+
+import (
+	"errors"
+	neturl "net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/SpooderfyBot/live/protocol/amf"
+)
+
+// ErrConnectRejected closes a connection whose connect command has a tcUrl
+// off the allowed connect hosts
+var ErrConnectRejected = errors.New("rtmp connect rejected")
+
+// allowedConnectHosts holds the []string of hosts a tcUrl must be on,
+// empty accepts any connect
+var allowedConnectHosts atomic.Value
+
+// SetAllowedConnectHosts sets the hosts the tcUrl of a connect must be on,
+// a host or a host:port, nil accepts every connect
+func SetAllowedConnectHosts(hosts []string) {
+	allowedConnectHosts.Store(hosts)
+}
+
+// connectAllowed tells whether tcUrl is on one of the allowed hosts
+func connectAllowed(tcUrl string) bool {
+	hosts, _ := allowedConnectHosts.Load().([]string)
+	if len(hosts) == 0 {
+		return true
+	}
+	u, err := neturl.Parse(strings.TrimSpace(tcUrl))
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	for _, host := range hosts {
+		if strings.EqualFold(host, u.Hostname()) || strings.EqualFold(host, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// connectRejected tells the client its connect was refused, on the
+// chunk stream of the connect command
+func (connServer *ConnServer) connectRejected(cur *ChunkStream) error {
+	event := make(amf.Object)
+	event["level"] = "error"
+	event["code"] = "NetConnection.Connect.Rejected"
+	event["description"] = "Connection rejected, tcUrl host is not allowed."
+	return connServer.writeMsg(cur.CSID, cur.StreamID, "onStatus", 0, nil, event)
+}
@@ -141,6 +141,12 @@ func (conn *Conn) HandshakeClient() (err error) {
 }
 
 func (conn *Conn) HandshakeServer() (err error) {
+	return conn.HandshakeServerDeadline(time.Now().Add(timeout))
+}
+
+// HandshakeServerDeadline fails the handshake when it is not over by
+// deadline, however slowly the client trickles its bytes in
+func (conn *Conn) HandshakeServerDeadline(deadline time.Time) (err error) {
 	var random [(1 + 1536*2) * 2]byte
 
 	C0C1C2 := random[:1536*2+1]
@@ -156,11 +162,11 @@ func (conn *Conn) HandshakeServer() (err error) {
 	S2 := S0S1S2[1536+1:]
 
 	// < C0C1
-	conn.Conn.SetDeadline(time.Now().Add(timeout))
+	conn.Conn.SetDeadline(deadline)
 	if _, err = io.ReadFull(conn.rw, C0C1); err != nil {
 		return
 	}
-	conn.Conn.SetDeadline(time.Now().Add(timeout))
+	conn.Conn.SetDeadline(deadline)
 	if C0[0] != 3 {
 		err = fmt.Errorf("rtmp: handshake version=%d invalid", C0[0])
 		return
@@ -188,17 +194,17 @@ func (conn *Conn) HandshakeServer() (err error) {
 	}
 
 	// > S0S1S2
-	conn.Conn.SetDeadline(time.Now().Add(timeout))
+	conn.Conn.SetDeadline(deadline)
 	if _, err = conn.rw.Write(S0S1S2); err != nil {
 		return
 	}
-	conn.Conn.SetDeadline(time.Now().Add(timeout))
+	conn.Conn.SetDeadline(deadline)
 	if err = conn.rw.Flush(); err != nil {
 		return
 	}
 
 	// < C2
-	conn.Conn.SetDeadline(time.Now().Add(timeout))
+	conn.Conn.SetDeadline(deadline)
 	if _, err = io.ReadFull(conn.rw, C2); err != nil {
 		return
 	}
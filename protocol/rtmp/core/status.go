@@ -0,0 +1,36 @@
+package core
+
+import (
+	"time"
+
+	"github.com/SpooderfyBot/live/protocol/amf"
+)
+
+// statusWriteTimeout bounds the onStatus write of a closing connection,
+// a player that stopped reading must not keep it open
+const statusWriteTimeout = time.Second
+
+// StatusError is a reason to close a connection the client is told of,
+// with an onStatus message sent before the connection is closed
+type StatusError struct {
+	Level       string
+	Code        string
+	Description string
+}
+
+func (e *StatusError) Error() string {
+	return e.Description
+}
+
+// sendStatus writes e on the stream of the publish or play command
+func (connServer *ConnServer) sendStatus(e *StatusError) error {
+	connServer.writeLock.Lock()
+	defer connServer.writeLock.Unlock()
+
+	connServer.conn.Conn.SetWriteDeadline(time.Now().Add(statusWriteTimeout))
+	event := make(amf.Object)
+	event["level"] = e.Level
+	event["code"] = e.Code
+	event["description"] = e.Description
+	return connServer.writeMsg(connServer.cmdCSID, connServer.cmdStreamID, "onStatus", 0, nil, event)
+}
@@ -0,0 +1,47 @@
+package rtmp
+
+import (
+	"github.com/SpooderfyBot/live/configure"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the values of rtmp.duplicate_publish, what happens when a publisher
+// connects to a room that already has one
+const (
+	// DuplicateRejectNew closes the new publisher, the live one goes on
+	DuplicateRejectNew = "reject_new"
+	// DuplicateReplaceOld closes the live publisher for the new one
+	DuplicateReplaceOld = "replace_old"
+	// DuplicateRejectNewWithStatus is DuplicateRejectNew sending the new
+	// publisher an onStatus error before closing it
+	DuplicateRejectNewWithStatus = "reject_new_with_status"
+)
+
+// duplicatePublish is rtmp.duplicate_publish, DuplicateRejectNew when
+// unset or unknown
+func duplicatePublish() string {
+	switch policy := configure.Config.GetString("rtmp.duplicate_publish"); policy {
+	case DuplicateReplaceOld, DuplicateRejectNewWithStatus:
+		return policy
+	case "", DuplicateRejectNew:
+	default:
+		log.Warningf("unknown rtmp.duplicate_publish %q, using %s", policy, DuplicateRejectNew)
+	}
+	return DuplicateRejectNew
+}
+
+// PublishChecker is implemented by handlers telling if a room has a
+// publisher, such as *RtmpStream
+type PublishChecker interface {
+	Publishing(key string) bool
+}
+
+// Publishing tells if the stream of key is read from its own publisher.
+// A publisher that dropped within rtmp.publish_grace is not publishing,
+// nor is a room playing a promoted stream.
+func (rs *RtmpStream) Publishing(key string) bool {
+	s, ok := rs.GetStream(key)
+	// r is set before the start is
+	return ok && s.started() && s.GetReader().Info().Key == key
+}
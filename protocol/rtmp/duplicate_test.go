@@ -0,0 +1,84 @@
+package rtmp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/amf"
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lastStatus reads c until it is closed and returns the last onStatus
+func lastStatus(c *core.ConnClient) amf.Object {
+	var status amf.Object
+	for {
+		var cs core.ChunkStream
+		if err := c.Read(&cs); err != nil {
+			return status
+		}
+		if cs.TypeID != 20 {
+			continue
+		}
+		vs, _ := (&amf.Decoder{}).DecodeBatch(bytes.NewReader(cs.Data), amf.AMF0)
+		if len(vs) == 4 && vs[0] == "onStatus" {
+			status, _ = vs[3].(amf.Object)
+		}
+	}
+}
+
+func TestDuplicatePublish(t *testing.T) {
+	defer configure.Config.Set("rtmp.duplicate_publish", "")
+
+	for _, policy := range []string{DuplicateRejectNew, DuplicateReplaceOld, DuplicateRejectNewWithStatus} {
+		t.Run(policy, func(t *testing.T) {
+			at := assert.New(t)
+			configure.Config.Set("rtmp.duplicate_publish", policy)
+			key, err := configure.RoomKeys.SetKey("duplicate_" + policy)
+			at.Nil(err)
+			room := "live/duplicate_" + policy
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			at.Nil(err)
+			defer listener.Close()
+			handler := NewRtmpStream()
+			go NewRtmpServer(handler, nil).Serve(listener)
+			url := "rtmp://" + listener.Addr().String() + "/live/" + key
+
+			first := core.NewConnClient()
+			at.Nil(first.Start(url, av.PUBLISH))
+			defer first.Close(nil)
+			at.True(waitFor(func() bool { return handler.Publishing(room) }))
+			s, _ := handler.GetStream(room)
+			firstID := s.ID()
+
+			second := core.NewConnClient()
+			at.Nil(second.Start(url, av.PUBLISH))
+			defer second.Close(nil)
+
+			switch policy {
+			case DuplicateRejectNew:
+				// closed without an onStatus
+				at.Nil(lastStatus(second))
+			case DuplicateRejectNewWithStatus:
+				status := lastStatus(second)
+				at.Equal("NetStream.Publish.BadName", status["code"])
+				at.Equal("the room already has a publisher", status["description"])
+			case DuplicateReplaceOld:
+				at.Equal("replaced by a new publisher", lastStatus(first)["description"])
+				at.True(waitFor(func() bool {
+					s, _ := handler.GetStream(room)
+					return handler.Publishing(room) && s.ID() != firstID
+				}))
+				return
+			}
+			s, _ = handler.GetStream(room)
+			at.Equal(firstID, s.ID())
+			at.True(handler.Publishing(room))
+		})
+	}
+}
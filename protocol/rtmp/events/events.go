@@ -0,0 +1,180 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type Type string
+
+const (
+	PublishStart  Type = "publish_start"
+	PublishEnd    Type = "publish_end"
+	PublishIdle   Type = "publish_idle"
+	PublishLimit  Type = "publish_limit"
+	CodecChange   Type = "codec_change"
+	GopFlush      Type = "gop_flush"
+	Promote       Type = "promote"
+	Alias         Type = "alias"
+	PlayerJoin    Type = "player_join"
+	PlayerLeave   Type = "player_leave"
+	PlayerLag     Type = "player_lag"
+	RelayStart    Type = "relay_start"
+	RelayStop     Type = "relay_stop"
+	RelayFail     Type = "relay_fail"
+	RelayRetry    Type = "relay_retry"
+	RelayFailover Type = "relay_failover"
+	RelayResync   Type = "relay_resync"
+	RelayReap     Type = "relay_reap"
+	HLSWriteFail  Type = "hls_write_fail"
+)
+
+type Event struct {
+	Time   time.Time `json:"time"`
+	Key    string    `json:"key"`
+	Type   Type      `json:"type"`
+	Reason string    `json:"reason"`
+}
+
+// ring keeps the last len(buf) events of a single stream key
+type ring struct {
+	buf   []Event
+	next  int
+	count int
+}
+
+func newRing(size int) *ring {
+	return &ring{
+		buf: make([]Event, size),
+	}
+}
+
+func (r *ring) push(e Event) {
+	r.buf[r.next] = e
+	r.next = (r.next + 1) % len(r.buf)
+	if r.count < len(r.buf) {
+		r.count++
+	}
+}
+
+// last is the time of the newest event
+func (r *ring) last() time.Time {
+	return r.buf[(r.next-1+len(r.buf))%len(r.buf)].Time
+}
+
+func (r *ring) list() []Event {
+	ret := make([]Event, 0, r.count)
+	start := r.next - r.count
+	if start < 0 {
+		start += len(r.buf)
+	}
+	for i := 0; i < r.count; i++ {
+		ret = append(ret, r.buf[(start+i)%len(r.buf)])
+	}
+	return ret
+}
+
+// defaultTTL is how long the events of a key are kept after its last one
+const defaultTTL = time.Hour
+
+// History keeps the last size events of each key, a key without events for
+// ttl is dropped so the rooms that come and go don't pile up
+type History struct {
+	lock  sync.RWMutex
+	size  int
+	debug bool
+	ttl   time.Duration
+	swept time.Time
+	rooms map[string]*ring
+}
+
+func NewHistory(size int, debug bool) *History {
+	if size <= 0 {
+		size = 1
+	}
+	return &History{
+		size:  size,
+		debug: debug,
+		ttl:   defaultTTL,
+		rooms: make(map[string]*ring),
+	}
+}
+
+var Default = newDefault()
+
+// newDefault is the history of event_history_size events per key, kept
+// event_history_ttl seconds after the last one
+func newDefault() *History {
+	h := NewHistory(
+		configure.Config.GetInt("event_history_size"),
+		configure.Config.GetBool("event_log_debug"),
+	)
+	if s := configure.Config.GetInt("event_history_ttl"); s > 0 {
+		h.ttl = time.Duration(s) * time.Second
+	}
+	return h
+}
+
+func (h *History) Emit(key string, t Type, reason string) {
+	e := Event{
+		Time:   time.Now(),
+		Key:    key,
+		Type:   t,
+		Reason: reason,
+	}
+
+	if h.debug {
+		log.Debugf("[EVENT] %s %s: %s", key, t, reason)
+	}
+
+	h.record(e)
+}
+
+func (h *History) record(e Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	// a sweep every half ttl keeps a key at most one and a half ttl
+	if e.Time.Sub(h.swept) >= h.ttl/2 {
+		h.sweep(e.Time)
+	}
+	r, ok := h.rooms[e.Key]
+	if !ok {
+		r = newRing(h.size)
+		h.rooms[e.Key] = r
+	}
+	r.push(e)
+}
+
+// sweep drops the keys whose last event is older than ttl
+func (h *History) sweep(now time.Time) {
+	for key, r := range h.rooms {
+		if now.Sub(r.last()) > h.ttl {
+			delete(h.rooms, key)
+		}
+	}
+	h.swept = now
+}
+
+// Get returns the recorded events of key, oldest first
+func (h *History) Get(key string) []Event {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	r, ok := h.rooms[key]
+	if !ok {
+		return []Event{}
+	}
+	return r.list()
+}
+
+func Emit(key string, t Type, reason string) {
+	Default.Emit(key, t, reason)
+}
+
+func Get(key string) []Event {
+	return Default.Get(key)
+}
@@ -0,0 +1,57 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryKeepsLastEvents(t *testing.T) {
+	at := assert.New(t)
+	h := NewHistory(3, false)
+
+	h.Emit("live/a", PublishStart, "1")
+	h.Emit("live/a", PlayerJoin, "2")
+	at.Equal(2, len(h.Get("live/a")))
+
+	h.Emit("live/a", PlayerLeave, "3")
+	h.Emit("live/a", PublishEnd, "4")
+	got := h.Get("live/a")
+	at.Equal(3, len(got))
+	at.Equal("2", got[0].Reason)
+	at.Equal("3", got[1].Reason)
+	at.Equal("4", got[2].Reason)
+	at.Equal(PublishEnd, got[2].Type)
+}
+
+func TestHistorySeparatesKeys(t *testing.T) {
+	at := assert.New(t)
+	h := NewHistory(2, false)
+
+	h.Emit("live/a", PublishStart, "")
+	h.Emit("live/b", PublishStart, "")
+	h.Emit("live/b", PublishEnd, "")
+
+	at.Equal(1, len(h.Get("live/a")))
+	at.Equal(2, len(h.Get("live/b")))
+	at.Equal(0, len(h.Get("live/c")))
+}
+
+func TestHistoryDropsIdleKeys(t *testing.T) {
+	at := assert.New(t)
+	h := NewHistory(2, false)
+	h.ttl = time.Minute
+	now := time.Now()
+
+	h.record(Event{Time: now, Key: "live/gone", Type: PublishEnd})
+	h.record(Event{Time: now.Add(50 * time.Second), Key: "live/on", Type: PublishStart})
+	// the sweeps are half a ttl apart, the last one was at 50s
+	h.record(Event{Time: now.Add(70 * time.Second), Key: "live/on", Type: PlayerJoin})
+	at.Equal(1, len(h.Get("live/gone")))
+
+	h.record(Event{Time: now.Add(81 * time.Second), Key: "live/on", Type: PlayerLeave})
+	at.Equal(0, len(h.Get("live/gone")))
+	at.Equal(2, len(h.Get("live/on")))
+	at.Equal(1, len(h.rooms))
+}
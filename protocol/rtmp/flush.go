@@ -0,0 +1,20 @@
+package rtmp
+
+import "sync/atomic"
+
+// FlushGop empties the gop cache of the live stream of key without
+// disconnecting its publisher or players, the players joining next wait for
+// the following key frame. It is done by TransStart on the next packet.
+func (rs *RtmpStream) FlushGop(key string) error {
+	s, ok := rs.GetStream(key)
+	if !ok || s.GetReader() == nil || !s.started() {
+		return ErrNotLive
+	}
+	s.FlushGop()
+	return nil
+}
+
+// FlushGop has the gop cache emptied before the next packet is cached
+func (s *Stream) FlushGop() {
+	atomic.StoreInt32(&s.flushGop, 1)
+}
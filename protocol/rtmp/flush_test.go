@@ -0,0 +1,65 @@
+package rtmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlushGop(t *testing.T) {
+	at := assert.New(t)
+	start := time.Now()
+
+	rs := NewRtmpStream()
+	publisher := &keyedReader{newChanReader("publisher"), "live/flush"}
+	rs.HandleReader(publisher)
+	defer publisher.Close(nil)
+	player := newKeyedWriter("live/flush", "player")
+	rs.HandleWriter(player)
+
+	video := func(ts uint32, data []byte) {
+		tag := &flv.Tag{}
+		_, err := tag.ParseMediaTagHeader(data, true)
+		at.Nil(err)
+		publisher.packets <- av.Packet{IsVideo: true, TimeStamp: ts, Data: data, Header: tag}
+	}
+	key := func(ts uint32) { video(ts, flv.NewAVCNALU([][]byte{{0x65, 0x88, 0x84}}, true, 0)) }
+	inter := func(ts uint32) { video(ts, flv.NewAVCNALU([][]byte{{0x41, 0x9a}}, false, 0)) }
+
+	at.Equal(ErrNotLive, rs.FlushGop("live/nobody"))
+
+	video(0, flv.NewAVCSeqHeader([]byte{0x67, 0x42, 0x00, 0x1e, 0xab}, []byte{0x68, 0xce, 0x3c, 0x80}))
+	key(10)
+	inter(20)
+	at.True(waitFor(received(player, 20)))
+
+	at.Nil(rs.FlushGop("live/flush"))
+	inter(30)
+	// the gop of 10 is gone, a player joining gets the sequence header and
+	// then waits for the next key frame
+	late := newKeyedWriter("live/flush", "late")
+	rs.HandleWriter(late)
+	inter(40)
+	inter(50)
+	key(60)
+	at.True(waitFor(received(late, 60)))
+	at.Equal([]uint32{0, 60}, late.recorded())
+
+	// nobody was disconnected
+	at.True(waitFor(received(player, 60)))
+	at.Nil(player.closed())
+	at.True(rs.Publishing("live/flush"))
+
+	flushes := 0
+	for _, e := range events.Get("live/flush") {
+		if e.Type == events.GopFlush && !e.Time.Before(start) {
+			flushes++
+		}
+	}
+	at.Equal(1, flushes)
+}
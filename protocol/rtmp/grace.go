@@ -0,0 +1,78 @@
+package rtmp
+
+import (
+	"time"
+
+	"github.com/SpooderfyBot/live/configure"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// publishGrace is rtmp.publish_grace, the seconds the players and the gop
+// cache of a room wait for a publisher whose connection dropped, 0 ends
+// the stream at once
+func publishGrace() time.Duration {
+	return time.Duration(configure.Config.GetFloat64("rtmp.publish_grace") * float64(time.Second))
+}
+
+// holdForReconnect keeps the players of s attached for d, after which the
+// stream is closed as if the publisher had not dropped
+func (s *Stream) holdForReconnect(d time.Duration) {
+	s.graceLock.Lock()
+	defer s.graceLock.Unlock()
+
+	log.Infof("[%v] publisher dropped, waiting %v for it to come back", s.info.Key, d)
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		s.graceLock.Lock()
+		expired := s.grace == t
+		if expired {
+			s.grace = nil
+		}
+		s.graceLock.Unlock()
+
+		if expired {
+			log.Infof("[%v] publisher did not come back, closing stream", s.info.Key)
+			s.closeInter()
+		}
+	})
+	s.grace = t
+}
+
+// resume tells whether s was waiting for its publisher, in which case it
+// stops waiting and the new publisher takes over the stream as it is
+func (s *Stream) resume() bool {
+	s.graceLock.Lock()
+	defer s.graceLock.Unlock()
+
+	if s.grace == nil {
+		return false
+	}
+	s.grace.Stop()
+	s.grace = nil
+	return true
+}
+
+// waiting tells whether s waits for its publisher to come back
+func (s *Stream) waiting() bool {
+	s.graceLock.Lock()
+	defer s.graceLock.Unlock()
+	return s.grace != nil
+}
+
+// Resumer is implemented by the writers that mark the break in the
+// timeline of a resumed stream, such as the hls source
+type Resumer interface {
+	PublisherResumed()
+}
+
+// notifyResumed tells the writers of s that a new publisher resumes it,
+// before any of its packets is written
+func (s *Stream) notifyResumed() {
+	s.ws.Range(func(key, val interface{}) bool {
+		if r, ok := val.(*PackWriterCloser).w.(Resumer); ok {
+			r.PublisherResumed()
+		}
+		return true
+	})
+}
@@ -0,0 +1,96 @@
+package rtmp
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// closingWriter is a recordWriter keeping why it was closed
+type closingWriter struct {
+	recordWriter
+	closeLock sync.Mutex
+	reason    error
+}
+
+func (w *closingWriter) Close(err error) {
+	w.closeLock.Lock()
+	w.reason = err
+	w.closeLock.Unlock()
+}
+
+func (w *closingWriter) closed() error {
+	w.closeLock.Lock()
+	defer w.closeLock.Unlock()
+	return w.reason
+}
+
+// resumingWriter is a closingWriter counting the resumes of its stream
+type resumingWriter struct {
+	closingWriter
+	resumes int32
+}
+
+func (w *resumingWriter) PublisherResumed() { atomic.AddInt32(&w.resumes, 1) }
+
+func TestPublishGrace(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("rtmp.publish_grace", 0.3)
+	defer configure.Config.Set("rtmp.publish_grace", 0)
+
+	rs := NewRtmpStream()
+	first := newChanReader("first")
+	rs.HandleReader(first)
+	stream, _ := rs.GetStream("live/rebase")
+	player := &resumingWriter{closingWriter: closingWriter{recordWriter: recordWriter{RWBaser: av.NewRWBaser(0)}}}
+	rs.HandleWriter(player)
+	for ts := uint32(0); ts <= 200; ts += 40 {
+		first.packets <- av.Packet{IsAudio: true, TimeStamp: ts}
+	}
+	at.Equal(int32(0), atomic.LoadInt32(&player.resumes))
+
+	// the connection drops and the encoder is back within the window
+	first.Close(nil)
+	time.Sleep(100 * time.Millisecond)
+	at.Nil(player.closed())
+	second := newChanReader("second")
+	rs.HandleReader(second)
+	for ts := uint32(0); ts <= 200; ts += 40 {
+		second.packets <- av.Packet{IsAudio: true, TimeStamp: ts}
+	}
+	at.True(waitFor(func() bool {
+		got := player.recorded()
+		return len(got) > 0 && got[len(got)-1] == 440
+	}))
+	resumed, _ := rs.GetStream("live/rebase")
+	at.True(stream == resumed, "the stream was not kept")
+	at.Equal(int32(1), atomic.LoadInt32(&player.resumes))
+
+	// the window of the first drop doesn't end the resumed stream
+	time.Sleep(400 * time.Millisecond)
+	at.Nil(player.closed())
+
+	// this time it stays away longer than the window
+	second.Close(nil)
+	time.Sleep(100 * time.Millisecond)
+	at.Nil(player.closed())
+	at.True(waitFor(func() bool { return player.closed() != nil }))
+	at.Equal(ErrUnpublished, player.closed())
+
+	// a stream stopped on purpose is closed right away
+	third := newChanReader("third")
+	rs.HandleReader(third)
+	stream, _ = rs.GetStream("live/rebase")
+	other := &closingWriter{recordWriter: recordWriter{RWBaser: av.NewRWBaser(0)}}
+	rs.HandleWriter(other)
+	third.packets <- av.Packet{IsAudio: true, TimeStamp: 0}
+	stream.TransStopReason(ErrKicked)
+	at.True(waitFor(func() bool { return other.closed() != nil }))
+	at.Equal(ErrUnpublished, other.closed())
+}
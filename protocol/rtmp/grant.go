@@ -0,0 +1,88 @@
+package rtmp
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// looksLikeJWT tells a token from a stream key, keys never hold dots
+func looksLikeJWT(s string) bool {
+	return strings.Count(s, ".") == 2
+}
+
+// takeQueryToken removes the query of s and returns its jwt parameter
+func takeQueryToken(s *string) string {
+	i := strings.IndexByte(*s, '?')
+	if i < 0 {
+		return ""
+	}
+	q, _ := url.ParseQuery((*s)[i+1:])
+	token := q.Get("jwt")
+	if token != "" {
+		*s = (*s)[:i]
+	}
+	return token
+}
+
+// roomGrant finds the jwt room grant a publisher may send in place of its
+// stream key. found is false when jwt.secret is unset or it sent no token
+func roomGrant(c *core.ConnServer) (room string, found bool, err error) {
+	if !c.IsPublisher() {
+		return "", false, nil
+	}
+	return findRoomGrant(&c.ConnInfo.App, &c.ConnInfo.TcUrl, c.PublishInfo.Name)
+}
+
+// findRoomGrant takes the token from the stream name or from a jwt
+// parameter of the tcUrl, which clients also put in the app. The query is
+// removed so the stream is APP/ROOM like any other
+func findRoomGrant(app, tcUrl *string, name string) (room string, found bool, err error) {
+	secret := configure.Config.GetString("jwt.secret")
+	if secret == "" {
+		return "", false, nil
+	}
+
+	token := takeQueryToken(app)
+	if t := takeQueryToken(tcUrl); token == "" {
+		token = t
+	}
+	if looksLikeJWT(name) {
+		token = name
+	}
+	if token == "" {
+		return "", false, nil
+	}
+	room, err = parseRoomGrant(token, *app, []byte(secret))
+	return room, true, err
+}
+
+// parseRoomGrant validates token and returns its room claim, an app claim
+// limits the grant to that app
+func parseRoomGrant(token, app string, secret []byte) (string, error) {
+	method := configure.JWTSigningMethod()
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return secret, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	room, _ := claims["room"].(string)
+	if room == "" || strings.Contains(room, "/") {
+		return "", fmt.Errorf("invalid room claim %q", room)
+	}
+	if a, ok := claims["app"].(string); ok && a != app {
+		return "", fmt.Errorf("grant is for app %s", a)
+	}
+	return room, nil
+}
@@ -0,0 +1,104 @@
+package rtmp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func signGrant(t *testing.T, claims jwt.MapClaims, secret string) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestParseRoomGrant(t *testing.T) {
+	at := assert.New(t)
+	secret := []byte("grant")
+	exp := time.Now().Add(time.Minute).Unix()
+
+	room, err := parseRoomGrant(signGrant(t, jwt.MapClaims{"room": "movie", "exp": exp}, "grant"), "live", secret)
+	at.Nil(err)
+	at.Equal("movie", room)
+
+	_, err = parseRoomGrant(signGrant(t, jwt.MapClaims{"room": "movie", "exp": time.Now().Add(-time.Minute).Unix()}, "grant"), "live", secret)
+	at.NotNil(err)
+	_, err = parseRoomGrant(signGrant(t, jwt.MapClaims{"room": "movie"}, "other"), "live", secret)
+	at.NotNil(err)
+	_, err = parseRoomGrant(signGrant(t, jwt.MapClaims{"room": "movie", "app": "live"}, "grant"), "other", secret)
+	at.NotNil(err)
+	_, err = parseRoomGrant(signGrant(t, jwt.MapClaims{"room": "../movie"}, "grant"), "live", secret)
+	at.NotNil(err)
+	_, err = parseRoomGrant(signGrant(t, jwt.MapClaims{"name": "movie"}, "grant"), "live", secret)
+	at.NotNil(err)
+}
+
+func TestFindRoomGrant(t *testing.T) {
+	at := assert.New(t)
+	token := signGrant(t, jwt.MapClaims{"room": "movie"}, "grant")
+	app, tcUrl := "live?jwt="+token, "rtmp://127.0.0.1/live?jwt="+token
+
+	// without a secret tokens are stream keys like any other
+	_, found, _ := findRoomGrant(&app, &tcUrl, token)
+	at.False(found)
+
+	configure.Config.Set("jwt.secret", "grant")
+	defer configure.Config.Set("jwt.secret", "")
+
+	room, found, err := findRoomGrant(&app, &tcUrl, "anything")
+	at.True(found)
+	at.Nil(err)
+	at.Equal("movie", room)
+	at.Equal("live", app)
+	at.Equal("rtmp://127.0.0.1/live", tcUrl)
+
+	room, found, err = findRoomGrant(&app, &tcUrl, token)
+	at.True(found)
+	at.Nil(err)
+	at.Equal("movie", room)
+
+	_, found, _ = findRoomGrant(&app, &tcUrl, "movie-key")
+	at.False(found)
+}
+
+func TestPublishRoomGrant(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("jwt.secret", "grant")
+	defer configure.Config.Set("jwt.secret", "")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer listener.Close()
+
+	handler := NewRtmpStream()
+	go NewRtmpServer(handler, nil).Serve(listener)
+	url := "rtmp://" + listener.Addr().String() + "/live/"
+
+	expired := signGrant(t, jwt.MapClaims{"room": "expired", "exp": time.Now().Add(-time.Minute).Unix()}, "grant")
+	rejected := core.NewConnClient()
+	if err := rejected.Start(url+expired, av.PUBLISH); err == nil {
+		var c core.ChunkStream
+		at.NotNil(rejected.Read(&c))
+		rejected.Close(nil)
+	}
+	_, ok := handler.GetStream("live/expired")
+	at.False(ok)
+
+	valid := signGrant(t, jwt.MapClaims{"room": "granted", "exp": time.Now().Add(time.Minute).Unix()}, "grant")
+	publisher := core.NewConnClient()
+	at.Nil(publisher.Start(url+valid, av.PUBLISH))
+	defer publisher.Close(nil)
+	at.True(waitFor(func() bool {
+		s, ok := handler.GetStream("live/granted")
+		return ok && s.GetReader() != nil
+	}))
+}
@@ -0,0 +1,31 @@
+package rtmp
+
+import (
+	"sync"
+
+	"github.com/SpooderfyBot/live/av"
+)
+
+// StreamInspector gives the api and the other servers access to the
+// streams of a handler, RtmpStream implements it
+type StreamInspector interface {
+	GetStream(key string) (*Stream, bool)
+	GetStreams() *sync.Map
+}
+
+// InspectorProvider is implemented by handlers wrapping another one, a
+// fan-out or a recorder, to expose the streams of the one they wrap
+type InspectorProvider interface {
+	Inspector() StreamInspector
+}
+
+// Inspect returns the StreamInspector of h, either h itself or the one it
+// provides, false when it has none
+func Inspect(h av.Handler) (StreamInspector, bool) {
+	if p, ok := h.(InspectorProvider); ok {
+		i := p.Inspector()
+		return i, i != nil
+	}
+	i, ok := h.(StreamInspector)
+	return i, ok
+}
@@ -0,0 +1,64 @@
+package rtmp
+
+import (
+	"sync/atomic"
+
+	"github.com/SpooderfyBot/live/configure"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maintenance is 1 while the server drains before a shutdown: new
+// publishers and players are refused, the live streams go on
+var maintenance int32
+
+func init() {
+	loadMaintenance()
+	configure.OnReload(loadMaintenance)
+}
+
+// loadMaintenance turns the maintenance on with rtmp.maintenance. A config
+// without it leaves the mode as it is, only SetMaintenance ends it
+func loadMaintenance() {
+	if configure.Config.GetBool("rtmp.maintenance") {
+		SetMaintenance(true)
+	}
+}
+
+// SetMaintenance turns the maintenance mode on or off
+func SetMaintenance(on bool) {
+	v := int32(0)
+	if on {
+		v = 1
+	}
+	if atomic.SwapInt32(&maintenance, v) == v {
+		return
+	}
+	if on {
+		log.Info("maintenance mode on, new publishers and players are refused")
+	} else {
+		log.Info("maintenance mode off")
+	}
+}
+
+// Maintenance tells whether the server drains, new players are then refused
+func Maintenance() bool {
+	return atomic.LoadInt32(&maintenance) == 1
+}
+
+// Drainer is implemented by handlers refusing new publishers in
+// maintenance, such as *RtmpStream
+type Drainer interface {
+	Draining(key string) bool
+}
+
+// Draining tells if a publisher of key is refused for the maintenance. The
+// one replacing the live publisher of key, or coming back to a stream that
+// waits for it, carries on a broadcast and is let in
+func (rs *RtmpStream) Draining(key string) bool {
+	if !Maintenance() || rs.Publishing(key) {
+		return false
+	}
+	s, ok := rs.GetStream(key)
+	return !ok || !s.waiting()
+}
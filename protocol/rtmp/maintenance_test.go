@@ -0,0 +1,82 @@
+package rtmp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenance(t *testing.T) {
+	at := assert.New(t)
+	defer SetMaintenance(false)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer listener.Close()
+	handler := NewRtmpStream()
+	go NewRtmpServer(handler, nil).Serve(listener)
+	url := "rtmp://" + listener.Addr().String() + "/live/"
+	publish := func(room string) *core.ConnClient {
+		key, err := configure.RoomKeys.SetKey(room)
+		at.Nil(err)
+		c := core.NewConnClient()
+		at.Nil(c.Start(url+key, av.PUBLISH))
+		return c
+	}
+	play := func(room string) *core.ConnClient {
+		c := core.NewConnClient()
+		at.Nil(c.Start(url+room, av.PLAY))
+		return c
+	}
+
+	live := publish("maintenance_live")
+	defer live.Close(nil)
+	at.True(waitFor(func() bool { return handler.Publishing("live/maintenance_live") }))
+	s, _ := handler.GetStream("live/maintenance_live")
+	player := play("maintenance_live")
+	defer player.Close(nil)
+	at.True(waitFor(func() bool { return s.PlayerCount() == 1 }))
+
+	SetMaintenance(true)
+	at.True(Maintenance())
+	at.True(handler.Draining("live/maintenance_new"))
+	at.False(handler.Draining("live/maintenance_live"))
+
+	// new publishers and players are refused
+	refused := publish("maintenance_new")
+	status := lastStatus(refused)
+	at.Equal("NetStream.Publish.Rejected", status["code"])
+	at.Equal("server draining", status["description"])
+	refused.Close(nil)
+	at.False(handler.Publishing("live/maintenance_new"))
+
+	refused = play("maintenance_live")
+	status = lastStatus(refused)
+	at.Equal("NetStream.Play.Failed", status["code"])
+	at.Equal("server draining", status["description"])
+	refused.Close(nil)
+
+	// while the live stream and its player go on
+	at.True(handler.Publishing("live/maintenance_live"))
+	at.Equal(1, s.PlayerCount())
+
+	// a reload without rtmp.maintenance keeps it on
+	loadMaintenance()
+	at.True(Maintenance())
+
+	SetMaintenance(false)
+	next := publish("maintenance_new")
+	defer next.Close(nil)
+	at.True(waitFor(func() bool { return handler.Publishing("live/maintenance_new") }))
+
+	// and the config turns it on
+	configure.Config.Set("rtmp.maintenance", true)
+	defer configure.Config.Set("rtmp.maintenance", false)
+	loadMaintenance()
+	at.True(Maintenance())
+}
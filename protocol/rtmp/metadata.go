@@ -0,0 +1,85 @@
+package rtmp
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/protocol/amf"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxTimedMetadata is the number of injected metadata waiting for the
+// next packet of the publisher
+const maxTimedMetadata = 16
+
+var ErrMetadataQueueFull = fmt.Errorf("metadata queue full")
+
+// NewTimedMetadata returns an onMetaData data packet carrying fields
+func NewTimedMetadata(fields map[string]string) (av.Packet, error) {
+	array := make(amf.Object, len(fields))
+	for k, v := range fields {
+		array[k] = v
+	}
+
+	b := bytes.NewBuffer(nil)
+	encoder := &amf.Encoder{}
+	if _, err := encoder.EncodeAmf0String(b, "onMetaData", true); err != nil {
+		return av.Packet{}, err
+	}
+	if _, err := encoder.EncodeAmf0EcmaArray(b, array, true); err != nil {
+		return av.Packet{}, err
+	}
+	return av.Packet{
+		IsMetadata: true,
+		Header:     &av.TimedMetadata{Fields: fields},
+		Data:       b.Bytes(),
+	}, nil
+}
+
+// InjectMetadata sends fields, such as the title of the track being
+// played, to the players along with the next packet of the publisher.
+// Players get an onMetaData data tag and hls an ID3 tag, the cached
+// metadata of the encoder is left alone for the players joining later.
+func (s *Stream) InjectMetadata(fields map[string]string) error {
+	p, err := NewTimedMetadata(fields)
+	if err != nil {
+		return err
+	}
+	select {
+	case s.timed <- p:
+		return nil
+	default:
+		return ErrMetadataQueueFull
+	}
+}
+
+// sendTimed writes the injected metadata at timestamp to the writers
+// already started, the others get the cache first
+func (s *Stream) sendTimed(timestamp uint32) {
+	for {
+		var p av.Packet
+		select {
+		case p = <-s.timed:
+		default:
+			return
+		}
+		p.TimeStamp = timestamp
+
+		s.ws.Range(func(key, val interface{}) bool {
+			v := val.(*PackWriterCloser)
+			if !v.init {
+				return true
+			}
+			newPacket := p
+			if err := v.w.Write(&newPacket); err != nil {
+				log.Debugf("[%s] write metadata error: %v, remove", v.w.Info(), err)
+				s.ws.Delete(key)
+				events.Emit(s.info.Key, events.PlayerLeave, err.Error())
+			}
+			return true
+		})
+	}
+}
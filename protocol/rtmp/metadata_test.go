@@ -0,0 +1,73 @@
+package rtmp
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/protocol/amf"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// packetWriter is a player keeping the packets it was sent
+type packetWriter struct {
+	av.RWBaser
+	lock    sync.Mutex
+	packets []av.Packet
+}
+
+func (w *packetWriter) Info() av.Info {
+	return av.Info{Key: "live/rebase", URL: "rtmp://127.0.0.1/live/rebase", UID: "player"}
+}
+
+func (w *packetWriter) Alive() bool    { return true }
+func (w *packetWriter) Close(error)    {}
+func (w *packetWriter) IsPlayer() bool { return true }
+
+func (w *packetWriter) Write(p *av.Packet) error {
+	w.lock.Lock()
+	w.packets = append(w.packets, *p)
+	w.lock.Unlock()
+	return nil
+}
+
+func (w *packetWriter) recorded() []av.Packet {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]av.Packet(nil), w.packets...)
+}
+
+func TestInjectMetadata(t *testing.T) {
+	at := assert.New(t)
+
+	rs := NewRtmpStream()
+	publisher := newChanReader("publisher")
+	rs.HandleReader(publisher)
+	defer publisher.Close(nil)
+	player := &packetWriter{RWBaser: av.NewRWBaser(0)}
+	rs.HandleWriter(player)
+
+	// the first packet only sends the cache to the player
+	publisher.packets <- av.Packet{IsAudio: true, TimeStamp: 0}
+	publisher.packets <- av.Packet{IsAudio: true, TimeStamp: 20}
+	at.True(waitFor(func() bool { return len(player.recorded()) == 1 }))
+
+	s, ok := rs.GetStream("live/rebase")
+	at.True(ok)
+	at.Nil(s.InjectMetadata(map[string]string{"title": "Song"}))
+	publisher.packets <- av.Packet{IsAudio: true, TimeStamp: 40}
+	at.True(waitFor(func() bool { return len(player.recorded()) == 3 }))
+
+	// the metadata goes out right before the next packet, at its timestamp
+	got := player.recorded()
+	at.True(got[1].IsMetadata)
+	at.Equal(uint32(40), got[1].TimeStamp)
+	at.True(got[2].IsAudio)
+	at.Equal(&av.TimedMetadata{Fields: map[string]string{"title": "Song"}}, got[1].Header)
+
+	// the batch ends on EOF
+	decoded, _ := (&amf.Decoder{}).DecodeBatch(bytes.NewReader(got[1].Data), amf.AMF0)
+	at.Equal([]interface{}{"onMetaData", amf.Object{"title": "Song"}}, decoded)
+}
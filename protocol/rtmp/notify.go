@@ -0,0 +1,65 @@
+package rtmp
+
+import (
+	"sync"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/utils/worker"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// publishObservers are the callbacks of an RtmpStream for the streams
+// going live and ending
+type publishObservers struct {
+	lock        sync.RWMutex
+	publish     []func(av.Info)
+	unpublished []func(av.Info)
+}
+
+// OnPublish registers f to be called with the info of every publisher
+// starting, or coming back within rtmp.publish_grace, on rs
+func (rs *RtmpStream) OnPublish(f func(av.Info)) {
+	rs.observers.lock.Lock()
+	rs.observers.publish = append(rs.observers.publish, f)
+	rs.observers.lock.Unlock()
+}
+
+// OnUnpublish registers f to be called with the info of every publisher
+// of rs going away, kicked or replaced included
+func (rs *RtmpStream) OnUnpublish(f func(av.Info)) {
+	rs.observers.lock.Lock()
+	rs.observers.unpublished = append(rs.observers.unpublished, f)
+	rs.observers.lock.Unlock()
+}
+
+func (rs *RtmpStream) notifyPublish(info av.Info) {
+	rs.observers.lock.RLock()
+	fns := rs.observers.publish
+	rs.observers.lock.RUnlock()
+	notifyObservers(fns, info, "publish")
+}
+
+func (rs *RtmpStream) notifyUnpublish(info av.Info) {
+	rs.observers.lock.RLock()
+	fns := rs.observers.unpublished
+	rs.observers.lock.RUnlock()
+	notifyObservers(fns, info, "unpublish")
+}
+
+// notifyObservers calls fns from the shared worker pool, so a slow
+// callback never holds a publisher back. The calls of a stream key run in
+// order, a publish is seen before its unpublish.
+func notifyObservers(fns []func(av.Info), info av.Info, what string) {
+	if len(fns) == 0 {
+		return
+	}
+	job := func() {
+		for _, f := range fns {
+			f(info)
+		}
+	}
+	if !worker.Shared().Submit(info.Key, job) {
+		log.Warningf("[%s] %s callbacks queue full, dropping", info.Key, what)
+	}
+}
@@ -0,0 +1,63 @@
+package rtmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishObservers(t *testing.T) {
+	at := assert.New(t)
+
+	rs := NewRtmpStream()
+	published, unpublished := make(chan av.Info, 4), make(chan av.Info, 4)
+	rs.OnPublish(func(info av.Info) { published <- info })
+	rs.OnUnpublish(func(info av.Info) { unpublished <- info })
+	next := func(c chan av.Info) (av.Info, bool) {
+		select {
+		case info := <-c:
+			return info, true
+		case <-time.After(2 * time.Second):
+			return av.Info{}, false
+		}
+	}
+
+	r := &keyedReader{chanReader: newChanReader("observed"), key: "live/observed"}
+	rs.HandleReader(r)
+	info, ok := next(published)
+	if at.True(ok) {
+		at.Equal("live/observed", info.Key)
+		at.Equal("observed", info.UID)
+		at.Equal("rtmp://127.0.0.1/live/observed", info.URL)
+	}
+	select {
+	case <-unpublished:
+		at.Fail("unpublished while live")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	r.Close(nil)
+	info, ok = next(unpublished)
+	if at.True(ok) {
+		at.Equal("live/observed", info.Key)
+		at.Equal("observed", info.UID)
+	}
+
+	// a replaced publisher ends before the new one starts
+	first := &keyedReader{chanReader: newChanReader("first"), key: "live/replaced"}
+	rs.HandleReader(first)
+	s, _ := rs.GetStream("live/replaced")
+	at.True(waitFor(s.started))
+	second := &keyedReader{chanReader: newChanReader("second"), key: "live/replaced"}
+	rs.HandleReader(second)
+	defer second.Close(nil)
+	info, _ = next(published)
+	at.Equal("first", info.UID)
+	info, _ = next(unpublished)
+	at.Equal("first", info.UID)
+	info, _ = next(published)
+	at.Equal("second", info.UID)
+}
@@ -0,0 +1,22 @@
+package rtmp
+
+import (
+	"context"
+	"time"
+
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+)
+
+// Ping dials url the way Client.Dial does but stops after the handshake
+// and the connect message, nothing is published nor played. It returns
+// how long that took.
+func Ping(ctx context.Context, url string) (time.Duration, error) {
+	connClient := core.NewConnClient()
+	start := time.Now()
+	if err := connClient.ConnectContext(ctx, url); err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+	connClient.Close(nil)
+	return latency, nil
+}
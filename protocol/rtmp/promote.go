@@ -0,0 +1,80 @@
+package rtmp
+
+import (
+	"fmt"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNotLive    = fmt.Errorf("stream is not live")
+	ErrSameStream = fmt.Errorf("a stream can't be promoted into itself")
+)
+
+// Promotion is the outcome of Promote
+type Promotion struct {
+	Players           int  // players of to moved onto from
+	ReplacedPublisher bool // to had a live publisher, now closed
+}
+
+// Promote makes the publisher of from the source of to: to is bound to the
+// stream of from, its players are moved there without reconnecting and
+// its own publisher, if any, is closed. from must be live, to doesn't
+// have to be, its waiting players get the stream of from all the same.
+// The binding lasts until a publisher connects to to again, which takes
+// its players back.
+func (rs *RtmpStream) Promote(from, to string) (Promotion, error) {
+	var ret Promotion
+	if from == to {
+		return ret, ErrSameStream
+	}
+	src, ok := rs.GetStream(from)
+	if !ok || src.GetReader() == nil || !src.started() {
+		return ret, ErrNotLive
+	}
+
+	// new players of to go to src from now on
+	i, ok := rs.streams.Load(to)
+	rs.streams.Store(to, src)
+	if dst, _ := i.(*Stream); ok && dst != src {
+		ret.Players = dst.moveWriters(src)
+		ret.ReplacedPublisher = dst.started()
+		dst.TransStopReason(ErrPromotedOut)
+	}
+
+	log.Infof("[%v] promoted to %v, %d players moved", from, to, ret.Players)
+	events.Emit(to, events.Promote, from+" -> "+to)
+	events.Emit(from, events.Promote, from+" -> "+to)
+	return ret, nil
+}
+
+// moveWriters hands the writers of s over to dst, they get the cached gop
+// of dst first as a new player would
+func (s *Stream) moveWriters(dst *Stream) (n int) {
+	s.ws.Range(func(key, val interface{}) bool {
+		v := val.(*PackWriterCloser)
+		s.ws.Delete(key)
+		dst.AddWriter(v.w)
+		if p, ok := v.w.(av.Player); ok && p.IsPlayer() {
+			n++
+		}
+		return true
+	})
+	return
+}
+
+// takeBack moves the writers of s playing key to dst, for a room taking
+// its players back from the stream it was promoted to
+func (s *Stream) takeBack(key string, dst *Stream) {
+	s.ws.Range(func(k, val interface{}) bool {
+		v := val.(*PackWriterCloser)
+		if v.w.Info().Key == key {
+			s.ws.Delete(k)
+			dst.AddWriter(v.w)
+		}
+		return true
+	})
+}
@@ -0,0 +1,109 @@
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// keyedReader is a chanReader publishing to key
+type keyedReader struct {
+	*chanReader
+	key string
+}
+
+func (r *keyedReader) Info() av.Info {
+	return av.Info{Key: r.key, URL: "rtmp://127.0.0.1/" + r.key, UID: r.uid}
+}
+
+// keyedWriter is a closingWriter playing key
+type keyedWriter struct {
+	closingWriter
+	key, uid string
+}
+
+func (w *keyedWriter) Info() av.Info {
+	return av.Info{Key: w.key, URL: "rtmp://127.0.0.1/" + w.key, UID: w.uid}
+}
+
+func newKeyedWriter(key, uid string) *keyedWriter {
+	return &keyedWriter{
+		closingWriter: closingWriter{recordWriter: recordWriter{RWBaser: av.NewRWBaser(0)}},
+		key:           key,
+		uid:           uid,
+	}
+}
+
+func received(w *keyedWriter, ts uint32) func() bool {
+	return func() bool {
+		for _, got := range w.recorded() {
+			if got == ts {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestPromote(t *testing.T) {
+	at := assert.New(t)
+
+	rs := NewRtmpStream()
+	primary := &keyedReader{newChanReader("primary"), "live/room"}
+	backup := &keyedReader{newChanReader("backup"), "live/room_backup"}
+	rs.HandleReader(primary)
+	rs.HandleReader(backup)
+	viewer := newKeyedWriter("live/room", "viewer")
+	rs.HandleWriter(viewer)
+	backupViewer := newKeyedWriter("live/room_backup", "backup_viewer")
+	rs.HandleWriter(backupViewer)
+
+	// the first packet a player gets is the gop cache
+	primary.packets <- av.Packet{IsAudio: true, TimeStamp: 100}
+	primary.packets <- av.Packet{IsAudio: true, TimeStamp: 140}
+	backup.packets <- av.Packet{IsAudio: true, TimeStamp: 200}
+	backup.packets <- av.Packet{IsAudio: true, TimeStamp: 240}
+	at.True(waitFor(received(viewer, 140)))
+	at.True(waitFor(received(backupViewer, 240)))
+
+	_, err := rs.Promote("live/room", "live/room")
+	at.Equal(ErrSameStream, err)
+	_, err = rs.Promote("live/nobody", "live/room")
+	at.Equal(ErrNotLive, err)
+
+	p, err := rs.Promote("live/room_backup", "live/room")
+	at.Nil(err)
+	at.Equal(Promotion{Players: 1, ReplacedPublisher: true}, p)
+	select {
+	case <-primary.closed:
+	default:
+		t.Error("the primary publisher was not closed")
+	}
+
+	// the viewer of the room now plays the backup without reconnecting
+	backupStream, _ := rs.GetStream("live/room_backup")
+	roomStream, _ := rs.GetStream("live/room")
+	at.True(backupStream == roomStream)
+	backup.packets <- av.Packet{IsAudio: true, TimeStamp: 300}
+	backup.packets <- av.Packet{IsAudio: true, TimeStamp: 340}
+	at.True(waitFor(received(viewer, 340)))
+	at.True(waitFor(received(backupViewer, 340)))
+	at.Nil(viewer.closed())
+
+	// the primary comes back and takes its viewer back
+	again := &keyedReader{newChanReader("again"), "live/room"}
+	rs.HandleReader(again)
+	roomStream, _ = rs.GetStream("live/room")
+	at.False(backupStream == roomStream)
+	again.packets <- av.Packet{IsAudio: true, TimeStamp: 500}
+	again.packets <- av.Packet{IsAudio: true, TimeStamp: 540}
+	at.True(waitFor(received(viewer, 540)))
+	backup.packets <- av.Packet{IsAudio: true, TimeStamp: 600}
+	backup.packets <- av.Packet{IsAudio: true, TimeStamp: 640}
+	at.True(waitFor(received(backupViewer, 640)))
+	at.False(received(viewer, 640)())
+	at.Nil(viewer.closed())
+	at.Nil(backupViewer.closed())
+}
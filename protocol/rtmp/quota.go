@@ -0,0 +1,104 @@
+package rtmp
+
+import (
+	"sync"
+	"time"
+)
+
+// Quota caps the bytes sent to the rtmp players of a stream within each
+// period. Past it no player may join, and the ones playing are dropped
+// when DropPlayers is set
+type Quota struct {
+	Bytes       int64
+	Period      time.Duration
+	DropPlayers bool
+}
+
+// QuotaStatus is the use of the quota of a stream in its current period
+type QuotaStatus struct {
+	Quota
+	Used      int64
+	Remaining int64
+	ResetAt   time.Time
+}
+
+// roomQuota counts the bytes sent to the players of a stream, it outlives
+// the streams so reconnecting the publisher doesn't reset it
+type roomQuota struct {
+	lock  sync.Mutex
+	quota Quota
+	start time.Time
+	used  int64
+}
+
+// rollover starts a new period once the current one is over
+func (q *roomQuota) rollover(now time.Time) {
+	if q.quota.Period > 0 && now.Sub(q.start) >= q.quota.Period {
+		q.start, q.used = now, 0
+	}
+}
+
+// add counts n bytes sent to a player and returns false when the quota
+// is exceeded and the player must be dropped
+func (q *roomQuota) add(n int64) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.quota.Bytes <= 0 {
+		return true
+	}
+	q.rollover(time.Now())
+	q.used += n
+	return q.used <= q.quota.Bytes || !q.quota.DropPlayers
+}
+
+func (q *roomQuota) exceeded() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.quota.Bytes <= 0 {
+		return false
+	}
+	q.rollover(time.Now())
+	return q.used >= q.quota.Bytes
+}
+
+func (rs *RtmpStream) roomQuota(key string) *roomQuota {
+	q, _ := rs.quotas.LoadOrStore(key, &roomQuota{})
+	return q.(*roomQuota)
+}
+
+// SetQuota sets the quota of key and starts its first period, a quota of
+// 0 bytes removes it
+func (rs *RtmpStream) SetQuota(key string, quota Quota) {
+	q := rs.roomQuota(key)
+	q.lock.Lock()
+	q.quota = quota
+	q.start, q.used = time.Now(), 0
+	q.lock.Unlock()
+}
+
+// QuotaStatus returns the use of the quota of key, false when it has none
+func (rs *RtmpStream) QuotaStatus(key string) (QuotaStatus, bool) {
+	v, ok := rs.quotas.Load(key)
+	if !ok {
+		return QuotaStatus{}, false
+	}
+	q := v.(*roomQuota)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.quota.Bytes <= 0 {
+		return QuotaStatus{}, false
+	}
+	q.rollover(time.Now())
+	status := QuotaStatus{
+		Quota:     q.quota,
+		Used:      q.used,
+		Remaining: q.quota.Bytes - q.used,
+	}
+	if status.Remaining < 0 {
+		status.Remaining = 0
+	}
+	if q.quota.Period > 0 {
+		status.ResetAt = q.start.Add(q.quota.Period)
+	}
+	return status, true
+}
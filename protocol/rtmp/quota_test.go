@@ -0,0 +1,69 @@
+package rtmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoomQuota(t *testing.T) {
+	at := assert.New(t)
+	rs := NewRtmpStream()
+
+	_, ok := rs.QuotaStatus("live/quota")
+	at.False(ok)
+	at.True(rs.CanAddPlayer("live/quota"))
+
+	rs.SetQuota("live/quota", Quota{Bytes: 100, Period: 50 * time.Millisecond})
+	q := rs.roomQuota("live/quota")
+	at.True(q.add(60))
+	status, ok := rs.QuotaStatus("live/quota")
+	at.True(ok)
+	at.Equal(int64(60), status.Used)
+	at.Equal(int64(40), status.Remaining)
+	at.False(status.ResetAt.IsZero())
+
+	// without DropPlayers the players keep going, only new ones are refused
+	at.True(q.add(60))
+	at.False(rs.CanAddPlayer("live/quota"))
+	status, _ = rs.QuotaStatus("live/quota")
+	at.Equal(int64(0), status.Remaining)
+
+	// a new period starts from zero
+	time.Sleep(60 * time.Millisecond)
+	at.True(rs.CanAddPlayer("live/quota"))
+	status, _ = rs.QuotaStatus("live/quota")
+	at.Equal(int64(100), status.Remaining)
+
+	rs.SetQuota("live/quota", Quota{Bytes: 100, DropPlayers: true})
+	at.True(q.add(100))
+	at.False(q.add(1))
+
+	rs.SetQuota("live/quota", Quota{})
+	_, ok = rs.QuotaStatus("live/quota")
+	at.False(ok)
+	at.True(q.add(1000))
+}
+
+func TestVirWriterQuota(t *testing.T) {
+	at := assert.New(t)
+	rs := NewRtmpStream()
+	rs.SetQuota("live/stalled", Quota{Bytes: 1000, DropPlayers: true})
+
+	conn := &stalledConn{release: make(chan struct{}), closed: make(chan struct{})}
+	close(conn.release)
+	defer close(conn.closed)
+	w := NewVirWriter(conn)
+	w.quota = rs.roomQuota("live/stalled")
+
+	// the player is closed once it was sent more than the quota
+	at.True(waitFor(func() bool {
+		return w.Write(&av.Packet{IsAudio: true, Data: make([]byte, 100)}) != nil
+	}))
+	status, _ := rs.QuotaStatus("live/stalled")
+	at.Equal(int64(0), status.Remaining)
+	at.False(rs.CanAddPlayer("live/stalled"))
+}
@@ -6,6 +6,8 @@ import (
 	"net/url"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SpooderfyBot/live/utils/uid"
@@ -13,13 +15,18 @@ import (
 	"github.com/SpooderfyBot/live/av"
 	"github.com/SpooderfyBot/live/configure"
 	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/protocol/amf"
 	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+	"github.com/SpooderfyBot/live/utils/logsample"
 
 	log "github.com/sirupsen/logrus"
 )
 
 const (
 	maxQueueNum           = 1024
+	minQueueNum           = 128
+	defaultLagThreshold   = 75
 	SAVE_STATICS_INTERVAL = 5000
 )
 
@@ -28,6 +35,44 @@ var (
 	writeTimeout = configure.Config.GetInt("write_timeout")
 )
 
+// queueSize is the length of the packet queue of each player, for the
+// size n of the playback settings
+func queueSize(n int) int {
+	if n <= 0 {
+		return maxQueueNum
+	}
+	if n < minQueueNum {
+		return minQueueNum
+	}
+	return n
+}
+
+// lagThreshold is the number of queued packets from which a player
+// is reported lagging, write_buffer.lag_threshold is a percent of size
+func lagThreshold(size int) int {
+	percent := configure.Config.GetInt("write_buffer.lag_threshold")
+	if percent <= 0 || percent > 100 {
+		percent = defaultLagThreshold
+	}
+	return size * percent / 100
+}
+
+func init() {
+	if err := core.SetChunkSize(uint32(configure.Config.GetInt("rtmp.chunk_size"))); err != nil {
+		log.Warningf("rtmp.chunk_size: %v, using %d", err, core.DefaultChunkSize)
+	}
+	setConnectHosts := func() {
+		core.SetAllowedConnectHosts(configure.Config.GetStringSlice("rtmp.allowed_connect_hosts"))
+	}
+	setConnectHosts()
+	configure.OnReload(setConnectHosts)
+	if size := configure.Config.GetInt("rtmp.max_message_size"); size > 0 {
+		if err := core.SetMaxMessageSize(uint32(size)); err != nil {
+			log.Warningf("rtmp.max_message_size: %v, using %d", err, core.DefaultMaxMessageSize)
+		}
+	}
+}
+
 type Client struct {
 	handler av.Handler
 	getter  av.GetWriter
@@ -90,7 +135,12 @@ func (s *Server) Serve(listener net.Listener) (err error) {
 		if err != nil {
 			return
 		}
-		conn := core.NewConn(netconn, 4*1024)
+		if ip := hostOf(netconn.RemoteAddr()); bans.blocked(ip, time.Now()) {
+			logsample.Debug("rtmp refused client").Debug("refused blocked client: ", ip)
+			netconn.Close()
+			continue
+		}
+		conn := core.NewConn(newCountedConn(netconn), 4*1024)
 		log.Debug("new client, connect remote: ", conn.RemoteAddr().String(),
 			"local:", conn.LocalAddr().String())
 		go s.handleConn(conn)
@@ -98,18 +148,33 @@ func (s *Server) Serve(listener net.Listener) (err error) {
 }
 
 func (s *Server) handleConn(conn *core.Conn) error {
-	if err := conn.HandshakeServer(); err != nil {
+	// a client has until deadline to publish or play, so connections
+	// stalling in the handshake don't pile up
+	deadline := time.Now().Add(handshakeTimeout())
+	if err := conn.HandshakeServerDeadline(deadline); err != nil {
 		conn.Close()
+		bans.fail(hostOf(conn.RemoteAddr()), time.Now())
 		log.Error("handleConn HandshakeServer err: ", err)
 		return err
 	}
 	connServer := core.NewConnServer(conn)
 
+	conn.SetDeadline(deadline)
 	if err := connServer.ReadMsg(); err != nil {
 		conn.Close()
+		bans.fail(hostOf(conn.RemoteAddr()), time.Now())
 		log.Error("handleConn read msg err: ", err)
 		return err
 	}
+	conn.SetDeadline(time.Time{})
+
+	room, granted, err := roomGrant(connServer)
+	if err != nil {
+		err := fmt.Errorf("invalid room grant err=%s", err.Error())
+		conn.Close()
+		log.Error("roomGrant err: ", err)
+		return err
+	}
 
 	appname, name, _ := connServer.GetInfo()
 
@@ -122,21 +187,57 @@ func (s *Server) handleConn(conn *core.Conn) error {
 
 	log.Debugf("handleConn: IsPublisher=%v", connServer.IsPublisher())
 	if connServer.IsPublisher() {
-		if configure.Config.GetBool("rtmp_noauth") {
-			key, err := configure.RoomKeys.GetKey(name)
-			if err != nil {
-				err := fmt.Errorf("Cannot create key err=%s", err.Error())
+		channel := room
+		if !granted {
+			if configure.Config.GetBool("rtmp_noauth") {
+				// the room is made if need be, its name stands for its key
+				if _, err := configure.RoomKeys.GetKey(name); err != nil && err != configure.ErrKeyHashed {
+					err := fmt.Errorf("Cannot create key err=%s", err.Error())
+					conn.Close()
+					log.Error("GetKey err: ", err)
+					return err
+				}
+				channel = name
+			} else if channel, err = configure.RoomKeys.GetChannel(name); err != nil {
+				err := fmt.Errorf("invalid key err=%s", err.Error())
 				conn.Close()
-				log.Error("GetKey err: ", err)
+				log.Error("CheckKey err: ", err)
 				return err
 			}
-			name = key
 		}
-		channel, err := configure.RoomKeys.GetChannel(name)
-		if err != nil {
-			err := fmt.Errorf("invalid key err=%s", err.Error())
-			conn.Close()
-			log.Error("CheckKey err: ", err)
+		if a, ok := s.handler.(Aliaser); ok {
+			if to, aliased := a.Alias(appname + "/" + channel); aliased {
+				err := fmt.Errorf("stream %s/%s is an alias of %s, publish there", appname, channel, to)
+				connServer.Close(ErrAliasPublish)
+				log.Warning(err)
+				return err
+			}
+		}
+		if c, ok := s.handler.(PublishChecker); ok && c.Publishing(appname+"/"+channel) {
+			switch duplicatePublish() {
+			case DuplicateRejectNew:
+				err := fmt.Errorf("stream %s/%s already has a publisher", appname, channel)
+				conn.Close()
+				log.Warning(err)
+				return err
+			case DuplicateRejectNewWithStatus:
+				err := fmt.Errorf("stream %s/%s already has a publisher", appname, channel)
+				connServer.Close(ErrDuplicatePublish)
+				log.Warning(err)
+				return err
+			}
+			log.Infof("stream %s/%s publisher replaced", appname, channel)
+		}
+		if d, ok := s.handler.(Drainer); ok && d.Draining(appname+"/"+channel) {
+			err := fmt.Errorf("stream %s/%s refused, the server is in maintenance", appname, channel)
+			connServer.Close(ErrDrainingPublish)
+			log.Warning(err)
+			return err
+		}
+		if l, ok := s.handler.(StreamLimiter); ok && !l.CanPublish(appname+"/"+channel) {
+			err := fmt.Errorf("stream %s/%s refused, the server reached rtmp.max_streams", appname, channel)
+			connServer.Close(ErrMaxStreams)
+			log.Warning(err)
 			return err
 		}
 		connServer.PublishInfo.Name = channel
@@ -147,17 +248,39 @@ func (s *Server) handleConn(conn *core.Conn) error {
 		s.handler.HandleReader(reader)
 		log.Debugf("new publisher: %+v", reader.Info())
 
-		if s.getter != nil {
+		settings, err := configure.RoomKeys.GetSettings(channel)
+		if err != nil {
+			log.Warning("GetSettings err: ", err)
+		}
+		if s.getter != nil && settings.HLS() {
 			writeType := reflect.TypeOf(s.getter)
 			log.Debugf("handleConn:writeType=%v", writeType)
 			writer := s.getter.GetWriter(reader.Info())
 			s.handler.HandleWriter(writer)
 		}
-		if configure.Config.GetBool("flv_archive") {
+		if settings.Archive() {
 			flvWriter := new(flv.FlvDvr)
 			s.handler.HandleWriter(flvWriter.GetWriter(reader.Info()))
 		}
 	} else {
+		if Maintenance() {
+			err := fmt.Errorf("player of %s/%s refused, the server is in maintenance", appname, name)
+			connServer.Close(ErrDrainingPlay)
+			log.Warning(err)
+			return err
+		}
+		if OverConnections() {
+			err := fmt.Errorf("player of %s/%s refused, the server reached rtmp.max_connections", appname, name)
+			connServer.Close(ErrMaxConnections)
+			log.Warning(err)
+			return err
+		}
+		if limiter, ok := s.handler.(PlayerLimiter); ok && !limiter.CanAddPlayer(appname+"/"+name) {
+			err := fmt.Errorf("stream %s/%s reached its max players or quota", appname, name)
+			conn.Close()
+			log.Warning(err)
+			return err
+		}
 		writer := NewVirWriter(connServer)
 		log.Debugf("new player: %+v", writer.Info())
 		s.handler.HandleWriter(writer)
@@ -166,6 +289,17 @@ func (s *Server) handleConn(conn *core.Conn) error {
 	return nil
 }
 
+// RemoteAddrer is implemented by the players that know the address
+// of their viewer
+type RemoteAddrer interface {
+	RemoteAddr() string
+}
+
+// PlayerLimiter is implemented by handlers that cap the players of a stream
+type PlayerLimiter interface {
+	CanAddPlayer(key string) bool
+}
+
 type GetInFo interface {
 	GetInfo() (string, string, string)
 }
@@ -187,25 +321,89 @@ type StaticsBW struct {
 	LastAudioDatainBytes   uint64
 	AudioSpeedInBytesperMS uint64
 
+	// the speeds above truncate the interval to whole seconds, these
+	// are computed over the exact time elapsed
+	VideoBitrateKbps uint64
+	AudioBitrateKbps uint64
+
 	LastTimestamp int64
 }
 
+// save counts length bytes of a packet received at nowInMS, the speeds
+// are sampled every SAVE_STATICS_INTERVAL
+func (bw *StaticsBW) save(streamid uint32, length uint64, isVideoFlag bool, nowInMS int64) {
+	bw.StreamId = streamid
+	if isVideoFlag {
+		bw.VideoDatainBytes = bw.VideoDatainBytes + length
+	} else {
+		bw.AudioDatainBytes = bw.AudioDatainBytes + length
+	}
+
+	if bw.LastTimestamp == 0 {
+		bw.LastTimestamp = nowInMS
+	} else if elapsed := nowInMS - bw.LastTimestamp; elapsed >= SAVE_STATICS_INTERVAL {
+		video := bw.VideoDatainBytes - bw.LastVideoDatainBytes
+		audio := bw.AudioDatainBytes - bw.LastAudioDatainBytes
+
+		diffTimestamp := elapsed / 1000
+		bw.VideoSpeedInBytesperMS = video * 8 / uint64(diffTimestamp) / 1000
+		bw.AudioSpeedInBytesperMS = audio * 8 / uint64(diffTimestamp) / 1000
+
+		// a bit per ms is a kbit/s
+		bw.VideoBitrateKbps = video * 8 / uint64(elapsed)
+		bw.AudioBitrateKbps = audio * 8 / uint64(elapsed)
+
+		bw.LastVideoDatainBytes = bw.VideoDatainBytes
+		bw.LastAudioDatainBytes = bw.AudioDatainBytes
+		bw.LastTimestamp = nowInMS
+	}
+}
+
+// BufferStats is the state of the packet queue of a player, the high
+// water marks are the most it held since the player joined
+type BufferStats struct {
+	Size             int   `json:"size"`
+	Packets          int   `json:"packets"`
+	Bytes            int64 `json:"bytes"`
+	HighWaterPackets int64 `json:"high_water_packets"`
+	HighWaterBytes   int64 `json:"high_water_bytes"`
+	Lagging          bool  `json:"lagging"`
+}
+
 type VirWriter struct {
+	// accessed atomically, kept first for their alignment
+	queuedBytes    int64
+	highWaterNum   int64
+	highWaterBytes int64
+
 	Uid    string
-	closed bool
+	closed int32 // accessed atomically, players are closed from several goroutines
 	av.RWBaser
 	conn        StreamReadWriteCloser
 	packetQueue chan *av.Packet
 	WriteBWInfo StaticsBW
+	bwLock      sync.Mutex // guards WriteBWInfo, the stats read it
+
+	lagAt     int
+	dropOnLag bool
+	lagging   bool
+	flushEach bool
+
+	quota *roomQuota // of its stream, set once it joins one
 }
 
 func NewVirWriter(conn StreamReadWriteCloser) *VirWriter {
+	playback := configure.PlaybackSettings()
+	size := queueSize(playback.QueueSize)
 	ret := &VirWriter{
 		Uid:         uid.NewId(),
 		conn:        conn,
 		RWBaser:     av.NewRWBaser(time.Second * time.Duration(writeTimeout)),
-		packetQueue: make(chan *av.Packet, maxQueueNum),
-		WriteBWInfo: StaticsBW{0, 0, 0, 0, 0, 0, 0, 0},
+		packetQueue: make(chan *av.Packet, size),
+		WriteBWInfo: StaticsBW{},
+		lagAt:       lagThreshold(size),
+		dropOnLag:   playback.DropOnLag,
+		flushEach:   playback.FlushEach,
 	}
 
 	go ret.Check()
@@ -220,26 +418,16 @@ func NewVirWriter(conn StreamReadWriteCloser) *VirWriter {
 
 func (v *VirWriter) SaveStatics(streamid uint32, length uint64, isVideoFlag bool) {
 	nowInMS := int64(time.Now().UnixNano() / 1e6)
+	v.bwLock.Lock()
+	defer v.bwLock.Unlock()
+	v.WriteBWInfo.save(streamid, length, isVideoFlag, nowInMS)
+}
 
-	v.WriteBWInfo.StreamId = streamid
-	if isVideoFlag {
-		v.WriteBWInfo.VideoDatainBytes = v.WriteBWInfo.VideoDatainBytes + length
-	} else {
-		v.WriteBWInfo.AudioDatainBytes = v.WriteBWInfo.AudioDatainBytes + length
-	}
-
-	if v.WriteBWInfo.LastTimestamp == 0 {
-		v.WriteBWInfo.LastTimestamp = nowInMS
-	} else if (nowInMS - v.WriteBWInfo.LastTimestamp) >= SAVE_STATICS_INTERVAL {
-		diffTimestamp := (nowInMS - v.WriteBWInfo.LastTimestamp) / 1000
-
-		v.WriteBWInfo.VideoSpeedInBytesperMS = (v.WriteBWInfo.VideoDatainBytes - v.WriteBWInfo.LastVideoDatainBytes) * 8 / uint64(diffTimestamp) / 1000
-		v.WriteBWInfo.AudioSpeedInBytesperMS = (v.WriteBWInfo.AudioDatainBytes - v.WriteBWInfo.LastAudioDatainBytes) * 8 / uint64(diffTimestamp) / 1000
-
-		v.WriteBWInfo.LastVideoDatainBytes = v.WriteBWInfo.VideoDatainBytes
-		v.WriteBWInfo.LastAudioDatainBytes = v.WriteBWInfo.AudioDatainBytes
-		v.WriteBWInfo.LastTimestamp = nowInMS
-	}
+// BWInfo returns a copy of WriteBWInfo
+func (v *VirWriter) BWInfo() StaticsBW {
+	v.bwLock.Lock()
+	defer v.bwLock.Unlock()
+	return v.WriteBWInfo
 }
 
 func (v *VirWriter) Check() {
@@ -254,40 +442,108 @@ func (v *VirWriter) Check() {
 
 func (v *VirWriter) DropPacket(pktQue chan *av.Packet, info av.Info) {
 	log.Warningf("[%v] packet queue max!!!", info)
-	for i := 0; i < maxQueueNum-84; i++ {
-		tmpPkt, ok := <-pktQue
+	size := cap(pktQue)
+	// go through what is queued once, never wait on an empty queue
+	for i, n := 0, len(pktQue); i < n; i++ {
+		tmpPkt, ok := v.dequeue(pktQue)
+		if !ok {
+			break
+		}
 		// try to don't drop audio
-		if ok && tmpPkt.IsAudio {
-			if len(pktQue) > maxQueueNum-2 {
-				log.Debug("drop audio pkt")
-				<-pktQue
+		if tmpPkt.IsAudio {
+			if len(pktQue) > size-2 {
+				logsample.Debug("rtmp drop audio").Debug("drop audio pkt")
+				v.dequeue(pktQue)
 			} else {
-				pktQue <- tmpPkt
+				v.enqueue(pktQue, tmpPkt)
 			}
 
 		}
 
-		if ok && tmpPkt.IsVideo {
+		if tmpPkt.IsVideo {
 			videoPkt, ok := tmpPkt.Header.(av.VideoPacketHeader)
 			// dont't drop sps config and dont't drop key frame
 			if ok && (videoPkt.IsSeq() || videoPkt.IsKeyFrame()) {
-				pktQue <- tmpPkt
+				v.enqueue(pktQue, tmpPkt)
 			}
-			if len(pktQue) > maxQueueNum-10 {
-				log.Debug("drop video pkt")
-				<-pktQue
+			if len(pktQue) > size-10 {
+				logsample.Debug("rtmp drop video").Debug("drop video pkt")
+				v.dequeue(pktQue)
 			}
 		}
 
 	}
-	log.Debug("packet queue len: ", len(pktQue))
+	logsample.Debug("rtmp queue len").Debug("packet queue len: ", len(pktQue))
+}
+
+// isSeqHeader tells an avc or aac sequence header, the decoder config a
+// player needs before the frames following it
+func isSeqHeader(p *av.Packet) bool {
+	if vh, ok := p.Header.(av.VideoPacketHeader); ok && p.IsVideo {
+		return vh.IsSeq()
+	}
+	if ah, ok := p.Header.(av.AudioPacketHeader); ok && !p.IsMetadata {
+		return ah.SoundFormat() == av.SOUND_AAC && ah.AACPacketType() == av.AAC_SEQHDR
+	}
+	return false
+}
+
+func (v *VirWriter) enqueue(pktQue chan *av.Packet, p *av.Packet) {
+	pktQue <- p
+	bytes := atomic.AddInt64(&v.queuedBytes, int64(len(p.Data)))
+	if bytes > atomic.LoadInt64(&v.highWaterBytes) {
+		atomic.StoreInt64(&v.highWaterBytes, bytes)
+	}
+	if num := int64(len(pktQue)); num > atomic.LoadInt64(&v.highWaterNum) {
+		atomic.StoreInt64(&v.highWaterNum, num)
+	}
+}
+
+func (v *VirWriter) dequeue(pktQue chan *av.Packet) (*av.Packet, bool) {
+	select {
+	case p, ok := <-pktQue:
+		if ok {
+			atomic.AddInt64(&v.queuedBytes, -int64(len(p.Data)))
+		}
+		return p, ok
+	default:
+		return nil, false
+	}
+}
+
+// checkLag emits a player_lag event when the queue crosses the lag
+// threshold, and again only once it drained to half of it
+func (v *VirWriter) checkLag(queued int) {
+	if queued >= v.lagAt {
+		if !v.lagging {
+			v.lagging = true
+			info := v.Info()
+			log.Warningf("[%v] player lagging, %d packets queued", info, queued)
+			events.Emit(info.Key, events.PlayerLag, fmt.Sprintf("%d packets queued", queued))
+		}
+	} else if queued < v.lagAt/2 {
+		v.lagging = false
+	}
+}
+
+// BufferStats returns the state of the packet queue
+func (v *VirWriter) BufferStats() BufferStats {
+	packets := len(v.packetQueue)
+	return BufferStats{
+		Size:             cap(v.packetQueue),
+		Packets:          packets,
+		Bytes:            atomic.LoadInt64(&v.queuedBytes),
+		HighWaterPackets: atomic.LoadInt64(&v.highWaterNum),
+		HighWaterBytes:   atomic.LoadInt64(&v.highWaterBytes),
+		Lagging:          packets >= v.lagAt,
+	}
 }
 
 //
 func (v *VirWriter) Write(p *av.Packet) (err error) {
 	err = nil
 
-	if v.closed {
+	if atomic.LoadInt32(&v.closed) == 1 {
 		err = fmt.Errorf("VirWriter closed")
 		return
 	}
@@ -296,10 +552,19 @@ func (v *VirWriter) Write(p *av.Packet) (err error) {
 			err = fmt.Errorf("VirWriter has already been closed:%v", e)
 		}
 	}()
-	if len(v.packetQueue) >= maxQueueNum-24 {
+	queued := len(v.packetQueue)
+	v.checkLag(queued)
+	if queued >= cap(v.packetQueue)-24 {
 		v.DropPacket(v.packetQueue, v.Info())
+		// the player could not decode anything after a lost config
+		if isSeqHeader(p) && len(v.packetQueue) < cap(v.packetQueue) {
+			v.enqueue(v.packetQueue, p)
+		}
 	} else {
-		v.packetQueue <- p
+		if v.lagging && v.dropOnLag {
+			v.DropPacket(v.packetQueue, v.Info())
+		}
+		v.enqueue(v.packetQueue, p)
 	}
 
 	return
@@ -311,6 +576,7 @@ func (v *VirWriter) SendPacket() error {
 	for {
 		p, ok := <-v.packetQueue
 		if ok {
+			atomic.AddInt64(&v.queuedBytes, -int64(len(p.Data)))
 			cs.Data = p.Data
 			cs.Length = uint32(len(p.Data))
 			cs.StreamID = p.StreamID
@@ -327,15 +593,22 @@ func (v *VirWriter) SendPacket() error {
 				}
 			}
 
+			if v.quota != nil && !v.quota.add(int64(cs.Length)) {
+				v.Close(ErrQuotaExceeded)
+				return ErrQuotaExceeded
+			}
 			v.SaveStatics(p.StreamID, uint64(cs.Length), p.IsVideo)
 			v.SetPreTime()
 			v.RecTimeStamp(cs.Timestamp, cs.TypeID)
 			err := v.conn.Write(cs)
 			if err != nil {
-				v.closed = true
+				atomic.StoreInt32(&v.closed, 1)
 				return err
 			}
-			Flush.Call(nil)
+			// a burst of packets goes out in one flush unless flushEach
+			if v.flushEach || len(v.packetQueue) == 0 {
+				Flush.Call(nil)
+			}
 		} else {
 			return fmt.Errorf("closed")
 		}
@@ -356,12 +629,22 @@ func (v *VirWriter) Info() (ret av.Info) {
 	return
 }
 
+func (v *VirWriter) IsPlayer() bool {
+	return true
+}
+
+func (v *VirWriter) RemoteAddr() string {
+	if r, ok := v.conn.(RemoteAddrer); ok {
+		return r.RemoteAddr()
+	}
+	return ""
+}
+
 func (v *VirWriter) Close(err error) {
 	log.Warning("player ", v.Info(), "closed: "+err.Error())
-	if !v.closed {
+	if atomic.CompareAndSwapInt32(&v.closed, 0, 1) {
 		close(v.packetQueue)
 	}
-	v.closed = true
 	v.conn.Close(err)
 }
 
@@ -371,41 +654,66 @@ type VirReader struct {
 	demuxer    *flv.Demuxer
 	conn       StreamReadWriteCloser
 	ReadBWInfo StaticsBW
+	bwLock     sync.Mutex // guards ReadBWInfo, the stats read it
+	guard      bitrateGuard
+	continuity continuity
 }
 
 func NewVirReader(conn StreamReadWriteCloser) *VirReader {
 	return &VirReader{
 		Uid:        uid.NewId(),
 		conn:       conn,
-		RWBaser:    av.NewRWBaser(time.Second * time.Duration(writeTimeout)),
+		RWBaser:    av.NewRWBaser(time.Second * time.Duration(readTimeout)),
 		demuxer:    flv.NewDemuxer(),
-		ReadBWInfo: StaticsBW{0, 0, 0, 0, 0, 0, 0, 0},
+		ReadBWInfo: StaticsBW{},
+		guard:      newBitrateGuard(),
 	}
 }
 
 func (v *VirReader) SaveStatics(streamid uint32, length uint64, isVideoFlag bool) {
-	nowInMS := int64(time.Now().UnixNano() / 1e6)
-
-	v.ReadBWInfo.StreamId = streamid
-	if isVideoFlag {
-		v.ReadBWInfo.VideoDatainBytes = v.ReadBWInfo.VideoDatainBytes + length
-	} else {
-		v.ReadBWInfo.AudioDatainBytes = v.ReadBWInfo.AudioDatainBytes + length
-	}
+	v.saveStatics(streamid, length, isVideoFlag, int64(time.Now().UnixNano()/1e6))
+}
 
-	if v.ReadBWInfo.LastTimestamp == 0 {
-		v.ReadBWInfo.LastTimestamp = nowInMS
-	} else if (nowInMS - v.ReadBWInfo.LastTimestamp) >= SAVE_STATICS_INTERVAL {
-		diffTimestamp := (nowInMS - v.ReadBWInfo.LastTimestamp) / 1000
+func (v *VirReader) saveStatics(streamid uint32, length uint64, isVideoFlag bool, nowInMS int64) {
+	v.bwLock.Lock()
+	defer v.bwLock.Unlock()
+	v.ReadBWInfo.save(streamid, length, isVideoFlag, nowInMS)
+}
 
-		//log.Printf("now=%d, last=%d, diff=%d", nowInMS, v.ReadBWInfo.LastTimestamp, diffTimestamp)
-		v.ReadBWInfo.VideoSpeedInBytesperMS = (v.ReadBWInfo.VideoDatainBytes - v.ReadBWInfo.LastVideoDatainBytes) * 8 / uint64(diffTimestamp) / 1000
-		v.ReadBWInfo.AudioSpeedInBytesperMS = (v.ReadBWInfo.AudioDatainBytes - v.ReadBWInfo.LastAudioDatainBytes) * 8 / uint64(diffTimestamp) / 1000
+// BWInfo returns a copy of ReadBWInfo
+func (v *VirReader) BWInfo() StaticsBW {
+	v.bwLock.Lock()
+	defer v.bwLock.Unlock()
+	return v.ReadBWInfo
+}
 
-		v.ReadBWInfo.LastVideoDatainBytes = v.ReadBWInfo.VideoDatainBytes
-		v.ReadBWInfo.LastAudioDatainBytes = v.ReadBWInfo.AudioDatainBytes
-		v.ReadBWInfo.LastTimestamp = nowInMS
+// checkBitrate disconnects a publisher above rtmp.max_publish_bitrate_kbps
+// for too long, the rate is only checked when a new sample was taken
+func (v *VirReader) checkBitrate(nowInMS int64) error {
+	bw := v.BWInfo()
+	if bw.LastTimestamp != nowInMS {
+		return nil
 	}
+	if err := v.guard.check(&bw, nowInMS); err != nil {
+		events.Emit(v.Info().Key, events.PublishLimit, err.Error())
+		log.Warningf("[%v] publisher disconnected: %v", v.Info(), err)
+		v.conn.Close(bitrateError(err))
+		return err
+	}
+	return nil
+}
+
+// BitrateLimit returns rtmp.max_publish_bitrate_kbps, 0 when unlimited,
+// and whether the publisher is close to it
+func (v *VirReader) BitrateLimit() (uint64, bool) {
+	bw := v.BWInfo()
+	return v.guard.limit, v.guard.near(&bw)
+}
+
+// Continuity returns the timestamp gaps and reorders seen since the
+// publisher connected
+func (v *VirReader) Continuity() ContinuityStats {
+	return v.continuity.stats()
 }
 
 func (v *VirReader) Read(p *av.Packet) (err error) {
@@ -415,7 +723,6 @@ func (v *VirReader) Read(p *av.Packet) (err error) {
 		}
 	}()
 
-	v.SetPreTime()
 	var cs core.ChunkStream
 	for {
 		err = v.conn.Read(&cs)
@@ -430,14 +737,30 @@ func (v *VirReader) Read(p *av.Packet) (err error) {
 		}
 	}
 
+	// the idle timeout only resets when a media packet actually arrived
+	v.SetPreTime()
+
 	p.IsAudio = cs.TypeID == av.TAG_AUDIO
 	p.IsVideo = cs.TypeID == av.TAG_VIDEO
 	p.IsMetadata = cs.TypeID == av.TAG_SCRIPTDATAAMF0 || cs.TypeID == av.TAG_SCRIPTDATAAMF3
 	p.StreamID = cs.StreamID
 	p.Data = cs.Data
 	p.TimeStamp = cs.Timestamp
+	v.continuity.check(p)
+	if p.IsMetadata {
+		// encoders disagree on the names and types of the onMetaData fields
+		if data, err := amf.NormalizeMetaData(p.Data); err == nil {
+			p.Data = data
+		} else {
+			log.Debug("normalize metadata error: ", err)
+		}
+	}
 
-	v.SaveStatics(p.StreamID, uint64(len(p.Data)), p.IsVideo)
+	nowInMS := int64(time.Now().UnixNano() / 1e6)
+	v.saveStatics(p.StreamID, uint64(len(p.Data)), p.IsVideo, nowInMS)
+	if err = v.checkBitrate(nowInMS); err != nil {
+		return err
+	}
 	v.demuxer.DemuxH(p)
 	return err
 }
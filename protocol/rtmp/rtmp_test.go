@@ -0,0 +1,448 @@
+package rtmp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/protocol/amf"
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func TestKeyRotationKeepsLivePublisher(t *testing.T) {
+	at := assert.New(t)
+
+	oldKey, err := configure.RoomKeys.SetKey("rotate")
+	at.Nil(err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer listener.Close()
+
+	handler := NewRtmpStream()
+	go NewRtmpServer(handler, nil).Serve(listener)
+	url := "rtmp://" + listener.Addr().String() + "/live/"
+
+	publisher := core.NewConnClient()
+	at.Nil(publisher.Start(url+oldKey, av.PUBLISH))
+	defer publisher.Close(nil)
+
+	var uid string
+	at.True(waitFor(func() bool {
+		s, ok := handler.GetStream("live/rotate")
+		if ok && s.GetReader() != nil {
+			uid = s.ID()
+			return true
+		}
+		return false
+	}))
+
+	newKey, err := configure.RoomKeys.SetKey("rotate")
+	at.Nil(err)
+	at.NotEqual(oldKey, newKey)
+
+	// the live publisher keeps streaming
+	audio := core.ChunkStream{
+		Format:   0,
+		CSID:     4,
+		TypeID:   av.TAG_AUDIO,
+		StreamID: publisher.GetStreamId(),
+		Data:     []byte{0xaf, 0x01, 0x00, 0x00},
+	}
+	audio.Length = uint32(len(audio.Data))
+	at.Nil(publisher.Write(audio))
+	at.Nil(publisher.Flush())
+
+	s, ok := handler.GetStream("live/rotate")
+	at.True(ok)
+	at.Equal(uid, s.ID())
+	at.True(s.started())
+
+	// a reconnect with the old key is rejected
+	_, err = configure.RoomKeys.GetChannel(oldKey)
+	at.NotNil(err)
+
+	retry := core.NewConnClient()
+	if err := retry.Start(url+oldKey, av.PUBLISH); err == nil {
+		var c core.ChunkStream
+		at.NotNil(retry.Read(&c))
+		retry.Close(nil)
+	}
+
+	s, ok = handler.GetStream("live/rotate")
+	at.True(ok)
+	at.Equal(uid, s.ID())
+}
+
+// stalledConn is a player connection whose writes block until release is closed
+type stalledConn struct {
+	name    string // of the room, stalled when empty
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (c *stalledConn) GetInfo() (string, string, string) {
+	name := c.name
+	if name == "" {
+		name = "stalled"
+	}
+	return "live", name, "rtmp://127.0.0.1/live/" + name
+}
+
+func (c *stalledConn) Close(error) {}
+
+func (c *stalledConn) Write(core.ChunkStream) error {
+	<-c.release
+	return nil
+}
+
+func (c *stalledConn) Flush() error { return nil }
+
+func (c *stalledConn) Read(*core.ChunkStream) error {
+	<-c.closed
+	return io.EOF
+}
+
+func TestVirWriterBuffer(t *testing.T) {
+	at := assert.New(t)
+
+	configure.Config.Set("write_buffer.size", 128)
+	configure.Config.Set("write_buffer.lag_threshold", 50)
+	defer configure.Config.Set("write_buffer.size", 0)
+	defer configure.Config.Set("write_buffer.lag_threshold", 0)
+
+	// a room of its own, whose history keeps the events of the earlier runs
+	begin := time.Now()
+	conn := &stalledConn{name: "stalled_buffer", release: make(chan struct{}), closed: make(chan struct{})}
+	defer close(conn.closed)
+	w := NewVirWriter(conn)
+
+	for i := 0; i < 100; i++ {
+		at.Nil(w.Write(&av.Packet{IsAudio: true, TimeStamp: uint32(i), Data: make([]byte, 10)}))
+	}
+	// one packet is stuck in the connection, the rest waits in the queue
+	at.True(waitFor(func() bool { return w.BufferStats().Packets == 99 }))
+	stats := w.BufferStats()
+	at.Equal(128, stats.Size)
+	at.Equal(int64(990), stats.Bytes)
+	at.Equal(int64(100), stats.HighWaterPackets)
+	at.True(stats.Lagging)
+
+	lags := 0
+	for _, e := range events.Get("live/stalled_buffer") {
+		if e.Type == events.PlayerLag && !e.Time.Before(begin) {
+			lags++
+		}
+	}
+	at.Equal(1, lags)
+
+	close(conn.release)
+	at.True(waitFor(func() bool { return w.BufferStats().Packets == 0 }))
+	stats = w.BufferStats()
+	at.Equal(int64(0), stats.Bytes)
+	at.Equal(int64(1000), stats.HighWaterBytes)
+	at.False(stats.Lagging)
+}
+
+func TestVirWriterDropOnLag(t *testing.T) {
+	at := assert.New(t)
+
+	configure.Config.Set("write_buffer.size", 128)
+	configure.Config.Set("write_buffer.drop_on_lag", true)
+	defer configure.Config.Set("write_buffer.size", 0)
+	defer configure.Config.Set("write_buffer.drop_on_lag", false)
+
+	conn := &stalledConn{release: make(chan struct{}), closed: make(chan struct{})}
+	defer close(conn.closed)
+	defer close(conn.release)
+	w := NewVirWriter(conn)
+
+	// inter frames are dropped once lagging, the key frames stay
+	for i := 0; i < 200; i++ {
+		data := make([]byte, 10)
+		data[0], data[1] = av.FRAME_INTER<<4|av.VIDEO_H264, av.AVC_NALU
+		if i%50 == 0 {
+			data[0] = av.FRAME_KEY<<4 | av.VIDEO_H264
+		}
+		tag := &flv.Tag{}
+		_, err := tag.ParseMediaTagHeader(data, true)
+		at.Nil(err)
+		at.Nil(w.Write(&av.Packet{IsVideo: true, TimeStamp: uint32(i), Data: data, Header: tag}))
+	}
+	stats := w.BufferStats()
+	at.True(stats.Packets < 128-24, stats.Packets)
+	at.Equal(int64(stats.Packets*10), stats.Bytes)
+}
+
+func TestVirWriterPlaybackMode(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("write_buffer.size", 128)
+	defer configure.Config.Set("write_buffer.size", 0)
+	defer configure.Config.Set("playback.mode", "")
+
+	conn := &stalledConn{release: make(chan struct{}), closed: make(chan struct{})}
+	defer close(conn.closed)
+	defer close(conn.release)
+
+	w := NewVirWriter(conn)
+	at.Equal(128, cap(w.packetQueue))
+	at.True(w.flushEach)
+
+	// the preset replaces the write_buffer settings
+	configure.Config.Set("playback.mode", configure.PlaybackSmooth)
+	w = NewVirWriter(conn)
+	at.Equal(4096, cap(w.packetQueue))
+	at.False(w.dropOnLag)
+	at.False(w.flushEach)
+
+	configure.Config.Set("playback.mode", configure.PlaybackLowLatency)
+	w = NewVirWriter(conn)
+	at.Equal(256, cap(w.packetQueue))
+	at.True(w.dropOnLag)
+}
+
+func TestStaticsBW(t *testing.T) {
+	at := assert.New(t)
+
+	bw := &StaticsBW{}
+	bw.save(1, 0, true, 10000)
+	// 5.5s of 1136kbit/s of video and 128kbit/s of audio
+	bw.save(1, 781000, true, 12000)
+	bw.save(1, 88000, false, 15500)
+
+	at.Equal(uint64(1136), bw.VideoBitrateKbps)
+	at.Equal(uint64(128), bw.AudioBitrateKbps)
+	// the old speeds divide by whole seconds, 5 instead of 5.5
+	at.Equal(uint64(1249), bw.VideoSpeedInBytesperMS)
+	at.Equal(int64(15500), bw.LastTimestamp)
+
+	// nothing is sampled before the next interval
+	bw.save(1, 1000, true, 16000)
+	at.Equal(uint64(1136), bw.VideoBitrateKbps)
+}
+
+func TestKickSendsStatus(t *testing.T) {
+	at := assert.New(t)
+
+	key, err := configure.RoomKeys.SetKey("kick")
+	at.Nil(err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer listener.Close()
+
+	handler := NewRtmpStream()
+	go NewRtmpServer(handler, nil).Serve(listener)
+	url := "rtmp://" + listener.Addr().String() + "/live/"
+
+	publisher := core.NewConnClient()
+	at.Nil(publisher.Start(url+key, av.PUBLISH))
+	defer publisher.Close(nil)
+	player := core.NewConnClient()
+	at.Nil(player.Start(url+"kick", av.PLAY))
+	defer player.Close(nil)
+
+	var uid string
+	at.True(waitFor(func() bool {
+		s, ok := handler.GetStream("live/kick")
+		if !ok {
+			return false
+		}
+		s.GetWs().Range(func(k, v interface{}) bool {
+			uid = v.(*PackWriterCloser).GetWriter().Info().UID
+			return false
+		})
+		return uid != ""
+	}))
+	s, _ := handler.GetStream("live/kick")
+	_, ok := s.KickPlayer(uid)
+	at.True(ok)
+
+	// the status is the last message before the connection is closed
+	var status amf.Object
+	for {
+		var c core.ChunkStream
+		if err := player.Read(&c); err != nil {
+			break
+		}
+		if c.TypeID != 20 {
+			continue
+		}
+		vs, _ := (&amf.Decoder{}).DecodeBatch(bytes.NewReader(c.Data), amf.AMF0)
+		if len(vs) == 4 && vs[0] == "onStatus" {
+			status, _ = vs[3].(amf.Object)
+		}
+	}
+	at.Equal(amf.Object{
+		"level":       "error",
+		"code":        "NetStream.Play.Stop",
+		"description": "kicked",
+	}, status)
+}
+
+func TestCodecChangeForwarded(t *testing.T) {
+	at := assert.New(t)
+	start := time.Now()
+
+	rs := NewRtmpStream()
+	publisher := newChanReader("publisher")
+	rs.HandleReader(publisher)
+	defer publisher.Close(nil)
+	player := &packetWriter{RWBaser: av.NewRWBaser(0)}
+	rs.HandleWriter(player)
+
+	video := func(ts uint32, data []byte) {
+		tag := &flv.Tag{}
+		_, err := tag.ParseMediaTagHeader(data, true)
+		at.Nil(err)
+		publisher.packets <- av.Packet{IsVideo: true, TimeStamp: ts, Data: data, Header: tag}
+	}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	first := flv.NewAVCSeqHeader([]byte{0x67, 0x42, 0x00, 0x1e, 0xab}, pps)
+	second := flv.NewAVCSeqHeader([]byte{0x67, 0x64, 0x00, 0x28, 0xac}, pps)
+	idr := []byte{0x65, 0x88, 0x84}
+
+	// the first packet only sends the cache to the player
+	video(0, first)
+	video(0, flv.NewAVCNALU([][]byte{idr}, true, 0))
+	// the encoder restarts at another resolution
+	video(40, second)
+	video(40, flv.NewAVCNALU([][]byte{idr}, true, 0))
+	at.True(waitFor(func() bool { return len(player.recorded()) == 4 }))
+
+	got := player.recorded()
+	at.Equal(first, got[0].Data)
+	at.Equal(second, got[2].Data)
+
+	changes := 0
+	for _, e := range events.Get("live/rebase") {
+		if e.Type == events.CodecChange && !e.Time.Before(start) {
+			changes++
+		}
+	}
+	at.Equal(1, changes)
+
+	// a player joining now starts on the new config
+	late := &packetWriter{RWBaser: av.NewRWBaser(0)}
+	rs.HandleWriter(late)
+	video(80, flv.NewAVCNALU([][]byte{{0x41, 0x9a}}, false, 0))
+	at.True(waitFor(func() bool { return len(late.recorded()) > 0 }))
+	at.Equal(second, late.recorded()[0].Data)
+}
+
+// idleReader is a chanReader of its own room timing out like a publisher
+type idleReader struct {
+	*chanReader
+	closeLock sync.Mutex
+	reason    error
+}
+
+func (r *idleReader) Info() av.Info {
+	return av.Info{Key: "live/idle", URL: "rtmp://127.0.0.1/live/idle", UID: r.uid}
+}
+
+func (r *idleReader) Alive() bool { return r.RWBaser.Alive() }
+
+func (r *idleReader) Close(err error) {
+	r.closeLock.Lock()
+	if r.reason == nil {
+		r.reason = err
+	}
+	r.closeLock.Unlock()
+	r.chanReader.Close(err)
+}
+
+func (r *idleReader) closed() error {
+	r.closeLock.Lock()
+	defer r.closeLock.Unlock()
+	return r.reason
+}
+
+func TestPublishIdle(t *testing.T) {
+	at := assert.New(t)
+	begin := time.Now()
+	r := &idleReader{chanReader: newChanReader("idle")}
+	r.RWBaser = av.NewRWBaser(100 * time.Millisecond)
+	rs := NewRtmpStream()
+	rs.HandleReader(r)
+	s, _ := rs.GetStream("live/idle")
+	at.True(waitFor(s.started))
+
+	at.Equal(1, s.CheckAlive())
+	at.Nil(r.closed())
+
+	// nothing came within the read timeout
+	time.Sleep(150 * time.Millisecond)
+	at.Equal(0, s.CheckAlive())
+	at.Equal(ErrPublishIdle, r.closed())
+	at.False(s.started())
+	idles := 0
+	for _, e := range events.Get("live/idle") {
+		if e.Type == events.PublishIdle && !e.Time.Before(begin) {
+			idles++
+		}
+	}
+	at.Equal(1, idles)
+}
+
+// chunkConn is a publisher connection reading the chunks sent on it
+type chunkConn struct {
+	stalledConn
+	chunks chan core.ChunkStream
+}
+
+func (c *chunkConn) Read(cs *core.ChunkStream) error {
+	select {
+	case *cs = <-c.chunks:
+		return nil
+	case <-c.closed:
+		return io.EOF
+	}
+}
+
+func TestVirReaderIdle(t *testing.T) {
+	at := assert.New(t)
+	conn := &chunkConn{
+		stalledConn: stalledConn{name: "idle_reader", closed: make(chan struct{})},
+		chunks:      make(chan core.ChunkStream),
+	}
+	defer close(conn.closed)
+	r := NewVirReader(conn)
+	r.RWBaser = av.NewRWBaser(100 * time.Millisecond)
+
+	read := make(chan error, 1)
+	go func() {
+		var p av.Packet
+		read <- r.Read(&p)
+	}()
+
+	// control messages keep coming but no media, the publisher is idle
+	for i := 0; i < 8; i++ {
+		conn.chunks <- core.ChunkStream{TypeID: 4, Data: make([]byte, 6)}
+		time.Sleep(20 * time.Millisecond)
+	}
+	at.False(r.Alive())
+
+	conn.chunks <- core.ChunkStream{TypeID: av.TAG_AUDIO, Length: 3, Data: []byte{0xaf, 0x01, 0x00}}
+	at.Nil(<-read)
+	at.True(r.Alive())
+}
@@ -0,0 +1,182 @@
+package rtmprelay
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+	"github.com/SpooderfyBot/live/utils/pio"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	flvHeaderLen    = 9
+	flvTagHeaderLen = 11
+	// maxFlvTagSize bounds the data of a plausible tag
+	maxFlvTagSize = 8 << 20
+	// maxFlvResync bounds the bytes skipped looking for the tag after a
+	// corrupt one before the source is given up
+	maxFlvResync = 4 << 20
+)
+
+// flvSource reads the tags of an http-flv stream. A tag failing the
+// checks of its header or previous tag size is skipped up to the next
+// plausible tag boundary.
+type flvSource struct {
+	url     string
+	body    io.ReadCloser
+	r       *bufio.Reader
+	pending []byte // read ahead while checking a tag that wasn't one
+	resyncs uint64
+}
+
+func newFlvSource(ctx context.Context, url string) (ret playSource, err error) {
+	connectCtx, connected := detach(ctx)
+	defer func() {
+		if !connected() {
+			if err == nil {
+				ret.Close(nil)
+			}
+			ret, err = nil, ctx.Err()
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(connectCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("flv source %s status=%d", url, resp.StatusCode)
+	}
+
+	s := &flvSource{
+		url:  url,
+		body: resp.Body,
+		r:    bufio.NewReader(resp.Body),
+	}
+	var header [flvHeaderLen]byte
+	if _, err := io.ReadFull(s.r, header[:]); err != nil {
+		s.body.Close()
+		return nil, err
+	}
+	if string(header[:3]) != "FLV" {
+		s.body.Close()
+		return nil, fmt.Errorf("flv source %s is not flv", url)
+	}
+	// skip the rest of the header and the first previous tag size
+	offset := int(pio.U32BE(header[5:9]))
+	if offset < flvHeaderLen {
+		s.body.Close()
+		return nil, fmt.Errorf("flv source %s invalid header", url)
+	}
+	if _, err := s.r.Discard(offset - flvHeaderLen + 4); err != nil {
+		s.body.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *flvSource) Read(c *core.ChunkStream) error {
+	for skipped := 0; ; skipped++ {
+		if skipped > maxFlvResync {
+			return fmt.Errorf("flv source %s no tag within %d bytes", s.url, maxFlvResync)
+		}
+		header, err := s.peek(flvTagHeaderLen)
+		if err != nil {
+			return err
+		}
+		if !plausibleTagHeader(header) {
+			s.discard(1)
+			continue
+		}
+		size := pio.U24BE(header[1:4])
+		tag := make([]byte, flvTagHeaderLen+size+4)
+		n, err := s.readFull(tag)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if err != nil || pio.U32BE(tag[len(tag)-4:]) != flvTagHeaderLen+size {
+			// not a tag boundary, or one the stream ends within, look
+			// again from the next byte
+			s.pending = append(tag[1:n], s.pending...)
+			continue
+		}
+		if skipped > 0 {
+			atomic.AddUint64(&s.resyncs, 1)
+			log.Warningf("flv source %s skipped %d bytes of corrupt tags", s.url, skipped)
+		}
+
+		c.TypeID = uint32(tag[0] & 0x1f)
+		c.Timestamp = pio.U24BE(tag[4:7]) | uint32(tag[7])<<24
+		c.Length = size
+		c.Data = tag[flvTagHeaderLen : flvTagHeaderLen+size]
+		return nil
+	}
+}
+
+// Resyncs is the number of times corrupt tags were skipped
+func (s *flvSource) Resyncs() uint64 {
+	return atomic.LoadUint64(&s.resyncs)
+}
+
+// plausibleTagHeader tells if header can start a tag: audio, video or
+// script data of a sane length on stream 0
+func plausibleTagHeader(header []byte) bool {
+	switch header[0] {
+	case av.TAG_AUDIO, av.TAG_VIDEO, av.TAG_SCRIPTDATAAMF0:
+	default:
+		return false
+	}
+	return pio.U24BE(header[1:4]) <= maxFlvTagSize && pio.U24BE(header[8:11]) == 0
+}
+
+// peek returns the next n bytes without consuming them
+func (s *flvSource) peek(n int) ([]byte, error) {
+	if len(s.pending) >= n {
+		return s.pending[:n], nil
+	}
+	b, err := s.r.Peek(n - len(s.pending))
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(nil), s.pending...), b...), nil
+}
+
+// discard skips n bytes that were peeked
+func (s *flvSource) discard(n int) {
+	if k := len(s.pending); k > 0 {
+		if k > n {
+			k = n
+		}
+		s.pending = s.pending[k:]
+		n -= k
+	}
+	s.r.Discard(n)
+}
+
+// readFull reads b and returns the bytes read, a stream ending after a
+// part of b is io.ErrUnexpectedEOF
+func (s *flvSource) readFull(b []byte) (int, error) {
+	n := copy(b, s.pending)
+	s.pending = s.pending[n:]
+	m, err := io.ReadFull(s.r, b[n:])
+	if err == io.EOF && n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n + m, err
+}
+
+func (s *flvSource) Close(err error) {
+	s.body.Close()
+}
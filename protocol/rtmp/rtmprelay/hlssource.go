@@ -0,0 +1,316 @@
+package rtmprelay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/container/ts"
+	"github.com/SpooderfyBot/live/parser/aac"
+	"github.com/SpooderfyBot/live/parser/h264"
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+	"github.com/SpooderfyBot/live/utils/logsample"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	hlsFetchTimeout    = 10 * time.Second
+	hlsMinPollInterval = 500 * time.Millisecond
+	hlsChunkQueueLen   = 512
+	aacFrameSamples    = 1024
+)
+
+var errHlsSourceClosed = fmt.Errorf("hls source closed")
+
+type hlsSegment struct {
+	seq int64
+	url string
+}
+
+type hlsPlaylist struct {
+	variant  string
+	target   time.Duration
+	segments []hlsSegment
+	end      bool
+}
+
+// parsePlaylist reads a media playlist, or the first variant of a master one
+func parsePlaylist(base *neturl.URL, body []byte) (*hlsPlaylist, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "#EXTM3U" {
+		return nil, fmt.Errorf("invalid m3u8 playlist")
+	}
+
+	ret := &hlsPlaylist{}
+	seq := int64(0)
+	variant := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			seq, _ = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			d, _ := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"))
+			ret.target = time.Duration(d) * time.Second
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
+			variant = true
+		case line == "#EXT-X-ENDLIST":
+			ret.end = true
+		case strings.HasPrefix(line, "#"):
+		default:
+			u, err := base.Parse(line)
+			if err != nil {
+				return nil, err
+			}
+			if variant {
+				ret.variant = u.String()
+				return ret, nil
+			}
+			ret.segments = append(ret.segments, hlsSegment{seq: seq, url: u.String()})
+			seq++
+		}
+	}
+	return ret, scanner.Err()
+}
+
+// hlsSource polls an hls playlist and turns its mpeg-ts segments
+// back into flv tags
+type hlsSource struct {
+	url       string
+	client    *http.Client
+	demuxer   *ts.Demuxer
+	chunks    chan core.ChunkStream
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+
+	lastSeq   int64
+	base      int64
+	sps       []byte
+	pps       []byte
+	seqSent   bool
+	aacConfig []byte
+}
+
+func newHlsSource(ctx context.Context, url string) (playSource, error) {
+	s := &hlsSource{
+		url:     url,
+		client:  &http.Client{Timeout: hlsFetchTimeout},
+		demuxer: ts.NewDemuxer(),
+		chunks:  make(chan core.ChunkStream, hlsChunkQueueLen),
+		done:    make(chan struct{}),
+		lastSeq: -1,
+		base:    -1,
+	}
+
+	// the first fetch is done here so a bad url fails the relay start
+	playlist, err := s.fetchPlaylist(ctx)
+	if err != nil {
+		return nil, err
+	}
+	go s.run(playlist)
+	return s, nil
+}
+
+func (s *hlsSource) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("hls source %s status=%d", url, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// fetchPlaylist returns the media playlist, following a master playlist
+// to its first variant which is then polled instead
+func (s *hlsSource) fetchPlaylist(ctx context.Context) (*hlsPlaylist, error) {
+	for i := 0; i < 2; i++ {
+		base, err := neturl.Parse(s.url)
+		if err != nil {
+			return nil, err
+		}
+		body, err := s.get(ctx, s.url)
+		if err != nil {
+			return nil, err
+		}
+		playlist, err := parsePlaylist(base, body)
+		if err != nil {
+			return nil, err
+		}
+		if playlist.variant == "" {
+			return playlist, nil
+		}
+		s.url = playlist.variant
+	}
+	return nil, fmt.Errorf("hls source %s nested master playlists", s.url)
+}
+
+func (s *hlsSource) run(playlist *hlsPlaylist) {
+	var err error
+	defer func() {
+		s.err = err
+		close(s.chunks)
+	}()
+
+	for {
+		for _, segment := range playlist.segments {
+			if segment.seq <= s.lastSeq {
+				continue
+			}
+			if err = s.readSegment(segment.url); err != nil {
+				return
+			}
+			s.lastSeq = segment.seq
+		}
+		if playlist.end {
+			err = io.EOF
+			return
+		}
+
+		interval := playlist.target / 2
+		if interval < hlsMinPollInterval {
+			interval = hlsMinPollInterval
+		}
+		select {
+		case <-s.done:
+			err = errHlsSourceClosed
+			return
+		case <-time.After(interval):
+		}
+
+		if playlist, err = s.fetchPlaylist(context.Background()); err != nil {
+			return
+		}
+	}
+}
+
+func (s *hlsSource) readSegment(url string) error {
+	body, err := s.get(context.Background(), url)
+	if err != nil {
+		return err
+	}
+	log.Debugf("hls source read segment %s, len=%d", url, len(body))
+	return s.demuxer.Demux(bytes.NewReader(body), s.onFrame)
+}
+
+func (s *hlsSource) onFrame(f *ts.Frame) error {
+	if s.base < 0 {
+		s.base = f.DTS
+	}
+	timestamp := uint32(0)
+	if f.DTS > s.base {
+		timestamp = uint32((f.DTS - s.base) / 90)
+	}
+
+	if f.IsVideo {
+		return s.onVideo(f, timestamp)
+	}
+	return s.onAudio(f, timestamp)
+}
+
+func (s *hlsSource) onVideo(f *ts.Frame, timestamp uint32) error {
+	var nalus [][]byte
+	keyFrame := false
+	sps, pps := s.sps, s.pps
+	for _, nalu := range h264.SplitAnnexB(f.Data) {
+		switch {
+		case h264.IsSPS(nalu):
+			sps = nalu
+		case h264.IsPPS(nalu):
+			pps = nalu
+		case h264.IsAUD(nalu):
+		default:
+			keyFrame = keyFrame || h264.IsIDR(nalu)
+			nalus = append(nalus, nalu)
+		}
+	}
+
+	if sps != nil && pps != nil && (!s.seqSent || !bytes.Equal(sps, s.sps) || !bytes.Equal(pps, s.pps)) {
+		if header := flv.NewAVCSeqHeader(sps, pps); header != nil {
+			if err := s.push(av.TAG_VIDEO, timestamp, header); err != nil {
+				return err
+			}
+			s.seqSent = true
+		}
+	}
+	s.sps, s.pps = sps, pps
+
+	// players can't decode anything before the sequence header
+	if !s.seqSent || len(nalus) == 0 {
+		return nil
+	}
+	return s.push(av.TAG_VIDEO, timestamp, flv.NewAVCNALU(nalus, keyFrame, int32((f.PTS-f.DTS)/90)))
+}
+
+func (s *hlsSource) onAudio(f *ts.Frame, timestamp uint32) error {
+	config, frames, err := aac.SplitADTS(f.Data)
+	if err != nil {
+		logsample.Debug("hls source drop audio").Debugf("hls source %s drop audio: %v", s.url, err)
+		return nil
+	}
+	if !bytes.Equal(config, s.aacConfig) {
+		if err := s.push(av.TAG_AUDIO, timestamp, flv.NewAACSeqHeader(config)); err != nil {
+			return err
+		}
+		s.aacConfig = config
+	}
+
+	rate := aac.ConfigSampleRate(config)
+	for i, frame := range frames {
+		offset := uint32(i * aacFrameSamples * 1000 / rate)
+		if err := s.push(av.TAG_AUDIO, timestamp+offset, flv.NewAACRaw(frame)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *hlsSource) push(typeID uint32, timestamp uint32, data []byte) error {
+	c := core.ChunkStream{
+		TypeID:    typeID,
+		Timestamp: timestamp,
+		Length:    uint32(len(data)),
+		Data:      data,
+	}
+	select {
+	case s.chunks <- c:
+		return nil
+	case <-s.done:
+		return errHlsSourceClosed
+	}
+}
+
+func (s *hlsSource) Read(c *core.ChunkStream) error {
+	rc, ok := <-s.chunks
+	if !ok {
+		return s.err
+	}
+	*c = rc
+	return nil
+}
+
+func (s *hlsSource) Close(err error) {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
@@ -2,28 +2,88 @@ package rtmprelay
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/SpooderfyBot/live/av"
 	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/SpooderfyBot/live/configure"
 	"github.com/SpooderfyBot/live/protocol/amf"
 	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
+	"github.com/SpooderfyBot/live/utils/logsample"
+	"github.com/SpooderfyBot/live/utils/reqid"
 
 	log "github.com/sirupsen/logrus"
 )
 
-var (
-	STOP_CTRL = "RTMPRELAY_STOP"
-)
+const defaultStartTimeout = 10 * time.Second
+
+// startTimeout bounds the connects of a relay start, relay.start_timeout
+// is in seconds
+func startTimeout() time.Duration {
+	if n := configure.Config.GetInt("relay.start_timeout"); n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	return defaultStartTimeout
+}
 
 type RtmpRelay struct {
-	PlayUrl              string
-	PublishUrl           string
+	// Key is the local stream key the relay belongs to, used for the event history
+	Key        string
+	PlayUrl    string
+	PublishUrl string
+	// Mirrors are the sources of a pull tried after PlayUrl, in order, when
+	// it can't be read. The publish end stays connected across a switch.
+	Mirrors              []string
+	active               int32 // index in sources() of the source read
+	tsOffset             uint32
+	lastTs               uint32
+	rebase               bool
+	resyncs              uint64 // of the source read, seen so far
+	awaitKey             bool   // drop the video up to a key frame
 	cs_chan              chan core.ChunkStream
-	sndctrl_chan         chan string
-	connectPlayClient    *core.ConnClient
+	connectPlayClient    playSource
 	connectPublishClient *core.ConnClient
-	startflag            bool
+	startflag            int32 // 1 while running, read by the api
+	lock                 sync.Mutex
+	run                  *relayRun // of the last start
+	stats                relayStats
+	requestID            string // of the api request that started the relay
+}
+
+// relayRun is a start of the relay, done is closed once when it stops
+// whichever of Stop and the end of the source comes first. exited is
+// released as its goroutines return, having closed their connection.
+type relayRun struct {
+	done   chan struct{}
+	once   sync.Once
+	exited sync.WaitGroup
+}
+
+func newRelayRun() *relayRun {
+	return &relayRun{done: make(chan struct{})}
+}
+
+func (r *relayRun) stopped() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop closes done, only the first call of a run returns true
+func (r *relayRun) stop() (stopped bool) {
+	r.once.Do(func() {
+		close(r.done)
+		stopped = true
+	})
+	return
 }
 
 func NewRtmpRelay(playurl *string, publishurl *string) *RtmpRelay {
@@ -31,95 +91,274 @@ func NewRtmpRelay(playurl *string, publishurl *string) *RtmpRelay {
 		PlayUrl:              *playurl,
 		PublishUrl:           *publishurl,
 		cs_chan:              make(chan core.ChunkStream, 500),
-		sndctrl_chan:         make(chan string),
 		connectPlayClient:    nil,
 		connectPublishClient: nil,
-		startflag:            false,
 	}
 }
 
-func (self *RtmpRelay) rcvPlayChunkStream() {
-	log.Debug("rcvPlayRtmpMediaPacket connectClient.Read...")
+func (self *RtmpRelay) rcvPlayChunkStream(run *relayRun) {
+	defer run.exited.Done()
+	self.log().Debug("rcvPlayRtmpMediaPacket connectClient.Read...")
 	for {
 		var rc core.ChunkStream
 
-		if self.startflag == false {
+		select {
+		case <-run.done:
 			self.connectPlayClient.Close(nil)
-			log.Debugf("rcvPlayChunkStream close: playurl=%s, publishurl=%s", self.PlayUrl, self.PublishUrl)
-			break
+			self.log().Debugf("rcvPlayChunkStream close: playurl=%s, publishurl=%s", self.PlayUrl, self.PublishUrl)
+			return
+		default:
 		}
 		err := self.connectPlayClient.Read(&rc)
 
-		if err != nil && err == io.EOF {
-			break
+		if err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("play EOF")
+			}
+			self.log().Debugf("rcvPlayChunkStream read error: playurl=%s, err=%v", self.ActiveSource(), err)
+			self.connectPlayClient.Close(nil)
+			self.stats.fail(err)
+			if self.failover(run) {
+				continue
+			}
+			self.halt(run, err.Error())
+			return
 		}
 		//log.Debugf("connectPlayClient.Read return rc.TypeID=%v length=%d, err=%v", rc.TypeID, len(rc.Data), err)
+		self.checkResync()
 		switch rc.TypeID {
 		case 20, 17:
-			r := bytes.NewReader(rc.Data)
-			vs, err := self.connectPlayClient.DecodeBatch(r, amf.AMF0)
+			if client, ok := self.connectPlayClient.(*core.ConnClient); ok {
+				r := bytes.NewReader(rc.Data)
+				vs, err := client.DecodeBatch(r, amf.AMF0)
 
-			log.Debugf("rcvPlayRtmpMediaPacket: vs=%v, err=%v", vs, err)
+				logsample.Debug("relay command").Debugf("rcvPlayRtmpMediaPacket: vs=%v, err=%v", vs, err)
+			}
 		case 18:
-			log.Debug("rcvPlayRtmpMediaPacket: metadata....")
+			logsample.Debug("relay metadata").Debug("rcvPlayRtmpMediaPacket: metadata....")
 		case 8, 9:
-			self.cs_chan <- rc
+			if !self.keep(rc) {
+				continue
+			}
+			if self.rebase {
+				// the new source goes on from the last timestamp sent
+				self.tsOffset = self.lastTs - rc.Timestamp
+				self.rebase = false
+			}
+			rc.Timestamp += self.tsOffset
+			self.lastTs = rc.Timestamp
+			select {
+			case self.cs_chan <- rc:
+			case <-run.done:
+			}
 		}
 	}
 }
 
-func (self *RtmpRelay) sendPublishChunkStream() {
+func (self *RtmpRelay) sendPublishChunkStream(run *relayRun) {
+	defer run.exited.Done()
 	for {
 		select {
 		case rc := <-self.cs_chan:
 			//log.Debugf("sendPublishChunkStream: rc.TypeID=%v length=%d", rc.TypeID, len(rc.Data))
-			self.connectPublishClient.Write(rc)
-		case ctrlcmd := <-self.sndctrl_chan:
-			if ctrlcmd == STOP_CTRL {
+			if err := self.connectPublishClient.Write(rc); err != nil {
+				// the target refused or went away, the relay is over
+				self.log().Debugf("sendPublishChunkStream write error: publishurl=%s, err=%v", self.PublishUrl, err)
+				self.stats.fail(err)
+				self.halt(run, "publish: "+err.Error())
 				self.connectPublishClient.Close(nil)
-				log.Debugf("sendPublishChunkStream close: playurl=%s, publishurl=%s", self.PlayUrl, self.PublishUrl)
 				return
 			}
+			self.stats.count(len(rc.Data), time.Now())
+		case <-run.done:
+			self.connectPublishClient.Close(nil)
+			self.log().Debugf("sendPublishChunkStream close: playurl=%s, publishurl=%s", self.PlayUrl, self.PublishUrl)
+			return
 		}
 	}
 }
 
 func (self *RtmpRelay) Start() error {
-	if self.startflag {
+	return self.StartContext(context.Background())
+}
+
+// StartContext connects both ends of the relay, giving up when ctx is
+// done or after relay.start_timeout with context.DeadlineExceeded
+func (self *RtmpRelay) StartContext(ctx context.Context) error {
+	if self.IsStart() {
 		return fmt.Errorf("The rtmprelay already started, playurl=%s, publishurl=%s\n", self.PlayUrl, self.PublishUrl)
 	}
 
-	self.connectPlayClient = core.NewConnClient()
+	// the connections of the last run are its own until it is over
+	self.lock.Lock()
+	last := self.run
+	self.lock.Unlock()
+	if last != nil {
+		last.exited.Wait()
+	}
+
+	self.requestID = reqid.FromContext(ctx)
+	ctx, cancel := context.WithTimeout(ctx, startTimeout())
+	defer cancel()
+
 	self.connectPublishClient = core.NewConnClient()
 
-	log.Debugf("play server addr:%v starting....", self.PlayUrl)
-	err := self.connectPlayClient.Start(self.PlayUrl, av.PLAY)
+	var err error
+	for i, url := range self.sources() {
+		self.log().Debugf("play server addr:%v starting....", url)
+		self.connectPlayClient, err = newPlaySource(ctx, url)
+		if err == nil {
+			atomic.StoreInt32(&self.active, int32(i))
+			break
+		}
+		self.log().Debugf("connectPlayClient.Start url=%v error", url)
+		if ctx.Err() != nil {
+			break
+		}
+	}
 	if err != nil {
-		log.Debugf("connectPlayClient.Start url=%v error", self.PlayUrl)
+		self.stats.fail(err)
+		self.emit(events.RelayFail, err.Error())
 		return err
 	}
+	self.tsOffset, self.lastTs, self.rebase = 0, 0, false
+	self.resyncs, self.awaitKey = 0, false
 
-	log.Debugf("publish server addr:%v starting....", self.PublishUrl)
-	err = self.connectPublishClient.Start(self.PublishUrl, av.PUBLISH)
+	self.log().Debugf("publish server addr:%v starting....", self.PublishUrl)
+	err = self.connectPublishClient.StartContext(ctx, self.PublishUrl, av.PUBLISH)
 	if err != nil {
-		log.Debugf("connectPublishClient.Start url=%v error", self.PublishUrl)
+		self.log().Debugf("connectPublishClient.Start url=%v error", self.PublishUrl)
 		self.connectPlayClient.Close(nil)
+		self.stats.fail(err)
+		self.emit(events.RelayFail, err.Error())
 		return err
 	}
 
-	self.startflag = true
-	go self.rcvPlayChunkStream()
-	go self.sendPublishChunkStream()
+	run := newRelayRun()
+	run.exited.Add(2)
+	self.lock.Lock()
+	self.run = run
+	self.lock.Unlock()
+	atomic.StoreInt32(&self.startflag, 1)
+	self.stats.start(time.Now())
+	self.emit(events.RelayStart, self.ActiveSource()+" -> "+self.PublishUrl)
+	go self.rcvPlayChunkStream(run)
+	go self.sendPublishChunkStream(run)
 
 	return nil
 }
 
+// Stop stops the relay, it may be called any number of times and after
+// the relay stopped by itself
 func (self *RtmpRelay) Stop() {
-	if !self.startflag {
-		log.Debugf("The rtmprelay already stoped, playurl=%s, publishurl=%s", self.PlayUrl, self.PublishUrl)
+	self.lock.Lock()
+	run := self.run
+	self.lock.Unlock()
+	if run == nil || !self.halt(run, "stopped") {
+		self.log().Debugf("The rtmprelay already stoped, playurl=%s, publishurl=%s", self.PlayUrl, self.PublishUrl)
+	}
+}
+
+// halt ends run for reason, the goroutines of the run close their end as
+// they see it done. Only the first halt of a run does anything.
+func (self *RtmpRelay) halt(run *relayRun, reason string) bool {
+	if !run.stop() {
+		return false
+	}
+	atomic.StoreInt32(&self.startflag, 0)
+	self.stats.stop(time.Now())
+	self.emit(events.RelayStop, reason)
+	return true
+}
+
+// sources are the play urls of the relay by priority
+func (self *RtmpRelay) sources() []string {
+	return append([]string{self.PlayUrl}, self.Mirrors...)
+}
+
+// ActiveSource is the play url the relay reads, PlayUrl unless it failed
+// over to one of the Mirrors
+func (self *RtmpRelay) ActiveSource() string {
+	return self.sources()[atomic.LoadInt32(&self.active)]
+}
+
+// failover connects the sources after the active one, wrapping around to
+// PlayUrl, and reads the first that answers. The publish end is kept so
+// the local stream and its players go on.
+func (self *RtmpRelay) failover(run *relayRun) bool {
+	sources := self.sources()
+	from := int(atomic.LoadInt32(&self.active))
+	for n := 1; n < len(sources) && !run.stopped(); n++ {
+		i := (from + n) % len(sources)
+		ctx, cancel := context.WithTimeout(context.Background(), startTimeout())
+		client, err := newPlaySource(ctx, sources[i])
+		cancel()
+		if err != nil {
+			self.log().Debugf("rtmprelay failover to %s error: %v", sources[i], err)
+			self.stats.fail(err)
+			continue
+		}
+		self.connectPlayClient = client
+		self.rebase = true
+		self.resyncs = 0
+		atomic.StoreInt32(&self.active, int32(i))
+		self.log().Infof("rtmprelay %s failed over from %s to %s", self.Key, sources[from], sources[i])
+		self.emit(events.RelayFailover, sources[from]+" -> "+sources[i])
+		return true
+	}
+	return false
+}
+
+// checkResync notices the source skipped corrupt input since the last
+// read: the gap is a discontinuity, the video resumes at a key frame
+// rather than with frames referring to the ones lost
+func (self *RtmpRelay) checkResync() {
+	r, ok := self.connectPlayClient.(resyncer)
+	if !ok {
 		return
 	}
+	n := r.Resyncs()
+	if n == self.resyncs {
+		return
+	}
+	self.stats.resync(n - self.resyncs)
+	self.resyncs = n
+	self.awaitKey = true
+	self.emit(events.RelayResync, self.ActiveSource())
+}
+
+// keep tells if the media message rc is sent, the video after a resync is
+// dropped up to a key frame or sequence header
+func (self *RtmpRelay) keep(rc core.ChunkStream) bool {
+	if !self.awaitKey || rc.TypeID != av.TAG_VIDEO {
+		return true
+	}
+	if len(rc.Data) == 0 || rc.Data[0]>>4 != av.FRAME_KEY {
+		return false
+	}
+	self.awaitKey = false
+	return true
+}
 
-	self.startflag = false
-	self.sndctrl_chan <- STOP_CTRL
+func (self *RtmpRelay) IsStart() bool {
+	return atomic.LoadInt32(&self.startflag) == 1
+}
+
+// Stats returns the bytes sent by the relay, its bitrate, uptime and the
+// last error it ran into
+func (self *RtmpRelay) Stats() Stats {
+	return self.stats.get(self.IsStart(), time.Now())
+}
+
+// log is the logger of the relay, its lines carry the id of the request
+// that started it
+func (self *RtmpRelay) log() *log.Entry {
+	return reqid.Entry(self.requestID)
+}
+
+func (self *RtmpRelay) emit(t events.Type, reason string) {
+	if self.Key == "" {
+		return
+	}
+	events.Emit(self.Key, t, reason)
 }
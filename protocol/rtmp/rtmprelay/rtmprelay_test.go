@@ -0,0 +1,215 @@
+package rtmprelay
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartTimeout(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("relay.start_timeout", 1)
+	defer configure.Config.Set("relay.start_timeout", 0)
+
+	// a server accepting connections but never answering the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if c, err := listener.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+
+	play := "rtmp://" + listener.Addr().String() + "/live/silent"
+	publish := "rtmp://127.0.0.1:1/live/silent"
+	relay := NewRtmpRelay(&play, &publish)
+	begin := time.Now()
+	at.Equal(context.DeadlineExceeded, relay.Start())
+	at.True(time.Since(begin) < 3*time.Second)
+	at.False(relay.IsStart())
+
+	// the half open connection is closed, the server reads up to EOF
+	c := <-accepted
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = ioutil.ReadAll(c)
+	at.Nil(err)
+}
+
+// resyncingSource is a playSource that skipped corrupt input n times
+type resyncingSource struct{ n uint64 }
+
+func (s *resyncingSource) Read(*core.ChunkStream) error { return nil }
+func (s *resyncingSource) Close(error)                  {}
+func (s *resyncingSource) Resyncs() uint64              { return s.n }
+
+func TestRelayResync(t *testing.T) {
+	at := assert.New(t)
+
+	source := &resyncingSource{}
+	relay := &RtmpRelay{connectPlayClient: source}
+	inter := core.ChunkStream{TypeID: av.TAG_VIDEO, Data: []byte{0x27, 0x01}}
+	key := core.ChunkStream{TypeID: av.TAG_VIDEO, Data: []byte{0x17, 0x01}}
+	audio := core.ChunkStream{TypeID: av.TAG_AUDIO, Data: []byte{0xaf, 0x01}}
+
+	relay.checkResync()
+	at.True(relay.keep(inter))
+
+	// after a resync the video waits for a key frame, the audio goes on
+	source.n = 2
+	relay.checkResync()
+	at.Equal(uint64(2), relay.Stats().Resyncs)
+	at.False(relay.keep(inter))
+	at.True(relay.keep(audio))
+	at.True(relay.keep(key))
+	at.True(relay.keep(inter))
+
+	relay.checkResync()
+	at.Equal(uint64(2), relay.Stats().Resyncs)
+}
+
+// rtmpSink accepts rtmp publishers, each one is passed to handle once it
+// asked to publish
+func rtmpSink(at *assert.Assertions, handle func(*core.ConnServer)) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	at.Nil(err)
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn := core.NewConn(c, 4*1024)
+				if conn.HandshakeServer() != nil {
+					c.Close()
+					return
+				}
+				server := core.NewConnServer(conn)
+				if server.ReadMsg() != nil || !server.IsPublisher() {
+					c.Close()
+					return
+				}
+				handle(server)
+			}()
+		}
+	}()
+	return listener
+}
+
+// drain reads what a publisher sends until it goes away
+func drain(server *core.ConnServer) {
+	defer server.Close(nil)
+	for {
+		var c core.ChunkStream
+		if server.Read(&c) != nil {
+			return
+		}
+	}
+}
+
+// flvServer serves a few frames of flv then ends the response
+func flvServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{'F', 'L', 'V', 1, 5, 0, 0, 0, 9, 0, 0, 0, 0})
+		for i := 0; i < 5; i++ {
+			w.Write(flvTag(av.TAG_VIDEO, uint32(i*40), []byte{0x17, 0x01, 0, 0, 0, 0xaa}))
+		}
+	}))
+}
+
+func waitStopped(relay *RtmpRelay) bool {
+	for i := 0; i < 200 && relay.IsStart(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return !relay.IsStart()
+}
+
+func TestRelayStopIdempotent(t *testing.T) {
+	at := assert.New(t)
+	sink := rtmpSink(at, drain)
+	defer sink.Close()
+	source := flvServer()
+	defer source.Close()
+
+	play := source.URL + "/live/a.flv"
+	publish := "rtmp://" + sink.Addr().String() + "/live/a"
+	relay := NewRtmpRelay(&play, &publish)
+	at.Nil(relay.Start())
+
+	// the source ends and stops the relay, Stop then does nothing
+	at.True(waitStopped(relay))
+	stopped := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				relay.Stop()
+			}()
+		}
+		wg.Wait()
+		relay.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop blocked after the relay stopped by itself")
+	}
+
+	// it starts again and stops once more
+	at.Nil(relay.Start())
+	relay.Stop()
+	at.False(relay.IsStart())
+	relay.Stop()
+	at.False(relay.Stats().Stopped.IsZero())
+}
+
+func TestRelayPublishRefused(t *testing.T) {
+	at := assert.New(t)
+	// the target takes the publish then hangs up
+	sink := rtmpSink(at, func(server *core.ConnServer) { server.Close(nil) })
+	defer sink.Close()
+	done := make(chan struct{})
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{'F', 'L', 'V', 1, 5, 0, 0, 0, 9, 0, 0, 0, 0})
+		for i := 0; ; i++ {
+			w.Write(flvTag(av.TAG_VIDEO, uint32(i*40), make([]byte, 4096)))
+			w.(http.Flusher).Flush()
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}))
+	defer source.Close()
+	defer close(done)
+
+	play := source.URL + "/live/a.flv"
+	publish := "rtmp://" + sink.Addr().String() + "/live/a"
+	relay := NewRtmpRelay(&play, &publish)
+	at.Nil(relay.Start())
+	defer relay.Stop()
+
+	at.True(waitStopped(relay), "a relay whose target hung up stops")
+	stats := relay.Stats()
+	at.False(stats.Running)
+	at.False(stats.Stopped.IsZero())
+	at.NotEmpty(stats.LastError)
+}
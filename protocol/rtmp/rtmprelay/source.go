@@ -0,0 +1,69 @@
+package rtmprelay
+
+import (
+	"context"
+	neturl "net/url"
+	"path"
+	"strings"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+)
+
+// playSource is where a relay reads the audio and video it publishes,
+// an rtmp play client or an adapter demuxing an http output
+type playSource interface {
+	Read(c *core.ChunkStream) error
+	Close(err error)
+}
+
+// resyncer is a playSource skipping corrupt input up to the next thing
+// it can read, Resyncs counts the times it did
+type resyncer interface {
+	Resyncs() uint64
+}
+
+// newPlaySource connects to url, http(s) urls ending in .m3u8 are read as
+// hls, other http(s) urls as http-flv and anything else stays rtmp.
+// ctx only bounds the connect, not the reads that follow.
+func newPlaySource(ctx context.Context, url string) (playSource, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "http" || u.Scheme == "https" {
+		if strings.EqualFold(path.Ext(u.Path), ".m3u8") {
+			return newHlsSource(ctx, url)
+		}
+		return newFlvSource(ctx, url)
+	}
+
+	client := core.NewConnClient()
+	if err := client.StartContext(ctx, url, av.PLAY); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// detach returns a context outliving ctx, for a connection kept after its
+// connect. It is canceled along with ctx until connected is called, which
+// reports whether ctx was still running by then.
+func detach(ctx context.Context) (ret context.Context, connected func() bool) {
+	ret, cancel := context.WithCancel(context.Background())
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+	return ret, func() bool {
+		close(stop)
+		<-stopped
+		return ret.Err() == nil
+	}
+}
@@ -0,0 +1,183 @@
+package rtmprelay
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/container/ts"
+	"github.com/SpooderfyBot/live/protocol/rtmp/core"
+	"github.com/SpooderfyBot/live/utils/pio"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func flvTag(typeID uint8, timestamp uint32, data []byte) []byte {
+	b := make([]byte, 11, 11+len(data)+4)
+	b[0] = typeID
+	pio.PutU24BE(b[1:4], uint32(len(data)))
+	pio.PutU24BE(b[4:7], timestamp&0xffffff)
+	b[7] = byte(timestamp >> 24)
+	b = append(b, data...)
+	b = append(b, 0, 0, 0, 0)
+	pio.PutU32BE(b[len(b)-4:], uint32(11+len(data)))
+	return b
+}
+
+func readAll(at *assert.Assertions, s playSource) []core.ChunkStream {
+	var ret []core.ChunkStream
+	for {
+		var c core.ChunkStream
+		err := s.Read(&c)
+		if err != nil {
+			at.Equal(io.EOF, err)
+			return ret
+		}
+		ret = append(ret, c)
+	}
+}
+
+func TestFlvSource(t *testing.T) {
+	at := assert.New(t)
+
+	seq := flv.NewAVCSeqHeader([]byte{0x67, 0x42, 0x00, 0x1e, 0xab}, []byte{0x68, 0xce})
+	audio := flv.NewAACRaw([]byte{0x21, 0x19})
+	var file bytes.Buffer
+	file.Write([]byte{'F', 'L', 'V', 0x01, 0x05, 0, 0, 0, 9, 0, 0, 0, 0})
+	file.Write(flvTag(av.TAG_VIDEO, 0, seq))
+	file.Write(flvTag(av.TAG_AUDIO, 0x01000010, audio))
+
+	dir, err := ioutil.TempDir("", "flvsource")
+	at.Nil(err)
+	defer os.RemoveAll(dir)
+	at.Nil(ioutil.WriteFile(filepath.Join(dir, "movie.flv"), file.Bytes(), 0644))
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	s, err := newPlaySource(context.Background(), server.URL+"/movie.flv")
+	at.Nil(err)
+	at.IsType(&flvSource{}, s)
+	chunks := readAll(at, s)
+	s.Close(nil)
+
+	if at.Equal(2, len(chunks)) {
+		at.Equal(uint32(av.TAG_VIDEO), chunks[0].TypeID)
+		at.Equal(seq, chunks[0].Data)
+		at.Equal(uint32(len(seq)), chunks[0].Length)
+		at.Equal(uint32(av.TAG_AUDIO), chunks[1].TypeID)
+		at.Equal(uint32(0x01000010), chunks[1].Timestamp)
+		at.Equal(audio, chunks[1].Data)
+	}
+
+	_, err = newPlaySource(context.Background(), server.URL+"/missing.flv")
+	at.NotNil(err)
+}
+
+func TestFlvSourceResync(t *testing.T) {
+	at := assert.New(t)
+
+	audio := flv.NewAACRaw([]byte{0x21, 0x19})
+	var file bytes.Buffer
+	file.Write([]byte{'F', 'L', 'V', 0x01, 0x05, 0, 0, 0, 9, 0, 0, 0, 0})
+	file.Write(flvTag(av.TAG_AUDIO, 10, audio))
+	// a tag announcing more data than it has, then garbage
+	bad := flvTag(av.TAG_VIDEO, 20, []byte{0x27, 0x01, 0, 0, 0})
+	pio.PutU24BE(bad[1:4], 40)
+	file.Write(bad)
+	file.Write([]byte{0x09, 0xff, 0x08, 0x12})
+	file.Write(flvTag(av.TAG_AUDIO, 30, audio))
+	file.Write(flvTag(av.TAG_AUDIO, 40, audio))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(file.Bytes())
+	}))
+	defer server.Close()
+
+	s, err := newPlaySource(context.Background(), server.URL+"/movie.flv")
+	at.Nil(err)
+	chunks := readAll(at, s)
+	s.Close(nil)
+
+	if at.Equal(3, len(chunks)) {
+		at.Equal(uint32(10), chunks[0].Timestamp)
+		at.Equal(uint32(30), chunks[1].Timestamp)
+		at.Equal(audio, chunks[1].Data)
+		at.Equal(uint32(40), chunks[2].Timestamp)
+	}
+	at.Equal(uint64(1), s.(resyncer).Resyncs())
+}
+
+type testVideoHeader struct{ key bool }
+
+func (h testVideoHeader) IsKeyFrame() bool       { return h.key }
+func (h testVideoHeader) IsSeq() bool            { return false }
+func (h testVideoHeader) CodecID() uint8         { return av.VIDEO_H264 }
+func (h testVideoHeader) CompositionTime() int32 { return 0 }
+
+func TestHlsSource(t *testing.T) {
+	at := assert.New(t)
+
+	sps := []byte{0x67, 0x42, 0x00, 0x1e, 0xab}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	// the muxer only pads key frames of more than one ts packet right
+	idr := append([]byte{0x65, 0x88, 0x84}, bytes.Repeat([]byte{0x21}, 400)...)
+	var annexb []byte
+	for _, nalu := range [][]byte{sps, pps, idr} {
+		annexb = append(annexb, 0, 0, 0, 1)
+		annexb = append(annexb, nalu...)
+	}
+	// two 44.1kHz stereo aac-lc frames
+	adts := []byte{
+		0xff, 0xf1, 0x50, 0x80, 0x01, 0x3f, 0xfc, 0x21, 0x19,
+		0xff, 0xf1, 0x50, 0x80, 0x01, 0x3f, 0xfc, 0x21, 0x1a,
+	}
+
+	m := ts.NewMuxer()
+	var segment bytes.Buffer
+	segment.Write(m.PAT())
+	segment.Write(m.PMT(av.SOUND_AAC, true))
+	at.Nil(m.Mux(&av.Packet{IsVideo: true, TimeStamp: 1000, Data: annexb, Header: testVideoHeader{true}}, &segment))
+	at.Nil(m.Mux(&av.Packet{TimeStamp: 1040, Data: adts}, &segment))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live/master.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\nmovie/index.m3u8\n"))
+	})
+	mux.HandleFunc("/live/movie/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:7\n#EXTINF:1.000,\n0.ts\n#EXT-X-ENDLIST\n"))
+	})
+	mux.HandleFunc("/live/movie/0.ts", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(segment.Bytes())
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	s, err := newPlaySource(context.Background(), server.URL+"/live/master.m3u8")
+	at.Nil(err)
+	at.IsType(&hlsSource{}, s)
+	chunks := readAll(at, s)
+	s.Close(nil)
+
+	if !at.Equal(5, len(chunks)) {
+		return
+	}
+	at.Equal(flv.NewAVCSeqHeader(sps, pps), chunks[0].Data)
+	at.Equal(flv.NewAVCNALU([][]byte{idr}, true, 0), chunks[1].Data)
+	at.Equal(uint32(0), chunks[1].Timestamp)
+	at.Equal(flv.NewAACSeqHeader([]byte{0x12, 0x10}), chunks[2].Data)
+	at.Equal(flv.NewAACRaw([]byte{0x21, 0x19}), chunks[3].Data)
+	at.Equal(uint32(40), chunks[3].Timestamp)
+	at.Equal(uint32(av.TAG_AUDIO), chunks[4].TypeID)
+	at.Equal(uint32(40+1024*1000/44100), chunks[4].Timestamp)
+
+	_, err = newPlaySource(context.Background(), server.URL+"/live/missing.m3u8")
+	at.NotNil(err)
+}
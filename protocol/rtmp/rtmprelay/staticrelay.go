@@ -1,6 +1,7 @@
 package rtmprelay
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -22,12 +23,16 @@ type StaticPush struct {
 var G_StaticPushMap = make(map[string](*StaticPush))
 var g_MapLock = new(sync.RWMutex)
 var G_PushUrlList []string = nil
+var g_PushUrlLock = new(sync.Mutex)
 
 var (
 	STATIC_RELAY_STOP_CTRL = "STATIC_RTMPRELAY_STOP"
 )
 
 func GetStaticPushList(appname string) ([]string, error) {
+	// every publisher reads it, the first ones at once
+	g_PushUrlLock.Lock()
+	defer g_PushUrlLock.Unlock()
 	if G_PushUrlList == nil {
 		// Do not unmarshel the config every time, lots of reflect works -gs
 		pushurlList, ok := configure.GetStaticPushUrlList(appname)
@@ -108,7 +113,9 @@ func (self *StaticPush) Start() error {
 	self.connectClient = core.NewConnClient()
 
 	log.Debugf("static publish server addr:%v starting....", self.RtmpUrl)
-	err := self.connectClient.Start(self.RtmpUrl, "publish")
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout())
+	defer cancel()
+	err := self.connectClient.StartContext(ctx, self.RtmpUrl, "publish")
 	if err != nil {
 		log.Debugf("connectClient.Start url=%v error", self.RtmpUrl)
 		return err
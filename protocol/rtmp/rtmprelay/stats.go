@@ -0,0 +1,101 @@
+package rtmprelay
+
+import (
+	"sync"
+	"time"
+)
+
+// rateInterval is how often the bitrate of a relay is sampled, a sample
+// older than two intervals means nothing flows
+const rateInterval = time.Second
+
+// Stats is the state of a relay, for operators checking it carries data
+type Stats struct {
+	Running     bool
+	Bytes       uint64 // of the media messages sent to the publish url
+	BitrateKbps uint64 // over the last second
+	Started     time.Time
+	Stopped     time.Time // zero while it runs or before its first start
+	Uptime      time.Duration
+	LastError   string
+	Resyncs     uint64 // corrupt input of the sources skipped
+}
+
+// relayStats counts what a relay sends, it is written by the sending
+// goroutine and read by the api
+type relayStats struct {
+	lock      sync.Mutex
+	bytes     uint64
+	started   time.Time
+	stopped   time.Time
+	lastError string
+	resyncs   uint64
+
+	sampleAt    time.Time
+	sampleBytes uint64
+	kbps        uint64
+}
+
+func (s *relayStats) start(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.bytes, s.sampleBytes, s.kbps = 0, 0, 0
+	s.started, s.sampleAt = now, now
+	s.stopped = time.Time{}
+}
+
+func (s *relayStats) stop(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped.IsZero() {
+		s.stopped = now
+	}
+}
+
+// count adds n bytes sent at now
+func (s *relayStats) count(n int, now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.bytes += uint64(n)
+	if elapsed := now.Sub(s.sampleAt); elapsed >= rateInterval {
+		s.kbps = (s.bytes - s.sampleBytes) * 8 / uint64(elapsed/time.Millisecond)
+		s.sampleAt, s.sampleBytes = now, s.bytes
+	}
+}
+
+// resync counts n skips of corrupt input, they add up across restarts
+func (s *relayStats) resync(n uint64) {
+	s.lock.Lock()
+	s.resyncs += n
+	s.lock.Unlock()
+}
+
+func (s *relayStats) fail(err error) {
+	s.lock.Lock()
+	s.lastError = err.Error()
+	s.lock.Unlock()
+}
+
+func (s *relayStats) get(running bool, now time.Time) Stats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ret := Stats{
+		Running:   running,
+		Bytes:     s.bytes,
+		Started:   s.started,
+		Stopped:   s.stopped,
+		LastError: s.lastError,
+		Resyncs:   s.resyncs,
+	}
+	if running && now.Sub(s.sampleAt) < 2*rateInterval {
+		ret.BitrateKbps = s.kbps
+	}
+	if !s.started.IsZero() {
+		end := now
+		if !s.stopped.IsZero() {
+			end = s.stopped
+		}
+		ret.Uptime = end.Sub(s.started)
+	}
+	return ret
+}
@@ -0,0 +1,45 @@
+package rtmprelay
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayStats(t *testing.T) {
+	at := assert.New(t)
+
+	s := &relayStats{}
+	at.Equal(Stats{}, s.get(false, time.Now()))
+
+	begin := time.Now()
+	s.start(begin)
+	// 250KB a second is 2000kbit/s
+	for ms := 100; ms <= 1000; ms += 100 {
+		s.count(25000, begin.Add(time.Duration(ms)*time.Millisecond))
+	}
+	got := s.get(true, begin.Add(1500*time.Millisecond))
+	at.Equal(uint64(250000), got.Bytes)
+	at.Equal(uint64(2000), got.BitrateKbps)
+	at.Equal(1500*time.Millisecond, got.Uptime)
+	at.Equal(begin, got.Started)
+
+	// nothing was sent for a while
+	at.Equal(uint64(0), s.get(true, begin.Add(5*time.Second)).BitrateKbps)
+
+	// the uptime stops with the relay, the error stays after a restart
+	s.fail(fmt.Errorf("play EOF"))
+	s.stop(begin.Add(6 * time.Second))
+	got = s.get(false, begin.Add(10*time.Second))
+	at.Equal(6*time.Second, got.Uptime)
+	at.Equal("play EOF", got.LastError)
+	at.False(got.Running)
+
+	s.start(begin.Add(20 * time.Second))
+	got = s.get(true, begin.Add(21*time.Second))
+	at.Equal(uint64(0), got.Bytes)
+	at.Equal(time.Second, got.Uptime)
+	at.Equal("play EOF", got.LastError)
+}
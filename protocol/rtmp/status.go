@@ -0,0 +1,42 @@
+package rtmp
+
+import "github.com/SpooderfyBot/live/protocol/rtmp/core"
+
+// the reasons the server closes a publisher or a player, sent to the
+// client as the code and description of an onStatus message
+var (
+	ErrKicked = &core.StatusError{Level: "error", Code: "NetStream.Play.Stop",
+		Description: "kicked"}
+	ErrWriteTimeout = &core.StatusError{Level: "error", Code: "NetStream.Play.Failed",
+		Description: "write timeout"}
+	ErrQuotaExceeded = &core.StatusError{Level: "error", Code: "NetStream.Play.Failed",
+		Description: "bandwidth quota exceeded"}
+	ErrUnpublished = &core.StatusError{Level: "status", Code: "NetStream.Play.UnpublishNotify",
+		Description: "publisher closed"}
+	ErrReplaced = &core.StatusError{Level: "error", Code: "NetStream.Publish.BadName",
+		Description: "replaced by a new publisher"}
+	ErrPromotedOut = &core.StatusError{Level: "error", Code: "NetStream.Publish.BadName",
+		Description: "replaced by a promoted stream"}
+	ErrPublishIdle = &core.StatusError{Level: "error", Code: "NetStream.Publish.Idle",
+		Description: "read timeout"}
+	ErrRoomDeleted = &core.StatusError{Level: "error", Code: "NetStream.Publish.Denied",
+		Description: "room deleted"}
+	ErrAliasPublish = &core.StatusError{Level: "error", Code: "NetStream.Publish.BadName",
+		Description: "the room is an alias of another, publish to that one"}
+	ErrDuplicatePublish = &core.StatusError{Level: "error", Code: "NetStream.Publish.BadName",
+		Description: "the room already has a publisher"}
+	ErrMaxStreams = &core.StatusError{Level: "error", Code: "NetStream.Publish.Rejected",
+		Description: "the server reached its max streams"}
+	ErrMaxConnections = &core.StatusError{Level: "error", Code: "NetStream.Play.Failed",
+		Description: "the server reached its max connections"}
+	ErrDrainingPublish = &core.StatusError{Level: "error", Code: "NetStream.Publish.Rejected",
+		Description: "server draining"}
+	ErrDrainingPlay = &core.StatusError{Level: "error", Code: "NetStream.Play.Failed",
+		Description: "server draining"}
+)
+
+// bitrateError is the close reason of a publisher above its bitrate limit
+func bitrateError(err error) *core.StatusError {
+	return &core.StatusError{Level: "error", Code: "NetStream.Publish.Rejected",
+		Description: err.Error()}
+}
@@ -1,13 +1,15 @@
 package rtmp
 
 import (
-	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
 	"github.com/SpooderfyBot/live/protocol/rtmp/cache"
+	"github.com/SpooderfyBot/live/protocol/rtmp/events"
 	"github.com/SpooderfyBot/live/protocol/rtmp/rtmprelay"
 
 	log "github.com/sirupsen/logrus"
@@ -18,13 +20,24 @@ var (
 )
 
 type RtmpStream struct {
-	streams *sync.Map // key
+	streams    *sync.Map    // key
+	maxPlayers *sync.Map    // key -> per stream override of rtmp.max_players_per_stream
+	streamsMax atomic.Value // int override of rtmp.max_streams, negative when removed
+	quotas     *sync.Map    // key -> *roomQuota
+	observers  *publishObservers
+	aliasLock  sync.RWMutex
+	aliases    map[string]string // alias key -> key of the stream it plays
 }
 
 func NewRtmpStream() *RtmpStream {
 	ret := &RtmpStream{
-		streams: &sync.Map{},
+		streams:    &sync.Map{},
+		maxPlayers: &sync.Map{},
+		quotas:     &sync.Map{},
+		observers:  &publishObservers{},
+		aliases:    make(map[string]string),
 	}
+	ret.loadAliases()
 	go ret.CheckAlive()
 	return ret
 }
@@ -35,7 +48,19 @@ func (rs *RtmpStream) HandleReader(r av.ReadCloser) {
 
 	var stream *Stream
 	i, ok := rs.streams.Load(info.Key)
-	if stream, ok = i.(*Stream); ok {
+	if stream, ok = i.(*Stream); ok && stream.info.Key != info.Key {
+		// the room was bound to a promoted stream, it takes its players back
+		log.Infof("[%v] publisher is back, leaving %v", info.Key, stream.info.Key)
+		ns := NewStream()
+		ns.info = info
+		stream.takeBack(info.Key, ns)
+		stream = ns
+		rs.streams.Store(info.Key, ns)
+	} else if ok && stream.resume() {
+		// back within the grace window, the players never left
+		log.Infof("[%v] publisher came back", info.Key)
+		stream.notifyResumed()
+	} else if ok {
 		stream.TransStop()
 		id := stream.ID()
 		if id != EmptyID && id != info.UID {
@@ -46,27 +71,38 @@ func (rs *RtmpStream) HandleReader(r av.ReadCloser) {
 		}
 	} else {
 		stream = NewStream()
-		rs.streams.Store(info.Key, stream)
+		// set before it is stored, the api reads it from the map
 		stream.info = info
+		rs.streams.Store(info.Key, stream)
 	}
 
+	stream.unpublished = rs.notifyUnpublish
 	stream.AddReader(r)
+	events.Emit(info.Key, events.PublishStart, info.URL)
+	rs.notifyPublish(info)
 }
 
 func (rs *RtmpStream) HandleWriter(w av.WriteCloser) {
 	info := w.Info()
 	log.Debugf("HandleWriter: info[%v]", info)
 
+	// the players of an alias join the stream of its target
+	key := rs.playKey(info.Key)
 	var s *Stream
-	item, ok := rs.streams.Load(info.Key)
+	item, ok := rs.streams.Load(key)
 	if !ok {
 		log.Debugf("HandleWriter: not found create new info[%v]", info)
 		s = NewStream()
-		rs.streams.Store(info.Key, s)
+		rs.streams.Store(key, s)
 		s.info = info
+		s.info.Key = key
 	} else {
 		s = item.(*Stream)
+		if v, ok := w.(*VirWriter); ok {
+			v.quota = rs.roomQuota(key)
+		}
 		s.AddWriter(w)
+		events.Emit(key, events.PlayerJoin, info.URL)
 	}
 }
 
@@ -84,6 +120,40 @@ func (rs *RtmpStream) GetStream(key string) (value *Stream, ok bool) {
 	}
 }
 
+// SetMaxPlayers overrides rtmp.max_players_per_stream for key, 0 means
+// unlimited and a negative value removes the override
+func (rs *RtmpStream) SetMaxPlayers(key string, n int) {
+	if n < 0 {
+		rs.maxPlayers.Delete(key)
+		return
+	}
+	rs.maxPlayers.Store(key, n)
+}
+
+func (rs *RtmpStream) MaxPlayers(key string) int {
+	if n, ok := rs.maxPlayers.Load(key); ok {
+		return n.(int)
+	}
+	return configure.Config.GetInt("rtmp.max_players_per_stream")
+}
+
+// CanAddPlayer tells whether key is below its max players and its quota
+func (rs *RtmpStream) CanAddPlayer(key string) bool {
+	key = rs.playKey(key)
+	if q, ok := rs.quotas.Load(key); ok && q.(*roomQuota).exceeded() {
+		return false
+	}
+	max := rs.MaxPlayers(key)
+	if max <= 0 {
+		return true
+	}
+	s, ok := rs.GetStream(key)
+	if !ok {
+		return true
+	}
+	return s.PlayerCount() < max
+}
+
 func (rs *RtmpStream) CheckAlive() {
 	for {
 		<-time.After(5 * time.Second)
@@ -98,16 +168,32 @@ func (rs *RtmpStream) CheckAlive() {
 }
 
 type Stream struct {
-	isStart bool
+	isStart int32 // 1 while TransStart runs, atomic
 	cache   *cache.Cache
-	r       av.ReadCloser
-	ws      *sync.Map
-	info    av.Info
+	// r is the publisher, set by AddReader while the api reads it
+	rLock sync.RWMutex
+	r     av.ReadCloser
+	ws    *sync.Map
+	info  av.Info
+	// rebaser is shared by the streams a room goes through on reconnects
+	rebaser *rebaser
+	// timed holds the metadata injected until the next packet is sent
+	timed chan av.Packet
+	// grace runs out rtmp.publish_grace after the publisher dropped
+	graceLock sync.Mutex
+	grace     *time.Timer
+	// unpublished is told of the publisher going away
+	unpublished func(av.Info)
+	// flushGop is set by FlushGop for TransStart to empty the cache, atomic
+	flushGop int32
 }
 
 type PackWriterCloser struct {
 	init bool
-	w    av.WriteCloser
+	// awaitKey drops the video of a writer that joined with no gop cached
+	// until the next key frame
+	awaitKey bool
+	w        av.WriteCloser
 }
 
 func (p *PackWriterCloser) GetWriter() av.WriteCloser {
@@ -116,19 +202,27 @@ func (p *PackWriterCloser) GetWriter() av.WriteCloser {
 
 func NewStream() *Stream {
 	return &Stream{
-		cache: cache.NewCache(),
-		ws:    &sync.Map{},
+		cache:   cache.NewCache(),
+		ws:      &sync.Map{},
+		rebaser: &rebaser{},
+		timed:   make(chan av.Packet, maxTimedMetadata),
 	}
 }
 
+func (s *Stream) started() bool {
+	return atomic.LoadInt32(&s.isStart) == 1
+}
+
 func (s *Stream) ID() string {
-	if s.r != nil {
-		return s.r.Info().UID
+	if r := s.GetReader(); r != nil {
+		return r.Info().UID
 	}
 	return EmptyID
 }
 
 func (s *Stream) GetReader() av.ReadCloser {
+	s.rLock.RLock()
+	defer s.rLock.RUnlock()
 	return s.r
 }
 
@@ -136,19 +230,65 @@ func (s *Stream) GetWs() *sync.Map {
 	return s.ws
 }
 
+// MediaInfo describes the codecs and the last key frame of the publisher
+func (s *Stream) MediaInfo() cache.MediaInfo {
+	return s.cache.MediaInfo()
+}
+
+// PlayerCount is the number of viewers, internal writers are not counted
+func (s *Stream) PlayerCount() (n int) {
+	s.ws.Range(func(key, val interface{}) bool {
+		if p, ok := val.(*PackWriterCloser).w.(av.Player); ok && p.IsPlayer() {
+			n++
+		}
+		return true
+	})
+	return
+}
+
+// KickPlayer closes and drops the first player whose remote address
+// or uid is id, the stream and its other players keep going
+func (s *Stream) KickPlayer(id string) (w av.WriteCloser, ok bool) {
+	s.ws.Range(func(key, val interface{}) bool {
+		v := val.(*PackWriterCloser).w
+		if p, isPlayer := v.(av.Player); !isPlayer || !p.IsPlayer() {
+			return true
+		}
+		addr := ""
+		if r, hasAddr := v.(RemoteAddrer); hasAddr {
+			addr = r.RemoteAddr()
+		}
+		if v.Info().UID != id && (addr == "" || addr != id) {
+			return true
+		}
+		s.ws.Delete(key)
+		v.Close(ErrKicked)
+		events.Emit(s.info.Key, events.PlayerLeave, "kicked")
+		w, ok = v, true
+		return false
+	})
+	return
+}
+
+// Copy moves the players of s to dst, the timestamps are rebased on the
+// read side so they carry on without a per writer base timestamp
 func (s *Stream) Copy(dst *Stream) {
 	dst.info = s.info
+	dst.rebaser = s.rebaser
+	dst.timed = s.timed
 	s.ws.Range(func(key, val interface{}) bool {
 		v := val.(*PackWriterCloser)
 		s.ws.Delete(key)
-		v.w.CalcBaseTimestamp()
 		dst.AddWriter(v.w)
 		return true
 	})
 }
 
 func (s *Stream) AddReader(r av.ReadCloser) {
+	s.rLock.Lock()
 	s.r = r
+	s.rLock.Unlock()
+	s.rebaser.reset()
 	go s.TransStart()
 }
 
@@ -191,8 +331,10 @@ func (s *Stream) StartStaticPush() {
 		if staticpushObj != nil {
 			if err := staticpushObj.Start(); err != nil {
 				log.Debugf("StartStaticPush: staticpushObj.Start %s error=%v", pushurl, err)
+				events.Emit(key, events.RelayFail, err.Error())
 			} else {
 				log.Debugf("StartStaticPush: staticpushObj.Start %s ok", pushurl)
+				events.Emit(key, events.RelayStart, pushurl)
 			}
 		} else {
 			log.Debugf("StartStaticPush GetStaticPushObject %s error", pushurl)
@@ -232,6 +374,7 @@ func (s *Stream) StopStaticPush() {
 		if (staticpushObj != nil) && (err == nil) {
 			staticpushObj.Stop()
 			rtmprelay.ReleaseStaticPushObject(pushurl)
+			events.Emit(key, events.RelayStop, pushurl)
 			log.Debugf("StopStaticPush: staticpushObj.Stop %s ", pushurl)
 		} else {
 			log.Debugf("StopStaticPush GetStaticPushObject %s error", pushurl)
@@ -317,30 +460,53 @@ func (s *Stream) SendStaticPush(packet av.Packet) {
 }
 
 func (s *Stream) TransStart() {
-	s.isStart = true
+	atomic.StoreInt32(&s.isStart, 1)
 	var p av.Packet
+	r := s.GetReader()
+	publisher, unpublished := r.Info(), s.unpublished
+	if unpublished != nil {
+		// however the publisher went away
+		defer unpublished(publisher)
+	}
 
 	log.Debugf("TransStart: %v", s.info)
 
 	s.StartStaticPush()
 
 	for {
-		if !s.isStart {
+		if !s.started() {
 			s.closeInter()
 			return
 		}
-		err := s.r.Read(&p)
+		err := r.Read(&p)
 		if err != nil {
+			events.Emit(s.info.Key, events.PublishEnd, err.Error())
+			// its connection counts against rtmp.max_connections until closed
+			r.Close(err)
+			// isStart is still set when the publisher went away by itself
+			if grace := publishGrace(); grace > 0 && atomic.CompareAndSwapInt32(&s.isStart, 1, 0) {
+				s.StopStaticPush()
+				s.holdForReconnect(grace)
+				return
+			}
 			s.closeInter()
-			s.isStart = false
+			atomic.StoreInt32(&s.isStart, 0)
 			return
 		}
+		s.rebaser.rebase(&p)
+		s.sendTimed(p.TimeStamp)
 
 		if s.IsSendStaticPush() {
 			s.SendStaticPush(p)
 		}
 
-		s.cache.Write(p)
+		if atomic.SwapInt32(&s.flushGop, 0) == 1 {
+			s.cache.FlushGop()
+			events.Emit(s.info.Key, events.GopFlush, "gop cache flushed")
+		}
+		if changed := s.cache.Write(p); changed != "" {
+			events.Emit(s.info.Key, events.CodecChange, changed+" sequence header changed")
+		}
 
 		s.ws.Range(func(key, val interface{}) bool {
 			v := val.(*PackWriterCloser)
@@ -349,16 +515,27 @@ func (s *Stream) TransStart() {
 				if err = s.cache.Send(v.w); err != nil {
 					log.Debugf("[%s] send cache packet error: %v, remove", v.w.Info(), err)
 					s.ws.Delete(key)
+					events.Emit(s.info.Key, events.PlayerLeave, err.Error())
 					return true
 				}
 				v.init = true
+				v.awaitKey = !s.cache.HasGop()
 			} else {
+				if v.awaitKey && p.IsVideo {
+					if vh, ok := p.Header.(av.VideoPacketHeader); ok && !vh.IsSeq() {
+						if !vh.IsKeyFrame() {
+							return true
+						}
+						v.awaitKey = false
+					}
+				}
 				newPacket := p
 				//writeType := reflect.TypeOf(v.w)
 				//log.Debugf("w.Write: type=%v, %v", writeType, v.w.Info())
 				if err = v.w.Write(&newPacket); err != nil {
 					log.Debugf("[%s] write packet error: %v, remove", v.w.Info(), err)
 					s.ws.Delete(key)
+					events.Emit(s.info.Key, events.PlayerLeave, err.Error())
 				}
 			}
 			return true
@@ -367,21 +544,32 @@ func (s *Stream) TransStart() {
 }
 
 func (s *Stream) TransStop() {
+	s.TransStopReason(ErrReplaced)
+}
+
+// TransStopReason is TransStop telling the publisher why it is closed
+func (s *Stream) TransStopReason(reason error) {
 	log.Debugf("TransStop: %s", s.info.Key)
 
-	if s.isStart && s.r != nil {
-		s.r.Close(fmt.Errorf("stop old"))
+	// a stopped stream doesn't wait for its publisher anymore
+	s.resume()
+	// cleared first so TransStart doesn't take the close for a drop
+	if r := s.GetReader(); atomic.SwapInt32(&s.isStart, 0) == 1 && r != nil {
+		r.Close(reason)
 	}
-
-	s.isStart = false
 }
 
 func (s *Stream) CheckAlive() (n int) {
-	if s.r != nil && s.isStart {
-		if s.r.Alive() {
+	if r := s.GetReader(); r != nil && s.started() {
+		if r.Alive() {
 			n++
 		} else {
-			s.r.Close(fmt.Errorf("read timeout"))
+			// no packet from the publisher within read_timeout, the encoder
+			// is most likely gone without closing the connection
+			log.Infof("[%v] publisher idle, closing stream", r.Info())
+			events.Emit(s.info.Key, events.PublishIdle, "read timeout")
+			s.TransStopReason(ErrPublishIdle)
+			s.CloseAndComplete()
 		}
 	}
 
@@ -389,10 +577,11 @@ func (s *Stream) CheckAlive() (n int) {
 		v := val.(*PackWriterCloser)
 		if v.w != nil {
 			//Alive from RWBaser, check last frame now - timestamp, if > timeout then Remove it
-			if !v.w.Alive() && s.isStart {
+			if !v.w.Alive() && s.started() {
 				log.Infof("write timeout remove")
 				s.ws.Delete(key)
-				v.w.Close(fmt.Errorf("write timeout"))
+				v.w.Close(ErrWriteTimeout)
+				events.Emit(s.info.Key, events.PlayerLeave, "write timeout")
 				return true
 			}
 			n++
@@ -404,18 +593,19 @@ func (s *Stream) CheckAlive() (n int) {
 }
 
 func (s *Stream) closeInter() {
-	if s.r != nil {
+	if r := s.GetReader(); r != nil {
 		s.StopStaticPush()
-		log.Debugf("[%v] publisher closed", s.r.Info())
+		log.Debugf("[%v] publisher closed", r.Info())
 	}
 
 	s.ws.Range(func(key, val interface{}) bool {
 		v := val.(*PackWriterCloser)
 		if v.w != nil {
-			v.w.Close(fmt.Errorf("closed"))
+			v.w.Close(ErrUnpublished)
 			if v.w.Info().IsInterval() {
 				s.ws.Delete(key)
 				log.Debugf("[%v] player closed and remove\n", v.w.Info())
+				events.Emit(s.info.Key, events.PlayerLeave, "publisher closed")
 			}
 		}
 		return true
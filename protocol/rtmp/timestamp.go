@@ -0,0 +1,53 @@
+package rtmp
+
+import (
+	"sync"
+
+	"github.com/SpooderfyBot/live/av"
+)
+
+// rebaseGap is put between the last timestamp of a publisher and the
+// first one of the publisher taking over, about a frame at 25fps
+const rebaseGap = 40
+
+// rebaser keeps the timestamps of a room monotonic across publisher
+// reconnects. A reconnecting encoder usually starts again from 0, each
+// new publisher is offset to carry on where the last one stopped.
+type rebaser struct {
+	lock      sync.Mutex
+	offset    int64
+	last      uint32
+	delivered bool
+	started   bool
+}
+
+// reset is called when a new publisher takes over the room
+func (r *rebaser) reset() {
+	r.lock.Lock()
+	r.started = false
+	r.lock.Unlock()
+}
+
+// rebase offsets the timestamp of p, read from the current publisher
+func (r *rebaser) rebase(p *av.Packet) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.started {
+		r.started = true
+		r.offset = 0
+		if r.delivered {
+			r.offset = int64(r.last) + rebaseGap - int64(p.TimeStamp)
+		}
+	}
+
+	ts := int64(p.TimeStamp) + r.offset
+	if ts < 0 {
+		ts = 0
+	}
+	p.TimeStamp = uint32(ts)
+	if !r.delivered || p.TimeStamp > r.last {
+		r.last = p.TimeStamp
+	}
+	r.delivered = true
+}
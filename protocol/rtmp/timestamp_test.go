@@ -0,0 +1,137 @@
+package rtmp
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebaser(t *testing.T) {
+	at := assert.New(t)
+
+	r := &rebaser{}
+	var out []uint32
+	feed := func(timestamps ...uint32) {
+		for _, ts := range timestamps {
+			p := &av.Packet{TimeStamp: ts}
+			r.rebase(p)
+			out = append(out, p.TimeStamp)
+		}
+	}
+
+	// the first publisher keeps its own timestamps
+	feed(0, 40, 80, 120)
+	// the encoder reconnects and starts over from 0
+	r.reset()
+	feed(0, 0, 40, 80)
+	// and again from an arbitrary timestamp
+	r.reset()
+	feed(90000, 90040)
+
+	at.Equal([]uint32{0, 40, 80, 120, 160, 160, 200, 240, 280, 320}, out)
+	for i := 1; i < len(out); i++ {
+		at.True(out[i] >= out[i-1], "timestamp %d went back", i)
+	}
+}
+
+// chanReader is a publisher whose packets are fed through a channel
+type chanReader struct {
+	av.RWBaser
+	uid       string
+	packets   chan av.Packet
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newChanReader(uid string) *chanReader {
+	return &chanReader{
+		RWBaser: av.NewRWBaser(0),
+		uid:     uid,
+		packets: make(chan av.Packet),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (r *chanReader) Info() av.Info {
+	return av.Info{Key: "live/rebase", URL: "rtmp://127.0.0.1/live/rebase", UID: r.uid}
+}
+
+func (r *chanReader) Alive() bool { return true }
+
+func (r *chanReader) Close(error) {
+	r.closeOnce.Do(func() { close(r.closed) })
+}
+
+func (r *chanReader) Read(p *av.Packet) error {
+	select {
+	case *p = <-r.packets:
+		return nil
+	case <-r.closed:
+		return fmt.Errorf("closed")
+	}
+}
+
+// recordWriter is a player keeping the timestamps it was sent
+type recordWriter struct {
+	av.RWBaser
+	lock       sync.Mutex
+	timestamps []uint32
+}
+
+func (w *recordWriter) Info() av.Info {
+	return av.Info{Key: "live/rebase", URL: "rtmp://127.0.0.1/live/rebase", UID: "player"}
+}
+
+func (w *recordWriter) Alive() bool    { return true }
+func (w *recordWriter) Close(error)    {}
+func (w *recordWriter) IsPlayer() bool { return true }
+
+func (w *recordWriter) Write(p *av.Packet) error {
+	w.lock.Lock()
+	w.timestamps = append(w.timestamps, p.TimeStamp)
+	w.lock.Unlock()
+	return nil
+}
+
+func (w *recordWriter) recorded() []uint32 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]uint32(nil), w.timestamps...)
+}
+
+func TestReconnectKeepsTimestampsMonotonic(t *testing.T) {
+	at := assert.New(t)
+
+	rs := NewRtmpStream()
+	first := newChanReader("first")
+	rs.HandleReader(first)
+	player := &recordWriter{RWBaser: av.NewRWBaser(0)}
+	rs.HandleWriter(player)
+
+	for ts := uint32(1000); ts <= 1400; ts += 40 {
+		first.packets <- av.Packet{IsAudio: true, TimeStamp: ts}
+	}
+
+	// the encoder reconnects while its old connection is still up
+	second := newChanReader("second")
+	rs.HandleReader(second)
+	for ts := uint32(0); ts <= 400; ts += 40 {
+		second.packets <- av.Packet{IsAudio: true, TimeStamp: ts}
+	}
+	at.True(waitFor(func() bool {
+		got := player.recorded()
+		return len(got) > 0 && got[len(got)-1] == 1840
+	}))
+	second.Close(nil)
+
+	// the second publisher carries on 40ms after the first one, ending on 1400+40+400
+	got := player.recorded()
+	at.Contains(got, uint32(1400))
+	for i := 1; i < len(got); i++ {
+		at.True(got[i] >= got[i-1], "timestamp went back from %d to %d", got[i-1], got[i])
+	}
+}
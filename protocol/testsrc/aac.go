@@ -0,0 +1,15 @@
+package testsrc
+
+const (
+	SampleRate = 44100
+	// samples in an aac frame
+	frameSamples = 1024
+)
+
+// audioConfig is the AudioSpecificConfig of mono aac lc at 44.1kHz
+var audioConfig = []byte{0x12, 0x08}
+
+// silence is a raw aac frame of one channel without spectral data: a
+// single_channel_element with a global gain of 0 and max_sfb of 0, then
+// the end element
+var silence = []byte{0x00, 0x00, 0x00, 0x07}
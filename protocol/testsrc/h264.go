@@ -0,0 +1,200 @@
+package testsrc
+
+// The pattern is coded without an encoder: the key frames are made of
+// I_PCM macroblocks carrying their samples as is, and the frames between
+// them skip every macroblock, repeating the key frame.
+
+const (
+	widthMbs  = 16
+	heightMbs = 9
+	Width     = widthMbs * 16
+	Height    = heightMbs * 16
+	FrameRate = 25
+
+	// frame_num is coded on log2_max_frame_num_minus4 + 4 bits
+	maxFrameNum = 16
+)
+
+// the color bars, as y, cb, cr
+var bars = [][3]byte{
+	{235, 128, 128}, // white
+	{210, 16, 146},  // yellow
+	{170, 166, 16},  // cyan
+	{145, 54, 34},   // green
+	{106, 202, 222}, // magenta
+	{81, 90, 240},   // red
+	{41, 240, 110},  // blue
+	{16, 128, 128},  // black
+}
+
+// bitWriter writes the exp-golomb coded fields of an rbsp
+type bitWriter struct {
+	b    []byte
+	bits int // used in the last byte, 0 when it is full
+}
+
+func (w *bitWriter) bit(v uint32) {
+	if w.bits == 0 {
+		w.b = append(w.b, 0)
+	}
+	if v != 0 {
+		w.b[len(w.b)-1] |= 0x80 >> uint(w.bits)
+	}
+	w.bits = (w.bits + 1) % 8
+}
+
+func (w *bitWriter) u(n int, v uint32) {
+	for i := n - 1; i >= 0; i-- {
+		w.bit(v >> uint(i) & 1)
+	}
+}
+
+func (w *bitWriter) ue(v uint32) {
+	n := 0
+	for x := v + 1; x > 1; x >>= 1 {
+		n++
+	}
+	w.u(n, 0)
+	w.u(n+1, v+1)
+}
+
+func (w *bitWriter) se(v int32) {
+	if v > 0 {
+		w.ue(uint32(2*v - 1))
+	} else {
+		w.ue(uint32(-2 * v))
+	}
+}
+
+// align pads the last byte with zeros
+func (w *bitWriter) align() {
+	for w.bits != 0 {
+		w.bit(0)
+	}
+}
+
+// trailing ends the rbsp with its stop bit
+func (w *bitWriter) trailing() {
+	w.bit(1)
+	w.align()
+}
+
+// nalu is the rbsp of w behind header, with emulation prevention bytes
+// keeping it from holding a start code
+func (w *bitWriter) nalu(header byte) []byte {
+	ret := make([]byte, 1, len(w.b)+len(w.b)/64+1)
+	ret[0] = header
+	zeros := 0
+	for _, c := range w.b {
+		if zeros >= 2 && c <= 3 {
+			ret = append(ret, 3)
+			zeros = 0
+		}
+		ret = append(ret, c)
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return ret
+}
+
+// sps is a constrained baseline sequence parameter set of the pattern
+func sps() []byte {
+	w := &bitWriter{}
+	w.u(8, 66)           // profile_idc, baseline
+	w.u(8, 0xc0)         // constraint_set0_flag and constraint_set1_flag
+	w.u(8, 30)           // level_idc
+	w.ue(0)              // seq_parameter_set_id
+	w.ue(0)              // log2_max_frame_num_minus4
+	w.ue(2)              // pic_order_cnt_type, output in decoding order
+	w.ue(1)              // max_num_ref_frames
+	w.u(1, 0)            // gaps_in_frame_num_value_allowed_flag
+	w.ue(widthMbs - 1)   // pic_width_in_mbs_minus1
+	w.ue(heightMbs - 1)  // pic_height_in_map_units_minus1
+	w.u(1, 1)            // frame_mbs_only_flag
+	w.u(1, 1)            // direct_8x8_inference_flag
+	w.u(1, 0)            // frame_cropping_flag
+	w.u(1, 1)            // vui_parameters_present_flag
+	w.u(4, 0)            // aspect ratio, overscan, video signal and chroma loc flags
+	w.u(1, 1)            // timing_info_present_flag
+	w.u(32, 1)           // num_units_in_tick
+	w.u(32, 2*FrameRate) // time_scale
+	w.u(1, 1)            // fixed_frame_rate_flag
+	w.u(4, 0)            // hrd, pic_struct and bitstream restriction flags
+	w.trailing()
+	return w.nalu(0x67)
+}
+
+// pps is the picture parameter set of the pattern, cavlc coded
+func pps() []byte {
+	w := &bitWriter{}
+	w.ue(0)   // pic_parameter_set_id
+	w.ue(0)   // seq_parameter_set_id
+	w.u(1, 0) // entropy_coding_mode_flag
+	w.u(1, 0) // bottom_field_pic_order_in_frame_present_flag
+	w.ue(0)   // num_slice_groups_minus1
+	w.ue(0)   // num_ref_idx_l0_default_active_minus1
+	w.ue(0)   // num_ref_idx_l1_default_active_minus1
+	w.u(1, 0) // weighted_pred_flag
+	w.u(2, 0) // weighted_bipred_idc
+	w.se(0)   // pic_init_qp_minus26
+	w.se(0)   // pic_init_qs_minus26
+	w.se(0)   // chroma_qp_index_offset
+	w.u(1, 0) // deblocking_filter_control_present_flag
+	w.u(1, 0) // constrained_intra_pred_flag
+	w.u(1, 0) // redundant_pic_cnt_present_flag
+	w.trailing()
+	return w.nalu(0x68)
+}
+
+// keyFrame is an idr slice of the color bars moved shift bars to the
+// left, idrID must differ from the one of the previous key frame
+func keyFrame(shift int, idrID uint32) []byte {
+	w := &bitWriter{}
+	w.ue(0)     // first_mb_in_slice
+	w.ue(7)     // slice_type, I for the whole picture
+	w.ue(0)     // pic_parameter_set_id
+	w.u(4, 0)   // frame_num
+	w.ue(idrID) // idr_pic_id
+	w.u(1, 0)   // no_output_of_prior_pics_flag
+	w.u(1, 0)   // long_term_reference_flag
+	w.se(0)     // slice_qp_delta
+	barMbs := widthMbs / len(bars)
+	for y := 0; y < heightMbs; y++ {
+		for x := 0; x < widthMbs; x++ {
+			c := bars[(x/barMbs+shift)%len(bars)]
+			w.ue(25) // mb_type, I_PCM
+			w.align()
+			for i := 0; i < 16*16; i++ {
+				w.b = append(w.b, c[0])
+			}
+			for i := 0; i < 8*8; i++ {
+				w.b = append(w.b, c[1])
+			}
+			for i := 0; i < 8*8; i++ {
+				w.b = append(w.b, c[2])
+			}
+		}
+	}
+	w.trailing()
+	return w.nalu(0x65)
+}
+
+// interFrame is a p slice skipping every macroblock, frameNum counts the
+// frames since the key frame
+func interFrame(frameNum uint32) []byte {
+	w := &bitWriter{}
+	w.ue(0)                      // first_mb_in_slice
+	w.ue(5)                      // slice_type, P for the whole picture
+	w.ue(0)                      // pic_parameter_set_id
+	w.u(4, frameNum%maxFrameNum) // frame_num
+	w.u(1, 0)                    // num_ref_idx_active_override_flag
+	w.u(1, 0)                    // ref_pic_list_modification_flag_l0
+	w.u(1, 0)                    // adaptive_ref_pic_marking_mode_flag
+	w.se(0)                      // slice_qp_delta
+	w.ue(widthMbs * heightMbs)   // mb_skip_run
+	w.trailing()
+	return w.nalu(0x41)
+}
@@ -0,0 +1,125 @@
+// Package testsrc publishes a synthetic test pattern, color bars and
+// silence, for checking the playback of a room without an encoder.
+package testsrc
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/utils/uid"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the bars move once per gop
+const gopFrames = FrameRate
+
+// Reader is a publisher of the test pattern for duration, packets are read
+// in real time. It ends with io.EOF once duration elapsed or it is closed.
+type Reader struct {
+	Uid string
+	av.RWBaser
+	key, url  string
+	duration  time.Duration
+	demuxer   *flv.Demuxer
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// only used by Read
+	start        time.Time
+	headers      int
+	video, audio int // frames sent
+	idrID        uint32
+}
+
+// NewReader publishes to key, app/room, for duration
+func NewReader(key, url string, duration time.Duration) *Reader {
+	return &Reader{
+		Uid:      uid.NewId(),
+		RWBaser:  av.NewRWBaser(time.Minute),
+		key:      key,
+		url:      url,
+		duration: duration,
+		demuxer:  flv.NewDemuxer(),
+		closed:   make(chan struct{}),
+	}
+}
+
+// next is the packet following the ones read, with its timestamp in ms
+func (r *Reader) next() *av.Packet {
+	switch r.headers {
+	case 0:
+		r.headers++
+		return &av.Packet{IsVideo: true, Data: flv.NewAVCSeqHeader(sps(), pps())}
+	case 1:
+		r.headers++
+		return &av.Packet{IsAudio: true, Data: flv.NewAACSeqHeader(audioConfig)}
+	}
+
+	videoTs := uint32(r.video * 1000 / FrameRate)
+	audioTs := uint32(r.audio * frameSamples * 1000 / SampleRate)
+	if audioTs < videoTs {
+		r.audio++
+		return &av.Packet{IsAudio: true, TimeStamp: audioTs, Data: flv.NewAACRaw(silence)}
+	}
+
+	n := r.video % gopFrames
+	var data []byte
+	if n == 0 {
+		data = flv.NewAVCNALU([][]byte{keyFrame(r.video/gopFrames, r.idrID)}, true, 0)
+		r.idrID ^= 1
+	} else {
+		data = flv.NewAVCNALU([][]byte{interFrame(uint32(n))}, false, 0)
+	}
+	r.video++
+	return &av.Packet{IsVideo: true, TimeStamp: videoTs, Data: data}
+}
+
+func (r *Reader) Read(p *av.Packet) error {
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+	pkt := r.next()
+	at := time.Duration(pkt.TimeStamp) * time.Millisecond
+	if at >= r.duration {
+		r.Close(io.EOF)
+		return io.EOF
+	}
+
+	wait := time.NewTimer(time.Until(r.start.Add(at)))
+	defer wait.Stop()
+	select {
+	case <-wait.C:
+	case <-r.closed:
+		return io.EOF
+	}
+	*p = *pkt
+	r.SetPreTime()
+	return r.demuxer.DemuxH(p)
+}
+
+func (r *Reader) Info() (ret av.Info) {
+	ret.UID = r.Uid
+	ret.URL = r.url
+	ret.Key = r.key
+	return
+}
+
+func (r *Reader) Alive() bool {
+	select {
+	case <-r.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+func (r *Reader) Close(err error) {
+	r.closeOnce.Do(func() {
+		log.Debug("test pattern ", r.Info(), " closed: ", err)
+		close(r.closed)
+	})
+}
@@ -0,0 +1,87 @@
+package testsrc
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/parser/h264"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReader(t *testing.T) {
+	at := assert.New(t)
+
+	r := NewReader("live/test", "test://127.0.0.1/live/test", 300*time.Millisecond)
+	at.Equal("live/test", r.Info().Key)
+	var packets []av.Packet
+	for {
+		var p av.Packet
+		err := r.Read(&p)
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+		packets = append(packets, p)
+	}
+	at.False(r.Alive())
+
+	// the sequence headers first
+	at.True(len(packets) > 2)
+	vh, ok := packets[0].Header.(av.VideoPacketHeader)
+	at.True(ok && vh.IsSeq())
+	sps, err := h264.ParseAVCConfig(packets[0].Data[5:])
+	at.Nil(err)
+	at.Equal(Width, sps.Width)
+	at.Equal(Height, sps.Height)
+	at.Equal(float64(FrameRate), sps.FrameRate)
+	ah, ok := packets[1].Header.(av.AudioPacketHeader)
+	at.True(ok && ah.AACPacketType() == av.AAC_SEQHDR)
+
+	// then a key frame and the frames following it in timestamp order
+	vh, ok = packets[2].Header.(av.VideoPacketHeader)
+	at.True(ok && vh.IsKeyFrame() && !vh.IsSeq())
+	video, audio := 0, 0
+	for i, p := range packets[2:] {
+		if i > 0 {
+			at.True(p.TimeStamp >= packets[i+1].TimeStamp)
+		}
+		at.True(p.TimeStamp < 300)
+		if p.IsVideo {
+			video++
+		} else {
+			audio++
+		}
+	}
+	at.Equal(8, video)
+	at.Equal(13, audio)
+}
+
+func TestReaderClose(t *testing.T) {
+	at := assert.New(t)
+
+	r := NewReader("live/test", "test://127.0.0.1/live/test", time.Hour)
+	var p av.Packet
+	for i := 0; i < 3; i++ {
+		at.Nil(r.Read(&p))
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		r.Close(nil)
+	}()
+	for {
+		if err := r.Read(&p); err != nil {
+			at.Equal(io.EOF, err)
+			break
+		}
+	}
+}
+
+func TestEmulationPrevention(t *testing.T) {
+	at := assert.New(t)
+
+	w := &bitWriter{b: []byte{0, 0, 1, 0, 0, 0, 0, 0, 3, 4}}
+	at.Equal([]byte{0x65, 0, 0, 3, 1, 0, 0, 3, 0, 0, 3, 0, 3, 4}, w.nalu(0x65))
+}
@@ -0,0 +1,196 @@
+//go:build webrtc
+// +build webrtc
+
+package webrtc
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/parser/h264"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	maxQueueNum   = 1024
+	maxLate       = 512
+	videoRate     = 90000
+	pliInterval   = 3 * time.Second
+	videoRateInMS = videoRate / 1000
+)
+
+// whipReader turns the h264 track of a WHIP publisher into flv packets,
+// the way a VirReader does for an rtmp publisher
+type whipReader struct {
+	Uid string
+	av.RWBaser
+	app, title, url string
+	demuxer         *flv.Demuxer
+	packets         chan *av.Packet
+	closed          chan struct{}
+	closeOnce       sync.Once
+	onClose         func()
+
+	// only used by the video track goroutine
+	sps, pps []byte
+	seqSent  bool
+	firstTs  uint32
+	hasTs    bool
+}
+
+func newWhipReader(app, title, url, id string, onClose func()) *whipReader {
+	return &whipReader{
+		Uid:     id,
+		app:     app,
+		title:   title,
+		url:     url,
+		RWBaser: av.NewRWBaser(time.Second * time.Duration(configure.Config.GetInt("read_timeout"))),
+		demuxer: flv.NewDemuxer(),
+		packets: make(chan *av.Packet, maxQueueNum),
+		closed:  make(chan struct{}),
+		onClose: onClose,
+	}
+}
+
+func (r *whipReader) readTrack(pc *webrtc.PeerConnection, track *webrtc.TrackRemote) {
+	mimeType := track.Codec().MimeType
+	if !strings.EqualFold(mimeType, webrtc.MimeTypeH264) {
+		// the answer only takes h264, see newWhipAPI
+		log.Warningf("[%v] whip track %s is not supported, dropping it", r.Info(), mimeType)
+		for {
+			if _, _, err := track.ReadRTP(); err != nil {
+				return
+			}
+		}
+	}
+
+	go r.sendPLI(pc, track.SSRC())
+
+	builder := samplebuilder.New(maxLate, &codecs.H264Packet{}, videoRate)
+	for {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			r.Close(err)
+			return
+		}
+		builder.Push(pkt)
+		for s := builder.Pop(); s != nil; s = builder.Pop() {
+			r.writeSample(s)
+		}
+	}
+}
+
+// sendPLI asks the publisher for a key frame on a regular basis,
+// so new players don't wait long for a gop to start from
+func (r *whipReader) sendPLI(pc *webrtc.PeerConnection, ssrc webrtc.SSRC) {
+	ticker := time.NewTicker(pliInterval)
+	defer ticker.Stop()
+	for {
+		if err := pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)}}); err != nil {
+			log.Debug("whip send pli error: ", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-r.closed:
+			return
+		}
+	}
+}
+
+func (r *whipReader) writeSample(s *media.Sample) {
+	var frame [][]byte
+	keyFrame := false
+	for _, nalu := range h264.SplitAnnexB(s.Data) {
+		switch {
+		case h264.IsSPS(nalu):
+			if !bytes.Equal(nalu, r.sps) {
+				r.sps = append([]byte(nil), nalu...)
+				r.seqSent = false
+			}
+		case h264.IsPPS(nalu):
+			if !bytes.Equal(nalu, r.pps) {
+				r.pps = append([]byte(nil), nalu...)
+				r.seqSent = false
+			}
+		case h264.IsAUD(nalu):
+		default:
+			if h264.IsIDR(nalu) {
+				keyFrame = true
+			}
+			frame = append(frame, nalu)
+		}
+	}
+
+	if !r.hasTs {
+		r.firstTs = s.PacketTimestamp
+		r.hasTs = true
+	}
+	timestamp := (s.PacketTimestamp - r.firstTs) / videoRateInMS
+
+	if !r.seqSent {
+		// players can't decode anything before a sequence header and a key frame
+		if r.sps == nil || r.pps == nil || !keyFrame {
+			return
+		}
+		r.push(&av.Packet{
+			IsVideo:   true,
+			TimeStamp: timestamp,
+			Data:      flv.NewAVCSeqHeader(r.sps, r.pps),
+		})
+		r.seqSent = true
+	}
+	if len(frame) == 0 {
+		return
+	}
+	r.push(&av.Packet{
+		IsVideo:   true,
+		TimeStamp: timestamp,
+		Data:      flv.NewAVCNALU(frame, keyFrame, 0),
+	})
+}
+
+func (r *whipReader) push(p *av.Packet) {
+	select {
+	case r.packets <- p:
+	case <-r.closed:
+	}
+}
+
+func (r *whipReader) Read(p *av.Packet) error {
+	select {
+	case pkt := <-r.packets:
+		*p = *pkt
+	case <-r.closed:
+		return io.EOF
+	}
+	// the idle timeout only resets when a media packet actually arrived
+	r.SetPreTime()
+	return r.demuxer.DemuxH(p)
+}
+
+func (r *whipReader) Info() (ret av.Info) {
+	ret.UID = r.Uid
+	ret.URL = r.url
+	ret.Key = r.app + "/" + r.title
+	return
+}
+
+func (r *whipReader) Close(err error) {
+	r.closeOnce.Do(func() {
+		log.Debug("whip publisher ", r.Info(), " closed: ", err)
+		close(r.closed)
+		r.onClose()
+	})
+}
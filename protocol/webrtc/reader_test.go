@@ -0,0 +1,114 @@
+//go:build webrtc
+// +build webrtc
+
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testSPS = []byte{0x67, 0x42, 0x00, 0x1f, 0xe9, 0x02, 0xc1, 0x2c, 0x80}
+	testPPS = []byte{0x68, 0xce, 0x06, 0xe2}
+	testIDR = []byte{0x65, 0x88, 0x84, 0x00, 0x33}
+	testP   = []byte{0x41, 0x9a, 0x02, 0x04}
+	testAUD = []byte{0x09, 0xf0}
+)
+
+// annexB joins nalus with start codes, the way the sample builder
+// hands an access unit over
+func annexB(nalus ...[]byte) []byte {
+	var b []byte
+	for _, n := range nalus {
+		b = append(b, 0x00, 0x00, 0x00, 0x01)
+		b = append(b, n...)
+	}
+	return b
+}
+
+// queued takes the packets the reader pushed so far
+func queued(r *whipReader) (ret []*av.Packet) {
+	for {
+		select {
+		case p := <-r.packets:
+			ret = append(ret, p)
+		default:
+			return
+		}
+	}
+}
+
+func TestWhipSequenceHeaderGating(t *testing.T) {
+	at := assert.New(t)
+	r := newWhipReader("live", "whip", "webrtc://127.0.0.1/live/whip", "whip", func() {})
+	defer r.Close(nil)
+
+	// nothing goes out before the parameter sets and a key frame
+	r.writeSample(&media.Sample{Data: annexB(testP), PacketTimestamp: 1000})
+	r.writeSample(&media.Sample{Data: annexB(testSPS, testPPS), PacketTimestamp: 1000})
+	r.writeSample(&media.Sample{Data: annexB(testP), PacketTimestamp: 4000})
+	at.Empty(queued(r))
+
+	r.writeSample(&media.Sample{Data: annexB(testAUD, testIDR), PacketTimestamp: 7000})
+	got := queued(r)
+	if at.Len(got, 2) {
+		// the sequence header then the key frame, the aud is dropped
+		at.Equal([]byte{0x17, 0x00}, got[0].Data[:2])
+		at.Equal([]byte{0x17, 0x01}, got[1].Data[:2])
+		at.Equal(4+len(testIDR), len(got[1].Data)-5)
+	}
+
+	r.writeSample(&media.Sample{Data: annexB(testP), PacketTimestamp: 10000})
+	got = queued(r)
+	if at.Len(got, 1) {
+		at.Equal([]byte{0x27, 0x01}, got[0].Data[:2])
+	}
+
+	// new parameter sets wait for the next key frame again
+	sps := append([]byte(nil), testSPS...)
+	sps[3] = 0x28
+	r.writeSample(&media.Sample{Data: annexB(sps, testPPS, testP), PacketTimestamp: 13000})
+	at.Empty(queued(r))
+	r.writeSample(&media.Sample{Data: annexB(testIDR), PacketTimestamp: 16000})
+	got = queued(r)
+	if at.Len(got, 2) {
+		at.Equal([]byte{0x17, 0x00}, got[0].Data[:2])
+		at.Equal([]byte{0x17, 0x01}, got[1].Data[:2])
+	}
+}
+
+func TestWhipTimestamps(t *testing.T) {
+	at := assert.New(t)
+	r := newWhipReader("live", "whip", "webrtc://127.0.0.1/live/whip", "whip", func() {})
+	defer r.Close(nil)
+
+	// the 90kHz rtp clock starts anywhere, the flv one at the first sample
+	first := uint32(0xffffffff - 45000)
+	r.writeSample(&media.Sample{Data: annexB(testP), PacketTimestamp: first})
+	r.writeSample(&media.Sample{Data: annexB(testSPS, testPPS, testIDR), PacketTimestamp: first + 9000})
+	r.writeSample(&media.Sample{Data: annexB(testP), PacketTimestamp: first + 12000})
+	// the rtp timestamp wraps around
+	r.writeSample(&media.Sample{Data: annexB(testP), PacketTimestamp: first + 90000})
+
+	var ts []uint32
+	for _, p := range queued(r) {
+		at.True(p.IsVideo)
+		ts = append(ts, p.TimeStamp)
+	}
+	at.Equal([]uint32{100, 100, 133, 1000}, ts)
+
+	// Read demuxes the tag header for the players
+	r.push(&av.Packet{IsVideo: true, TimeStamp: 1033, Data: []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x41}})
+	var p av.Packet
+	at.Nil(r.Read(&p))
+	if vh, ok := p.Header.(av.VideoPacketHeader); at.True(ok) {
+		at.False(vh.IsKeyFrame())
+		at.Equal(uint8(av.VIDEO_H264), vh.CodecID())
+	}
+	at.Equal(uint32(1033), p.TimeStamp)
+}
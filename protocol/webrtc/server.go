@@ -0,0 +1,370 @@
+//go:build webrtc
+// +build webrtc
+
+package webrtc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/protocol/rtmp"
+	"github.com/SpooderfyBot/live/utils/uid"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+const maxOfferSize = 64 * 1024
+
+// whipProfiles are the h264 profiles taken from a WHIP publisher, by the
+// profile-level-id and packetization-mode of their fmtp line
+var whipProfiles = []string{
+	"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f",
+	"level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42001f",
+	"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+	"level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42e01f",
+	"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d001f",
+	"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=64001f",
+}
+
+// Server accepts WHIP publishers and WHEP players and plugs them
+// into the same av.Handler as the rtmp server
+type Server struct {
+	handler  av.Handler
+	getter   av.GetWriter
+	sessions sync.Map
+	whipAPI  *webrtc.API
+}
+
+type session struct {
+	pc     *webrtc.PeerConnection
+	closer av.Closer
+}
+
+func NewServer(h av.Handler, getter av.GetWriter) *Server {
+	return &Server{
+		handler: h,
+		getter:  getter,
+		whipAPI: newWhipAPI(),
+	}
+}
+
+// newWhipAPI makes the peer connections of the publishers, it only knows
+// the h264 codecs so an audio track is rejected in the answer: flv only
+// carries aac and mp3, opus would need a transcode
+func newWhipAPI() *webrtc.API {
+	m := &webrtc.MediaEngine{}
+	feedback := []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}}
+	for i, fmtp := range whipProfiles {
+		if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     webrtc.MimeTypeH264,
+				ClockRate:    videoRate,
+				SDPFmtpLine:  fmtp,
+				RTCPFeedback: feedback,
+			},
+			PayloadType: webrtc.PayloadType(102 + 2*i),
+		}, webrtc.RTPCodecTypeVideo); err != nil {
+			log.Panic("whip register codec: ", err)
+		}
+	}
+	i := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		log.Panic("whip register interceptors: ", err)
+	}
+	return webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i))
+}
+
+func (server *Server) Serve(l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/whip/", func(w http.ResponseWriter, r *http.Request) {
+		server.handleWhip(w, r)
+	})
+	mux.HandleFunc("/whep/", func(w http.ResponseWriter, r *http.Request) {
+		server.handleWhep(w, r)
+	})
+	mux.HandleFunc("/resource/", func(w http.ResponseWriter, r *http.Request) {
+		server.handleResource(w, r)
+	})
+	if err := http.Serve(l, mux); err != nil {
+		return err
+	}
+	return nil
+}
+
+func setCORS(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Expose-Headers", "Location")
+}
+
+// parsePath splits /{prefix}/{app}/{name} into app and name
+func parsePath(prefix, path string) (app, name string, err error) {
+	paths := strings.SplitN(strings.TrimPrefix(path, prefix), "/", 2)
+	if len(paths) != 2 || paths[0] == "" || paths[1] == "" {
+		return "", "", fmt.Errorf("invalid path %s", path)
+	}
+	return paths[0], paths[1], nil
+}
+
+// readOffer reads the sdp offer of a WHIP or WHEP request
+func readOffer(w http.ResponseWriter, r *http.Request) (*webrtc.SessionDescription, bool) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return nil, false
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/sdp") {
+		http.Error(w, "content type must be application/sdp", http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxOfferSize))
+	if err != nil {
+		http.Error(w, "invalid offer", http.StatusBadRequest)
+		return nil, false
+	}
+	return &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(body)}, true
+}
+
+// answer completes the negotiation and replies with the sdp answer,
+// the Location header points to the resource used to end the session
+func (server *Server) answer(w http.ResponseWriter, pc *webrtc.PeerConnection, offer *webrtc.SessionDescription, id string) error {
+	if err := pc.SetRemoteDescription(*offer); err != nil {
+		return err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	<-gatherComplete
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/resource/"+id)
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write([]byte(pc.LocalDescription().SDP))
+	return err
+}
+
+func (server *Server) closeSession(id string) {
+	v, ok := server.sessions.Load(id)
+	if !ok {
+		return
+	}
+	server.sessions.Delete(id)
+	s := v.(*session)
+	if err := s.pc.Close(); err != nil {
+		log.Debug("webrtc close peer connection error: ", err)
+	}
+	s.closer.Close(fmt.Errorf("webrtc session %s closed", id))
+}
+
+func (server *Server) watchSession(id string, pc *webrtc.PeerConnection) {
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Debugf("webrtc session %s: %s", id, state)
+		switch state {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			go server.closeSession(id)
+		}
+	})
+}
+
+func (server *Server) handleWhip(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("whip handleConn panic: ", r)
+		}
+	}()
+
+	offer, ok := readOffer(w, r)
+	if !ok {
+		return
+	}
+
+	app, name, err := parsePath("/whip/", r.URL.Path)
+	if err != nil {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	if !configure.CheckAppName(app) {
+		http.Error(w, fmt.Sprintf("application name=%s is not configured", app), http.StatusNotFound)
+		return
+	}
+	channel := name
+	if configure.Config.GetBool("rtmp_noauth") {
+		// the room is made if need be, its name stands for its key
+		if _, err := configure.RoomKeys.GetKey(name); err != nil && err != configure.ErrKeyHashed {
+			log.Error("GetKey err: ", err)
+			http.Error(w, "cannot create key", http.StatusInternalServerError)
+			return
+		}
+	} else if channel, err = configure.RoomKeys.GetChannel(name); err != nil {
+		log.Error("CheckKey err: ", err)
+		http.Error(w, "invalid key", http.StatusUnauthorized)
+		return
+	}
+
+	pc, err := server.whipAPI.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		log.Error("whip new peer connection error: ", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	}); err != nil {
+		pc.Close()
+		log.Error("whip add transceiver error: ", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	id := uid.NewId()
+	url := "webrtc://" + r.Host + "/" + app + "/" + channel
+	reader := newWhipReader(app, channel, url, id, func() {
+		go server.closeSession(id)
+	})
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		reader.readTrack(pc, track)
+	})
+	server.sessions.Store(id, &session{pc: pc, closer: reader})
+	server.watchSession(id, pc)
+
+	if err := server.answer(w, pc, offer, id); err != nil {
+		server.closeSession(id)
+		log.Error("whip negotiation error: ", err)
+		http.Error(w, "invalid offer", http.StatusBadRequest)
+		return
+	}
+
+	server.handler.HandleReader(reader)
+	log.Debugf("new whip publisher: %+v", reader.Info())
+	settings, err := configure.RoomKeys.GetSettings(channel)
+	if err != nil {
+		log.Warning("GetSettings err: ", err)
+	}
+	if server.getter != nil && settings.HLS() {
+		server.handler.HandleWriter(server.getter.GetWriter(reader.Info()))
+	}
+	if settings.Archive() {
+		flvWriter := new(flv.FlvDvr)
+		server.handler.HandleWriter(flvWriter.GetWriter(reader.Info()))
+	}
+}
+
+func (server *Server) handleWhep(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("whep handleConn panic: ", r)
+		}
+	}()
+
+	offer, ok := readOffer(w, r)
+	if !ok {
+		return
+	}
+
+	app, name, err := parsePath("/whep/", r.URL.Path)
+	if err != nil {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	key := app + "/" + name
+	inspector, ok := rtmp.Inspect(server.handler)
+	if !ok {
+		http.Error(w, "invalid handler", http.StatusInternalServerError)
+		return
+	}
+	v, ok := inspector.GetStreams().Load(key)
+	if !ok || v.(*rtmp.Stream).GetReader() == nil {
+		http.Error(w, "invalid path", http.StatusNotFound)
+		return
+	}
+	if limiter, ok := server.handler.(rtmp.PlayerLimiter); ok && !limiter.CanAddPlayer(key) {
+		http.Error(w, "too many players", http.StatusServiceUnavailable)
+		return
+	}
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		log.Error("whep new peer connection error: ", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", key)
+	if err != nil {
+		pc.Close()
+		log.Error("whep new track error: ", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		pc.Close()
+		log.Error("whep add track error: ", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	// rtcp has to be read for the interceptors (nack, reports) to work
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	id := uid.NewId()
+	writer := newWhepWriter(app, name, r.URL.String(), id, track, func() {
+		go server.closeSession(id)
+	})
+	server.sessions.Store(id, &session{pc: pc, closer: writer})
+	server.watchSession(id, pc)
+
+	if err := server.answer(w, pc, offer, id); err != nil {
+		server.closeSession(id)
+		log.Error("whep negotiation error: ", err)
+		http.Error(w, "invalid offer", http.StatusBadRequest)
+		return
+	}
+
+	log.Debugf("new whep player: %+v", writer.Info())
+	server.handler.HandleWriter(writer)
+}
+
+// handleResource ends a WHIP or WHEP session on DELETE
+func (server *Server) handleResource(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		id := strings.TrimPrefix(r.URL.Path, "/resource/")
+		if _, ok := server.sessions.Load(id); !ok {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		server.closeSession(id)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
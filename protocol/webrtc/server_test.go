@@ -0,0 +1,82 @@
+//go:build webrtc
+// +build webrtc
+
+package webrtc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+type testHandler struct {
+	sync.Mutex
+	readers []av.ReadCloser
+}
+
+func (h *testHandler) HandleReader(r av.ReadCloser) {
+	h.Lock()
+	defer h.Unlock()
+	h.readers = append(h.readers, r)
+}
+
+func (h *testHandler) HandleWriter(w av.WriteCloser) {}
+
+func TestWhipRejectsAudio(t *testing.T) {
+	at := assert.New(t)
+	configure.Config.Set("rtmp_noauth", true)
+	defer configure.Config.Set("rtmp_noauth", false)
+
+	// a browser offers opus along with h264
+	offerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if !at.Nil(err) {
+		return
+	}
+	defer offerer.Close()
+	audio, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "whip")
+	at.Nil(err)
+	video, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "whip")
+	at.Nil(err)
+	_, err = offerer.AddTrack(audio)
+	at.Nil(err)
+	_, err = offerer.AddTrack(video)
+	at.Nil(err)
+	offer, err := offerer.CreateOffer(nil)
+	at.Nil(err)
+	gatherComplete := webrtc.GatheringCompletePromise(offerer)
+	at.Nil(offerer.SetLocalDescription(offer))
+	<-gatherComplete
+
+	h := &testHandler{}
+	server := NewServer(h, nil)
+	req := httptest.NewRequest("POST", "/whip/live/whipaudio", strings.NewReader(offerer.LocalDescription().SDP))
+	req.Header.Set("Content-Type", "application/sdp")
+	rec := httptest.NewRecorder()
+	server.handleWhip(rec, req)
+	if !at.Equal(http.StatusCreated, rec.Code, rec.Body.String()) {
+		return
+	}
+
+	location := rec.Header().Get("Location")
+	sdp := rec.Body.String()
+	at.True(strings.Contains(sdp, "m=audio 0 "), sdp)
+	at.False(strings.Contains(strings.ToLower(sdp), "opus"), sdp)
+	at.True(strings.Contains(sdp, "H264/90000"), sdp)
+	h.Lock()
+	at.Len(h.readers, 1)
+	h.Unlock()
+
+	rec = httptest.NewRecorder()
+	server.handleResource(rec, httptest.NewRequest("DELETE", location, nil))
+	at.Equal(http.StatusOK, rec.Code)
+	_, ok := server.sessions.Load(strings.TrimPrefix(location, "/resource/"))
+	at.False(ok)
+}
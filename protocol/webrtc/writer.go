@@ -0,0 +1,154 @@
+//go:build webrtc
+// +build webrtc
+
+package webrtc
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SpooderfyBot/live/av"
+	"github.com/SpooderfyBot/live/configure"
+	"github.com/SpooderfyBot/live/container/flv"
+	"github.com/SpooderfyBot/live/parser"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultFrameDuration = 33 * time.Millisecond
+
+// whepWriter plays a stream to a WHEP player, only h264 video is sent
+// since webrtc has no aac
+type whepWriter struct {
+	Uid string
+	av.RWBaser
+	app, title, url string
+	track           *webrtc.TrackLocalStaticSample
+	demuxer         *flv.Demuxer
+	codec           *parser.CodecParser
+	buf             *bytes.Buffer
+	packetQueue     chan *av.Packet
+	closed          bool
+	closeOnce       sync.Once
+	lastTimestamp   uint32
+	started         bool
+	onClose         func()
+}
+
+func newWhepWriter(app, title, url, id string, track *webrtc.TrackLocalStaticSample, onClose func()) *whepWriter {
+	ret := &whepWriter{
+		Uid:         id,
+		app:         app,
+		title:       title,
+		url:         url,
+		track:       track,
+		RWBaser:     av.NewRWBaser(time.Second * time.Duration(configure.Config.GetInt("write_timeout"))),
+		demuxer:     flv.NewDemuxer(),
+		codec:       parser.NewCodecParser(),
+		buf:         bytes.NewBuffer(nil),
+		packetQueue: make(chan *av.Packet, maxQueueNum),
+		onClose:     onClose,
+	}
+	go func() {
+		err := ret.SendPacket()
+		if err != nil {
+			log.Debug("whep SendPacket error: ", err)
+			ret.Close(err)
+		}
+	}()
+	return ret
+}
+
+func (w *whepWriter) Write(p *av.Packet) (err error) {
+	if w.closed {
+		return fmt.Errorf("whep writer closed")
+	}
+	if !p.IsVideo {
+		return nil
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("whep writer has already been closed:%v", e)
+		}
+	}()
+
+	// the packet is shared with the other writers, demux a copy
+	pkt := *p
+	select {
+	case w.packetQueue <- &pkt:
+	default:
+		log.Warningf("[%v] packet queue max!!!", w.Info())
+	}
+	return
+}
+
+func (w *whepWriter) SendPacket() error {
+	for {
+		p, ok := <-w.packetQueue
+		if !ok {
+			return fmt.Errorf("closed")
+		}
+		w.SetPreTime()
+
+		err := w.demuxer.Demux(p)
+		if err == flv.ErrAvcEndSEQ {
+			continue
+		} else if err != nil {
+			return err
+		}
+		header, ok := p.Header.(av.VideoPacketHeader)
+		if !ok || header.CodecID() != av.VIDEO_H264 {
+			continue
+		}
+		// wait for a key frame so the player starts on a full picture
+		if !w.started && !header.IsSeq() && !header.IsKeyFrame() {
+			continue
+		}
+
+		w.buf.Reset()
+		if err := w.codec.Parse(p, w.buf); err != nil {
+			log.Debugf("[%v] whep parse error: %v", w.Info(), err)
+			continue
+		}
+		if header.IsSeq() {
+			continue
+		}
+
+		duration := defaultFrameDuration
+		if w.started && p.TimeStamp > w.lastTimestamp {
+			duration = time.Duration(p.TimeStamp-w.lastTimestamp) * time.Millisecond
+		}
+		w.started = true
+		w.lastTimestamp = p.TimeStamp
+
+		if err := w.track.WriteSample(media.Sample{Data: w.buf.Bytes(), Duration: duration}); err != nil {
+			return err
+		}
+	}
+}
+
+func (w *whepWriter) IsPlayer() bool {
+	return true
+}
+
+func (w *whepWriter) Info() (ret av.Info) {
+	ret.UID = w.Uid
+	ret.URL = w.url
+	ret.Key = w.app + "/" + w.title
+	ret.Inter = true
+	return
+}
+
+func (w *whepWriter) Close(err error) {
+	w.closeOnce.Do(func() {
+		log.Debug("whep player ", w.Info(), " closed: ", err)
+		w.closed = true
+		close(w.packetQueue)
+		w.onClose()
+	})
+}
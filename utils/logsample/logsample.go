@@ -0,0 +1,82 @@
+// Package logsample thins out the debug lines logged for every packet or
+// connection, so debug logs stay usable under load
+package logsample
+
+import (
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+
+	"github.com/SpooderfyBot/live/configure"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Sampler keeps the first keep lines of every every lines of each site,
+// all of them when every is 0
+type Sampler struct {
+	// accessed atomically, kept first for their alignment
+	keep  int64
+	every int64
+
+	sites sync.Map // site name -> *uint64 lines seen
+}
+
+func NewSampler(keep, every int) *Sampler {
+	s := &Sampler{}
+	s.Set(keep, every)
+	return s
+}
+
+// Set changes the rate, a keep under 1 is taken as 1
+func (s *Sampler) Set(keep, every int) {
+	if keep < 1 {
+		keep = 1
+	}
+	atomic.StoreInt64(&s.keep, int64(keep))
+	atomic.StoreInt64(&s.every, int64(every))
+}
+
+// Keep counts a line of site and tells if it is logged
+func (s *Sampler) Keep(site string) bool {
+	keep, every := atomic.LoadInt64(&s.keep), atomic.LoadInt64(&s.every)
+	if every <= 0 || keep >= every {
+		return true
+	}
+	seen, ok := s.sites.Load(site)
+	if !ok {
+		seen, _ = s.sites.LoadOrStore(site, new(uint64))
+	}
+	n := atomic.AddUint64(seen.(*uint64), 1) - 1
+	return n%uint64(every) < uint64(keep)
+}
+
+// discard is the entry of the lines sampled out
+var discard = log.NewEntry(&log.Logger{
+	Out:       ioutil.Discard,
+	Formatter: new(log.TextFormatter),
+	Hooks:     make(log.LevelHooks),
+	Level:     log.PanicLevel,
+})
+
+var std = NewSampler(1, 0)
+
+func init() {
+	load := func() {
+		std.Set(configure.Config.GetInt("log_sample.keep"), configure.Config.GetInt("log_sample.every"))
+	}
+	load()
+	configure.OnReload(load)
+}
+
+// Debug returns the entry to log a debug line of site with, one that
+// drops it when the line is sampled out or debug is off. Log through the
+// entry so the caller reported is the call site:
+//
+//	logsample.Debug("rtmp drop video").Debug("drop video pkt")
+func Debug(site string) *log.Entry {
+	if !log.IsLevelEnabled(log.DebugLevel) || !std.Keep(site) {
+		return discard
+	}
+	return log.NewEntry(log.StandardLogger())
+}
@@ -0,0 +1,63 @@
+package logsample
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSamplerFraction(t *testing.T) {
+	at := assert.New(t)
+
+	kept := func(s *Sampler, site string, lines int) int {
+		n := 0
+		for i := 0; i < lines; i++ {
+			if s.Keep(site) {
+				n++
+			}
+		}
+		return n
+	}
+
+	s := NewSampler(1, 10)
+	at.Equal(100, kept(s, "a", 1000))
+	// each site is sampled on its own
+	at.Equal(10, kept(s, "b", 100))
+
+	s.Set(3, 4)
+	at.InDelta(750, kept(s, "c", 1000), 3)
+
+	// no sampling, or a keep past every, logs everything
+	s.Set(0, 0)
+	at.Equal(50, kept(s, "a", 50))
+	s.Set(5, 2)
+	at.Equal(50, kept(s, "a", 50))
+}
+
+func TestDebug(t *testing.T) {
+	at := assert.New(t)
+	var out bytes.Buffer
+	oldOut, oldLevel := log.StandardLogger().Out, log.GetLevel()
+	log.SetOutput(&out)
+	defer func() {
+		log.SetOutput(oldOut)
+		log.SetLevel(oldLevel)
+		std.Set(1, 0)
+	}()
+
+	std.Set(1, 4)
+	log.SetLevel(log.InfoLevel)
+	Debug("test").Debug("hidden")
+	at.Empty(out.String())
+
+	log.SetLevel(log.DebugLevel)
+	for i := 0; i < 8; i++ {
+		Debug("test debug").Debug(fmt.Sprint("line ", i))
+	}
+	at.Equal(2, bytes.Count(out.Bytes(), []byte("line ")))
+	at.Contains(out.String(), "line 0")
+	at.Contains(out.String(), "line 4")
+}
@@ -0,0 +1,64 @@
+// Package reqid carries the correlation id of an api request in its
+// context, so the lines logged on its behalf can be told apart
+package reqid
+
+import (
+	"context"
+
+	"github.com/SpooderfyBot/live/utils/uid"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Header is the http header the id comes in and is echoed back with
+const Header = "X-Request-ID"
+
+// Field is the log field holding the id
+const Field = "request_id"
+
+const maxLen = 128
+
+type ctxKey struct{}
+
+// New returns a random id
+func New() string {
+	return uid.NewId()
+}
+
+// Valid tells an id taken from a client is fit for the logs: at most 128
+// printable ascii characters, without spaces
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext returns ctx carrying id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext is the id ctx carries, empty when none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// Entry is the logger of the lines of id, the standard one when id is empty
+func Entry(id string) *log.Entry {
+	if id == "" {
+		return log.NewEntry(log.StandardLogger())
+	}
+	return log.WithField(Field, id)
+}
+
+// Logger is the Entry of the id ctx carries
+func Logger(ctx context.Context) *log.Entry {
+	return Entry(FromContext(ctx))
+}
@@ -5,9 +5,18 @@ import "math/rand"
 var letterRunes = []rune("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 func RandStringRunes(n int) string {
+	return randString(n, letterRunes)
+}
+
+// RandString returns n characters picked from charset
+func RandString(n int, charset string) string {
+	return randString(n, []rune(charset))
+}
+
+func randString(n int, runes []rune) string {
 	b := make([]rune, n)
 	for i := range b {
-		b[i] = letterRunes[rand.Intn(len(letterRunes))]
+		b[i] = runes[rand.Intn(len(runes))]
 	}
 	return string(b)
 }
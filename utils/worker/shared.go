@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"sync"
+
+	"github.com/SpooderfyBot/live/configure"
+)
+
+const (
+	defaultWorkers  = 4
+	defaultQueueLen = 256
+)
+
+var (
+	sharedOnce sync.Once
+	shared     *Pool
+)
+
+// Shared is the pool the webhooks and relay retries run on, so a flood of
+// them queues up instead of spawning goroutines. It has workers.size
+// goroutines, hooks.workers for older configs, each queuing up to
+// workers.queue_len jobs
+func Shared() *Pool {
+	sharedOnce.Do(func() {
+		size := configure.Config.GetInt("workers.size")
+		if size <= 0 {
+			size = configure.Config.GetInt("hooks.workers")
+		}
+		if size <= 0 {
+			size = defaultWorkers
+		}
+		queueLen := configure.Config.GetInt("workers.queue_len")
+		if queueLen <= 0 {
+			queueLen = defaultQueueLen
+		}
+		shared = NewPool(size, queueLen)
+	})
+	return shared
+}
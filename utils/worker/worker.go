@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// Pool runs jobs on a fixed number of goroutines. Jobs sharing a key
+// always run on the same goroutine, so they run in order
+type Pool struct {
+	// accessed atomically, kept first for their alignment
+	queued  int64
+	running int64
+	dropped int64
+
+	queueLen int
+	queues   []chan func()
+}
+
+// Stats is the load of a pool, saturation is the percent of its queues
+// in use, jobs are dropped past 100
+type Stats struct {
+	Workers    int   `json:"workers"`
+	Capacity   int   `json:"capacity"`
+	Queued     int64 `json:"queued"`
+	Running    int64 `json:"running"`
+	Dropped    int64 `json:"dropped"`
+	Saturation int   `json:"saturation"`
+}
+
+func NewPool(workers, queueLen int) *Pool {
+	if workers <= 0 {
+		workers = 1
+	}
+	p := &Pool{
+		queueLen: queueLen,
+		queues:   make([]chan func(), workers),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan func(), queueLen)
+		go p.run(p.queues[i])
+	}
+	return p
+}
+
+func (p *Pool) run(queue chan func()) {
+	for job := range queue {
+		atomic.AddInt64(&p.queued, -1)
+		atomic.AddInt64(&p.running, 1)
+		job()
+		atomic.AddInt64(&p.running, -1)
+	}
+}
+
+// Submit never blocks, the job is dropped and false returned
+// when the queue of its worker is full
+func (p *Pool) Submit(key string, job func()) bool {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	// counted before it is queued, a worker may take it right away
+	atomic.AddInt64(&p.queued, 1)
+	select {
+	case p.queues[h.Sum32()%uint32(len(p.queues))] <- job:
+		return true
+	default:
+		atomic.AddInt64(&p.queued, -1)
+		atomic.AddInt64(&p.dropped, 1)
+		return false
+	}
+}
+
+func (p *Pool) Stats() Stats {
+	s := Stats{
+		Workers:  len(p.queues),
+		Capacity: len(p.queues) * p.queueLen,
+		Queued:   atomic.LoadInt64(&p.queued),
+		Running:  atomic.LoadInt64(&p.running),
+		Dropped:  atomic.LoadInt64(&p.dropped),
+	}
+	if s.Capacity > 0 {
+		s.Saturation = int(s.Queued * 100 / int64(s.Capacity))
+	}
+	return s
+}